@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"math"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/cadenzr/cadenzr/config"
 	"github.com/cadenzr/cadenzr/controllers"
 	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
 
 	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/streamers"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
@@ -21,6 +25,8 @@ func startAPI() {
 
 	e.Static("/", "app/dist")
 	e.Static("/images", "images")
+	// Covers are loaded by image tags, which can't send a token, like the images above.
+	e.GET("/api/covers/:id", controllers.CoverController.Show)
 
 	// Login route
 	e.POST("/api/login", controllers.AuthController.Login)
@@ -38,9 +44,12 @@ func startAPI() {
 	rQuery := e.Group("/api")
 	rQuery.Use(middleware.JWTWithConfig(jwtConfQuery))
 
+	r.GET("/libraries", controllers.LibraryController.Index)
 	r.GET("/albums", controllers.AlbumController.Index)
 	r.GET("/albums/:id", controllers.AlbumController.Show)
 	rQuery.GET("/albums/:id/download", controllers.AlbumController.Download)
+	r.GET("/artists", controllers.ArtistController.Index)
+	r.GET("/artists/:id", controllers.ArtistController.Show)
 	r.GET("/playlists", controllers.PlaylistController.Index)
 	r.POST("/playlists", controllers.PlaylistController.Create)
 	r.DELETE("/playlists/:id/songs/:sid", controllers.PlaylistController.DeleteSong)
@@ -50,22 +59,136 @@ func startAPI() {
 
 	e.GET("/api/songs/:id/stream", controllers.SongController.FileStream)
 	e.POST("/api/songs/:id/played", controllers.SongController.Played)
+	r.GET("/songs/:id/lyrics", controllers.LyricsController.Show)
+	r.PUT("/songs/:id/lyrics", controllers.LyricsController.Update)
+	r.DELETE("/songs/:id/lyrics", controllers.LyricsController.Delete)
+	r.GET("/songs/:id/chapters", controllers.AudiobookController.Chapters)
+	r.POST("/songs/:id/scrobble", controllers.ScrobbleController.Scrobble)
+
+	for kind, path := range map[string]string{models.AnnotationSong: "/songs/:id", models.AnnotationAlbum: "/albums/:id", models.AnnotationArtist: "/artists/:id"} {
+		r.PUT(path+"/favorite", controllers.AnnotationController.Star(kind))
+		r.DELETE(path+"/favorite", controllers.AnnotationController.Unstar(kind))
+		r.PUT(path+"/rating", controllers.AnnotationController.Rate(kind))
+		r.DELETE(path+"/rating", controllers.AnnotationController.Unrate(kind))
+	}
+	r.GET("/favorites", controllers.AnnotationController.Favorites)
+
+	r.GET("/queue", controllers.QueueController.Show)
+	r.PUT("/queue", controllers.QueueController.Save)
+	r.PUT("/queue/position", controllers.QueueController.Position)
+	r.DELETE("/queue", controllers.QueueController.Delete)
+
+	r.GET("/jukebox", controllers.JukeboxController.Show)
+	r.POST("/jukebox/play", controllers.JukeboxController.Play)
+	r.POST("/jukebox/pause", controllers.JukeboxController.Pause)
+	r.POST("/jukebox/skip", controllers.JukeboxController.Skip)
+	r.POST("/jukebox/seek", controllers.JukeboxController.Seek)
+	r.PUT("/jukebox/volume", controllers.JukeboxController.Volume)
+	r.PUT("/jukebox/queue", controllers.JukeboxController.SetQueue)
+	r.POST("/jukebox/queue", controllers.JukeboxController.AddToQueue)
+	r.DELETE("/jukebox/queue/:index", controllers.JukeboxController.RemoveFromQueue)
+	r.DELETE("/jukebox/queue", controllers.JukeboxController.ClearQueue)
+
+	r.GET("/radio", controllers.RadioController.Index)
+	r.GET("/radio/:station", controllers.RadioController.Show)
+	r.PUT("/radio/:station/queue", controllers.RadioController.SetQueue)
+	r.POST("/radio/:station/queue", controllers.RadioController.Enqueue)
+	r.POST("/radio/:station/skip", controllers.RadioController.Skip)
+	// Radio players can't send headers, so the token goes in the query.
+	radioStreams := e.Group("/radio")
+	radioStreams.Use(middleware.JWTWithConfig(jwtConfQuery))
+	radioStreams.GET("/:station", controllers.RadioController.Stream)
+
+	r.GET("/radiostations", controllers.RadioStationController.Index)
+	r.POST("/radiostations", controllers.RadioStationController.Create)
+	r.POST("/radiostations/import", controllers.RadioStationController.Import)
+	r.GET("/radiostations/:id", controllers.RadioStationController.Show)
+	r.PUT("/radiostations/:id", controllers.RadioStationController.Update)
+	r.DELETE("/radiostations/:id", controllers.RadioStationController.Delete)
+	// Players request the stream themselves, so the token goes in the query.
+	rQuery.GET("/radiostations/:id/stream", controllers.RadioStationController.Stream)
+
+	r.GET("/audiobooks", controllers.AudiobookController.Index)
+	r.GET("/audiobooks/:id", controllers.AudiobookController.Show)
+	r.PUT("/audiobooks/:id/position", controllers.AudiobookController.SavePosition)
+	r.DELETE("/audiobooks/:id/position", controllers.AudiobookController.DeletePosition)
+
+	r.GET("/podcasts", controllers.PodcastController.Index)
+	r.POST("/podcasts", controllers.PodcastController.Subscribe)
+	r.GET("/podcasts/:id", controllers.PodcastController.Show)
+	r.DELETE("/podcasts/:id", controllers.PodcastController.Unsubscribe)
+	r.POST("/podcasts/:id/refresh", controllers.PodcastController.Refresh)
+	r.POST("/podcasts/episodes/:id/download", controllers.PodcastController.Download)
+	r.DELETE("/podcasts/episodes/:id/download", controllers.PodcastController.RemoveDownload)
+	r.PUT("/podcasts/episodes/:id/position", controllers.PodcastController.Position)
+	// Players request the audio themselves, so the token goes in the query.
+	rQuery.GET("/podcasts/episodes/:id/stream", controllers.PodcastController.Stream)
+
+	r.GET("/nowplaying", controllers.NowPlayingController.Index)
+	r.POST("/nowplaying", controllers.NowPlayingController.Heartbeat)
+	r.DELETE("/nowplaying", controllers.NowPlayingController.Stop)
+
+	r.GET("/history", controllers.HistoryController.Index)
+	r.POST("/history/import", controllers.HistoryController.Import)
+
+	r.GET("/scrobble/accounts", controllers.ScrobbleController.Accounts)
+	r.GET("/scrobble/accounts/lastfm/auth", controllers.ScrobbleController.LastFMAuth)
+	r.POST("/scrobble/accounts/lastfm", controllers.ScrobbleController.LinkLastFM)
+	r.POST("/scrobble/accounts/listenbrainz", controllers.ScrobbleController.LinkListenBrainz)
+	r.DELETE("/scrobble/accounts/:service", controllers.ScrobbleController.Unlink)
 
 	r.POST("/upload", upload)
 
-	r.POST("/scan", func(c echo.Context) error {
-		done := make(chan struct{})
-		scanCh <- done
-
-		<-done
-		return c.NoContent(http.StatusOK)
-	})
-
+	uploads, err := newResumableUploads()
+	if err != nil {
+		log.Fatalf("Failed to set up resumable uploads: %v", err)
+	}
+	// Clients ask which protocol versions are supported before they log in. The CORS
+	// middleware answers OPTIONS requests, so the headers are added before it runs.
+	e.Pre(uploads.Options("/api/tus"))
+	r.POST("/tus", uploads.Create)
+	r.HEAD("/tus/:id", uploads.Head)
+	r.PATCH("/tus/:id", uploads.Patch)
+	r.DELETE("/tus/:id", uploads.Delete)
+	r.GET("/tus/:id", uploads.Show)
+
+	streamers.Transcodings = streamers.NewCache(
+		filepath.Join("cache", "transcodings"),
+		config.Config.TranscodeCacheSize,
+		time.Duration(config.Config.TranscodeCacheAge)*time.Hour,
+	)
+	go func() {
+		for now := range time.Tick(time.Hour) {
+			streamers.Transcodings.Evict(now)
+		}
+	}()
+	r.GET("/transcodings/cache", controllers.TranscodeCacheController.Stats)
+	r.DELETE("/transcodings/cache", controllers.TranscodeCacheController.Purge)
+
+	r.POST("/scan", controllers.ScanController.Start)
+	r.GET("/scan/status", controllers.ScanController.Status)
+	r.GET("/scan/history", controllers.ScanController.History)
+	r.GET("/scan/:id", controllers.ScanController.Show)
+	r.DELETE("/scan", controllers.ScanController.Cancel)
+	r.DELETE("/scan/:id", controllers.ScanController.Cancel)
+
+	// EventSource and WebSocket clients can't set headers, so the token goes in the query.
+	rQuery.GET("/events", controllers.EventController.Stream)
+	rQuery.GET("/events/ws", controllers.EventController.WebSocket)
+
+	// HLS players request the segments themselves, so the token goes in the query.
+	rQuery.GET("/songs/:id/hls/master.m3u8", controllers.HLSController.SongMaster)
+	rQuery.GET("/songs/:id/hls/:variant/index.m3u8", controllers.HLSController.SongPlaylist)
+	rQuery.GET("/songs/:id/hls/:variant/:segment", controllers.HLSController.Segment)
+	rQuery.GET("/albums/:id/hls/master.m3u8", controllers.HLSController.AlbumMaster)
+	rQuery.GET("/albums/:id/hls/:variant/index.m3u8", controllers.HLSController.AlbumPlaylist)
+
+	// A plain M3U of the song files, for players without HLS support.
 	rQuery.GET("/albums/:id/playlist.m3u8", func(c echo.Context) error {
 		id := controllers.StrToUint(c.Param("id"))
 
 		songs := []*models.Song{}
-		db.DB.Find(&songs, "album_id = ?", id)
+		controllers.WhereSongsInScope(c, db.DB).Find(&songs, "album_id = ?", id)
 
 		endpoint := "http://" + config.Config.Hostname
 		if config.Config.Hostname == "" {
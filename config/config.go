@@ -2,7 +2,10 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"path/filepath"
+	"runtime"
 	"strings"
 )
 
@@ -13,6 +16,144 @@ const (
 	EnvProduction = "production"
 )
 
+// Cover preferences.
+const (
+	CoverFolder   = "folder"
+	CoverEmbedded = "embedded"
+)
+
+// ReplayGain modes.
+const (
+	ReplayGainOff   = "off"
+	ReplayGainTrack = "track"
+	ReplayGainAlbum = "album"
+)
+
+// Library is a directory with music.
+type Library struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// ReadOnly libraries are never written to, e.g. by uploads.
+	ReadOnly bool `json:"read_only"`
+	// Include contains glob patterns of files to add. Empty means all files.
+	// Patterns without a '/' are matched against every element of the path
+	// relative to the library, other patterns against the relative path.
+	Include []string `json:"include"`
+	// Exclude contains glob patterns of files and directories to skip.
+	Exclude []string `json:"exclude"`
+	// Users contains the usernames that can access the library. Empty means everyone.
+	Users []string `json:"users"`
+	// Audiobooks marks every file in the library as an audiobook.
+	Audiobooks bool `json:"audiobooks"`
+}
+
+// TranscodeProfile is a named set of encoder settings for transcoded streams.
+type TranscodeProfile struct {
+	Name string `json:"name"`
+	// Codec is mp3, vorbis, opus or aac.
+	Codec string `json:"codec"`
+	// Bitrate is in kbit/s. 0 uses the default of the encoder.
+	Bitrate int `json:"bitrate"`
+	// SampleRate is in Hz. 0 keeps the sample rate of the song.
+	SampleRate int `json:"sample_rate"`
+	// Channels is 0 to keep the channels of the song.
+	Channels int `json:"channels"`
+	// Container is mp3 for mp3, ogg or webm for vorbis and opus, adts or mp4 for aac.
+	// Empty uses the first.
+	Container string `json:"container"`
+}
+
+// BitrateRule sets the transcoding bitrates for some users and clients.
+type BitrateRule struct {
+	// Users contains the usernames the rule applies to. Empty means everyone.
+	Users []string `json:"users"`
+	// Clients contains parts of client names or user agents the rule applies to,
+	// without case. Empty means every client.
+	Clients []string `json:"clients"`
+	// Profile is the name of the profile used when the client doesn't ask for one.
+	Profile string `json:"profile"`
+	// DefaultBitrate is used when the client doesn't ask for a bitrate, in kbit/s.
+	DefaultBitrate int `json:"default_bitrate"`
+	// MaxBitrate is the highest bitrate streamed in kbit/s. Songs above it are transcoded.
+	MaxBitrate int `json:"max_bitrate"`
+}
+
+// LastFM configures scrobbling to Last.fm or a compatible service.
+type LastFM struct {
+	// APIKey and Secret are those of an API account. Scrobbling to Last.fm is off without them.
+	APIKey string `json:"api_key"`
+	Secret string `json:"secret"`
+	// URL is the root of the API.
+	URL string `json:"url"`
+	// AuthURL is the page where users allow cadenzr to scrobble.
+	AuthURL string `json:"auth_url"`
+}
+
+// ListenBrainz configures scrobbling to ListenBrainz or a compatible service.
+type ListenBrainz struct {
+	// Disabled turns off scrobbling to ListenBrainz.
+	Disabled bool `json:"disabled"`
+	// URL is the root of the API.
+	URL string `json:"url"`
+}
+
+// Jukebox outputs.
+const (
+	JukeboxALSA  = "alsa"
+	JukeboxPulse = "pulse"
+	JukeboxFile  = "file"
+	JukeboxNull  = "null"
+)
+
+// Jukebox configures playing songs on the audio output of the server.
+type Jukebox struct {
+	// Output is "alsa", "pulse", "file" or "null". Empty turns the jukebox off.
+	Output string `json:"output"`
+	// Device is the ALSA or PulseAudio device, or the path the file output writes to.
+	Device string `json:"device"`
+	// Users contains the usernames that can control the jukebox. The administrator
+	// always can.
+	Users []string `json:"users"`
+}
+
+// RadioStation is a live stream that all listeners hear at the same time.
+type RadioStation struct {
+	// Name is used in the address of the stream, /radio/<name>.
+	Name string `json:"name"`
+	// Profile is the name of the transcoding profile of the stream, which must use the
+	// mp3 or ogg container. Empty uses "mp3".
+	Profile string `json:"profile"`
+	// Playlist is the name of the playlist played on shuffle when the queue is empty.
+	// Empty shuffles all songs. Only songs every listener can access are shuffled.
+	Playlist string `json:"playlist"`
+}
+
+// Podcasts configures the podcast subscriptions.
+type Podcasts struct {
+	// Directory is where episodes are downloaded.
+	Directory string `json:"directory"`
+	// Download downloads new episodes when feeds are refreshed. Other episodes are
+	// streamed from their feed unless they are downloaded through the API.
+	Download bool `json:"download"`
+	// RefreshInterval is the number of hours between refreshes of the feeds.
+	RefreshInterval int `json:"refresh_interval"`
+	// Keep is the number of downloaded episodes kept for each channel. 0 keeps all.
+	Keep int `json:"keep"`
+	// MaxAge is the number of days after publication downloaded episodes are kept. 0
+	// keeps them forever.
+	MaxAge int `json:"max_age"`
+}
+
+// Audiobooks configures how audiobooks are told apart from music, besides libraries
+// with only audiobooks.
+type Audiobooks struct {
+	// Genres are the genres of audiobooks. Genres are not case sensitive.
+	Genres []string `json:"genres"`
+	// Folders are the names of directories with audiobooks. Every file below them is
+	// an audiobook. Names are not case sensitive.
+	Folders []string `json:"folders"`
+}
+
 // Configuration contains all configuration parameters.
 type Configuration struct {
 	Hostname string `json:"hostname"`
@@ -22,6 +163,70 @@ type Configuration struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 
+	Libraries []Library `json:"libraries"`
+
+	// ScanWorkers is the number of files that are probed concurrently while scanning.
+	ScanWorkers int `json:"scan_workers"`
+	// ScanBatchSize is the number of songs inserted per database transaction while scanning.
+	ScanBatchSize int `json:"scan_batch_size"`
+
+	// CoverNames are the names, without extension, of images in the directory of a song
+	// that are used as its cover, in order of preference. Names are not case sensitive.
+	CoverNames []string `json:"cover_names"`
+	// CoverPreference is CoverFolder to use an image from CoverNames over a picture
+	// embedded in the song, or CoverEmbedded for the other way around.
+	CoverPreference string `json:"cover_preference"`
+
+	// UploadMaxSize is the maximum size in bytes of an upload request, of an uploaded
+	// file or of all files extracted from an uploaded zip archive.
+	UploadMaxSize int64 `json:"upload_max_size"`
+	// UploadExpiry is the number of hours an unfinished resumable upload is kept.
+	UploadExpiry int `json:"upload_expiry"`
+
+	// ReplayGain is the gain applied to streamed songs when the client does not ask
+	// for one: ReplayGainOff, ReplayGainTrack or ReplayGainAlbum. Songs with a gain
+	// are transcoded to apply it.
+	ReplayGain string `json:"replay_gain"`
+	// DisableLoudnessAnalysis turns off measuring the loudness of songs without
+	// ReplayGain tags in the background.
+	DisableLoudnessAnalysis bool `json:"disable_loudness_analysis"`
+
+	// TranscodeProfiles are the ways songs can be transcoded. The first is the default.
+	TranscodeProfiles []TranscodeProfile `json:"transcode_profiles"`
+	// BitrateRules choose the profile and bitrate of streams. The first matching rule
+	// with a profile or default bitrate sets it, the lowest maximum of all matching
+	// rules applies.
+	BitrateRules []BitrateRule `json:"bitrate_rules"`
+	// TranscodeCacheSize is the maximum size in bytes of the cached transcodings, HLS
+	// segments included.
+	TranscodeCacheSize int64 `json:"transcode_cache_size"`
+	// TranscodeCacheAge is the number of hours a cached transcoding is kept after it was last used.
+	TranscodeCacheAge int `json:"transcode_cache_age"`
+
+	// WriteRatings writes the song ratings of the administrator into the tags of the
+	// files, in libraries that are not read only.
+	WriteRatings bool `json:"write_ratings"`
+
+	// NowPlayingTimeout is the number of seconds a client is still listed as playing after
+	// its song should have ended, or after its last heartbeat while paused.
+	NowPlayingTimeout int `json:"now_playing_timeout"`
+
+	LastFM       LastFM       `json:"lastfm"`
+	ListenBrainz ListenBrainz `json:"listenbrainz"`
+
+	Jukebox Jukebox `json:"jukebox"`
+
+	Radio []RadioStation `json:"radio"`
+
+	// RadioProxy lets clients play the streams of the radio station catalog through the
+	// server. Only the administrator changes the catalog, and streams on the server
+	// itself or its local network are refused.
+	RadioProxy bool `json:"radio_proxy"`
+
+	Podcasts Podcasts `json:"podcasts"`
+
+	Audiobooks Audiobooks `json:"audiobooks"`
+
 	Environment string `json:"environment"`
 }
 
@@ -95,10 +300,159 @@ func cleanUp(config *Configuration) error {
 		config.Environment = EnvLocal
 	}
 
+	if config.ScanWorkers <= 0 {
+		config.ScanWorkers = runtime.NumCPU()
+	}
+
+	if config.ScanBatchSize <= 0 {
+		config.ScanBatchSize = 100
+	}
+
+	// An empty list in the file turns off folder covers.
+	if config.CoverNames == nil {
+		config.CoverNames = []string{"cover", "folder", "front", "album"}
+	}
+
+	config.CoverPreference = strings.ToLower(config.CoverPreference)
+	switch config.CoverPreference {
+	case CoverFolder:
+	case CoverEmbedded:
+	case "":
+		config.CoverPreference = CoverFolder
+	default:
+		return errors.New("Cover preference must be '" + CoverFolder + "' or '" + CoverEmbedded + "'")
+	}
+
+	if config.UploadMaxSize <= 0 {
+		config.UploadMaxSize = 1 << 30
+	}
+
+	if config.UploadExpiry <= 0 {
+		config.UploadExpiry = 24
+	}
+
+	if config.TranscodeCacheSize <= 0 {
+		config.TranscodeCacheSize = 1 << 30
+	}
+
+	if config.TranscodeCacheAge <= 0 {
+		config.TranscodeCacheAge = 30 * 24
+	}
+
+	if config.NowPlayingTimeout <= 0 {
+		config.NowPlayingTimeout = 120
+	}
+
+	if len(config.LastFM.URL) == 0 {
+		config.LastFM.URL = "https://ws.audioscrobbler.com/2.0/"
+	}
+
+	if len(config.LastFM.AuthURL) == 0 {
+		config.LastFM.AuthURL = "https://www.last.fm/api/auth/"
+	}
+
+	if len(config.ListenBrainz.URL) == 0 {
+		config.ListenBrainz.URL = "https://api.listenbrainz.org"
+	}
+
+	config.Jukebox.Output = strings.ToLower(config.Jukebox.Output)
+	switch config.Jukebox.Output {
+	case "", JukeboxALSA, JukeboxPulse, JukeboxNull:
+	case JukeboxFile:
+		if len(config.Jukebox.Device) == 0 {
+			return errors.New("The jukebox file output needs a device path")
+		}
+	default:
+		return errors.New("Jukebox output must be '" + JukeboxALSA + "', '" + JukeboxPulse + "', '" + JukeboxFile + "' or '" + JukeboxNull + "'")
+	}
+
+	config.Podcasts.Directory = strings.TrimSpace(config.Podcasts.Directory)
+	if len(config.Podcasts.Directory) == 0 {
+		config.Podcasts.Directory = "podcasts"
+	}
+
+	if config.Podcasts.RefreshInterval <= 0 {
+		config.Podcasts.RefreshInterval = 6
+	}
+
+	if config.Podcasts.Keep < 0 || config.Podcasts.MaxAge < 0 {
+		return errors.New("The podcast retention can't be negative")
+	}
+
+	// Empty lists in the file turn off detection by genre or folder.
+	if config.Audiobooks.Genres == nil {
+		config.Audiobooks.Genres = []string{"Audiobook", "Audiobooks", "Audio Book", "Hörbuch"}
+	}
+
+	if config.Audiobooks.Folders == nil {
+		config.Audiobooks.Folders = []string{"Audiobooks"}
+	}
+
+	stations := map[string]bool{}
+	for i := range config.Radio {
+		station := &config.Radio[i]
+		station.Name = strings.TrimSpace(station.Name)
+		if len(station.Name) == 0 || strings.ContainsAny(station.Name, "/?#") {
+			return errors.New("Every radio station needs a name without '/', '?' or '#'")
+		}
+
+		if stations[station.Name] {
+			return errors.New("Radio station '" + station.Name + "' is defined more than once")
+		}
+		stations[station.Name] = true
+
+		if len(station.Profile) == 0 {
+			station.Profile = "mp3"
+		}
+	}
+
+	config.ReplayGain = strings.ToLower(config.ReplayGain)
+	switch config.ReplayGain {
+	case ReplayGainOff:
+	case ReplayGainTrack:
+	case ReplayGainAlbum:
+	case "":
+		config.ReplayGain = ReplayGainOff
+	default:
+		return errors.New("ReplayGain must be '" + ReplayGainOff + "', '" + ReplayGainTrack + "' or '" + ReplayGainAlbum + "'")
+	}
+
 	if len(config.Username) == 0 {
 		config.Username = "admin"
 		config.Password = ""
 	}
 
+	if len(config.Libraries) == 0 {
+		config.Libraries = []Library{
+			{Name: "media", Path: "media"},
+		}
+	}
+
+	names := map[string]bool{}
+	for i := range config.Libraries {
+		library := &config.Libraries[i]
+		library.Name = strings.TrimSpace(library.Name)
+		library.Path = filepath.Clean(strings.TrimSpace(library.Path))
+
+		if len(library.Name) == 0 {
+			return errors.New("Every library needs a name")
+		}
+
+		if names[library.Name] {
+			return errors.New("Library name '" + library.Name + "' is used more than once")
+		}
+		names[library.Name] = true
+
+		if library.Path == "." {
+			return errors.New("Library '" + library.Name + "' needs a path")
+		}
+
+		for _, pattern := range append(library.Include, library.Exclude...) {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return errors.New("Library '" + library.Name + "' has an invalid pattern: " + pattern)
+			}
+		}
+	}
+
 	return nil
 }
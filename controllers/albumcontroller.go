@@ -27,6 +27,16 @@ type songResponse struct {
 	Mime        string             `json:"mime"`
 	Cover       models.NullString  `json:"cover"`
 	Played      uint               `json:"played"`
+	Library     models.NullInt64   `json:"library"`
+	TrackGain   models.NullFloat64 `json:"track_gain"`
+	TrackPeak   models.NullFloat64 `json:"track_peak"`
+	AlbumGain   models.NullFloat64 `json:"album_gain"`
+	AlbumPeak   models.NullFloat64 `json:"album_peak"`
+	MBID        string             `json:"mbid"`
+	Audiobook   bool               `json:"audiobook"`
+	// Favorite and Rating are those of the current user.
+	Favorite bool `json:"favorite"`
+	Rating   int  `json:"rating"`
 }
 
 type imageResponse struct {
@@ -37,11 +47,16 @@ type imageResponse struct {
 	Hash string `json:"hash"`
 }
 type albumResponse struct {
-	ID    uint              `json:"id"`
-	Name  string            `json:"name"`
-	Year  models.NullInt64  `json:"year"`
-	Cover models.NullString `json:"cover"`
-	Songs []*songResponse   `json:"songs"`
+	ID    uint               `json:"id"`
+	Name  string             `json:"name"`
+	Year  models.NullInt64   `json:"year"`
+	Cover models.NullString  `json:"cover"`
+	Gain  models.NullFloat64 `json:"gain"`
+	Peak  models.NullFloat64 `json:"peak"`
+	Songs []*songResponse    `json:"songs"`
+	// Favorite and Rating are those of the current user.
+	Favorite bool `json:"favorite"`
+	Rating   int  `json:"rating"`
 }
 
 func TransformImage(image *models.Image) *imageResponse {
@@ -59,6 +74,8 @@ func TransformAlbum(album *models.Album) *albumResponse {
 	r.ID = album.ID
 	r.Name = album.Name
 	r.Year = album.Year
+	r.Gain = album.Gain
+	r.Peak = album.Peak
 
 	if album.Cover != nil {
 		r.Cover.Set(album.Cover.Link)
@@ -92,6 +109,11 @@ func TransFormSong(song *models.Song) *songResponse {
 	r.Duration = song.Duration
 	r.Mime = song.Mime
 	r.Played = song.Played
+	r.Library = song.LibraryID
+	r.TrackGain = song.TrackGain
+	r.TrackPeak = song.TrackPeak
+	r.MBID = song.MBID
+	r.Audiobook = song.Audiobook
 
 	if song.Artist != nil {
 		r.Artist.Set(song.Artist.Name)
@@ -99,6 +121,8 @@ func TransFormSong(song *models.Song) *songResponse {
 
 	if song.Album != nil {
 		r.Album.Set(song.Album.Name)
+		r.AlbumGain = song.Album.Gain
+		r.AlbumPeak = song.Album.Peak
 	}
 
 	if song.Cover != nil {
@@ -127,15 +151,34 @@ func StrToUint(s string) uint {
 type albumController struct {
 }
 
+// withoutEmptyAlbums removes albums without songs when the songs are limited to some libraries.
+func withoutEmptyAlbums(ctx echo.Context, albums []*models.Album) []*models.Album {
+	if _, filtered := libraryScope(ctx); !filtered {
+		return albums
+	}
+
+	r := []*models.Album{}
+	for _, album := range albums {
+		if len(album.Songs) != 0 {
+			r = append(r, album)
+		}
+	}
+
+	return r
+}
+
 func (c *albumController) Index(ctx echo.Context) error {
 	albums := []*models.Album{}
-	if gormDB := db.DB.Preload("Songs").Preload("Cover").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Find(&albums); gormDB.Error != nil {
+	if gormDB := preloadSongs(ctx, db.DB, "Songs").Preload("Cover").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Find(&albums); gormDB.Error != nil {
 		log.Errorf("AlbumController::Index Database failed: %v", gormDB.Error)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
+	r := TransformAlbums(withoutEmptyAlbums(ctx, albums)...)
+	loadAnnotations(ctx).albums(r)
+
 	return ctx.JSON(http.StatusOK, echo.Map{
-		"data": TransformAlbums(albums...),
+		"data": r,
 	})
 }
 
@@ -143,8 +186,8 @@ func (c *albumController) Show(ctx echo.Context) error {
 	id := StrToUint(ctx.Param("id"))
 
 	album := &models.Album{}
-	gormDB := db.DB.Preload("Songs").Preload("Cover").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").First(&album, "id = ?", id)
-	if gormDB.RecordNotFound() {
+	gormDB := preloadSongs(ctx, db.DB, "Songs").Preload("Cover").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").First(&album, "id = ?", id)
+	if gormDB.RecordNotFound() || len(withoutEmptyAlbums(ctx, []*models.Album{album})) == 0 {
 		log.Debugf("AlbumController::Show Album '%d' not found.", id)
 		return ctx.NoContent(http.StatusNotFound)
 	} else if gormDB.Error != nil {
@@ -152,15 +195,18 @@ func (c *albumController) Show(ctx echo.Context) error {
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	return ctx.JSON(http.StatusOK, TransformAlbum(album))
+	r := TransformAlbum(album)
+	loadAnnotations(ctx).albums([]*albumResponse{r})
+
+	return ctx.JSON(http.StatusOK, r)
 }
 
 func (c *albumController) Download(ctx echo.Context) error {
 	id := StrToUint(ctx.Param("id"))
 
 	album := &models.Album{}
-	gormDB := db.DB.Preload("Songs").Preload("Cover").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").First(&album, "id = ?", id)
-	if gormDB.RecordNotFound() {
+	gormDB := preloadSongs(ctx, db.DB, "Songs").Preload("Cover").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").First(&album, "id = ?", id)
+	if gormDB.RecordNotFound() || len(withoutEmptyAlbums(ctx, []*models.Album{album})) == 0 {
 		log.Debugf("AlbumController::Download Album '%d' not found.", id)
 		return ctx.NoContent(http.StatusNotFound)
 	} else if gormDB.Error != nil {
@@ -0,0 +1,407 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/ratings"
+	"github.com/cadenzr/cadenzr/scan"
+	"github.com/labstack/echo"
+)
+
+// annotatedColumns are the columns of the songs table that refer to annotated items.
+var annotatedColumns = map[string]string{
+	models.AnnotationSong:   "id",
+	models.AnnotationAlbum:  "album_id",
+	models.AnnotationArtist: "artist_id",
+}
+
+type annotationResponse struct {
+	Favorite bool       `json:"favorite"`
+	Starred  *time.Time `json:"starred"`
+	Rating   int        `json:"rating"`
+}
+
+// userAnnotations are the annotations of the user of a request by kind and item.
+type userAnnotations map[string]map[uint]*models.Annotation
+
+// loadAnnotations returns the annotations of the user of a request.
+func loadAnnotations(ctx echo.Context) userAnnotations {
+	a := userAnnotations{}
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return a
+	}
+
+	annotations := []*models.Annotation{}
+	if gormDB := db.DB.Where("user_id = ?", claim.ID).Find(&annotations); gormDB.Error != nil {
+		log.Errorf("Could not load annotations of user '%s': %v", claim.Username, gormDB.Error)
+		return a
+	}
+
+	for _, annotation := range annotations {
+		if a[annotation.ItemType] == nil {
+			a[annotation.ItemType] = map[uint]*models.Annotation{}
+		}
+		a[annotation.ItemType][annotation.ItemID] = annotation
+	}
+
+	return a
+}
+
+// get returns whether an item is a favorite and its rating.
+func (a userAnnotations) get(kind string, id uint) (bool, int) {
+	annotation, ok := a[kind][id]
+	if !ok {
+		return false, 0
+	}
+
+	return annotation.Starred != nil, annotation.Rating
+}
+
+func (a userAnnotations) songs(songs []*songResponse) {
+	for _, song := range songs {
+		song.Favorite, song.Rating = a.get(models.AnnotationSong, song.ID)
+	}
+}
+
+func (a userAnnotations) albums(albums []*albumResponse) {
+	for _, album := range albums {
+		album.Favorite, album.Rating = a.get(models.AnnotationAlbum, album.ID)
+		a.songs(album.Songs)
+	}
+}
+
+func (a userAnnotations) artists(artists []*artistResponse) {
+	for _, artist := range artists {
+		artist.Favorite, artist.Rating = a.get(models.AnnotationArtist, artist.ID)
+		a.songs(artist.Songs)
+	}
+}
+
+// visibleItems returns which of the items of a kind have songs the user of a request can access.
+func visibleItems(ctx echo.Context, kind string, ids []uint) (map[uint]bool, error) {
+	column := annotatedColumns[kind]
+
+	found := []uint{}
+	gormDB := WhereSongsInScope(ctx, db.DB.Table("songs")).Where(column+" IN (?)", ids).Pluck("DISTINCT "+column, &found)
+	if gormDB.Error != nil {
+		return nil, gormDB.Error
+	}
+
+	visible := map[uint]bool{}
+	for _, id := range found {
+		visible[id] = true
+	}
+
+	return visible, nil
+}
+
+type annotationController struct {
+}
+
+// annotation returns the annotation of the item in the request for its user, a new one
+// if the item has none. Responds itself if the item isn't found.
+func (c *annotationController) annotation(ctx echo.Context, kind string, action string) (*models.Annotation, error) {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return nil, ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	id := StrToUint(ctx.Param("id"))
+	visible, err := visibleItems(ctx, kind, []uint{id})
+	if err != nil {
+		log.Errorf("AnnotationController::%s Database failed: %v", action, err)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	} else if !visible[id] {
+		log.Debugf("AnnotationController::%s %s '%d' not found.", action, kind, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	}
+
+	annotation := &models.Annotation{}
+	gormDB := db.DB.Where(models.Annotation{UserID: claim.ID, ItemType: kind, ItemID: id}).FirstOrInit(annotation)
+	if gormDB.Error != nil {
+		log.Errorf("AnnotationController::%s Database failed: %v", action, gormDB.Error)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return annotation, nil
+}
+
+// save stores an annotation and responds with it. Annotations without a star or rating are removed.
+func (c *annotationController) save(ctx echo.Context, annotation *models.Annotation, action string) error {
+	var gormDB = db.DB
+	if annotation.Starred == nil && annotation.Rating == 0 {
+		if annotation.ID != 0 {
+			gormDB = db.DB.Unscoped().Delete(annotation)
+		}
+	} else {
+		gormDB = db.DB.Save(annotation)
+	}
+
+	if gormDB.Error != nil {
+		log.Errorf("AnnotationController::%s Database failed: %v", action, gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, &annotationResponse{
+		Favorite: annotation.Starred != nil,
+		Starred:  annotation.Starred,
+		Rating:   annotation.Rating,
+	})
+}
+
+// Star returns the action that makes an item of a kind a favorite of the user.
+func (c *annotationController) Star(kind string) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		annotation, err := c.annotation(ctx, kind, "Star")
+		if annotation == nil {
+			return err
+		}
+
+		if annotation.Starred == nil {
+			now := time.Now()
+			annotation.Starred = &now
+		}
+
+		return c.save(ctx, annotation, "Star")
+	}
+}
+
+// Unstar returns the action that removes an item of a kind from the favorites of the user.
+func (c *annotationController) Unstar(kind string) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		annotation, err := c.annotation(ctx, kind, "Unstar")
+		if annotation == nil {
+			return err
+		}
+
+		annotation.Starred = nil
+		return c.save(ctx, annotation, "Unstar")
+	}
+}
+
+// Rate returns the action that sets the rating of the user for an item of a kind, from 1
+// to 5. A rating of 0 removes it.
+func (c *annotationController) Rate(kind string) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		params := &struct {
+			Rating int `json:"rating" form:"rating"`
+		}{}
+		if err := ctx.Bind(params); err != nil || params.Rating < 0 || params.Rating > ratings.MaxRating {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "Rating must be from 0 to 5."})
+		}
+
+		return c.rate(ctx, kind, params.Rating, "Rate")
+	}
+}
+
+// Unrate returns the action that removes the rating of the user for an item of a kind.
+func (c *annotationController) Unrate(kind string) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return c.rate(ctx, kind, 0, "Unrate")
+	}
+}
+
+func (c *annotationController) rate(ctx echo.Context, kind string, rating int, action string) error {
+	annotation, err := c.annotation(ctx, kind, action)
+	if annotation == nil {
+		return err
+	}
+
+	annotation.Rating = rating
+	if kind == models.AnnotationSong && config.Config.WriteRatings && IsAdmin(ctx) {
+		ratingWrites.queue(annotation.ItemID, rating)
+	}
+
+	return c.save(ctx, annotation, action)
+}
+
+// ratingWriter writes the ratings of songs into their files one at a time, so two files
+// are never rewritten at once. Only the latest rating of a song is written, quick changes
+// can't end up in the file in the wrong order.
+type ratingWriter struct {
+	mu      sync.Mutex
+	pending map[uint]int
+	order   []uint
+	running bool
+	done    sync.WaitGroup
+}
+
+var ratingWrites = &ratingWriter{pending: map[uint]int{}}
+
+// queue writes the rating of a song after the writes that are waiting.
+func (w *ratingWriter) queue(id uint, rating int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.pending[id]; !ok {
+		w.order = append(w.order, id)
+	}
+	w.pending[id] = rating
+
+	if !w.running {
+		w.running = true
+		w.done.Add(1)
+		go w.run()
+	}
+}
+
+// run writes the queued ratings until there are none left.
+func (w *ratingWriter) run() {
+	defer w.done.Done()
+
+	for {
+		w.mu.Lock()
+		if len(w.order) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+
+		id := w.order[0]
+		w.order = w.order[1:]
+		rating := w.pending[id]
+		delete(w.pending, id)
+		w.mu.Unlock()
+
+		writeRating(id, rating)
+	}
+}
+
+// wait returns when the queued ratings are written.
+func (w *ratingWriter) wait() {
+	w.done.Wait()
+}
+
+// writeRating writes the rating of a song into its file, unless its library is read only.
+func writeRating(id uint, rating int) {
+	song := &models.Song{}
+	if gormDB := db.DB.First(song, id); gormDB.Error != nil {
+		log.Errorf("Could not load song '%d' to write its rating: %v", id, gormDB.Error)
+		return
+	}
+
+	if lib := library.Get(uint(song.LibraryID.Int64)); lib == nil || lib.ReadOnly {
+		return
+	}
+
+	if err := ratings.Write(song.Path, rating); err != nil {
+		log.WithFields(log.Fields{"file": song.Path, "reason": err.Error()}).Warn("Could not write rating.")
+		return
+	}
+
+	// Keep the hash up to date so uploads of the same file are still recognized.
+	hash, size, err := scan.HashFile(song.Path)
+	if err != nil {
+		return
+	}
+	db.DB.Table("songs").Where("id = ?", id).Updates(map[string]interface{}{"hash": hash, "size": size})
+}
+
+// Favorites returns the favorite songs, albums and artists of the user, the most
+// recently starred first.
+func (c *annotationController) Favorites(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	annotations := []*models.Annotation{}
+	if gormDB := db.DB.Where("user_id = ? AND starred IS NOT NULL", claim.ID).Order("starred DESC").Find(&annotations); gormDB.Error != nil {
+		log.Errorf("AnnotationController::Favorites Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	ids := map[string][]uint{}
+	order := map[string]map[uint]int{}
+	for i, annotation := range annotations {
+		ids[annotation.ItemType] = append(ids[annotation.ItemType], annotation.ItemID)
+		if order[annotation.ItemType] == nil {
+			order[annotation.ItemType] = map[uint]int{}
+		}
+		order[annotation.ItemType][annotation.ItemID] = i
+	}
+
+	songs := []*models.Song{}
+	albums := []*models.Album{}
+	artists := []*models.Artist{}
+	if len(ids[models.AnnotationSong]) != 0 {
+		gormDB := WhereSongsInScope(ctx, db.DB).Preload("Album").Preload("Artist").Preload("Cover").Where("id IN (?)", ids[models.AnnotationSong]).Find(&songs)
+		if gormDB.Error != nil {
+			log.Errorf("AnnotationController::Favorites Database failed: %v", gormDB.Error)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+		sort.Slice(songs, func(i, j int) bool {
+			return order[models.AnnotationSong][songs[i].ID] < order[models.AnnotationSong][songs[j].ID]
+		})
+	}
+
+	if len(ids[models.AnnotationAlbum]) != 0 {
+		visible, err := visibleItems(ctx, models.AnnotationAlbum, ids[models.AnnotationAlbum])
+		if err == nil {
+			err = db.DB.Preload("Cover").Where("id IN (?)", ids[models.AnnotationAlbum]).Find(&albums).Error
+		}
+		if err != nil {
+			log.Errorf("AnnotationController::Favorites Database failed: %v", err)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+
+		shown := []*models.Album{}
+		for _, album := range albums {
+			if visible[album.ID] {
+				shown = append(shown, album)
+			}
+		}
+		albums = shown
+		sort.Slice(albums, func(i, j int) bool {
+			return order[models.AnnotationAlbum][albums[i].ID] < order[models.AnnotationAlbum][albums[j].ID]
+		})
+	}
+
+	if len(ids[models.AnnotationArtist]) != 0 {
+		visible, err := visibleItems(ctx, models.AnnotationArtist, ids[models.AnnotationArtist])
+		if err == nil {
+			err = db.DB.Preload("Image").Preload("Links").Where("id IN (?)", ids[models.AnnotationArtist]).Find(&artists).Error
+		}
+		if err != nil {
+			log.Errorf("AnnotationController::Favorites Database failed: %v", err)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+
+		shown := []*models.Artist{}
+		for _, artist := range artists {
+			if visible[artist.ID] {
+				shown = append(shown, artist)
+			}
+		}
+		artists = shown
+		sort.Slice(artists, func(i, j int) bool {
+			return order[models.AnnotationArtist][artists[i].ID] < order[models.AnnotationArtist][artists[j].ID]
+		})
+	}
+
+	a := loadAnnotations(ctx)
+	songResponses := TransformSongs(songs...)
+	a.songs(songResponses)
+	albumResponses := TransformAlbums(albums...)
+	a.albums(albumResponses)
+	artistResponses := TransformArtists(artists...)
+	a.artists(artistResponses)
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"songs":   songResponses,
+		"albums":  albumResponses,
+		"artists": artistResponses,
+	})
+}
+
+// AnnotationController Contains the actions for favorites and ratings.
+var AnnotationController annotationController
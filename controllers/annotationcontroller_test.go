@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/dhowden/tag"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAnnotationController(t *testing.T) {
+	e := echo.New()
+
+	withDb(func() {
+		artist := &models.Artist{Name: "Artist"}
+		db.DB.Create(artist)
+		album := &models.Album{Name: "Album"}
+		db.DB.Create(album)
+		song := &models.Song{Name: "Song", Mime: "audio/mpeg", Path: "/song.mp3", Artist: artist, Album: album}
+		db.DB.Create(song)
+
+		request := func(action echo.HandlerFunc, method string, id string, userID uint, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/items/"+id, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: userID, Username: "user"}})
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		Convey("Songs, albums and artists can be starred and rated.", t, func() {
+			rec := request(AnnotationController.Star(models.AnnotationSong), echo.PUT, "1", 1, "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			response := &annotationResponse{}
+			So(json.NewDecoder(rec.Body).Decode(response), ShouldBeNil)
+			So(response.Favorite, ShouldBeTrue)
+			So(response.Starred, ShouldNotBeNil)
+
+			So(request(AnnotationController.Rate(models.AnnotationAlbum), echo.PUT, "1", 1, `{"rating": 4}`).Code, ShouldEqual, http.StatusOK)
+			So(request(AnnotationController.Star(models.AnnotationArtist), echo.PUT, "1", 1, "").Code, ShouldEqual, http.StatusOK)
+
+			So(request(AnnotationController.Rate(models.AnnotationSong), echo.PUT, "1", 1, `{"rating": 6}`).Code, ShouldEqual, http.StatusBadRequest)
+			So(request(AnnotationController.Star(models.AnnotationSong), echo.PUT, "2", 1, "").Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Responses include the annotations of the current user.", t, func() {
+			rec := request(AlbumController.Show, echo.GET, "1", 1, "")
+			album := &albumResponse{}
+			So(json.NewDecoder(rec.Body).Decode(album), ShouldBeNil)
+			So(album.Rating, ShouldEqual, 4)
+			So(album.Favorite, ShouldBeFalse)
+			So(album.Songs[0].Favorite, ShouldBeTrue)
+
+			rec = request(AlbumController.Show, echo.GET, "1", 2, "")
+			album = &albumResponse{}
+			So(json.NewDecoder(rec.Body).Decode(album), ShouldBeNil)
+			So(album.Rating, ShouldEqual, 0)
+			So(album.Songs[0].Favorite, ShouldBeFalse)
+		})
+
+		Convey("Favorites are listed per kind.", t, func() {
+			rec := request(AnnotationController.Favorites, echo.GET, "", 1, "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			favorites := struct {
+				Songs   []*songResponse   `json:"songs"`
+				Albums  []*albumResponse  `json:"albums"`
+				Artists []*artistResponse `json:"artists"`
+			}{}
+			So(json.NewDecoder(rec.Body).Decode(&favorites), ShouldBeNil)
+			So(len(favorites.Songs), ShouldEqual, 1)
+			So(len(favorites.Albums), ShouldEqual, 0)
+			So(len(favorites.Artists), ShouldEqual, 1)
+			So(favorites.Artists[0].Favorite, ShouldBeTrue)
+		})
+
+		Convey("Annotations without a star or rating are removed.", t, func() {
+			So(request(AnnotationController.Unstar(models.AnnotationSong), echo.DELETE, "1", 1, "").Code, ShouldEqual, http.StatusOK)
+			So(request(AnnotationController.Unrate(models.AnnotationAlbum), echo.DELETE, "1", 1, "").Code, ShouldEqual, http.StatusOK)
+
+			count := 0
+			db.DB.Model(&models.Annotation{}).Count(&count)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRatingWrites(t *testing.T) {
+	e := echo.New()
+
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	username := config.Config.Username
+	writeRatings := config.Config.WriteRatings
+	defer func() {
+		config.Config.Username = username
+		config.Config.WriteRatings = writeRatings
+	}()
+	config.Config.Username = "admin"
+	config.Config.WriteRatings = true
+
+	lib := library.New(config.Library{Name: "music", Path: dir})
+	lib.ID = 1
+	library.Set(lib)
+	defer library.Set()
+
+	withDb(func() {
+		path := filepath.Join(dir, "song.mp3")
+		ioutil.WriteFile(path, []byte("\xff\xfbaudio"), 0644)
+		song := &models.Song{Name: "Song", Mime: "audio/mpeg", Path: path}
+		song.LibraryID.Set(1)
+		db.DB.Create(song)
+
+		rate := func(rating string) int {
+			req := httptest.NewRequest(echo.PUT, "/api/songs/1/rating", strings.NewReader(`{"rating": `+rating+`}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: "admin"}})
+
+			So(AnnotationController.Rate(models.AnnotationSong)(c), ShouldBeNil)
+			return rec.Code
+		}
+
+		Convey("The latest rating ends up in the file.", t, func() {
+			So(rate("2"), ShouldEqual, http.StatusOK)
+			So(rate("5"), ShouldEqual, http.StatusOK)
+			ratingWrites.wait()
+
+			f, err := os.Open(path)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			m, err := tag.ReadFrom(f)
+			So(err, ShouldBeNil)
+			So(m.Raw()["POPM"], ShouldResemble, []byte("cadenzr\x00\xff"))
+		})
+	})
+}
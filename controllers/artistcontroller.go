@@ -10,10 +10,21 @@ import (
 	"github.com/labstack/echo"
 )
 
+type artistLinkResponse struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
 type artistResponse struct {
-	ID    uint            `json:"id"`
-	Name  string          `json:"name"`
-	Songs []*songResponse `json:"songs"`
+	ID        uint                  `json:"id"`
+	Name      string                `json:"name"`
+	Image     models.NullString     `json:"image"`
+	Biography models.NullString     `json:"biography"`
+	Links     []*artistLinkResponse `json:"links"`
+	Songs     []*songResponse       `json:"songs"`
+	// Favorite and Rating are those of the current user.
+	Favorite bool `json:"favorite"`
+	Rating   int  `json:"rating"`
 }
 
 func TransformArtists(artists ...*models.Artist) []*artistResponse {
@@ -30,6 +41,16 @@ func TransformArtist(artist *models.Artist) *artistResponse {
 	r := &artistResponse{}
 	r.ID = artist.ID
 	r.Name = artist.Name
+	r.Biography = artist.Biography
+
+	if artist.Image != nil {
+		r.Image.Set(artist.Image.Link)
+	}
+
+	r.Links = []*artistLinkResponse{}
+	for _, link := range artist.Links {
+		r.Links = append(r.Links, &artistLinkResponse{Name: link.Name, URL: link.URL})
+	}
 
 	if artist.Songs != nil {
 		r.Songs = TransformSongs(artist.Songs...)
@@ -43,7 +64,7 @@ type artistController struct {
 
 func (c *artistController) Index(ctx echo.Context) error {
 	artists := []*models.Artist{}
-	if gormDB := db.DB.Preload("Songs").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Find(&artists); gormDB.Error != nil {
+	if gormDB := preloadSongs(ctx, db.DB, "Songs").Preload("Image").Preload("Links").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Find(&artists); gormDB.Error != nil {
 		log.Errorf("ArtistController::Index Database failed: %v", gormDB.Error)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
@@ -52,13 +73,46 @@ func (c *artistController) Index(ctx echo.Context) error {
 	log.Println(err)
 	log.Println("Artists: ", string(data))
 
+	if _, filtered := libraryScope(ctx); filtered {
+		visible := []*models.Artist{}
+		for _, artist := range artists {
+			if len(artist.Songs) != 0 {
+				visible = append(visible, artist)
+			}
+		}
+		artists = visible
+	}
+
+	r := TransformArtists(artists...)
+	loadAnnotations(ctx).artists(r)
+
 	return ctx.JSON(http.StatusOK, echo.Map{
-		"data": TransformArtists(artists...),
+		"data": r,
 	})
 }
 
-func (c *artistController) Show(echo.Context) error {
-	panic("Not implemented")
+func (c *artistController) Show(ctx echo.Context) error {
+	id := StrToUint(ctx.Param("id"))
+
+	artist := &models.Artist{}
+	gormDB := preloadSongs(ctx, db.DB, "Songs").Preload("Image").Preload("Links").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").First(artist, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("ArtistController::Show Artist '%d' not found.", id)
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("ArtistController::Show Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if _, filtered := libraryScope(ctx); filtered && len(artist.Songs) == 0 {
+		log.Debugf("ArtistController::Show Artist '%d' not in the user's libraries.", id)
+		return ctx.NoContent(http.StatusNotFound)
+	}
+
+	r := TransformArtist(artist)
+	loadAnnotations(ctx).artists([]*artistResponse{r})
+
+	return ctx.JSON(http.StatusOK, r)
 }
 
 func (c *artistController) Create(echo.Context) error {
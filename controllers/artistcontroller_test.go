@@ -2,12 +2,15 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
 	"github.com/cadenzr/cadenzr/probers"
 	"github.com/cadenzr/cadenzr/scan"
 
@@ -69,8 +72,8 @@ func TestArtistControllerIndex(t *testing.T) {
 
 		// Add a song to the database for testing...
 		probers.Initialize()
-		go scan.ScanFilesystem("../media/0demo/Curse the Day.mp3")
-		<-scan.ScanDone
+		demo := "../media/0demo/Curse the Day.mp3"
+		scan.Scan(context.Background(), library.ForPath(demo), demo, scan.DefaultOptions())
 
 		Convey("Test index artists.", t, func() {
 
@@ -97,3 +100,43 @@ func TestArtistControllerIndex(t *testing.T) {
 		})
 	})
 }
+
+func TestArtistControllerShow(t *testing.T) {
+	e := echo.New()
+
+	withDb(func() {
+		artist := &models.Artist{Name: "Band"}
+		artist.Biography.Set("A band.")
+		artist.Image = &models.Image{Path: "images/band.jpg", Link: "/images/band.jpg", Mime: "image/jpeg", Hash: "band"}
+		db.DB.Create(artist)
+		db.DB.Create(&models.ArtistLink{ArtistID: artist.ID, Name: "band.example", URL: "https://band.example"})
+
+		show := func(id string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("get", "/api/artists/"+id, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+
+			So(ArtistController.Show(c), ShouldBeNil)
+			return rec
+		}
+
+		Convey("Artists have an image, biography and links.", t, func() {
+			rec := show("1")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			response := &artistResponse{}
+			So(json.NewDecoder(rec.Result().Body).Decode(response), ShouldBeNil)
+			So(response.Name, ShouldEqual, "Band")
+			So(response.Image.String, ShouldEqual, "/images/band.jpg")
+			So(response.Biography.String, ShouldEqual, "A band.")
+			So(len(response.Links), ShouldEqual, 1)
+			So(response.Links[0].URL, ShouldEqual, "https://band.example")
+		})
+
+		Convey("Unknown artists are not found.", t, func() {
+			So(show("2").Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,342 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+)
+
+// Playback speeds that are stored for audiobooks.
+const (
+	minBookSpeed = 0.25
+	maxBookSpeed = 4
+)
+
+type chapterResponse struct {
+	SongID uint   `json:"song_id"`
+	Title  string `json:"title"`
+	// Start and End are in seconds from the start of the song.
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+type bookPositionResponse struct {
+	SongID uint `json:"song_id"`
+	// Position is the time in seconds in the song.
+	Position float64   `json:"position"`
+	Speed    float64   `json:"speed"`
+	Updated  time.Time `json:"updated"`
+}
+
+type audiobookResponse struct {
+	ID     uint              `json:"id"`
+	Name   string            `json:"name"`
+	Author models.NullString `json:"author"`
+	Cover  models.NullString `json:"cover"`
+	// Duration is the length of all songs of the book in seconds.
+	Duration float64 `json:"duration"`
+	// Songs and Chapters are only sent for a single book, in the order they are played.
+	Songs    []*songResponse    `json:"songs,omitempty"`
+	Chapters []*chapterResponse `json:"chapters,omitempty"`
+	// Position is where the current user resumes the book, null if they didn't start it.
+	Position *bookPositionResponse `json:"position"`
+}
+
+func TransformChapters(chapters ...*models.Chapter) []*chapterResponse {
+	r := []*chapterResponse{}
+	for _, chapter := range chapters {
+		r = append(r, &chapterResponse{
+			SongID: chapter.SongID,
+			Title:  chapter.Title,
+			Start:  chapter.Start,
+			End:    chapter.End,
+		})
+	}
+
+	return r
+}
+
+func TransformBookPosition(position *models.BookPosition) *bookPositionResponse {
+	return &bookPositionResponse{
+		SongID:   position.SongID,
+		Position: position.Position,
+		Speed:    position.Speed,
+		Updated:  position.UpdatedAt,
+	}
+}
+
+// TransformAudiobook transforms an album with its audiobook songs.
+func TransformAudiobook(album *models.Album) *audiobookResponse {
+	r := &audiobookResponse{
+		ID:   album.ID,
+		Name: album.Name,
+	}
+
+	if album.Cover != nil {
+		r.Cover.Set(album.Cover.Link)
+	}
+
+	for _, song := range album.Songs {
+		if song.Artist != nil && !r.Author.Valid {
+			r.Author.Set(song.Artist.Name)
+		}
+		r.Duration += song.Duration.Float64
+	}
+
+	return r
+}
+
+type audiobookController struct {
+}
+
+// books returns the albums with audiobook songs the user can access, with those songs
+// in order. If id is not 0 only that album is returned.
+func (c *audiobookController) books(ctx echo.Context, id uint) ([]*models.Album, error) {
+	ids := []uint{}
+	query := WhereSongsInScope(ctx, db.DB.Model(&models.Song{})).Where("audiobook = ? AND album_id IS NOT NULL", true)
+	if id != 0 {
+		query = query.Where("album_id = ?", id)
+	}
+	if gormDB := query.Pluck("DISTINCT album_id", &ids); gormDB.Error != nil {
+		return nil, gormDB.Error
+	}
+
+	albums := []*models.Album{}
+	if len(ids) == 0 {
+		return albums, nil
+	}
+
+	gormDB := db.DB.Preload("Cover").Preload("Songs", func(tx *gorm.DB) *gorm.DB {
+		return WhereSongsInScope(ctx, tx).Where("audiobook = ?", true).Order("track").Order("path")
+	}).Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Where("id IN (?)", ids).Order("name").Find(&albums)
+
+	return albums, gormDB.Error
+}
+
+// positions sets the positions of the current user in the books.
+func (c *audiobookController) positions(ctx echo.Context, books []*audiobookResponse) error {
+	claim := CurrentUser(ctx)
+	if claim == nil || len(books) == 0 {
+		return nil
+	}
+
+	ids := []uint{}
+	for _, book := range books {
+		ids = append(ids, book.ID)
+	}
+
+	positions := []*models.BookPosition{}
+	if gormDB := db.DB.Where("user_id = ? AND album_id IN (?)", claim.ID, ids).Find(&positions); gormDB.Error != nil {
+		return gormDB.Error
+	}
+
+	byBook := map[uint]*models.BookPosition{}
+	for _, p := range positions {
+		byBook[p.AlbumID] = p
+	}
+
+	for _, book := range books {
+		if p, ok := byBook[book.ID]; ok {
+			book.Position = TransformBookPosition(p)
+		}
+	}
+
+	return nil
+}
+
+// book returns the book of the ':id' parameter. When there is none, the response is
+// sent and nil is returned.
+func (c *audiobookController) book(ctx echo.Context, action string) (*models.Album, error) {
+	id := StrToUint(ctx.Param("id"))
+
+	books, err := c.books(ctx, id)
+	if err != nil {
+		log.Errorf("AudiobookController::%s Database failed: %v", action, err)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if id == 0 || len(books) == 0 {
+		log.Debugf("AudiobookController::%s Audiobook '%d' not found.", action, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	}
+
+	return books[0], nil
+}
+
+// Index lists the audiobooks, without their songs.
+func (c *audiobookController) Index(ctx echo.Context) error {
+	books, err := c.books(ctx, 0)
+	if err != nil {
+		log.Errorf("AudiobookController::Index Database failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	r := []*audiobookResponse{}
+	for _, book := range books {
+		r = append(r, TransformAudiobook(book))
+	}
+
+	if err := c.positions(ctx, r); err != nil {
+		log.Errorf("AudiobookController::Index Database failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": r,
+	})
+}
+
+// Show returns an audiobook with its songs and their chapters.
+func (c *audiobookController) Show(ctx echo.Context) error {
+	book, err := c.book(ctx, "Show")
+	if book == nil {
+		return err
+	}
+
+	r := TransformAudiobook(book)
+	r.Songs = TransformSongs(book.Songs...)
+	loadAnnotations(ctx).songs(r.Songs)
+
+	ids := []uint{}
+	for _, song := range book.Songs {
+		ids = append(ids, song.ID)
+	}
+
+	chapters := []*models.Chapter{}
+	if gormDB := db.DB.Where("song_id IN (?)", ids).Order("start").Find(&chapters); gormDB.Error != nil {
+		log.Errorf("AudiobookController::Show Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	bySong := map[uint][]*models.Chapter{}
+	for _, chapter := range chapters {
+		bySong[chapter.SongID] = append(bySong[chapter.SongID], chapter)
+	}
+
+	r.Chapters = []*chapterResponse{}
+	for _, song := range book.Songs {
+		r.Chapters = append(r.Chapters, TransformChapters(bySong[song.ID]...)...)
+	}
+
+	if err := c.positions(ctx, []*audiobookResponse{r}); err != nil {
+		log.Errorf("AudiobookController::Show Database failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, r)
+}
+
+// Chapters lists the chapters of a song.
+func (c *audiobookController) Chapters(ctx echo.Context) error {
+	id := StrToUint(ctx.Param("id"))
+
+	song := &models.Song{}
+	gormDB := WhereSongsInScope(ctx, db.DB).First(song, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("AudiobookController::Chapters Song '%d' not found.", id)
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("AudiobookController::Chapters Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	chapters := []*models.Chapter{}
+	if gormDB := db.DB.Where("song_id = ?", song.ID).Order("start").Find(&chapters); gormDB.Error != nil {
+		log.Errorf("AudiobookController::Chapters Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": TransformChapters(chapters...),
+	})
+}
+
+// SavePosition stores where the user is in a book: the 'song_id' of the book, the
+// 'position' in seconds in it and the playback 'speed'. Without a speed the previous
+// one is kept, which starts at 1.
+func (c *audiobookController) SavePosition(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	book, err := c.book(ctx, "SavePosition")
+	if book == nil {
+		return err
+	}
+
+	params := &struct {
+		SongID   uint    `json:"song_id" form:"song_id"`
+		Position float64 `json:"position" form:"position"`
+		Speed    float64 `json:"speed" form:"speed"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("AudiobookController::SavePosition Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	inBook := false
+	for _, song := range book.Songs {
+		inBook = inBook || song.ID == params.SongID
+	}
+	if !inBook {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The song is not part of the audiobook."})
+	}
+
+	if params.Position < 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The position can't be negative."})
+	}
+
+	if params.Speed != 0 && (params.Speed < minBookSpeed || params.Speed > maxBookSpeed) {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The speed must be between 0.25 and 4."})
+	}
+
+	position := &models.BookPosition{}
+	if gormDB := db.DB.Where(models.BookPosition{UserID: claim.ID, AlbumID: book.ID}).FirstOrInit(position); gormDB.Error != nil {
+		log.Errorf("AudiobookController::SavePosition Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	position.SongID = params.SongID
+	position.Position = params.Position
+	if params.Speed != 0 {
+		position.Speed = params.Speed
+	} else if position.Speed == 0 {
+		position.Speed = 1
+	}
+
+	if gormDB := db.DB.Save(position); gormDB.Error != nil {
+		log.Errorf("AudiobookController::SavePosition Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, TransformBookPosition(position))
+}
+
+// DeletePosition forgets where the user is in a book, so it starts from the beginning.
+func (c *audiobookController) DeletePosition(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	book, err := c.book(ctx, "DeletePosition")
+	if book == nil {
+		return err
+	}
+
+	if gormDB := db.DB.Unscoped().Where("user_id = ? AND album_id = ?", claim.ID, book.ID).Delete(&models.BookPosition{}); gormDB.Error != nil {
+		log.Errorf("AudiobookController::DeletePosition Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// AudiobookController Contains the actions for the 'audiobooks' endpoint.
+var AudiobookController audiobookController
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAudiobookController(t *testing.T) {
+	e := echo.New()
+
+	withDb(func() {
+		author := &models.Artist{Name: "Author"}
+		db.DB.Create(author)
+		book := &models.Album{Name: "Book"}
+		db.DB.Create(book)
+		album := &models.Album{Name: "Album"}
+		db.DB.Create(album)
+
+		for i, name := range []string{"Part 2", "Part 1"} {
+			song := &models.Song{Name: name, Mime: "audio/mpeg", Path: "/book/" + name + ".mp3", Audiobook: true}
+			song.AlbumID.Set(int64(book.ID))
+			song.ArtistID.Set(int64(author.ID))
+			song.Track.Set(int64(2 - i))
+			song.Duration.Set(600)
+			db.DB.Create(song)
+			db.DB.Create(&models.Chapter{SongID: song.ID, Title: name + " end", Start: 300, End: 600})
+			db.DB.Create(&models.Chapter{SongID: song.ID, Title: name + " start", Start: 0, End: 300})
+		}
+
+		song := &models.Song{Name: "Song", Mime: "audio/mpeg", Path: "/album/song.mp3"}
+		song.AlbumID.Set(int64(album.ID))
+		db.DB.Create(song)
+
+		request := func(action echo.HandlerFunc, method string, id string, body string, userID uint) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/audiobooks", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: userID, Username: "user"}})
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		show := func(userID uint) *audiobookResponse {
+			rec := request(AudiobookController.Show, echo.GET, "1", "", userID)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r := &audiobookResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			return r
+		}
+
+		Convey("Only albums with audiobooks are listed.", t, func() {
+			rec := request(AudiobookController.Index, echo.GET, "", "", 1)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			index := &struct {
+				Data []*audiobookResponse `json:"data"`
+			}{}
+			So(json.NewDecoder(rec.Body).Decode(index), ShouldBeNil)
+			So(len(index.Data), ShouldEqual, 1)
+			So(index.Data[0].Name, ShouldEqual, "Book")
+			So(index.Data[0].Author.String, ShouldEqual, "Author")
+			So(index.Data[0].Duration, ShouldEqual, 1200)
+			So(index.Data[0].Songs, ShouldBeNil)
+			So(index.Data[0].Position, ShouldBeNil)
+
+			So(request(AudiobookController.Show, echo.GET, "2", "", 1).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Books have their songs and chapters in order.", t, func() {
+			r := show(1)
+			So(len(r.Songs), ShouldEqual, 2)
+			So(r.Songs[0].Name, ShouldEqual, "Part 1")
+			So(r.Songs[0].Audiobook, ShouldBeTrue)
+
+			titles := []string{}
+			for _, chapter := range r.Chapters {
+				titles = append(titles, chapter.Title)
+			}
+			So(titles, ShouldResemble, []string{"Part 1 start", "Part 1 end", "Part 2 start", "Part 2 end"})
+
+			rec := request(AudiobookController.Chapters, echo.GET, "1", "", 1)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldContainSubstring, "Part 2 start")
+		})
+
+		Convey("Positions and speeds are kept per user.", t, func() {
+			So(request(AudiobookController.SavePosition, echo.PUT, "1", `{"song_id": 2, "position": 42.5}`, 1).Code, ShouldEqual, http.StatusOK)
+			So(show(1).Position.Speed, ShouldEqual, 1)
+
+			So(request(AudiobookController.SavePosition, echo.PUT, "1", `{"song_id": 1, "position": 10, "speed": 1.5}`, 1).Code, ShouldEqual, http.StatusOK)
+			So(request(AudiobookController.SavePosition, echo.PUT, "1", `{"song_id": 1, "position": 20}`, 1).Code, ShouldEqual, http.StatusOK)
+			r := show(1)
+			So(r.Position.SongID, ShouldEqual, 1)
+			So(r.Position.Position, ShouldEqual, 20)
+			So(r.Position.Speed, ShouldEqual, 1.5)
+
+			So(show(2).Position, ShouldBeNil)
+
+			So(request(AudiobookController.SavePosition, echo.PUT, "1", `{"song_id": 3, "position": 1}`, 1).Code, ShouldEqual, http.StatusBadRequest)
+			So(request(AudiobookController.SavePosition, echo.PUT, "1", `{"song_id": 1, "position": 1, "speed": 10}`, 1).Code, ShouldEqual, http.StatusBadRequest)
+
+			So(request(AudiobookController.DeletePosition, echo.DELETE, "1", "", 1).Code, ShouldEqual, http.StatusNoContent)
+			So(show(1).Position, ShouldBeNil)
+		})
+	})
+}
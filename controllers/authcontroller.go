@@ -5,8 +5,10 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/cadenzr/cadenzr/config"
 	"github.com/cadenzr/cadenzr/db"
 	"github.com/cadenzr/cadenzr/log"
 	"github.com/cadenzr/cadenzr/models"
@@ -24,6 +26,58 @@ type UserLoginClaim struct {
 // Secret used for signing tokens.
 var Secret = []byte("secret")
 
+// CurrentUser returns the claim of the logged in user, or nil if the request
+// did not go through the jwt middleware.
+func CurrentUser(ctx echo.Context) *UserLoginClaim {
+	token, ok := ctx.Get("user").(*jwt.Token)
+	if !ok {
+		return nil
+	}
+
+	claim, ok := token.Claims.(*UserLoginClaim)
+	if !ok {
+		return nil
+	}
+
+	return claim
+}
+
+// optionalUser returns the claim of the logged in user on routes without the jwt
+// middleware, from a valid token in the 'token' query parameter or the Authorization
+// header. It returns nil otherwise.
+func optionalUser(ctx echo.Context) *UserLoginClaim {
+	if claim := CurrentUser(ctx); claim != nil {
+		return claim
+	}
+
+	raw := ctx.QueryParam("token")
+	if auth := ctx.Request().Header.Get(echo.HeaderAuthorization); len(raw) == 0 && strings.HasPrefix(auth, "Bearer ") {
+		raw = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	claim := &UserLoginClaim{}
+	token, err := jwt.ParseWithClaims(raw, claim, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method %v", t.Header["alg"])
+		}
+		return Secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil
+	}
+
+	return claim
+}
+
+// IsAdmin returns whether the user of a request is the administrator from the configuration.
+func IsAdmin(ctx echo.Context) bool {
+	claim := CurrentUser(ctx)
+	return claim != nil && claim.Username == config.Config.Username
+}
+
 type authController struct {
 }
 
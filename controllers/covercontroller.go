@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cadenzr/cadenzr/covers"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/labstack/echo"
+)
+
+// coverMaxAge is how long clients may use a cover without checking if it changed.
+const coverMaxAge = 24 * 60 * 60
+
+type coverController struct {
+}
+
+// Show returns the cover of an album resized to 'size' pixels in 'format' (jpeg or webp).
+// Albums without a cover get a placeholder.
+func (c *coverController) Show(ctx echo.Context) error {
+	id := StrToUint(ctx.Param("id"))
+
+	size := 0
+	if param := ctx.QueryParam("size"); len(param) != 0 {
+		var err error
+		if size, err = strconv.Atoi(param); err != nil || size <= 0 {
+			log.Debugf("CoverController::Show Invalid size '%s'.", param)
+			return ctx.NoContent(http.StatusBadRequest)
+		}
+	}
+
+	format := ctx.QueryParam("format")
+	if len(format) == 0 {
+		format = covers.JPEG
+	}
+	if !covers.ValidFormat(format) {
+		log.Debugf("CoverController::Show Invalid format '%s'.", format)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	album := &models.Album{}
+	gormDB := db.DB.Preload("Cover").First(album, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("CoverController::Show Album '%d' not found.", id)
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("CoverController::Show Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	var file string
+	var err error
+	if album.Cover != nil {
+		if file, err = covers.Thumbnail(album.Cover.Path, album.Cover.Hash, size, format); err != nil {
+			log.WithFields(log.Fields{"album": album.ID, "cover": album.Cover.Path, "reason": err.Error()}).Warn("Could not resize cover.")
+		}
+	}
+
+	if len(file) == 0 {
+		if file, err = covers.PlaceholderFile(album.Name, size, format); err != nil {
+			log.Errorf("CoverController::Show Could not create placeholder: %v", err)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	fh, err := os.Open(file)
+	if err != nil {
+		log.Errorf("CoverController::Show Could not open cover: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+	defer fh.Close()
+
+	// Cached files are named after the content of the cover, its size and format.
+	header := ctx.Response().Header()
+	header.Set(echo.HeaderContentType, covers.ContentType(format))
+	header.Set("ETag", `"`+filepath.Base(file)+`"`)
+	header.Set("Cache-Control", "public, max-age="+strconv.Itoa(coverMaxAge))
+
+	http.ServeContent(ctx.Response(), ctx.Request(), filepath.Base(file), time.Time{}, fh)
+	return nil
+}
+
+// CoverController Contains the actions for the 'covers' endpoint.
+var CoverController coverController
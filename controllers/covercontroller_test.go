@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/covers"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCoverControllerShow(t *testing.T) {
+	e := echo.New()
+
+	dir, _ := ioutil.TempDir("", "covers")
+	defer os.RemoveAll(dir)
+	covers.Dir = dir
+
+	withDb(func() {
+		album := &models.Album{Name: "No cover"}
+		db.DB.Create(album)
+
+		show := func(id string, query string, etag string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(echo.GET, "/api/covers/"+id+query, nil)
+			if len(etag) != 0 {
+				req.Header.Set("If-None-Match", etag)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+
+			So(CoverController.Show(c), ShouldBeNil)
+			return rec
+		}
+
+		Convey("Albums without a cover get a placeholder.", t, func() {
+			rec := show("1", "?size=100&format=webp", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get(echo.HeaderContentType), ShouldEqual, "image/webp")
+			So(rec.Header().Get("Cache-Control"), ShouldContainSubstring, "max-age=")
+			So(rec.Body.Len(), ShouldBeGreaterThan, 0)
+
+			etag := rec.Header().Get("ETag")
+			So(etag, ShouldEndWith, `-128.webp"`)
+
+			Convey("Unchanged covers are not sent again.", func() {
+				So(show("1", "?size=100&format=webp", etag).Code, ShouldEqual, http.StatusNotModified)
+			})
+		})
+
+		Convey("Invalid requests are refused.", t, func() {
+			So(show("1", "?format=bmp", "").Code, ShouldEqual, http.StatusBadRequest)
+			So(show("1", "?size=-1", "").Code, ShouldEqual, http.StatusBadRequest)
+			So(show("2", "", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
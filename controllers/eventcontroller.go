@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+)
+
+// keepAliveInterval is how often an idle event stream sends something so proxies don't close it.
+const keepAliveInterval = 15 * time.Second
+
+// eventRetry is the reconnection delay in milliseconds that is suggested to EventSource clients.
+const eventRetry = 3000
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Same origins as the CORS middleware, which allows everything.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscribe creates a subscription for the current user. The last seen event is
+// read from the Last-Event-ID header or 'last_event_id' query parameter and the
+// wanted event types from the comma separated 'types' query parameter.
+func subscribe(ctx echo.Context) *events.Subscription {
+	var userID uint
+	username := ""
+	if claim := CurrentUser(ctx); claim != nil {
+		userID = claim.ID
+		username = claim.Username
+	}
+
+	lastID := ctx.Request().Header.Get("Last-Event-ID")
+	if len(lastID) == 0 {
+		lastID = ctx.QueryParam("last_event_id")
+	}
+	last, _ := strconv.ParseUint(lastID, 10, 64)
+
+	types := []string{}
+	for _, typ := range strings.Split(ctx.QueryParam("types"), ",") {
+		if typ = strings.TrimSpace(typ); len(typ) != 0 {
+			types = append(types, typ)
+		}
+	}
+
+	// Events about songs are only sent to the users that can access their library.
+	allows := func(libraryID uint) bool {
+		l := library.Get(libraryID)
+		return l != nil && l.Allows(username)
+	}
+
+	return events.Default.SubscribeLibraries(userID, allows, last, types...)
+}
+
+type eventController struct {
+}
+
+// Stream sends events as Server-Sent Events.
+func (c *eventController) Stream(ctx echo.Context) error {
+	sub := subscribe(ctx)
+	defer events.Default.Unsubscribe(sub)
+
+	res := ctx.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	// Stop nginx from buffering the stream.
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(res, "retry: %d\n\n", eventRetry)
+	res.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// Dropped for being too slow. The client reconnects with its last event ID.
+				return nil
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Errorf("EventController::Stream Could not encode event: %v", err)
+				continue
+			}
+
+			if e.ID != 0 {
+				fmt.Fprintf(res, "id: %d\n", e.ID)
+			}
+			if _, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-ctx.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// WebSocket sends events as JSON messages over a websocket.
+func (c *eventController) WebSocket(ctx echo.Context) error {
+	conn, err := upgrader.Upgrade(ctx.Response(), ctx.Request(), nil)
+	if err != nil {
+		log.Debugf("EventController::WebSocket Upgrade failed: %v", err)
+		return nil
+	}
+	defer conn.Close()
+
+	sub := subscribe(ctx)
+	defer events.Default.Unsubscribe(sub)
+
+	// Clients don't send anything, but reading is needed to notice when they go away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return nil
+			}
+
+			if err := conn.WriteJSON(e); err != nil {
+				return nil
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepAliveInterval)); err != nil {
+				return nil
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+// EventController Contains the actions for the 'events' endpoint.
+var EventController eventController
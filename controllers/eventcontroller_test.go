@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/events"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEventControllerStream(t *testing.T) {
+	e := echo.New()
+
+	Convey("Events are sent as Server-Sent Events.", t, func() {
+		events.Default = events.NewBus(10)
+		events.Publish(events.SongAdded, 0, "missed")
+
+		reqCtx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest("get", "/api/events?types=song.added", nil).WithContext(reqCtx)
+		req.Header.Set("Last-Event-ID", "0")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		done := make(chan error)
+		go func() {
+			done <- EventController.Stream(c)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		events.Publish(events.PlaylistChanged, 0, "ignored")
+		events.Publish(events.SongAdded, 0, "new")
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+
+		So(<-done, ShouldBeNil)
+		body := rec.Body.String()
+		So(rec.Header().Get(echo.HeaderContentType), ShouldEqual, "text/event-stream")
+		So(body, ShouldNotContainSubstring, "missed")
+		So(body, ShouldNotContainSubstring, "ignored")
+		So(body, ShouldContainSubstring, "id: 3\nevent: song.added\n")
+		So(strings.Count(body, "event: "), ShouldEqual, 1)
+	})
+}
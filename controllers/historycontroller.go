@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/history"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/labstack/echo"
+)
+
+// defaultHistoryLimit is the number of plays returned when the client doesn't ask for a number.
+const defaultHistoryLimit = 50
+
+type playResponse struct {
+	PlayedAt time.Time     `json:"played_at"`
+	Source   string        `json:"source"`
+	Song     *songResponse `json:"song"`
+}
+
+type historyController struct {
+}
+
+// Index returns the plays of the user, most recent first. 'limit' and 'offset' page them.
+func (c *historyController) Index(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	limit, err := strconv.Atoi(ctx.QueryParam("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	offset, _ := strconv.Atoi(ctx.QueryParam("offset"))
+
+	plays := []*models.Play{}
+	gormDB := db.DB.Preload("Song").Preload("Song.Artist").Preload("Song.Album").
+		Where("user_id = ?", claim.ID).Order("played_at DESC").Limit(limit).Offset(offset).Find(&plays)
+	if gormDB.Error != nil {
+		log.Errorf("HistoryController::Index Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	a := loadAnnotations(ctx)
+	r := []*playResponse{}
+	for _, play := range plays {
+		if play.Song == nil {
+			continue
+		}
+
+		song := TransFormSong(play.Song)
+		a.songs([]*songResponse{song})
+		r = append(r, &playResponse{PlayedAt: play.PlayedAt, Source: play.Source, Song: song})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": r,
+	})
+}
+
+// Import adds the plays of another player to the history of the user. The export is the
+// body of the request, or the 'file' of a form: a Last.fm or ListenBrainz JSON export or
+// a .scrobbler.log. Plays are matched to the songs the user can access, the response
+// reports the songs that are not in the library.
+func (c *historyController) Import(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	req := ctx.Request()
+	// The form is parsed from the body too, so it is limited before either is read.
+	req.Body = http.MaxBytesReader(ctx.Response(), req.Body, config.Config.UploadMaxSize)
+	var src io.Reader = req.Body
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := ctx.FormFile("file")
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The export must be in 'file'."})
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			log.Errorf("HistoryController::Import Could not open upload: %v", err)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+		defer f.Close()
+		src = f
+	}
+
+	export, err := history.Parse(src)
+	if err != nil {
+		log.Debugf("HistoryController::Import Could not read export: %v", err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	songs := []*models.Song{}
+	if gormDB := WhereSongsInScope(ctx, db.DB).Preload("Artist").Preload("Album").Find(&songs); gormDB.Error != nil {
+		log.Errorf("HistoryController::Import Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	report, err := history.Import(claim.ID, export, history.NewMatcher(songs))
+	if err != nil {
+		log.Errorf("HistoryController::Import Failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	log.WithFields(log.Fields{"user": claim.Username, "imported": report.Imported, "unmatched": report.UnmatchedListens}).Info("Imported play history.")
+	return ctx.JSON(http.StatusOK, report)
+}
+
+// HistoryController Contains the actions for the 'history' endpoint.
+var HistoryController historyController
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/history"
+	"github.com/cadenzr/cadenzr/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHistoryController(t *testing.T) {
+	e := echo.New()
+	config.Config.UploadMaxSize = 1 << 20
+
+	withDb(func() {
+		artist := &models.Artist{Name: "Artist"}
+		db.DB.Create(artist)
+		song := &models.Song{Name: "Song", Mime: "audio/mpeg", Path: "/song.mp3", Artist: artist}
+		db.DB.Create(song)
+
+		request := func(action echo.HandlerFunc, method string, path string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 3, Username: "guest"}})
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		Convey("Exports are imported into the history of the user.", t, func() {
+			log := "#AUDIOSCROBBLER/1.1\n#TZ/UTC\nArtist\t\tSong\t1\t200\tL\t1500000000\t\nArtist\t\tUnknown\t2\t200\tL\t1500000300\t\n"
+			rec := request(HistoryController.Import, echo.POST, "/api/history/import", log)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			report := &history.Report{}
+			So(json.NewDecoder(rec.Body).Decode(report), ShouldBeNil)
+			So(report.Imported, ShouldEqual, 1)
+			So(report.UnmatchedListens, ShouldEqual, 1)
+			So(report.Unmatched[0].Title, ShouldEqual, "Unknown")
+
+			rec = request(HistoryController.Index, echo.GET, "/api/history", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			plays := struct {
+				Data []*playResponse `json:"data"`
+			}{}
+			So(json.NewDecoder(rec.Body).Decode(&plays), ShouldBeNil)
+			So(len(plays.Data), ShouldEqual, 1)
+			So(plays.Data[0].Source, ShouldEqual, models.PlayImported)
+			So(plays.Data[0].Song.Name, ShouldEqual, "Song")
+			So(plays.Data[0].PlayedAt.Unix(), ShouldEqual, 1500000000)
+		})
+
+		Convey("Unknown formats are refused.", t, func() {
+			So(request(HistoryController.Import, echo.POST, "/api/history/import", "artist,title").Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Uploads larger than the limit are refused.", t, func() {
+			body := &bytes.Buffer{}
+			form := multipart.NewWriter(body)
+			w, _ := form.CreateFormFile("file", "scrobbler.log")
+			w.Write(bytes.Repeat([]byte("#"), int(config.Config.UploadMaxSize)+1))
+			form.Close()
+
+			req := httptest.NewRequest(echo.POST, "/api/history/import", body)
+			req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 3, Username: "guest"}})
+
+			So(HistoryController.Import(c), ShouldBeNil)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "'file'")
+		})
+	})
+}
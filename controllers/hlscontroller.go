@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/hls"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/transcoders"
+	"github.com/labstack/echo"
+)
+
+type hlsController struct {
+}
+
+// withToken adds the token of the request to a link. Players don't send the query of a
+// playlist with the segments and playlists it links to.
+func withToken(ctx echo.Context, link string) string {
+	if token := ctx.QueryParam("token"); len(token) != 0 {
+		return link + "?token=" + url.QueryEscape(token)
+	}
+
+	return link
+}
+
+// hlsSource returns what the hls package needs to segment a song. The hash is part of
+// the cache key so a changed file gets new segments.
+func hlsSource(song *models.Song) *hls.Source {
+	key := strconv.Itoa(int(song.ID))
+	if len(song.Hash) >= 12 {
+		key += "-" + song.Hash[:12]
+	}
+
+	return &hls.Source{
+		Path:     song.Path,
+		Duration: song.Duration.Float64,
+		Key:      key,
+	}
+}
+
+// songTrack returns a song as a track of a media playlist in variant v.
+func songTrack(ctx echo.Context, song *models.Song, v hls.Variant) hls.Track {
+	return hls.Track{
+		Duration: song.Duration.Float64,
+		URI: func(segment int) string {
+			return withToken(ctx, "/api/songs/"+strconv.Itoa(int(song.ID))+"/hls/"+v.Name+"/"+strconv.Itoa(segment)+hls.SegmentExtension)
+		},
+	}
+}
+
+// song returns the song in the request if the user can access it and it can be segmented.
+// Responds itself if not.
+func (c *hlsController) song(ctx echo.Context, action string) (*models.Song, error) {
+	id := StrToUint(ctx.Param("id"))
+
+	song := &models.Song{}
+	gormDB := WhereSongsInScope(ctx, db.DB).First(song, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("HLSController::%s Song '%d' not found.", action, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("HLSController::%s Database failed: %v", action, gormDB.Error)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if song.Duration.Float64 <= 0 {
+		log.Debugf("HLSController::%s Song '%d' has no duration.", action, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	}
+
+	return song, nil
+}
+
+// variant returns the variant in the request. Responds itself if there is none.
+func (c *hlsController) variant(ctx echo.Context, action string) (hls.Variant, error) {
+	v, ok := hls.FindVariant(ctx.Param("variant"))
+	if !ok {
+		log.Debugf("HLSController::%s Unknown variant '%s'.", action, ctx.Param("variant"))
+		return v, ctx.NoContent(http.StatusNotFound)
+	}
+
+	return v, nil
+}
+
+// variants returns the variants the bitrate rules allow for the user and client.
+func variants(ctx echo.Context) []hls.Variant {
+	username := ""
+	if claim := CurrentUser(ctx); claim != nil {
+		username = claim.Username
+	}
+
+	client := ctx.QueryParam("client")
+	if len(client) == 0 {
+		client = ctx.Request().UserAgent()
+	}
+
+	return hls.VariantsUpTo(transcoders.MaxBitrate(username, client))
+}
+
+func playlist(ctx echo.Context, content string) error {
+	ctx.Response().Header().Set("Cache-Control", "no-cache")
+	return ctx.Blob(http.StatusOK, hls.ContentType, []byte(content))
+}
+
+// SongMaster returns the master playlist of a song, which lists its variants.
+func (c *hlsController) SongMaster(ctx echo.Context) error {
+	song, err := c.song(ctx, "SongMaster")
+	if song == nil {
+		return err
+	}
+
+	// Players load the master playlist once, when they start the song.
+	publishNowPlaying(ctx, song, 0, false)
+
+	return playlist(ctx, hls.MasterPlaylist(variants(ctx), func(v hls.Variant) string {
+		return withToken(ctx, "/api/songs/"+strconv.Itoa(int(song.ID))+"/hls/"+v.Name+"/index.m3u8")
+	}))
+}
+
+// SongPlaylist returns the segments of a song in a variant.
+func (c *hlsController) SongPlaylist(ctx echo.Context) error {
+	v, err := c.variant(ctx, "SongPlaylist")
+	if len(v.Name) == 0 {
+		return err
+	}
+
+	song, err := c.song(ctx, "SongPlaylist")
+	if song == nil {
+		return err
+	}
+
+	return playlist(ctx, hls.MediaPlaylist([]hls.Track{songTrack(ctx, song, v)}))
+}
+
+// Segment returns a segment of a song, it is transcoded when it is first requested.
+func (c *hlsController) Segment(ctx echo.Context) error {
+	v, err := c.variant(ctx, "Segment")
+	if len(v.Name) == 0 {
+		return err
+	}
+
+	name := ctx.Param("segment")
+	segment, err := strconv.Atoi(strings.TrimSuffix(name, hls.SegmentExtension))
+	if err != nil || !strings.HasSuffix(name, hls.SegmentExtension) {
+		log.Debugf("HLSController::Segment Invalid segment '%s'.", name)
+		return ctx.NoContent(http.StatusNotFound)
+	}
+
+	song, err := c.song(ctx, "Segment")
+	if song == nil {
+		return err
+	}
+
+	file, err := hls.Segment(hlsSource(song), v, segment)
+	if err == hls.ErrSegment {
+		log.Debugf("HLSController::Segment Song '%d' has no segment %d.", song.ID, segment)
+		return ctx.NoContent(http.StatusNotFound)
+	} else if err != nil {
+		log.WithFields(log.Fields{"song": song.ID, "segment": segment, "reason": err.Error()}).Error("Could not transcode segment.")
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	fh, err := os.Open(file)
+	if err != nil {
+		log.Errorf("HLSController::Segment Could not open segment: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+	defer fh.Close()
+
+	ctx.Response().Header().Set(echo.HeaderContentType, hls.SegmentContentType)
+	ctx.Response().Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(ctx.Response(), ctx.Request(), name, time.Time{}, fh)
+	return nil
+}
+
+// albumSongs returns the songs of the album in the request in track order. Responds itself if
+// there are none.
+func (c *hlsController) albumSongs(ctx echo.Context, action string) ([]*models.Song, error) {
+	id := StrToUint(ctx.Param("id"))
+
+	songs := []*models.Song{}
+	gormDB := WhereSongsInScope(ctx, db.DB).Where("album_id = ? AND duration > 0", id).Order("track, id").Find(&songs)
+	if gormDB.Error != nil {
+		log.Errorf("HLSController::%s Database failed: %v", action, gormDB.Error)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if len(songs) == 0 {
+		log.Debugf("HLSController::%s Album '%d' has no songs to stream.", action, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	}
+
+	return songs, nil
+}
+
+// AlbumMaster returns the master playlist of an album, which lists its variants.
+func (c *hlsController) AlbumMaster(ctx echo.Context) error {
+	songs, err := c.albumSongs(ctx, "AlbumMaster")
+	if songs == nil {
+		return err
+	}
+
+	id := ctx.Param("id")
+	return playlist(ctx, hls.MasterPlaylist(variants(ctx), func(v hls.Variant) string {
+		return withToken(ctx, "/api/albums/"+id+"/hls/"+v.Name+"/index.m3u8")
+	}))
+}
+
+// AlbumPlaylist returns the segments of all songs of an album in a variant.
+func (c *hlsController) AlbumPlaylist(ctx echo.Context) error {
+	v, err := c.variant(ctx, "AlbumPlaylist")
+	if len(v.Name) == 0 {
+		return err
+	}
+
+	songs, err := c.albumSongs(ctx, "AlbumPlaylist")
+	if songs == nil {
+		return err
+	}
+
+	tracks := []hls.Track{}
+	for _, song := range songs {
+		tracks = append(tracks, songTrack(ctx, song, v))
+	}
+
+	return playlist(ctx, hls.MediaPlaylist(tracks))
+}
+
+// HLSController Contains the actions for the HLS endpoints of songs and albums.
+var HLSController hlsController
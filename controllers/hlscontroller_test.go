@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/hls"
+	"github.com/cadenzr/cadenzr/models"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHLSController(t *testing.T) {
+	e := echo.New()
+
+	withDb(func() {
+		album := &models.Album{Name: "Album"}
+		db.DB.Create(album)
+
+		for _, name := range []string{"Second", "First"} {
+			song := &models.Song{Name: name, Mime: "audio/mpeg", Path: "/" + name + ".mp3"}
+			song.AlbumID.Set(int64(album.ID))
+			song.Duration.Set(15)
+			if name == "First" {
+				song.Track.Set(1)
+			} else {
+				song.Track.Set(2)
+			}
+			db.DB.Create(song)
+		}
+
+		request := func(action func(echo.Context) error, names []string, values []string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(echo.GET, "/?token=abc", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames(names...)
+			c.SetParamValues(values...)
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		Convey("Master playlists link to the variants with the token.", t, func() {
+			rec := request(HLSController.SongMaster, []string{"id"}, []string{"1"})
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get(echo.HeaderContentType), ShouldEqual, hls.ContentType)
+			So(rec.Body.String(), ShouldContainSubstring, "/api/songs/1/hls/high/index.m3u8?token=abc\n")
+		})
+
+		Convey("Album playlists contain the segments of the songs in track order.", t, func() {
+			rec := request(HLSController.AlbumPlaylist, []string{"id", "variant"}, []string{"1", "low"})
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldContainSubstring, "/api/songs/2/hls/low/1.ts?token=abc\n#EXT-X-DISCONTINUITY\n#EXTINF:10.000,\n/api/songs/1/hls/low/0.ts?token=abc\n")
+		})
+
+		Convey("Unknown songs, variants and segments are not found.", t, func() {
+			So(request(HLSController.SongMaster, []string{"id"}, []string{"3"}).Code, ShouldEqual, http.StatusNotFound)
+			So(request(HLSController.SongPlaylist, []string{"id", "variant"}, []string{"1", "lossless"}).Code, ShouldEqual, http.StatusNotFound)
+			So(request(HLSController.Segment, []string{"id", "variant", "segment"}, []string{"1", "low", "2.ts"}).Code, ShouldEqual, http.StatusNotFound)
+			So(request(HLSController.Segment, []string{"id", "variant", "segment"}, []string{"1", "low", "one.ts"}).Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,289 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/jukebox"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/labstack/echo"
+)
+
+type jukeboxResponse struct {
+	// Songs is the queue. Songs that were removed from the library, or that the user
+	// can't access, are null.
+	Songs []*songResponse `json:"songs"`
+	// Current is the index in Songs of the song that plays or plays next.
+	Current int  `json:"current"`
+	Playing bool `json:"playing"`
+	// Position is the time in seconds in the current song.
+	Position float64 `json:"position"`
+	Volume   int     `json:"volume"`
+}
+
+type jukeboxController struct {
+}
+
+// allowed checks that the jukebox is on and the user may control it. When not, the
+// response is sent and false is returned.
+func (c *jukeboxController) allowed(ctx echo.Context) (bool, error) {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return false, ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	if jukebox.Default == nil {
+		return false, ctx.JSON(http.StatusNotFound, echo.Map{"message": "The jukebox is off."})
+	}
+
+	if !IsAdmin(ctx) && !jukebox.Default.Allows(claim.Username) {
+		return false, ctx.NoContent(http.StatusForbidden)
+	}
+
+	return true, nil
+}
+
+// respond sends the state of the jukebox, or the error of a control.
+func (c *jukeboxController) respond(ctx echo.Context, action string, err error) error {
+	switch err {
+	case nil:
+	case jukebox.ErrEmptyQueue, jukebox.ErrInvalidIndex, jukebox.ErrInvalidPosition, jukebox.ErrInvalidVolume:
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	default:
+		log.Errorf("JukeboxController::%s Output failed: %v", action, err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	status := jukebox.Default.Status()
+	ids := []uint{}
+	for _, track := range status.Tracks {
+		ids = append(ids, track.SongID)
+	}
+
+	songs := []*models.Song{}
+	if len(ids) != 0 {
+		if gormDB := WhereSongsInScope(ctx, db.DB).Preload("Album").Preload("Artist").Preload("Cover").Where("id IN (?)", ids).Find(&songs); gormDB.Error != nil {
+			log.Errorf("JukeboxController::%s Database failed: %v", action, gormDB.Error)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	transformed := TransformSongs(songs...)
+	loadAnnotations(ctx).songs(transformed)
+	byID := map[uint]*songResponse{}
+	for _, song := range transformed {
+		byID[song.ID] = song
+	}
+
+	r := &jukeboxResponse{
+		Songs:    []*songResponse{},
+		Current:  status.Current,
+		Playing:  status.Playing,
+		Position: status.Position,
+		Volume:   status.Volume,
+	}
+	for _, id := range ids {
+		r.Songs = append(r.Songs, byID[id])
+	}
+
+	return ctx.JSON(http.StatusOK, r)
+}
+
+// tracks returns the tracks of songs in the scope of the user, in order. It returns nil
+// when not all songs were found.
+func (c *jukeboxController) tracks(ctx echo.Context, ids []uint) ([]*jukebox.Track, error) {
+	songs := []*models.Song{}
+	if gormDB := WhereSongsInScope(ctx, db.DB).Where("id IN (?)", ids).Find(&songs); gormDB.Error != nil {
+		return nil, gormDB.Error
+	}
+
+	byID := map[uint]*models.Song{}
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+
+	tracks := []*jukebox.Track{}
+	for _, id := range ids {
+		song, ok := byID[id]
+		if !ok {
+			return nil, nil
+		}
+
+		tracks = append(tracks, &jukebox.Track{
+			SongID:   song.ID,
+			Path:     song.Path,
+			Duration: song.Duration.Float64,
+		})
+	}
+
+	return tracks, nil
+}
+
+// bindSongs reads the 'songs' of a request as tracks. When that fails, the response is
+// sent and nil is returned.
+func (c *jukeboxController) bindSongs(ctx echo.Context, action string, params interface{}, ids *[]uint) ([]*jukebox.Track, error) {
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("JukeboxController::%s Binding params failed: %v", action, err)
+		return nil, ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if len(*ids) == 0 {
+		return []*jukebox.Track{}, nil
+	}
+
+	tracks, err := c.tracks(ctx, *ids)
+	if err != nil {
+		log.Errorf("JukeboxController::%s Database failed: %v", action, err)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	} else if tracks == nil {
+		return nil, ctx.JSON(http.StatusBadRequest, echo.Map{"message": "Not all songs were found."})
+	}
+
+	return tracks, nil
+}
+
+// Show returns the queue and what is playing.
+func (c *jukeboxController) Show(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	return c.respond(ctx, "Show", nil)
+}
+
+func (c *jukeboxController) Play(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	return c.respond(ctx, "Play", jukebox.Default.Play())
+}
+
+func (c *jukeboxController) Pause(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	jukebox.Default.Pause()
+	return c.respond(ctx, "Pause", nil)
+}
+
+// Skip moves to the song at 'index' in the queue, or to the next song without one.
+func (c *jukeboxController) Skip(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	params := &struct {
+		Index *int `json:"index" form:"index"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("JukeboxController::Skip Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if params.Index == nil {
+		return c.respond(ctx, "Skip", jukebox.Default.Next())
+	}
+
+	return c.respond(ctx, "Skip", jukebox.Default.Skip(*params.Index))
+}
+
+// Seek moves to 'position' seconds in the current song.
+func (c *jukeboxController) Seek(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	params := &struct {
+		Position float64 `json:"position" form:"position"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("JukeboxController::Seek Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	return c.respond(ctx, "Seek", jukebox.Default.Seek(params.Position))
+}
+
+// Volume sets the 'volume' from 0 to 100.
+func (c *jukeboxController) Volume(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	params := &struct {
+		Volume int `json:"volume" form:"volume"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("JukeboxController::Volume Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	return c.respond(ctx, "Volume", jukebox.Default.SetVolume(params.Volume))
+}
+
+// SetQueue replaces the queue with 'songs' and moves to the song at 'current'.
+func (c *jukeboxController) SetQueue(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	params := &struct {
+		Songs   []uint `json:"songs" form:"songs[]"`
+		Current int    `json:"current" form:"current"`
+	}{}
+	tracks, err := c.bindSongs(ctx, "SetQueue", params, &params.Songs)
+	if tracks == nil {
+		return err
+	}
+
+	return c.respond(ctx, "SetQueue", jukebox.Default.Set(tracks, params.Current))
+}
+
+// AddToQueue appends 'songs' to the queue.
+func (c *jukeboxController) AddToQueue(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	params := &struct {
+		Songs []uint `json:"songs" form:"songs[]"`
+	}{}
+	tracks, err := c.bindSongs(ctx, "AddToQueue", params, &params.Songs)
+	if tracks == nil {
+		return err
+	}
+
+	jukebox.Default.Add(tracks...)
+	return c.respond(ctx, "AddToQueue", nil)
+}
+
+// RemoveFromQueue removes the song at ':index' from the queue.
+func (c *jukeboxController) RemoveFromQueue(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	index, err := strconv.Atoi(ctx.Param("index"))
+	if err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	return c.respond(ctx, "RemoveFromQueue", jukebox.Default.Remove(index))
+}
+
+// ClearQueue stops playing and empties the queue.
+func (c *jukeboxController) ClearQueue(ctx echo.Context) error {
+	if ok, err := c.allowed(ctx); !ok {
+		return err
+	}
+
+	jukebox.Default.Clear()
+	return c.respond(ctx, "ClearQueue", nil)
+}
+
+// JukeboxController Contains the actions for the 'jukebox' endpoint.
+var JukeboxController jukeboxController
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/jukebox"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJukeboxController(t *testing.T) {
+	e := echo.New()
+	config.Config.Username = "admin"
+
+	output, err := jukebox.NewOutput(config.Jukebox{Output: config.JukeboxNull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jukebox.Default = jukebox.New(output)
+	defer func() { jukebox.Default = nil }()
+
+	withDb(func() {
+		for _, name := range []string{"One", "Two"} {
+			song := &models.Song{Name: name, Mime: "audio/mpeg", Path: "/" + name + ".mp3"}
+			song.Duration.Set(60)
+			db.DB.Create(song)
+		}
+
+		request := func(action echo.HandlerFunc, method string, body string, username string, params ...string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/jukebox", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: username}})
+			if len(params) != 0 {
+				c.SetParamNames(params[0])
+				c.SetParamValues(params[1])
+			}
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		decode := func(rec *httptest.ResponseRecorder) *jukeboxResponse {
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r := &jukeboxResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			return r
+		}
+
+		Convey("Only the administrator and jukebox users control the jukebox.", t, func() {
+			So(request(JukeboxController.Show, echo.GET, "", "user").Code, ShouldEqual, http.StatusForbidden)
+			So(request(JukeboxController.Show, echo.GET, "", "admin").Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("The queue is set and played.", t, func() {
+			r := decode(request(JukeboxController.SetQueue, echo.PUT, `{"songs": [2, 1, 2], "current": 1}`, "admin"))
+			So(len(r.Songs), ShouldEqual, 3)
+			So(r.Songs[0].Name, ShouldEqual, "Two")
+			So(r.Current, ShouldEqual, 1)
+
+			r = decode(request(JukeboxController.Play, echo.POST, "", "admin"))
+			So(r.Playing, ShouldBeTrue)
+		})
+
+		Convey("Unknown songs are refused.", t, func() {
+			So(request(JukeboxController.AddToQueue, echo.POST, `{"songs": [7]}`, "admin").Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Playback is controlled.", t, func() {
+			r := decode(request(JukeboxController.Skip, echo.POST, `{}`, "admin"))
+			So(r.Current, ShouldEqual, 2)
+
+			r = decode(request(JukeboxController.Seek, echo.POST, `{"position": 30}`, "admin"))
+			So(r.Position, ShouldBeGreaterThanOrEqualTo, 30)
+
+			So(request(JukeboxController.Volume, echo.PUT, `{"volume": 150}`, "admin").Code, ShouldEqual, http.StatusBadRequest)
+			r = decode(request(JukeboxController.Volume, echo.PUT, `{"volume": 40}`, "admin"))
+			So(r.Volume, ShouldEqual, 40)
+
+			r = decode(request(JukeboxController.Pause, echo.POST, "", "admin"))
+			So(r.Playing, ShouldBeFalse)
+		})
+
+		Convey("Songs of libraries the user can't access are null.", t, func() {
+			private := library.New(config.Library{Name: "private", Path: "/private", Users: []string{"someone"}})
+			private.ID = 1
+			open := library.New(config.Library{Name: "open", Path: "/open"})
+			open.ID = 2
+			library.Set(private, open)
+			defer library.Set()
+			db.DB.Exec("UPDATE songs SET library_id = id")
+			defer db.DB.Exec("UPDATE songs SET library_id = NULL")
+
+			r := decode(request(JukeboxController.Show, echo.GET, "", "admin"))
+			So(len(r.Songs), ShouldEqual, 3)
+			So(r.Songs[0].Name, ShouldEqual, "Two")
+			So(r.Songs[1], ShouldBeNil)
+		})
+
+		Convey("Songs are removed and the queue is cleared.", t, func() {
+			r := decode(request(JukeboxController.RemoveFromQueue, echo.DELETE, "", "admin", "index", "0"))
+			So(len(r.Songs), ShouldEqual, 2)
+			So(r.Current, ShouldEqual, 1)
+
+			r = decode(request(JukeboxController.ClearQueue, echo.DELETE, "", "admin"))
+			So(len(r.Songs), ShouldEqual, 0)
+		})
+	})
+}
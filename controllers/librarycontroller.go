@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+)
+
+type libraryResponse struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+func TransformLibraries(libs ...*library.Library) []*libraryResponse {
+	r := []*libraryResponse{}
+
+	for _, l := range libs {
+		r = append(r, &libraryResponse{
+			ID:       l.ID,
+			Name:     l.Name,
+			ReadOnly: l.ReadOnly,
+		})
+	}
+
+	return r
+}
+
+// libraryScope returns the IDs of the libraries a request may see songs from: the
+// libraries the current user can access, limited to the 'library' query parameter if
+// given. filtered is false when no libraries are set up, then all songs are visible.
+// Requests without a user only see the libraries that are open to everyone.
+func libraryScope(ctx echo.Context) (ids []uint, filtered bool) {
+	if len(library.All()) == 0 {
+		return nil, false
+	}
+
+	username := ""
+	if claim := optionalUser(ctx); claim != nil {
+		username = claim.Username
+	}
+
+	wanted := StrToUint(ctx.QueryParam("library"))
+	ids = []uint{}
+	for _, l := range library.Accessible(username) {
+		if wanted == 0 || wanted == l.ID {
+			ids = append(ids, l.ID)
+		}
+	}
+
+	return ids, true
+}
+
+// preloadSongs preloads an association with songs, limited to the library scope of the request.
+func preloadSongs(ctx echo.Context, gormDB *gorm.DB, association string) *gorm.DB {
+	if ids, filtered := libraryScope(ctx); filtered {
+		return gormDB.Preload(association, "library_id IN (?)", ids)
+	}
+
+	return gormDB.Preload(association)
+}
+
+// WhereSongsInScope limits a query on the songs table to the library scope of the request.
+func WhereSongsInScope(ctx echo.Context, gormDB *gorm.DB) *gorm.DB {
+	if ids, filtered := libraryScope(ctx); filtered {
+		return gormDB.Where("library_id IN (?)", ids)
+	}
+
+	return gormDB
+}
+
+type libraryController struct {
+}
+
+// Index lists the libraries the current user can access.
+func (c *libraryController) Index(ctx echo.Context) error {
+	username := ""
+	if claim := CurrentUser(ctx); claim != nil {
+		username = claim.Username
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": TransformLibraries(library.Accessible(username)...),
+	})
+}
+
+// LibraryController Contains the actions for the 'libraries' endpoint.
+var LibraryController libraryController
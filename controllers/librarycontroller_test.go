@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLibraryScope(t *testing.T) {
+	e := echo.New()
+
+	withDb(func() {
+		open := library.New(config.Library{Name: "open", Path: "/open"})
+		open.ID = 1
+		private := library.New(config.Library{Name: "private", Path: "/private", Users: []string{"admin"}})
+		private.ID = 2
+		library.Set(open, private)
+		defer library.Set()
+
+		for _, l := range []*library.Library{open, private} {
+			song := &models.Song{Name: l.Name + " song", Mime: "audio/mpeg", Path: l.Path + "/song.mp3"}
+			song.LibraryID.Set(int64(l.ID))
+			db.DB.Create(&models.Album{Name: l.Name + " album", Songs: []*models.Song{song}})
+		}
+
+		albums := func(username string, query string) []*albumResponse {
+			req := httptest.NewRequest("get", "/api/albums"+query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{Username: username}})
+
+			So(AlbumController.Index(c), ShouldBeNil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			response := &struct {
+				Data []*albumResponse
+			}{}
+			So(json.NewDecoder(rec.Result().Body).Decode(response), ShouldBeNil)
+			return response.Data
+		}
+
+		Convey("Users only see songs of their libraries.", t, func() {
+			So(len(albums("admin", "")), ShouldEqual, 2)
+
+			visible := albums("guest", "")
+			So(len(visible), ShouldEqual, 1)
+			So(visible[0].Name, ShouldEqual, "open album")
+			So(visible[0].Songs[0].Library.Int64, ShouldEqual, 1)
+		})
+
+		Convey("Songs can be filtered by library.", t, func() {
+			visible := albums("admin", "?library=2")
+			So(len(visible), ShouldEqual, 1)
+			So(visible[0].Name, ShouldEqual, "private album")
+
+			So(len(albums("guest", "?library=2")), ShouldEqual, 0)
+		})
+
+		Convey("Songs of restricted libraries can't be streamed or played without access.", t, func() {
+			private := &models.Song{}
+			db.DB.First(private, "library_id = ?", 2)
+			id := strconv.Itoa(int(private.ID))
+
+			songRequest := func(handler echo.HandlerFunc, method string, action string, username string) int {
+				req := httptest.NewRequest(method, "/api/songs/"+id+"/"+action, nil)
+				if len(username) != 0 {
+					token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &UserLoginClaim{Username: username}).SignedString(Secret)
+					So(err, ShouldBeNil)
+					req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+				}
+				rec := httptest.NewRecorder()
+				c := e.NewContext(req, rec)
+				c.SetParamNames("id")
+				c.SetParamValues(id)
+				So(handler(c), ShouldBeNil)
+				return rec.Code
+			}
+
+			So(songRequest(SongController.FileStream, echo.GET, "stream", ""), ShouldEqual, http.StatusNotFound)
+			So(songRequest(SongController.FileStream, echo.GET, "stream", "guest"), ShouldEqual, http.StatusNotFound)
+			So(songRequest(SongController.Played, echo.POST, "played", ""), ShouldEqual, http.StatusNotFound)
+			So(songRequest(SongController.Played, echo.POST, "played", "admin"), ShouldEqual, http.StatusOK)
+
+			stored := &models.Song{}
+			db.DB.First(stored, private.ID)
+			So(stored.Played, ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/lyrics"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/labstack/echo"
+)
+
+// maxLyricsUploadSize is the size in bytes of the largest lyrics that can be uploaded.
+const maxLyricsUploadSize = 1 << 20
+
+type lyricsLineResponse struct {
+	// Time is in seconds, like song durations.
+	Time float64 `json:"time"`
+	Text string  `json:"text"`
+}
+
+type lyricsResponse struct {
+	SongID uint                  `json:"song_id"`
+	Source string                `json:"source"`
+	Synced bool                  `json:"synced"`
+	Text   string                `json:"text"`
+	Lines  []*lyricsLineResponse `json:"lines"`
+}
+
+func TransformLyrics(l *models.Lyrics) *lyricsResponse {
+	parsed := lyrics.Parse(l.Text)
+
+	r := &lyricsResponse{}
+	r.SongID = l.SongID
+	r.Source = l.Source
+	r.Synced = parsed.Synced
+	r.Text = parsed.Text()
+	r.Lines = []*lyricsLineResponse{}
+	for _, line := range parsed.Lines {
+		r.Lines = append(r.Lines, &lyricsLineResponse{
+			Time: line.Time.Seconds(),
+			Text: line.Text,
+		})
+	}
+
+	return r
+}
+
+type lyricsController struct {
+}
+
+// song returns the song in the request if the user can access it. Responds itself if not.
+func (c *lyricsController) song(ctx echo.Context, action string) (*models.Song, error) {
+	id := StrToUint(ctx.Param("id"))
+
+	song := &models.Song{}
+	gormDB := WhereSongsInScope(ctx, db.DB).First(song, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("LyricsController::%s Song '%d' not found.", action, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("LyricsController::%s Database failed: %v", action, gormDB.Error)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return song, nil
+}
+
+// Show returns the lyrics of a song as lines, with times if they are synchronized.
+// With 'format=lrc' they are returned as LRC text instead.
+func (c *lyricsController) Show(ctx echo.Context) error {
+	song, err := c.song(ctx, "Show")
+	if song == nil {
+		return err
+	}
+
+	l := &models.Lyrics{}
+	gormDB := db.DB.First(l, "song_id = ?", song.ID)
+	if gormDB.RecordNotFound() {
+		log.Debugf("LyricsController::Show Song '%d' has no lyrics.", song.ID)
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("LyricsController::Show Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if ctx.QueryParam("format") == "lrc" {
+		return ctx.String(http.StatusOK, lyrics.Parse(l.Text).LRC())
+	}
+
+	return ctx.JSON(http.StatusOK, TransformLyrics(l))
+}
+
+// Update replaces the lyrics of a song. They are sent as 'text' in a JSON or form body,
+// as an uploaded 'file' or as a text/plain body. LRC text is stored as synchronized lyrics.
+func (c *lyricsController) Update(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("LyricsController::Update Only the administrator can edit lyrics.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	song, err := c.song(ctx, "Update")
+	if song == nil {
+		return err
+	}
+
+	text, err := readLyricsBody(ctx)
+	if err != nil {
+		log.Debugf("LyricsController::Update Could not read lyrics: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Lyrics are empty",
+		})
+	}
+
+	l := &models.Lyrics{}
+	// A map is assigned because a struct would skip Synced when it is false.
+	gormDB := db.DB.Where(models.Lyrics{SongID: song.ID}).Assign(map[string]interface{}{
+		"source": models.LyricsUser,
+		"synced": lyrics.IsLRC(text),
+		"text":   text,
+	}).FirstOrCreate(l)
+	if gormDB.Error != nil {
+		log.Errorf("LyricsController::Update Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, TransformLyrics(l))
+}
+
+// readLyricsBody returns the lyrics sent to Update.
+func readLyricsBody(ctx echo.Context) (string, error) {
+	req := ctx.Request()
+	contentType := req.Header.Get(echo.HeaderContentType)
+
+	switch {
+	case strings.HasPrefix(contentType, echo.MIMETextPlain):
+		raw, err := ioutil.ReadAll(io.LimitReader(req.Body, maxLyricsUploadSize))
+		return string(raw), err
+
+	case strings.HasPrefix(contentType, echo.MIMEMultipartForm):
+		if file, err := ctx.FormFile("file"); err == nil {
+			src, err := file.Open()
+			if err != nil {
+				return "", err
+			}
+			defer src.Close()
+
+			raw, err := ioutil.ReadAll(io.LimitReader(src, maxLyricsUploadSize))
+			return string(raw), err
+		}
+	}
+
+	params := &struct {
+		Text string `json:"text" form:"text"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		return "", err
+	}
+
+	return params.Text, nil
+}
+
+// Delete removes the lyrics of a song.
+func (c *lyricsController) Delete(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("LyricsController::Delete Only the administrator can edit lyrics.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	song, err := c.song(ctx, "Delete")
+	if song == nil {
+		return err
+	}
+
+	gormDB := db.DB.Unscoped().Where("song_id = ?", song.ID).Delete(&models.Lyrics{})
+	if gormDB.Error != nil {
+		log.Errorf("LyricsController::Delete Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	} else if gormDB.RowsAffected == 0 {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// LyricsController Contains the actions for the 'lyrics' endpoint of songs.
+var LyricsController lyricsController
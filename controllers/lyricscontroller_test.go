@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLyricsController(t *testing.T) {
+	e := echo.New()
+	config.Config.Username = "admin"
+
+	withDb(func() {
+		song := &models.Song{Name: "Song", Mime: "audio/mpeg", Path: "/song.mp3"}
+		db.DB.Create(song)
+		db.DB.Create(&models.Lyrics{SongID: song.ID, Source: models.LyricsSidecar, Synced: true, Text: "[00:02.00]Two\n[00:01.00]One"})
+		db.DB.Create(&models.Song{Name: "Instrumental", Mime: "audio/mpeg", Path: "/instrumental.mp3"})
+
+		request := func(method string, id string, username string, body string, contentType string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/songs/"+id+"/lyrics", strings.NewReader(body))
+			if len(contentType) != 0 {
+				req.Header.Set(echo.HeaderContentType, contentType)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{Username: username}})
+
+			var err error
+			switch method {
+			case echo.GET:
+				err = LyricsController.Show(c)
+			case echo.PUT:
+				err = LyricsController.Update(c)
+			case echo.DELETE:
+				err = LyricsController.Delete(c)
+			}
+			So(err, ShouldBeNil)
+			return rec
+		}
+
+		decode := func(rec *httptest.ResponseRecorder) *lyricsResponse {
+			response := &lyricsResponse{}
+			So(json.NewDecoder(rec.Result().Body).Decode(response), ShouldBeNil)
+			return response
+		}
+
+		Convey("Synchronized lyrics are returned as timed lines.", t, func() {
+			rec := request(echo.GET, "1", "guest", "", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			response := decode(rec)
+			So(response.Synced, ShouldBeTrue)
+			So(response.Source, ShouldEqual, models.LyricsSidecar)
+			So(response.Text, ShouldEqual, "One\nTwo")
+			So(len(response.Lines), ShouldEqual, 2)
+			So(response.Lines[0].Time, ShouldEqual, 1)
+		})
+
+		Convey("Songs without lyrics are not found.", t, func() {
+			So(request(echo.GET, "2", "guest", "", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Only the administrator can edit lyrics.", t, func() {
+			So(request(echo.PUT, "1", "guest", "text", echo.MIMETextPlain).Code, ShouldEqual, http.StatusForbidden)
+			So(request(echo.DELETE, "1", "guest", "", "").Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Lyrics can be replaced.", t, func() {
+			rec := request(echo.PUT, "1", "admin", `{"text": "Plain now"}`, echo.MIMEApplicationJSON)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			response := decode(request(echo.GET, "1", "guest", "", ""))
+			So(response.Synced, ShouldBeFalse)
+			So(response.Source, ShouldEqual, models.LyricsUser)
+			So(response.Text, ShouldEqual, "Plain now")
+
+			stored := &models.Lyrics{}
+			db.DB.First(stored, "song_id = ?", 1)
+			So(stored.Synced, ShouldBeFalse)
+
+			So(request(echo.PUT, "2", "admin", "[00:01.00]Hum", echo.MIMETextPlain).Code, ShouldEqual, http.StatusOK)
+			So(decode(request(echo.GET, "2", "guest", "", "")).Synced, ShouldBeTrue)
+
+			So(request(echo.PUT, "2", "admin", " ", echo.MIMETextPlain).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Lyrics can be removed.", t, func() {
+			So(request(echo.DELETE, "2", "admin", "", "").Code, ShouldEqual, http.StatusNoContent)
+			So(request(echo.GET, "2", "guest", "", "").Code, ShouldEqual, http.StatusNotFound)
+			So(request(echo.DELETE, "2", "admin", "", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
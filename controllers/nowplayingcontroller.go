@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/nowplaying"
+	"github.com/labstack/echo"
+)
+
+type nowPlayingResponse struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Client   string `json:"client"`
+	// Song is nil when it is not in the libraries of the current user.
+	Song    *songResponse `json:"song"`
+	Started time.Time     `json:"started"`
+	// Position is the estimated time in seconds in the song now.
+	Position float64   `json:"position"`
+	Paused   bool      `json:"paused"`
+	Updated  time.Time `json:"updated"`
+}
+
+type nowPlayingController struct {
+}
+
+// Index lists who is listening to what on which client, the most recent first.
+func (c *nowPlayingController) Index(ctx echo.Context) error {
+	entries := []*nowplaying.Entry{}
+	if nowplaying.Default != nil {
+		entries = nowplaying.Default.List()
+	}
+
+	ids := []uint{}
+	for _, e := range entries {
+		ids = append(ids, e.SongID)
+	}
+
+	songs := []*models.Song{}
+	if len(ids) != 0 {
+		if gormDB := WhereSongsInScope(ctx, db.DB).Preload("Album").Preload("Artist").Preload("Cover").Where("id IN (?)", ids).Find(&songs); gormDB.Error != nil {
+			log.Errorf("NowPlayingController::Index Database failed: %v", gormDB.Error)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	transformed := TransformSongs(songs...)
+	loadAnnotations(ctx).songs(transformed)
+	byID := map[uint]*songResponse{}
+	for _, song := range transformed {
+		byID[song.ID] = song
+	}
+
+	r := []*nowPlayingResponse{}
+	for _, e := range entries {
+		r = append(r, &nowPlayingResponse{
+			UserID:   e.UserID,
+			Username: e.Username,
+			Client:   e.Client,
+			Song:     byID[e.SongID],
+			Started:  e.Started,
+			Position: e.Position,
+			Paused:   e.Paused,
+			Updated:  e.Updated,
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": r,
+	})
+}
+
+// Heartbeat is sent by clients while they play a song, with the song, the position in
+// seconds and whether playback is paused.
+func (c *nowPlayingController) Heartbeat(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil || nowplaying.Default == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	params := &struct {
+		SongID   uint    `json:"song_id" form:"song_id"`
+		Position float64 `json:"position" form:"position"`
+		Paused   bool    `json:"paused" form:"paused"`
+		Client   string  `json:"client" form:"client"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("NowPlayingController::Heartbeat Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if params.Position < 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The position can't be negative."})
+	}
+
+	song := &models.Song{}
+	gormDB := WhereSongsInScope(ctx, db.DB).First(song, "id = ?", params.SongID)
+	if gormDB.RecordNotFound() {
+		log.Debugf("NowPlayingController::Heartbeat Song '%d' not found.", params.SongID)
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("NowPlayingController::Heartbeat Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if len(params.Client) == 0 {
+		params.Client = clientName(ctx)
+	}
+
+	nowplaying.Default.Update(&nowplaying.Report{
+		UserID:   claim.ID,
+		Username: claim.Username,
+		Client:   params.Client,
+		SongID:   song.ID,
+		Duration: song.Duration.Float64,
+		Position: params.Position,
+		Paused:   params.Paused,
+	})
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Stop removes the song of a client, named by 'client' or its user agent, when playback
+// stops.
+func (c *nowPlayingController) Stop(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil || nowplaying.Default == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	if !nowplaying.Default.Stop(claim.ID, clientName(ctx)) {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// NowPlayingController Contains the actions for the 'nowplaying' endpoint.
+var NowPlayingController nowPlayingController
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/nowplaying"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNowPlayingController(t *testing.T) {
+	e := echo.New()
+
+	withDb(func() {
+		song := &models.Song{Name: "One", Mime: "audio/mpeg", Path: "/One.mp3"}
+		song.Duration.Set(200)
+		db.DB.Create(song)
+
+		nowplaying.Default = nowplaying.NewRegistry(time.Minute)
+		defer func() { nowplaying.Default = nil }()
+
+		request := func(action echo.HandlerFunc, method string, target string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, target, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("User-Agent", "browser")
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: "user"}})
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		list := func() []*nowPlayingResponse {
+			rec := request(NowPlayingController.Index, echo.GET, "/api/nowplaying", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r := &struct {
+				Data []*nowPlayingResponse `json:"data"`
+			}{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			return r.Data
+		}
+
+		Convey("Heartbeats list the song of the client.", t, func() {
+			rec := request(NowPlayingController.Heartbeat, echo.POST, "/api/nowplaying", `{"song_id": 1, "position": 20, "client": "office"}`)
+			So(rec.Code, ShouldEqual, http.StatusNoContent)
+
+			r := list()
+			So(len(r), ShouldEqual, 1)
+			So(r[0].Username, ShouldEqual, "user")
+			So(r[0].Client, ShouldEqual, "office")
+			So(r[0].Song.Name, ShouldEqual, "One")
+			So(r[0].Position, ShouldBeGreaterThanOrEqualTo, 20)
+		})
+
+		Convey("Heartbeats for unknown songs are refused.", t, func() {
+			rec := request(NowPlayingController.Heartbeat, echo.POST, "/api/nowplaying", `{"song_id": 5}`)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Streams only report the song on their first request.", t, func() {
+			dir, _ := ioutil.TempDir("", "cadenzr")
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "Two.mp3")
+			ioutil.WriteFile(path, []byte("\xff\xfbaudio"), 0644)
+			db.DB.Create(&models.Song{Name: "Two", Mime: "audio/mpeg", Path: path})
+
+			stream := func(rangeHeader string) {
+				req := httptest.NewRequest(echo.GET, "/api/songs/2/stream?client=stream", nil)
+				if len(rangeHeader) != 0 {
+					req.Header.Set("Range", rangeHeader)
+				}
+				rec := httptest.NewRecorder()
+				c := e.NewContext(req, rec)
+				c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: "user"}})
+				c.SetParamNames("id")
+				c.SetParamValues("2")
+				So(SongController.FileStream(c), ShouldBeNil)
+			}
+
+			stream("bytes=4-")
+			So(len(list()), ShouldEqual, 1)
+
+			stream("bytes=0-")
+			r := list()
+			So(len(r), ShouldEqual, 2)
+			So(r[0].Client, ShouldEqual, "stream")
+
+			nowplaying.Default.Stop(1, "stream")
+		})
+
+		Convey("Clients remove their song when they stop.", t, func() {
+			So(request(NowPlayingController.Stop, echo.DELETE, "/api/nowplaying?client=office", "").Code, ShouldEqual, http.StatusNoContent)
+			So(request(NowPlayingController.Stop, echo.DELETE, "/api/nowplaying?client=office", "").Code, ShouldEqual, http.StatusNotFound)
+			So(len(list()), ShouldEqual, 0)
+		})
+	})
+}
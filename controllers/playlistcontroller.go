@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
 	"github.com/cadenzr/cadenzr/log"
 	"github.com/cadenzr/cadenzr/models"
 	"github.com/labstack/echo"
@@ -38,18 +39,40 @@ func TransformPlaylists(playlists ...*models.Playlist) []*playlistResponse {
 	return r
 }
 
+// Playlist change actions.
+const (
+	playlistCreated      = "created"
+	playlistDeleted      = "deleted"
+	playlistSongsAdded   = "songs_added"
+	playlistSongsRemoved = "songs_removed"
+)
+
+func publishPlaylistChanged(id uint, action string, songs ...uint) {
+	events.Publish(events.PlaylistChanged, 0, echo.Map{
+		"id":     id,
+		"action": action,
+		"songs":  songs,
+	})
+}
+
 type playlistController struct {
 }
 
 func (c *playlistController) Index(ctx echo.Context) error {
 	playlists := []*models.Playlist{}
-	if gormDB := db.DB.Preload("Songs").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Find(&playlists); gormDB.Error != nil {
+	if gormDB := preloadSongs(ctx, db.DB, "Songs").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Find(&playlists); gormDB.Error != nil {
 		log.Errorf("PlaylistController::Index Database failed: %v", gormDB.Error)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
+	r := TransformPlaylists(playlists...)
+	a := loadAnnotations(ctx)
+	for _, playlist := range r {
+		a.songs(playlist.Songs)
+	}
+
 	return ctx.JSON(http.StatusOK, echo.Map{
-		"data": TransformPlaylists(playlists...),
+		"data": r,
 	})
 }
 
@@ -57,7 +80,7 @@ func (c *playlistController) Show(ctx echo.Context) error {
 	id := StrToUint(ctx.Param("id"))
 
 	playlist := &models.Playlist{}
-	gormDB := db.DB.Preload("Songs").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Where("id = ?", id).Find(&playlist)
+	gormDB := preloadSongs(ctx, db.DB, "Songs").Preload("Songs.Album").Preload("Songs.Artist").Preload("Songs.Cover").Where("id = ?", id).Find(&playlist)
 
 	if gormDB.RecordNotFound() {
 		log.Debugf("PlaylistController::Show Playlist '%d' not found.", id)
@@ -67,7 +90,10 @@ func (c *playlistController) Show(ctx echo.Context) error {
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	return ctx.JSON(http.StatusOK, TransformPlaylist(playlist))
+	r := TransformPlaylist(playlist)
+	loadAnnotations(ctx).songs(r.Songs)
+
+	return ctx.JSON(http.StatusOK, r)
 }
 
 func (c *playlistController) Create(ctx echo.Context) error {
@@ -108,6 +134,7 @@ func (c *playlistController) Create(ctx echo.Context) error {
 	}
 
 	log.WithFields(log.Fields{"id": playlist.ID, "name": playlist.Name}).Info("New playlist created.")
+	publishPlaylistChanged(playlist.ID, playlistCreated)
 	return ctx.JSON(http.StatusCreated, TransformPlaylist(playlist))
 }
 
@@ -123,6 +150,7 @@ func (c *playlistController) Delete(ctx echo.Context) error {
 	}
 
 	log.WithFields(log.Fields{"id": id}).Info("Deleted playlist.")
+	publishPlaylistChanged(id, playlistDeleted)
 	return ctx.NoContent(http.StatusOK)
 }
 
@@ -160,6 +188,7 @@ func (c *playlistController) AddSongs(ctx echo.Context) error {
 	}
 
 	log.WithFields(log.Fields{"playlist": id, "songs": params.Sids}).Info("Added songs to playlist.")
+	publishPlaylistChanged(id, playlistSongsAdded, params.Sids...)
 	return ctx.NoContent(http.StatusOK)
 }
 
@@ -177,6 +206,7 @@ func (c *playlistController) DeleteSong(ctx echo.Context) error {
 	}
 
 	log.WithFields(log.Fields{"playlist": id, "song": sid}).Info("Deleted song from playlist.")
+	publishPlaylistChanged(id, playlistSongsRemoved, sid)
 	return ctx.NoContent(http.StatusOK)
 }
 
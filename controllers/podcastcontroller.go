@@ -0,0 +1,397 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/podcasts"
+	"github.com/cadenzr/cadenzr/radio"
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+)
+
+type podcastEpisodeResponse struct {
+	ID          uint      `json:"id"`
+	ChannelID   uint      `json:"channel_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Published   time.Time `json:"published"`
+	Duration    float64   `json:"duration"`
+	Mime        string    `json:"mime"`
+	Size        int64     `json:"size"`
+	Status      string    `json:"status"`
+	// Link plays the download, or redirects to the feed's audio. The token goes in its query.
+	Link string `json:"link"`
+	// Position and Played are those of the current user.
+	Position float64 `json:"position"`
+	Played   bool    `json:"played"`
+}
+
+type podcastChannelResponse struct {
+	ID          uint       `json:"id"`
+	URL         string     `json:"url"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Link        string     `json:"link"`
+	Image       string     `json:"image"`
+	Refreshed   *time.Time `json:"refreshed"`
+	Error       string     `json:"error"`
+	// Episodes are only sent for a single channel, the newest first.
+	Episodes []*podcastEpisodeResponse `json:"episodes,omitempty"`
+}
+
+func TransformPodcastEpisode(episode *models.PodcastEpisode) *podcastEpisodeResponse {
+	return &podcastEpisodeResponse{
+		ID:          episode.ID,
+		ChannelID:   episode.ChannelID,
+		Title:       episode.Title,
+		Description: episode.Description,
+		Published:   episode.Published,
+		Duration:    episode.Duration,
+		Mime:        episode.Mime,
+		Size:        episode.Size,
+		Status:      episode.Status,
+		Link:        "/api/podcasts/episodes/" + strconv.Itoa(int(episode.ID)) + "/stream",
+	}
+}
+
+func TransformPodcastChannel(channel *models.PodcastChannel) *podcastChannelResponse {
+	r := &podcastChannelResponse{
+		ID:          channel.ID,
+		URL:         channel.URL,
+		Title:       channel.Title,
+		Description: channel.Description,
+		Link:        channel.Link,
+		Image:       channel.ImageURL,
+		Refreshed:   channel.Refreshed,
+		Error:       channel.Error,
+	}
+
+	if channel.Episodes != nil {
+		r.Episodes = []*podcastEpisodeResponse{}
+		for _, episode := range channel.Episodes {
+			r.Episodes = append(r.Episodes, TransformPodcastEpisode(episode))
+		}
+	}
+
+	return r
+}
+
+type podcastController struct {
+}
+
+// manager returns the podcast manager for actions of the administrator. When the user
+// may not manage podcasts, the response is sent and nil is returned.
+func (c *podcastController) manager(ctx echo.Context, action string) (*podcasts.Manager, error) {
+	if !IsAdmin(ctx) {
+		log.Debugf("PodcastController::%s Only the administrator can manage podcasts.", action)
+		return nil, ctx.NoContent(http.StatusForbidden)
+	}
+
+	if podcasts.Default == nil {
+		return nil, ctx.JSON(http.StatusNotFound, echo.Map{"message": "Podcasts are not set up."})
+	}
+
+	return podcasts.Default, nil
+}
+
+// channel returns the channel of the ':id' parameter. When there is none, the response
+// is sent and nil is returned.
+func (c *podcastController) channel(ctx echo.Context, action string) (*models.PodcastChannel, error) {
+	id := StrToUint(ctx.Param("id"))
+
+	channel := &models.PodcastChannel{}
+	gormDB := db.DB.First(channel, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("PodcastController::%s Channel '%d' not found.", action, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("PodcastController::%s Database failed: %v", action, gormDB.Error)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return channel, nil
+}
+
+// episode returns the episode of the ':id' parameter. When there is none, the response
+// is sent and nil is returned.
+func (c *podcastController) episode(ctx echo.Context, action string) (*models.PodcastEpisode, error) {
+	id := StrToUint(ctx.Param("id"))
+
+	episode := &models.PodcastEpisode{}
+	gormDB := db.DB.First(episode, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("PodcastController::%s Episode '%d' not found.", action, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("PodcastController::%s Database failed: %v", action, gormDB.Error)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return episode, nil
+}
+
+// positions fills in the positions of the current user.
+func (c *podcastController) positions(ctx echo.Context, episodes []*podcastEpisodeResponse) error {
+	claim := CurrentUser(ctx)
+	if claim == nil || len(episodes) == 0 {
+		return nil
+	}
+
+	ids := []uint{}
+	for _, episode := range episodes {
+		ids = append(ids, episode.ID)
+	}
+
+	positions := []*models.PodcastPosition{}
+	if gormDB := db.DB.Where("user_id = ? AND episode_id IN (?)", claim.ID, ids).Find(&positions); gormDB.Error != nil {
+		return gormDB.Error
+	}
+
+	byEpisode := map[uint]*models.PodcastPosition{}
+	for _, p := range positions {
+		byEpisode[p.EpisodeID] = p
+	}
+
+	for _, episode := range episodes {
+		if p, ok := byEpisode[episode.ID]; ok {
+			episode.Position = p.Position
+			episode.Played = p.Played
+		}
+	}
+
+	return nil
+}
+
+// respond sends a channel with its episodes.
+func (c *podcastController) respond(ctx echo.Context, action string, status int, id uint) error {
+	channel := &models.PodcastChannel{}
+	gormDB := db.DB.Preload("Episodes", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("published DESC")
+	}).First(channel, "id = ?", id)
+	if gormDB.Error != nil {
+		log.Errorf("PodcastController::%s Database failed: %v", action, gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	r := TransformPodcastChannel(channel)
+	if err := c.positions(ctx, r.Episodes); err != nil {
+		log.Errorf("PodcastController::%s Database failed: %v", action, err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(status, r)
+}
+
+// Index lists the channels without their episodes.
+func (c *podcastController) Index(ctx echo.Context) error {
+	channels := []*models.PodcastChannel{}
+	if gormDB := db.DB.Order("title").Find(&channels); gormDB.Error != nil {
+		log.Errorf("PodcastController::Index Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	r := []*podcastChannelResponse{}
+	for _, channel := range channels {
+		r = append(r, TransformPodcastChannel(channel))
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": r,
+	})
+}
+
+// Show returns a channel with its episodes.
+func (c *podcastController) Show(ctx echo.Context) error {
+	channel, err := c.channel(ctx, "Show")
+	if channel == nil {
+		return err
+	}
+
+	return c.respond(ctx, "Show", http.StatusOK, channel.ID)
+}
+
+// Subscribe adds the RSS or Atom feed at 'url'.
+func (c *podcastController) Subscribe(ctx echo.Context) error {
+	m, err := c.manager(ctx, "Subscribe")
+	if m == nil {
+		return err
+	}
+
+	params := &struct {
+		URL string `json:"url" form:"url"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("PodcastController::Subscribe Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	channel, err := m.Subscribe(strings.TrimSpace(params.URL))
+	switch err {
+	case nil:
+	case podcasts.ErrSubscribed:
+		return ctx.JSON(http.StatusConflict, echo.Map{"message": err.Error()})
+	case podcasts.ErrNotFeed:
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	default:
+		log.Debugf("PodcastController::Subscribe Could not subscribe to '%s': %v", params.URL, err)
+		return ctx.JSON(http.StatusBadGateway, echo.Map{"message": err.Error()})
+	}
+
+	return c.respond(ctx, "Subscribe", http.StatusCreated, channel.ID)
+}
+
+// Unsubscribe removes a channel with its episodes and downloads.
+func (c *podcastController) Unsubscribe(ctx echo.Context) error {
+	m, err := c.manager(ctx, "Unsubscribe")
+	if m == nil {
+		return err
+	}
+
+	channel, err := c.channel(ctx, "Unsubscribe")
+	if channel == nil {
+		return err
+	}
+
+	if err := m.Unsubscribe(channel); err != nil {
+		log.Errorf("PodcastController::Unsubscribe Failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Refresh fetches the feed of a channel now.
+func (c *podcastController) Refresh(ctx echo.Context) error {
+	m, err := c.manager(ctx, "Refresh")
+	if m == nil {
+		return err
+	}
+
+	channel, err := c.channel(ctx, "Refresh")
+	if channel == nil {
+		return err
+	}
+
+	if err := m.Refresh(channel); err != nil {
+		log.Debugf("PodcastController::Refresh Could not refresh '%s': %v", channel.URL, err)
+		return ctx.JSON(http.StatusBadGateway, echo.Map{"message": err.Error()})
+	}
+
+	return c.respond(ctx, "Refresh", http.StatusOK, channel.ID)
+}
+
+// Download downloads an episode in the background.
+func (c *podcastController) Download(ctx echo.Context) error {
+	m, err := c.manager(ctx, "Download")
+	if m == nil {
+		return err
+	}
+
+	episode, err := c.episode(ctx, "Download")
+	if episode == nil {
+		return err
+	}
+
+	if episode.Status == models.EpisodeDownloaded || episode.Status == models.EpisodeDownloading {
+		return ctx.JSON(http.StatusOK, TransformPodcastEpisode(episode))
+	}
+
+	if err := m.Queue(episode); err != nil {
+		log.Errorf("PodcastController::Download Database failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	episode.Status = models.EpisodeDownloading
+
+	return ctx.JSON(http.StatusAccepted, TransformPodcastEpisode(episode))
+}
+
+// RemoveDownload deletes the download of an episode, which is streamed from its feed again.
+func (c *podcastController) RemoveDownload(ctx echo.Context) error {
+	m, err := c.manager(ctx, "RemoveDownload")
+	if m == nil {
+		return err
+	}
+
+	episode, err := c.episode(ctx, "RemoveDownload")
+	if episode == nil {
+		return err
+	}
+
+	if err := m.RemoveDownload(episode); err != nil {
+		log.Errorf("PodcastController::RemoveDownload Failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, TransformPodcastEpisode(episode))
+}
+
+// Position stores how far the user listened to an episode, as 'position' in seconds and
+// whether it was 'played' to the end.
+func (c *podcastController) Position(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	episode, err := c.episode(ctx, "Position")
+	if episode == nil {
+		return err
+	}
+
+	params := &struct {
+		Position float64 `json:"position" form:"position"`
+		Played   bool    `json:"played" form:"played"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("PodcastController::Position Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if params.Position < 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The position can't be negative."})
+	}
+
+	position := &models.PodcastPosition{}
+	if gormDB := db.DB.Where(models.PodcastPosition{UserID: claim.ID, EpisodeID: episode.ID}).FirstOrInit(position); gormDB.Error != nil {
+		log.Errorf("PodcastController::Position Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	position.Position = params.Position
+	position.Played = params.Played
+	if gormDB := db.DB.Save(position); gormDB.Error != nil {
+		log.Errorf("PodcastController::Position Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Stream plays the download of an episode, or redirects to its audio in the feed.
+func (c *podcastController) Stream(ctx echo.Context) error {
+	episode, err := c.episode(ctx, "Stream")
+	if episode == nil {
+		return err
+	}
+
+	if episode.Status != models.EpisodeDownloaded || len(episode.Path) == 0 {
+		// The address comes from the feed, only audio on the web is redirected to.
+		if !radio.IsStreamURL(episode.URL) {
+			log.Debugf("PodcastController::Stream Episode %d has no http or https address.", episode.ID)
+			return ctx.NoContent(http.StatusNotFound)
+		}
+		return ctx.Redirect(http.StatusFound, episode.URL)
+	}
+
+	return ctx.File(episode.Path)
+}
+
+// PodcastController Contains the actions for the 'podcasts' endpoint.
+var PodcastController podcastController
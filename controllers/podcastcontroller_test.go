@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/podcasts"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const podcastFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+	<title>Show</title>
+	<item>
+		<title>One</title>
+		<guid>one</guid>
+		<pubDate>Thu, 1 Jun 2017 10:00:00 +0000</pubDate>
+		<enclosure url="%s/one.mp3" length="5" type="audio/mpeg"/>
+	</item>
+</channel>
+</rss>`
+
+func TestPodcastController(t *testing.T) {
+	e := echo.New()
+	config.Config.Username = "admin"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed" {
+			fmt.Fprintf(w, podcastFeed, "http://"+r.Host)
+			return
+		}
+		fmt.Fprint(w, "audio")
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "podcasts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	podcasts.Default = podcasts.NewManager(server.Client(), dir)
+	defer func() { podcasts.Default = nil }()
+
+	withDb(func() {
+		request := func(action echo.HandlerFunc, method string, id string, body string, username string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/podcasts", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: username}})
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		decode := func(rec *httptest.ResponseRecorder) *podcastChannelResponse {
+			r := &podcastChannelResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			return r
+		}
+
+		Convey("Only the administrator subscribes to feeds.", t, func() {
+			body := `{"url": "` + server.URL + `/feed"}`
+			So(request(PodcastController.Subscribe, echo.POST, "", body, "guest").Code, ShouldEqual, http.StatusForbidden)
+
+			rec := request(PodcastController.Subscribe, echo.POST, "", body, "admin")
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			r := decode(rec)
+			So(r.Title, ShouldEqual, "Show")
+			So(len(r.Episodes), ShouldEqual, 1)
+			So(r.Episodes[0].Status, ShouldEqual, models.EpisodeNew)
+
+			So(request(PodcastController.Subscribe, echo.POST, "", body, "admin").Code, ShouldEqual, http.StatusConflict)
+			So(request(PodcastController.Subscribe, echo.POST, "", `{"url": "file:///etc/passwd"}`, "admin").Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Positions are kept per user.", t, func() {
+			So(request(PodcastController.Position, echo.PUT, "1", `{"position": 42.5}`, "guest").Code, ShouldEqual, http.StatusNoContent)
+			So(request(PodcastController.Position, echo.PUT, "1", `{"position": 60, "played": true}`, "guest").Code, ShouldEqual, http.StatusNoContent)
+			So(request(PodcastController.Position, echo.PUT, "1", `{"position": -1}`, "guest").Code, ShouldEqual, http.StatusBadRequest)
+
+			r := decode(request(PodcastController.Show, echo.GET, "1", "", "guest"))
+			So(r.Episodes[0].Position, ShouldEqual, 60)
+			So(r.Episodes[0].Played, ShouldBeTrue)
+
+			count := 0
+			db.DB.Model(&models.PodcastPosition{}).Count(&count)
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("Episodes stream from the feed until they are downloaded.", t, func() {
+			rec := request(PodcastController.Stream, echo.GET, "1", "", "guest")
+			So(rec.Code, ShouldEqual, http.StatusFound)
+			So(rec.Header().Get(echo.HeaderLocation), ShouldEqual, server.URL+"/one.mp3")
+
+			So(request(PodcastController.Download, echo.POST, "1", "", "guest").Code, ShouldEqual, http.StatusForbidden)
+			rec = request(PodcastController.Download, echo.POST, "1", "", "admin")
+			So(rec.Code, ShouldEqual, http.StatusAccepted)
+			podcasts.Default.Wait()
+
+			rec = request(PodcastController.Stream, echo.GET, "1", "", "guest")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "audio")
+
+			So(request(PodcastController.RemoveDownload, echo.DELETE, "1", "", "guest").Code, ShouldEqual, http.StatusForbidden)
+			So(request(PodcastController.RemoveDownload, echo.DELETE, "1", "", "admin").Code, ShouldEqual, http.StatusOK)
+			So(request(PodcastController.Stream, echo.GET, "1", "", "guest").Code, ShouldEqual, http.StatusFound)
+		})
+
+		Convey("Episodes are not redirected to other addresses than http or https.", t, func() {
+			db.DB.Model(&models.PodcastEpisode{}).Where("id = ?", 1).UpdateColumn("url", "javascript:alert(1)")
+			defer db.DB.Model(&models.PodcastEpisode{}).Where("id = ?", 1).UpdateColumn("url", server.URL+"/one.mp3")
+
+			So(request(PodcastController.Stream, echo.GET, "1", "", "guest").Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Unsubscribing removes the channel.", t, func() {
+			So(request(PodcastController.Unsubscribe, echo.DELETE, "1", "", "admin").Code, ShouldEqual, http.StatusNoContent)
+
+			rec := request(PodcastController.Index, echo.GET, "", "", "guest")
+			index := &struct {
+				Data []*podcastChannelResponse `json:"data"`
+			}{}
+			So(json.NewDecoder(rec.Body).Decode(index), ShouldBeNil)
+			So(len(index.Data), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+)
+
+type queueResponse struct {
+	Songs []*songResponse `json:"songs"`
+	// Current is the index in Songs of the song that is playing.
+	Current int `json:"current"`
+	// CurrentSong is the id of the song that is playing, 0 for an empty queue.
+	CurrentSong uint `json:"current_song"`
+	// Position is the time in seconds in the current song.
+	Position  float64    `json:"position"`
+	Changed   *time.Time `json:"changed"`
+	ChangedBy string     `json:"changed_by"`
+}
+
+type queueController struct {
+}
+
+// load returns the play queue of a user with its songs in order, nil if there is none.
+func (c *queueController) load(userID uint) (*models.PlayQueue, error) {
+	queue := &models.PlayQueue{}
+	gormDB := db.DB.Preload("Entries", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("`index`")
+	}).Preload("Entries.Song").Preload("Entries.Song.Album").Preload("Entries.Song.Artist").Preload("Entries.Song.Cover").First(queue, "user_id = ?", userID)
+	if gormDB.RecordNotFound() {
+		return nil, nil
+	} else if gormDB.Error != nil {
+		return nil, gormDB.Error
+	}
+
+	return queue, nil
+}
+
+// respond sends a play queue. Songs that were removed from the library are left out.
+func (c *queueController) respond(ctx echo.Context, queue *models.PlayQueue) error {
+	r := &queueResponse{Songs: []*songResponse{}}
+	if queue == nil {
+		return ctx.JSON(http.StatusOK, r)
+	}
+
+	r.Position = queue.Position
+	r.Changed = &queue.UpdatedAt
+	r.ChangedBy = queue.ChangedBy
+	for _, entry := range queue.Entries {
+		if entry.Song == nil {
+			continue
+		}
+
+		if entry.Index == queue.Current {
+			r.Current = len(r.Songs)
+			r.CurrentSong = entry.SongID
+		}
+		r.Songs = append(r.Songs, TransFormSong(entry.Song))
+	}
+	loadAnnotations(ctx).songs(r.Songs)
+
+	return ctx.JSON(http.StatusOK, r)
+}
+
+// changed tells the other clients of a user that the queue changed.
+func (c *queueController) changed(userID uint, queue *models.PlayQueue) {
+	data := echo.Map{"changed_by": "", "current": 0, "position": 0}
+	if queue != nil {
+		data = echo.Map{"changed_by": queue.ChangedBy, "current": queue.Current, "position": queue.Position}
+	}
+
+	events.Publish(events.QueueChanged, userID, data)
+}
+
+// Show returns the play queue of the user, empty if they have none.
+func (c *queueController) Show(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	queue, err := c.load(claim.ID)
+	if err != nil {
+		log.Errorf("QueueController::Show Database failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.respond(ctx, queue)
+}
+
+// Save replaces the play queue of the user, like savePlayQueue of Subsonic. The song that
+// is playing is given by its index in 'current', or else by its id in 'current_song'.
+// The client is 'client', else the 'client' query parameter or user agent. Saving no
+// songs removes the queue.
+func (c *queueController) Save(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	params := &struct {
+		Songs       []uint  `json:"songs" form:"songs[]"`
+		Current     *int    `json:"current" form:"current"`
+		CurrentSong uint    `json:"current_song" form:"current_song"`
+		Position    float64 `json:"position" form:"position"`
+		Client      string  `json:"client" form:"client"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("QueueController::Save Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if len(params.Songs) == 0 {
+		return c.Delete(ctx)
+	}
+
+	current := -1
+	if params.Current != nil {
+		current = *params.Current
+	} else if params.CurrentSong == 0 {
+		current = 0
+	} else {
+		for i, id := range params.Songs {
+			if id == params.CurrentSong {
+				current = i
+				break
+			}
+		}
+	}
+
+	if current < 0 || current >= len(params.Songs) || params.Position < 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The current song must be in the queue."})
+	}
+
+	count := 0
+	ids := map[uint]bool{}
+	for _, id := range params.Songs {
+		ids[id] = true
+	}
+	distinct := make([]uint, 0, len(ids))
+	for id := range ids {
+		distinct = append(distinct, id)
+	}
+	if gormDB := WhereSongsInScope(ctx, db.DB.Table("songs")).Where("id IN (?)", distinct).Count(&count); gormDB.Error != nil {
+		log.Errorf("QueueController::Save Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	} else if count != len(distinct) {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "Not all songs were found."})
+	}
+
+	if len(params.Client) == 0 {
+		params.Client = clientName(ctx)
+	}
+
+	tx := db.DB.Begin()
+	queue := &models.PlayQueue{}
+	if gormDB := tx.Where(models.PlayQueue{UserID: claim.ID}).FirstOrInit(queue); gormDB.Error != nil {
+		tx.Rollback()
+		log.Errorf("QueueController::Save Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	queue.Current = current
+	queue.Position = params.Position
+	queue.ChangedBy = params.Client
+	if gormDB := tx.Save(queue); gormDB.Error != nil {
+		tx.Rollback()
+		log.Errorf("QueueController::Save Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if gormDB := tx.Where("queue_id = ?", queue.ID).Delete(&models.PlayQueueEntry{}); gormDB.Error != nil {
+		tx.Rollback()
+		log.Errorf("QueueController::Save Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	for i, id := range params.Songs {
+		if gormDB := tx.Create(&models.PlayQueueEntry{QueueID: queue.ID, SongID: id, Index: i}); gormDB.Error != nil {
+			tx.Rollback()
+			log.Errorf("QueueController::Save Database failed: %v", gormDB.Error)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if gormDB := tx.Commit(); gormDB.Error != nil {
+		log.Errorf("QueueController::Save Could not commit queue: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	c.changed(claim.ID, queue)
+
+	queue, err := c.load(claim.ID)
+	if err != nil {
+		log.Errorf("QueueController::Save Database failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.respond(ctx, queue)
+}
+
+// Position moves the play queue of the user to the song at index 'current' and the time
+// 'position' in seconds, without sending the songs again. Clients call it while playing.
+func (c *queueController) Position(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	params := &struct {
+		Current  int     `json:"current" form:"current"`
+		Position float64 `json:"position" form:"position"`
+		Client   string  `json:"client" form:"client"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("QueueController::Position Binding params failed: %v", err)
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	queue := &models.PlayQueue{}
+	gormDB := db.DB.First(queue, "user_id = ?", claim.ID)
+	if gormDB.RecordNotFound() {
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("QueueController::Position Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	count := 0
+	db.DB.Model(&models.PlayQueueEntry{}).Where("queue_id = ?", queue.ID).Count(&count)
+	if params.Current < 0 || params.Current >= count || params.Position < 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The current song must be in the queue."})
+	}
+
+	if len(params.Client) == 0 {
+		params.Client = clientName(ctx)
+	}
+
+	queue.Current = params.Current
+	queue.Position = params.Position
+	queue.ChangedBy = params.Client
+	if gormDB := db.DB.Save(queue); gormDB.Error != nil {
+		log.Errorf("QueueController::Position Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	c.changed(claim.ID, queue)
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Delete removes the play queue of the user.
+func (c *queueController) Delete(ctx echo.Context) error {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	queue := &models.PlayQueue{}
+	gormDB := db.DB.First(queue, "user_id = ?", claim.ID)
+	if gormDB.RecordNotFound() {
+		return ctx.NoContent(http.StatusNoContent)
+	} else if gormDB.Error != nil {
+		log.Errorf("QueueController::Delete Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	db.DB.Where("queue_id = ?", queue.ID).Delete(&models.PlayQueueEntry{})
+	if gormDB := db.DB.Unscoped().Delete(queue); gormDB.Error != nil {
+		log.Errorf("QueueController::Delete Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	c.changed(claim.ID, nil)
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// QueueController Contains the actions for the 'queue' endpoint.
+var QueueController queueController
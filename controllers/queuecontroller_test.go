@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQueueController(t *testing.T) {
+	e := echo.New()
+
+	withDb(func() {
+		for _, name := range []string{"One", "Two", "Three"} {
+			db.DB.Create(&models.Song{Name: name, Mime: "audio/mpeg", Path: "/" + name + ".mp3"})
+		}
+
+		request := func(action echo.HandlerFunc, method string, body string, client string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/queue", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("User-Agent", client)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: "user"}})
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		show := func() *queueResponse {
+			rec := request(QueueController.Show, echo.GET, "", "phone")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r := &queueResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			return r
+		}
+
+		Convey("Users without a queue get an empty one.", t, func() {
+			r := show()
+			So(len(r.Songs), ShouldEqual, 0)
+			So(r.Changed, ShouldBeNil)
+		})
+
+		Convey("A saved queue is resumed on another client.", t, func() {
+			s := events.Default.Subscribe(1, 0, events.QueueChanged)
+			defer events.Default.Unsubscribe(s)
+
+			rec := request(QueueController.Save, echo.PUT, `{"songs": [3, 1, 3], "current_song": 1, "position": 12.5}`, "desktop")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So((<-s.C).Data.(echo.Map)["changed_by"], ShouldEqual, "desktop")
+
+			r := show()
+			So(len(r.Songs), ShouldEqual, 3)
+			So(r.Songs[0].Name, ShouldEqual, "Three")
+			So(r.Current, ShouldEqual, 1)
+			So(r.CurrentSong, ShouldEqual, 1)
+			So(r.Position, ShouldEqual, 12.5)
+			So(r.ChangedBy, ShouldEqual, "desktop")
+			So(r.Changed, ShouldNotBeNil)
+		})
+
+		Convey("The position moves without sending the songs.", t, func() {
+			So(request(QueueController.Position, echo.PUT, `{"current": 2, "position": 3, "client": "phone"}`, "").Code, ShouldEqual, http.StatusNoContent)
+
+			r := show()
+			So(r.Current, ShouldEqual, 2)
+			So(r.CurrentSong, ShouldEqual, 3)
+			So(r.Position, ShouldEqual, 3)
+			So(r.ChangedBy, ShouldEqual, "phone")
+
+			So(request(QueueController.Position, echo.PUT, `{"current": 3}`, "").Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Invalid queues are refused.", t, func() {
+			So(request(QueueController.Save, echo.PUT, `{"songs": [1, 4]}`, "").Code, ShouldEqual, http.StatusBadRequest)
+			So(request(QueueController.Save, echo.PUT, `{"songs": [1], "current": 1}`, "").Code, ShouldEqual, http.StatusBadRequest)
+			So(len(show().Songs), ShouldEqual, 3)
+		})
+
+		Convey("Saving an empty queue removes it.", t, func() {
+			So(request(QueueController.Save, echo.PUT, `{"songs": []}`, "").Code, ShouldEqual, http.StatusNoContent)
+			So(len(show().Songs), ShouldEqual, 0)
+
+			count := -1
+			db.DB.Model(&models.PlayQueueEntry{}).Count(&count)
+			So(count, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/radio"
+	"github.com/labstack/echo"
+)
+
+type radioResponse struct {
+	Name string `json:"name"`
+	// Link is the address of the stream, the token goes in its query.
+	Link        string `json:"link"`
+	ContentType string `json:"content_type"`
+	// Song is what is playing, nil when nothing is or the user can't access it.
+	Song    *songResponse `json:"song"`
+	Started *time.Time    `json:"started"`
+	// Queue is what plays next. Songs that were removed from the library, or that the
+	// user can't access, are null.
+	Queue     []*songResponse `json:"queue"`
+	Listeners int             `json:"listeners"`
+}
+
+type radioController struct {
+}
+
+// station returns the station of the ':station' parameter. When there is none, the
+// response is sent and nil is returned.
+func (c *radioController) station(ctx echo.Context, action string) (*radio.Station, error) {
+	name := ctx.Param("station")
+	s, ok := radio.Find(name)
+	if !ok {
+		log.Debugf("RadioController::%s Station '%s' not found.", action, name)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	}
+
+	return s, nil
+}
+
+// transform returns the state of a station with its songs.
+func (c *radioController) transform(ctx echo.Context, s *radio.Station) (*radioResponse, error) {
+	current, queue, listeners := s.Status()
+	ids := append([]uint{}, queue...)
+	if current != nil {
+		ids = append(ids, current.SongID)
+	}
+
+	songs := []*models.Song{}
+	if len(ids) != 0 {
+		if gormDB := WhereSongsInScope(ctx, db.DB).Preload("Album").Preload("Artist").Preload("Cover").Where("id IN (?)", ids).Find(&songs); gormDB.Error != nil {
+			return nil, gormDB.Error
+		}
+	}
+
+	transformed := TransformSongs(songs...)
+	loadAnnotations(ctx).songs(transformed)
+	byID := map[uint]*songResponse{}
+	for _, song := range transformed {
+		byID[song.ID] = song
+	}
+
+	r := &radioResponse{
+		Name:        s.Name,
+		Link:        "/radio/" + s.Name,
+		ContentType: s.Profile.ContentType(),
+		Queue:       []*songResponse{},
+		Listeners:   listeners,
+	}
+	if current != nil {
+		r.Song = byID[current.SongID]
+		r.Started = &current.Started
+	}
+	for _, id := range queue {
+		r.Queue = append(r.Queue, byID[id])
+	}
+
+	return r, nil
+}
+
+func (c *radioController) respond(ctx echo.Context, action string, s *radio.Station) error {
+	r, err := c.transform(ctx, s)
+	if err != nil {
+		log.Errorf("RadioController::%s Database failed: %v", action, err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, r)
+}
+
+// Index lists the stations with what they play.
+func (c *radioController) Index(ctx echo.Context) error {
+	r := []*radioResponse{}
+	for _, s := range radio.All() {
+		station, err := c.transform(ctx, s)
+		if err != nil {
+			log.Errorf("RadioController::Index Database failed: %v", err)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+		r = append(r, station)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": r,
+	})
+}
+
+func (c *radioController) Show(ctx echo.Context) error {
+	s, err := c.station(ctx, "Show")
+	if s == nil {
+		return err
+	}
+
+	return c.respond(ctx, "Show", s)
+}
+
+// songs reads the 'songs' of a request and checks they are in the scope of the user.
+// When that fails, the response is sent and nil is returned.
+func (c *radioController) songs(ctx echo.Context, action string) ([]uint, error) {
+	params := &struct {
+		Songs []uint `json:"songs" form:"songs[]"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("RadioController::%s Binding params failed: %v", action, err)
+		return nil, ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if len(params.Songs) == 0 {
+		return []uint{}, nil
+	}
+
+	count := 0
+	if gormDB := WhereSongsInScope(ctx, db.DB.Table("songs")).Where("id IN (?)", params.Songs).Count(&count); gormDB.Error != nil {
+		log.Errorf("RadioController::%s Database failed: %v", action, gormDB.Error)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	distinct := map[uint]bool{}
+	for _, id := range params.Songs {
+		distinct[id] = true
+	}
+	if count != len(distinct) {
+		return nil, ctx.JSON(http.StatusBadRequest, echo.Map{"message": "Not all songs were found."})
+	}
+
+	return params.Songs, nil
+}
+
+// SetQueue replaces the queue of a station with 'songs'.
+func (c *radioController) SetQueue(ctx echo.Context) error {
+	s, err := c.station(ctx, "SetQueue")
+	if s == nil {
+		return err
+	}
+
+	ids, err := c.songs(ctx, "SetQueue")
+	if ids == nil {
+		return err
+	}
+
+	s.SetQueue(ids)
+	return c.respond(ctx, "SetQueue", s)
+}
+
+// Enqueue adds 'songs' to the queue of a station.
+func (c *radioController) Enqueue(ctx echo.Context) error {
+	s, err := c.station(ctx, "Enqueue")
+	if s == nil {
+		return err
+	}
+
+	ids, err := c.songs(ctx, "Enqueue")
+	if ids == nil {
+		return err
+	}
+
+	s.Enqueue(ids...)
+	return c.respond(ctx, "Enqueue", s)
+}
+
+// Skip moves a station on to the next song.
+func (c *radioController) Skip(ctx echo.Context) error {
+	s, err := c.station(ctx, "Skip")
+	if s == nil {
+		return err
+	}
+
+	s.Skip()
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Stream sends the live stream of a station until the listener goes away. Players that
+// send 'Icy-MetaData: 1' get the title of each song in the stream.
+func (c *radioController) Stream(ctx echo.Context) error {
+	s, err := c.station(ctx, "Stream")
+	if s == nil {
+		return err
+	}
+
+	// The station only plays songs that every listener can access.
+	username := ""
+	if claim := CurrentUser(ctx); claim != nil {
+		username = claim.Username
+	}
+
+	l := s.Listen(username)
+	defer s.Leave(l)
+
+	res := ctx.Response()
+	res.Header().Set(echo.HeaderContentType, s.Profile.ContentType())
+	res.Header().Set("Cache-Control", "no-cache, no-store")
+	res.Header().Set("icy-name", s.Name)
+	if s.Profile.Bitrate != 0 {
+		res.Header().Set("icy-br", strconv.Itoa(s.Profile.Bitrate))
+	}
+
+	var w io.Writer = res
+	if ctx.Request().Header.Get("Icy-MetaData") == "1" {
+		res.Header().Set("icy-metaint", strconv.Itoa(radio.MetaInt))
+		w = radio.NewICYWriter(res, s.Title)
+	}
+
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	for {
+		select {
+		case chunk, ok := <-l.C:
+			if !ok {
+				// The listener fell behind, it can tune in again.
+				return nil
+			}
+
+			if _, err := w.Write(chunk); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-ctx.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// RadioController Contains the actions for the 'radio' endpoint.
+var RadioController radioController
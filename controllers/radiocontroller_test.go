@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/radio"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRadioController(t *testing.T) {
+	e := echo.New()
+
+	if err := radio.Setup([]config.RadioStation{{Name: "office", Profile: "mp3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	withDb(func() {
+		for _, name := range []string{"One", "Two"} {
+			db.DB.Create(&models.Song{Name: name, Mime: "audio/mpeg", Path: "/" + name + ".mp3"})
+		}
+
+		request := func(action echo.HandlerFunc, method string, station string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/radio/"+station, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: "user"}})
+			c.SetParamNames("station")
+			c.SetParamValues(station)
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		Convey("Stations are listed with their stream.", t, func() {
+			rec := request(RadioController.Show, echo.GET, "office", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r := &radioResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			So(r.Link, ShouldEqual, "/radio/office")
+			So(r.ContentType, ShouldEqual, "audio/mpeg")
+			So(r.Song, ShouldBeNil)
+
+			So(request(RadioController.Show, echo.GET, "other", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Songs are queued on a station.", t, func() {
+			rec := request(RadioController.Enqueue, echo.POST, "office", `{"songs": [2, 1]}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r := &radioResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			So(len(r.Queue), ShouldEqual, 2)
+			So(r.Queue[0].Name, ShouldEqual, "Two")
+
+			So(request(RadioController.Enqueue, echo.POST, "office", `{"songs": [9]}`).Code, ShouldEqual, http.StatusBadRequest)
+
+			rec = request(RadioController.SetQueue, echo.PUT, "office", `{"songs": []}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r = &radioResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			So(len(r.Queue), ShouldEqual, 0)
+		})
+
+		Convey("Songs of libraries the user can't access are null.", t, func() {
+			private := library.New(config.Library{Name: "private", Path: "/", Users: []string{"admin"}})
+			private.ID = 1
+			library.Set(private)
+			defer library.Set()
+			db.DB.Model(&models.Song{}).Where("id = ?", 1).UpdateColumn("library_id", 1)
+
+			s, _ := radio.Find("office")
+			s.SetQueue([]uint{1})
+			defer s.SetQueue(nil)
+
+			rec := request(RadioController.Show, echo.GET, "office", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r := &radioResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			So(len(r.Queue), ShouldEqual, 1)
+			So(r.Queue[0], ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,340 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/radio"
+	"github.com/labstack/echo"
+)
+
+type radioStationResponse struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	StreamURL   string `json:"stream_url"`
+	HomepageURL string `json:"homepage_url"`
+	LogoURL     string `json:"logo_url"`
+	// Proxy is the address of the stream through the server, empty when that is off.
+	Proxy string `json:"proxy"`
+}
+
+func TransformRadioStation(station *models.RadioStation) *radioStationResponse {
+	r := &radioStationResponse{
+		ID:          station.ID,
+		Name:        station.Name,
+		StreamURL:   station.StreamURL,
+		HomepageURL: station.HomepageURL,
+		LogoURL:     station.LogoURL,
+	}
+
+	if config.Config.RadioProxy {
+		r.Proxy = "/api/radiostations/" + strconv.Itoa(int(station.ID)) + "/stream"
+	}
+
+	return r
+}
+
+func TransformRadioStations(stations ...*models.RadioStation) []*radioStationResponse {
+	r := []*radioStationResponse{}
+
+	for _, station := range stations {
+		r = append(r, TransformRadioStation(station))
+	}
+
+	return r
+}
+
+// radioStationImport is the result of importing a station list.
+type radioStationImport struct {
+	Imported   int                     `json:"imported"`
+	Duplicates int                     `json:"duplicates"`
+	Stations   []*radioStationResponse `json:"stations"`
+}
+
+// proxyClient only connects to public addresses.
+var proxyClient = radio.NewProxyClient()
+
+type radioStationController struct {
+}
+
+// station returns the station of the ':id' parameter. When there is none, the response
+// is sent and nil is returned.
+func (c *radioStationController) station(ctx echo.Context, action string) (*models.RadioStation, error) {
+	id := StrToUint(ctx.Param("id"))
+
+	station := &models.RadioStation{}
+	gormDB := db.DB.First(station, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("RadioStationController::%s Station '%d' not found.", action, id)
+		return nil, ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("RadioStationController::%s Database failed: %v", action, gormDB.Error)
+		return nil, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return station, nil
+}
+
+// bind reads 'name', 'stream_url', 'homepage_url' and 'logo_url' into a station. When
+// they are invalid, the response is sent and false is returned.
+func (c *radioStationController) bind(ctx echo.Context, action string, station *models.RadioStation) (bool, error) {
+	params := &struct {
+		Name        string `json:"name" form:"name"`
+		StreamURL   string `json:"stream_url" form:"stream_url"`
+		HomepageURL string `json:"homepage_url" form:"homepage_url"`
+		LogoURL     string `json:"logo_url" form:"logo_url"`
+	}{}
+	if err := ctx.Bind(params); err != nil {
+		log.Debugf("RadioStationController::%s Binding params failed: %v", action, err)
+		return false, ctx.NoContent(http.StatusBadRequest)
+	}
+
+	station.Name = strings.TrimSpace(params.Name)
+	station.StreamURL = strings.TrimSpace(params.StreamURL)
+	station.HomepageURL = strings.TrimSpace(params.HomepageURL)
+	station.LogoURL = strings.TrimSpace(params.LogoURL)
+
+	if len(station.Name) == 0 {
+		return false, ctx.JSON(http.StatusBadRequest, echo.Map{"message": "A station needs a name."})
+	}
+
+	if !radio.IsStreamURL(station.StreamURL) {
+		return false, ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The stream must be an http or https address."})
+	}
+
+	for _, u := range []string{station.HomepageURL, station.LogoURL} {
+		if len(u) != 0 && !radio.IsStreamURL(u) {
+			return false, ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The homepage and logo must be http or https addresses."})
+		}
+	}
+
+	return true, nil
+}
+
+// save stores a station. A stream that is in the catalog already is a conflict. When
+// the station is not stored, the response is sent and false is returned.
+func (c *radioStationController) save(ctx echo.Context, action string, station *models.RadioStation) (bool, error) {
+	count := 0
+	if gormDB := db.DB.Model(&models.RadioStation{}).Where("stream_url = ? AND id <> ?", station.StreamURL, station.ID).Count(&count); gormDB.Error != nil {
+		log.Errorf("RadioStationController::%s Database failed: %v", action, gormDB.Error)
+		return false, ctx.NoContent(http.StatusInternalServerError)
+	} else if count != 0 {
+		return false, ctx.JSON(http.StatusConflict, echo.Map{"message": "The stream is in the catalog already."})
+	}
+
+	if gormDB := db.DB.Save(station); gormDB.Error != nil {
+		log.Errorf("RadioStationController::%s Database failed: %v", action, gormDB.Error)
+		return false, ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return true, nil
+}
+
+func (c *radioStationController) Index(ctx echo.Context) error {
+	stations := []*models.RadioStation{}
+	if gormDB := db.DB.Order("name").Find(&stations); gormDB.Error != nil {
+		log.Errorf("RadioStationController::Index Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": TransformRadioStations(stations...),
+	})
+}
+
+func (c *radioStationController) Show(ctx echo.Context) error {
+	station, err := c.station(ctx, "Show")
+	if station == nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, TransformRadioStation(station))
+}
+
+func (c *radioStationController) Create(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("RadioStationController::Create Only the administrator can change the catalog.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	station := &models.RadioStation{}
+	if ok, err := c.bind(ctx, "Create", station); !ok {
+		return err
+	}
+
+	if ok, err := c.save(ctx, "Create", station); !ok {
+		return err
+	}
+
+	return ctx.JSON(http.StatusCreated, TransformRadioStation(station))
+}
+
+func (c *radioStationController) Update(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("RadioStationController::Update Only the administrator can change the catalog.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	station, err := c.station(ctx, "Update")
+	if station == nil {
+		return err
+	}
+
+	if ok, err := c.bind(ctx, "Update", station); !ok {
+		return err
+	}
+
+	if ok, err := c.save(ctx, "Update", station); !ok {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, TransformRadioStation(station))
+}
+
+func (c *radioStationController) Delete(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("RadioStationController::Delete Only the administrator can change the catalog.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	station, err := c.station(ctx, "Delete")
+	if station == nil {
+		return err
+	}
+
+	// The stream can be added again, so the row is not kept.
+	if gormDB := db.DB.Unscoped().Delete(station); gormDB.Error != nil {
+		log.Errorf("RadioStationController::Delete Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Import adds the streams of an M3U or PLS list, sent as the body or as an uploaded
+// 'file'. Streams that are in the catalog already are skipped.
+func (c *radioStationController) Import(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("RadioStationController::Import Only the administrator can change the catalog.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	req := ctx.Request()
+	// The form is parsed from the body too, so it is limited before either is read.
+	req.Body = http.MaxBytesReader(ctx.Response(), req.Body, config.Config.UploadMaxSize)
+	var src io.Reader = req.Body
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := ctx.FormFile("file")
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "The list must be in 'file'."})
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			log.Errorf("RadioStationController::Import Could not open upload: %v", err)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+		defer f.Close()
+		src = f
+	}
+
+	listed, err := radio.ParseStationList(src)
+	if err != nil {
+		log.Debugf("RadioStationController::Import Could not read list: %v", err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	r := &radioStationImport{Stations: []*radioStationResponse{}}
+	tx := db.DB.Begin()
+	for _, l := range listed {
+		count := 0
+		if gormDB := tx.Model(&models.RadioStation{}).Where("stream_url = ?", l.StreamURL).Count(&count); gormDB.Error != nil {
+			tx.Rollback()
+			log.Errorf("RadioStationController::Import Database failed: %v", gormDB.Error)
+			return ctx.NoContent(http.StatusInternalServerError)
+		} else if count != 0 {
+			r.Duplicates++
+			continue
+		}
+
+		station := &models.RadioStation{Name: l.Name, StreamURL: l.StreamURL}
+		if radio.IsStreamURL(l.LogoURL) {
+			station.LogoURL = l.LogoURL
+		}
+		if gormDB := tx.Create(station); gormDB.Error != nil {
+			tx.Rollback()
+			log.Errorf("RadioStationController::Import Database failed: %v", gormDB.Error)
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+
+		r.Imported++
+		r.Stations = append(r.Stations, TransformRadioStation(station))
+	}
+
+	if gormDB := tx.Commit(); gormDB.Error != nil {
+		log.Errorf("RadioStationController::Import Could not commit stations: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, r)
+}
+
+// Stream plays a station through the server, when the radio proxy is on. ICY metadata
+// is passed on to players that ask for it. Streams at addresses that are not public
+// are refused.
+func (c *radioStationController) Stream(ctx echo.Context) error {
+	if !config.Config.RadioProxy {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"message": "The radio proxy is off."})
+	}
+
+	station, err := c.station(ctx, "Stream")
+	if station == nil {
+		return err
+	}
+
+	req, err := http.NewRequest(echo.GET, station.StreamURL, nil)
+	if err != nil {
+		log.Errorf("RadioStationController::Stream Invalid stream address '%s': %v", station.StreamURL, err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+	req = req.WithContext(ctx.Request().Context())
+	if meta := ctx.Request().Header.Get("Icy-MetaData"); len(meta) != 0 {
+		req.Header.Set("Icy-MetaData", meta)
+	}
+
+	upstream, err := proxyClient.Do(req)
+	if err != nil {
+		log.Debugf("RadioStationController::Stream Could not reach '%s': %v", station.StreamURL, err)
+		return ctx.NoContent(http.StatusBadGateway)
+	}
+	defer upstream.Body.Close()
+
+	if upstream.StatusCode != http.StatusOK {
+		log.Debugf("RadioStationController::Stream '%s' answered %s.", station.StreamURL, upstream.Status)
+		return ctx.NoContent(http.StatusBadGateway)
+	}
+
+	res := ctx.Response()
+	for name, values := range upstream.Header {
+		if name == echo.HeaderContentType || strings.HasPrefix(strings.ToLower(name), "icy-") {
+			res.Header()[name] = values
+		}
+	}
+	res.Header().Set("Cache-Control", "no-cache, no-store")
+	res.WriteHeader(http.StatusOK)
+
+	if err := copyFlushing(res, upstream.Body); err != nil && ctx.Request().Context().Err() == nil {
+		log.Debugf("RadioStationController::Stream Stream '%s' ended: %v", station.StreamURL, err)
+	}
+
+	return nil
+}
+
+// RadioStationController Contains the actions for the 'radiostations' endpoint.
+var RadioStationController radioStationController
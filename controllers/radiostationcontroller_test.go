@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRadioStationController(t *testing.T) {
+	e := echo.New()
+	config.Config.Username = "admin"
+	config.Config.UploadMaxSize = 1 << 20
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(echo.HeaderContentType, "audio/mpeg")
+		w.Header().Set("icy-name", "Upstream")
+		if r.Header.Get("Icy-MetaData") == "1" {
+			w.Header().Set("icy-metaint", "8192")
+		}
+		fmt.Fprint(w, "audio")
+	}))
+	defer upstream.Close()
+
+	withDb(func() {
+		request := func(action echo.HandlerFunc, method string, id string, body string, username ...string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/radiostations", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("Icy-MetaData", "1")
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			if len(username) == 0 {
+				username = []string{"admin"}
+			}
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: username[0]}})
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		Convey("Only the administrator changes the catalog.", t, func() {
+			body := `{"name": "Upstream", "stream_url": "` + upstream.URL + `"}`
+			So(request(RadioStationController.Create, echo.POST, "", body, "guest").Code, ShouldEqual, http.StatusForbidden)
+			So(request(RadioStationController.Import, echo.POST, "", upstream.URL, "guest").Code, ShouldEqual, http.StatusForbidden)
+			So(request(RadioStationController.Update, echo.PUT, "1", body, "guest").Code, ShouldEqual, http.StatusForbidden)
+			So(request(RadioStationController.Delete, echo.DELETE, "1", "", "guest").Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Stations are created with a stream address.", t, func() {
+			rec := request(RadioStationController.Create, echo.POST, "", `{"name": " Upstream ", "stream_url": "`+upstream.URL+`", "homepage_url": "https://example.com"}`)
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			r := &radioStationResponse{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			So(r.Name, ShouldEqual, "Upstream")
+			So(r.Proxy, ShouldEqual, "")
+
+			So(request(RadioStationController.Create, echo.POST, "", `{"name": "Again", "stream_url": "`+upstream.URL+`"}`).Code, ShouldEqual, http.StatusConflict)
+			So(request(RadioStationController.Create, echo.POST, "", `{"name": "File", "stream_url": "file:///etc/passwd"}`).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Station lists are imported without duplicates.", t, func() {
+			list := "[playlist]\nFile1=" + upstream.URL + "\nFile2=http://two.example.com/\nTitle2=Two\n"
+			rec := request(RadioStationController.Import, echo.POST, "", list)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			r := &radioStationImport{}
+			So(json.NewDecoder(rec.Body).Decode(r), ShouldBeNil)
+			So(r.Imported, ShouldEqual, 1)
+			So(r.Duplicates, ShouldEqual, 1)
+			So(r.Stations[0].Name, ShouldEqual, "Two")
+
+			rec = request(RadioStationController.Index, echo.GET, "", "")
+			index := &struct {
+				Data []*radioStationResponse `json:"data"`
+			}{}
+			So(json.NewDecoder(rec.Body).Decode(index), ShouldBeNil)
+			So(len(index.Data), ShouldEqual, 2)
+		})
+
+		Convey("Stations are updated and deleted.", t, func() {
+			rec := request(RadioStationController.Update, echo.PUT, "2", `{"name": "Second", "stream_url": "http://two.example.com/"}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			So(request(RadioStationController.Delete, echo.DELETE, "2", "").Code, ShouldEqual, http.StatusNoContent)
+			So(request(RadioStationController.Show, echo.GET, "2", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Streams go through the server only when the proxy is on.", t, func() {
+			So(request(RadioStationController.Stream, echo.GET, "1", "").Code, ShouldEqual, http.StatusNotFound)
+
+			config.Config.RadioProxy = true
+			defer func() { config.Config.RadioProxy = false }()
+
+			// The test stream is on the server itself.
+			So(request(RadioStationController.Stream, echo.GET, "1", "", "guest").Code, ShouldEqual, http.StatusBadGateway)
+
+			client := proxyClient
+			proxyClient = upstream.Client()
+			defer func() { proxyClient = client }()
+
+			rec := request(RadioStationController.Stream, echo.GET, "1", "", "guest")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "audio")
+			So(rec.Header().Get("icy-metaint"), ShouldEqual, "8192")
+			So(rec.Header().Get("icy-name"), ShouldEqual, "Upstream")
+		})
+	})
+}
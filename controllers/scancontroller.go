@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/scan"
+	"github.com/labstack/echo"
+)
+
+type scanController struct {
+}
+
+// Start queues a scan. With 'wait' set the response is sent when the scan has stopped.
+// With 'force' set the songs whose file is gone are removed even when most of them are.
+// Only the administrator can start scans.
+func (c *scanController) Start(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("ScanController::Start Only the administrator can start scans.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	params := &struct {
+		Library uint   `json:"library" form:"library"`
+		Path    string `json:"path" form:"path"`
+		Wait    bool   `json:"wait" form:"wait"`
+		Force   bool   `json:"force" form:"force"`
+	}{}
+
+	// The body is optional, a plain POST scans the whole media directory.
+	if ctx.Request().ContentLength != 0 {
+		if err := ctx.Bind(params); err != nil {
+			log.Debugf("ScanController::Start Binding params failed: %v", err)
+			return ctx.NoContent(http.StatusBadRequest)
+		}
+	}
+
+	if params.Library == 0 {
+		params.Library = StrToUint(ctx.QueryParam("library"))
+	}
+
+	if len(params.Path) == 0 {
+		params.Path = ctx.QueryParam("path")
+	}
+
+	if !params.Wait {
+		params.Wait, _ = strconv.ParseBool(ctx.QueryParam("wait"))
+	}
+
+	if !params.Force {
+		params.Force, _ = strconv.ParseBool(ctx.QueryParam("force"))
+	}
+
+	job, err := scan.Jobs.Start(params.Library, params.Path, params.Force)
+	if err == scan.ErrInvalidPath || err == scan.ErrLibraryNotFound {
+		log.Debugf("ScanController::Start Invalid library '%d' or path '%s'.", params.Library, params.Path)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"message": err.Error(),
+		})
+	} else if err != nil {
+		log.Errorf("ScanController::Start Could not start scan: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if params.Wait {
+		select {
+		case <-job.Done():
+		case <-ctx.Request().Context().Done():
+			return nil
+		}
+
+		return ctx.JSON(http.StatusOK, job.Info())
+	}
+
+	return ctx.JSON(http.StatusAccepted, job.Info())
+}
+
+// Status returns the running scan and the scans waiting to run.
+func (c *scanController) Status(ctx echo.Context) error {
+	var current *scan.JobInfo
+	if job := scan.Jobs.Current(); job != nil {
+		current = job.Info()
+	}
+
+	queued := []*scan.JobInfo{}
+	for _, job := range scan.Jobs.Queued() {
+		queued = append(queued, job.Info())
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"current": current,
+		"queued":  queued,
+	})
+}
+
+// History returns the stopped scans, most recent first.
+func (c *scanController) History(ctx echo.Context) error {
+	jobs := []*scan.JobInfo{}
+	for _, job := range scan.Jobs.History() {
+		jobs = append(jobs, job.Info())
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"data": jobs,
+	})
+}
+
+func (c *scanController) Show(ctx echo.Context) error {
+	id, _ := strconv.ParseUint(ctx.Param("id"), 10, 64)
+
+	job, err := scan.Jobs.Get(id)
+	if err != nil {
+		log.Debugf("ScanController::Show Scan '%d' not found.", id)
+		return ctx.NoContent(http.StatusNotFound)
+	}
+
+	return ctx.JSON(http.StatusOK, job.Info())
+}
+
+// Cancel stops the scan with the given id, or the running scan if no id is given. Only
+// the administrator can cancel scans.
+func (c *scanController) Cancel(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("ScanController::Cancel Only the administrator can cancel scans.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	var id uint64
+	if ctx.Param("id") != "" {
+		id, _ = strconv.ParseUint(ctx.Param("id"), 10, 64)
+	} else if job := scan.Jobs.Current(); job != nil {
+		id = job.ID
+	}
+
+	job, err := scan.Jobs.Cancel(id)
+	if err != nil {
+		log.Debugf("ScanController::Cancel Scan '%d' not found.", id)
+		return ctx.NoContent(http.StatusNotFound)
+	}
+
+	log.WithFields(log.Fields{"id": job.ID, "path": job.Path}).Info("Scan cancelled.")
+	return ctx.JSON(http.StatusOK, job.Info())
+}
+
+// ScanController Contains the actions for the 'scan' endpoint.
+var ScanController scanController
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScanController(t *testing.T) {
+	e := echo.New()
+	config.Config.Username = "admin"
+
+	request := func(action echo.HandlerFunc, method string, id string, username string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/scan", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 1, Username: username}})
+		if len(id) != 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+		}
+
+		So(action(c), ShouldBeNil)
+		return rec
+	}
+
+	Convey("Only the administrator starts and cancels scans.", t, func() {
+		So(request(ScanController.Start, echo.POST, "", "guest").Code, ShouldEqual, http.StatusForbidden)
+		So(request(ScanController.Cancel, echo.DELETE, "", "guest").Code, ShouldEqual, http.StatusForbidden)
+		So(request(ScanController.Cancel, echo.DELETE, "1", "guest").Code, ShouldEqual, http.StatusForbidden)
+		So(request(ScanController.Cancel, echo.DELETE, "999", "admin").Code, ShouldEqual, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/history"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/scrobble"
+	"github.com/labstack/echo"
+)
+
+type scrobbleAccountResponse struct {
+	Service  string `json:"service"`
+	Username string `json:"username"`
+	// Pending is the number of plays that are waiting to be submitted.
+	Pending int `json:"pending"`
+}
+
+type scrobbleController struct {
+}
+
+// user returns the id of the user of the request and whether scrobbling is set up. Responds itself if not.
+func (c *scrobbleController) user(ctx echo.Context) (uint, bool, error) {
+	claim := CurrentUser(ctx)
+	if claim == nil {
+		return 0, false, ctx.NoContent(http.StatusUnauthorized)
+	}
+
+	if scrobble.Default == nil {
+		return 0, false, ctx.JSON(http.StatusServiceUnavailable, echo.Map{"message": "Scrobbling is not set up."})
+	}
+
+	return claim.ID, true, nil
+}
+
+// Accounts returns the linked accounts of the user.
+func (c *scrobbleController) Accounts(ctx echo.Context) error {
+	userID, ok, err := c.user(ctx)
+	if !ok {
+		return err
+	}
+
+	accounts := []*models.ScrobbleAccount{}
+	if gormDB := db.DB.Where("user_id = ?", userID).Order("service").Find(&accounts); gormDB.Error != nil {
+		log.Errorf("ScrobbleController::Accounts Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	response := []*scrobbleAccountResponse{}
+	for _, account := range accounts {
+		r := &scrobbleAccountResponse{Service: account.Service, Username: account.Username}
+		db.DB.Model(&models.PendingScrobble{}).Where("account_id = ?", account.ID).Count(&r.Pending)
+		response = append(response, r)
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// link stores the account of a service for a user, replacing the one it had.
+func (c *scrobbleController) link(ctx echo.Context, userID uint, service string, username string, token string) error {
+	account := &models.ScrobbleAccount{}
+	gormDB := db.DB.Where(models.ScrobbleAccount{UserID: userID, Service: service}).FirstOrInit(account)
+	if gormDB.Error != nil {
+		log.Errorf("ScrobbleController::Link Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	account.Username = username
+	account.Token = token
+	if gormDB := db.DB.Save(account); gormDB.Error != nil {
+		log.Errorf("ScrobbleController::Link Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, &scrobbleAccountResponse{Service: service, Username: username})
+}
+
+// linkError responds to a failed verification of an account.
+func (c *scrobbleController) linkError(ctx echo.Context, err error) error {
+	log.Debugf("ScrobbleController::Link Service refused the account: %v", err)
+	if scrobble.IsTemporary(err) {
+		return ctx.JSON(http.StatusBadGateway, echo.Map{"message": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+}
+
+// LastFMAuth returns the page where the user allows cadenzr to scrobble to Last.fm.
+// Last.fm sends the user to 'callback' with a token to pass to LinkLastFM.
+func (c *scrobbleController) LastFMAuth(ctx echo.Context) error {
+	if _, ok, err := c.user(ctx); !ok {
+		return err
+	}
+
+	lastFM, ok := scrobble.Default.Service(models.ScrobbleLastFM).(*scrobble.LastFM)
+	if !ok {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"message": "Last.fm is not configured."})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"url": lastFM.AuthorizeURL(ctx.QueryParam("callback"))})
+}
+
+// LinkLastFM links a Last.fm account with the token of an authorization.
+func (c *scrobbleController) LinkLastFM(ctx echo.Context) error {
+	userID, ok, err := c.user(ctx)
+	if !ok {
+		return err
+	}
+
+	lastFM, ok := scrobble.Default.Service(models.ScrobbleLastFM).(*scrobble.LastFM)
+	if !ok {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"message": "Last.fm is not configured."})
+	}
+
+	body := struct {
+		Token string `json:"token"`
+	}{}
+	if err := ctx.Bind(&body); err != nil || len(body.Token) == 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "A token is required."})
+	}
+
+	username, key, err := lastFM.Session(body.Token)
+	if err != nil {
+		return c.linkError(ctx, err)
+	}
+
+	return c.link(ctx, userID, models.ScrobbleLastFM, username, key)
+}
+
+// LinkListenBrainz links a ListenBrainz account with its user token.
+func (c *scrobbleController) LinkListenBrainz(ctx echo.Context) error {
+	userID, ok, err := c.user(ctx)
+	if !ok {
+		return err
+	}
+
+	listenBrainz, ok := scrobble.Default.Service(models.ScrobbleListenBrainz).(*scrobble.ListenBrainz)
+	if !ok {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"message": "ListenBrainz is not configured."})
+	}
+
+	body := struct {
+		Token string `json:"token"`
+	}{}
+	if err := ctx.Bind(&body); err != nil || len(body.Token) == 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "A token is required."})
+	}
+
+	username, err := listenBrainz.Validate(body.Token)
+	if err != nil {
+		return c.linkError(ctx, err)
+	}
+
+	return c.link(ctx, userID, models.ScrobbleListenBrainz, username, body.Token)
+}
+
+// Unlink removes the account of a service and the plays that were not submitted to it.
+func (c *scrobbleController) Unlink(ctx echo.Context) error {
+	userID, ok, err := c.user(ctx)
+	if !ok {
+		return err
+	}
+
+	account := &models.ScrobbleAccount{}
+	gormDB := db.DB.First(account, "user_id = ? AND service = ?", userID, ctx.Param("service"))
+	if gormDB.RecordNotFound() {
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("ScrobbleController::Unlink Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	db.DB.Unscoped().Where("account_id = ?", account.ID).Delete(&models.PendingScrobble{})
+	if gormDB := db.DB.Unscoped().Delete(account); gormDB.Error != nil {
+		log.Errorf("ScrobbleController::Unlink Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Scrobble adds a play of a song to the history of the user and relays it to their
+// accounts. With 'submission=false' the song is only reported as playing now, otherwise
+// it is scrobbled as played at 'time', in Unix seconds, which defaults to now.
+func (c *scrobbleController) Scrobble(ctx echo.Context) error {
+	userID, ok, err := c.user(ctx)
+	if !ok {
+		return err
+	}
+
+	id := StrToUint(ctx.Param("id"))
+	song := &models.Song{}
+	gormDB := WhereSongsInScope(ctx, db.DB).Preload("Artist").Preload("Album").First(song, "id = ?", id)
+	if gormDB.RecordNotFound() {
+		log.Debugf("ScrobbleController::Scrobble Song '%d' not found.", id)
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("ScrobbleController::Scrobble Database failed: %v", gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	playedAt := time.Now()
+	if t := ctx.QueryParam("time"); len(t) != 0 {
+		seconds, err := strconv.ParseInt(t, 10, 64)
+		if err != nil || seconds <= 0 {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "Time must be in Unix seconds."})
+		}
+		playedAt = time.Unix(seconds, 0)
+	}
+
+	track := scrobble.NewTrack(song, playedAt)
+	if ctx.QueryParam("submission") == "false" {
+		publishNowPlaying(ctx, song, 0, false)
+		err = scrobble.Default.NowPlaying(userID, track)
+	} else if _, err = history.Record(db.DB, userID, song.ID, playedAt, models.PlayScrobbled); err == nil {
+		err = scrobble.Default.Scrobble(userID, track)
+	}
+
+	if err != nil {
+		log.Errorf("ScrobbleController::Scrobble Failed: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// ScrobbleController Contains the actions for the 'scrobble' endpoint.
+var ScrobbleController scrobbleController
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/scrobble"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScrobbleController(t *testing.T) {
+	e := echo.New()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token good" {
+			w.Write([]byte(`{"valid": false, "message": "Invalid token"}`))
+			return
+		}
+		w.Write([]byte(`{"valid": true, "user_name": "someone"}`))
+	}))
+	defer server.Close()
+
+	scrobble.Default = scrobble.NewScrobbler(map[string]scrobble.Service{
+		models.ScrobbleListenBrainz: &scrobble.ListenBrainz{URL: server.URL},
+	})
+	defer func() { scrobble.Default = nil }()
+
+	withDb(func() {
+		artist := &models.Artist{Name: "Artist"}
+		db.DB.Create(artist)
+		song := &models.Song{Name: "Song", Mime: "audio/mpeg", Path: "/song.mp3"}
+		song.ArtistID.Set(int64(artist.ID))
+		song.Duration.Set(120)
+		db.DB.Create(song)
+
+		request := func(action echo.HandlerFunc, method string, path string, body string, names []string, values []string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames(names...)
+			c.SetParamValues(values...)
+			c.Set("user", &jwt.Token{Claims: &UserLoginClaim{ID: 7, Username: "guest"}})
+
+			So(action(c), ShouldBeNil)
+			return rec
+		}
+
+		Convey("ListenBrainz accounts are linked with a valid token.", t, func() {
+			rec := request(ScrobbleController.LinkListenBrainz, echo.POST, "/api/scrobble/accounts/listenbrainz", `{"token": "bad"}`, nil, nil)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+
+			rec = request(ScrobbleController.LinkListenBrainz, echo.POST, "/api/scrobble/accounts/listenbrainz", `{"token": "good"}`, nil, nil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			rec = request(ScrobbleController.LastFMAuth, echo.GET, "/api/scrobble/accounts/lastfm/auth", "", nil, nil)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Plays are queued for linked accounts.", t, func() {
+			rec := request(ScrobbleController.Scrobble, echo.POST, "/api/songs/1/scrobble?time=1500000000", "", []string{"id"}, []string{"1"})
+			So(rec.Code, ShouldEqual, http.StatusAccepted)
+
+			pending := &models.PendingScrobble{}
+			So(db.DB.First(pending).Error, ShouldBeNil)
+			So(pending.Artist, ShouldEqual, "Artist")
+			So(pending.Title, ShouldEqual, "Song")
+			So(pending.PlayedAt.Unix(), ShouldEqual, 1500000000)
+
+			rec = request(ScrobbleController.Accounts, echo.GET, "/api/scrobble/accounts", "", nil, nil)
+			accounts := []*scrobbleAccountResponse{}
+			So(json.NewDecoder(rec.Body).Decode(&accounts), ShouldBeNil)
+			So(len(accounts), ShouldEqual, 1)
+			So(accounts[0].Username, ShouldEqual, "someone")
+			So(accounts[0].Pending, ShouldEqual, 1)
+
+			So(request(ScrobbleController.Scrobble, echo.POST, "/api/songs/1/scrobble?time=soon", "", []string{"id"}, []string{"1"}).Code, ShouldEqual, http.StatusBadRequest)
+			So(request(ScrobbleController.Scrobble, echo.POST, "/api/songs/2/scrobble", "", []string{"id"}, []string{"2"}).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Songs played in the web client are added to the history and scrobbled.", t, func() {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &UserLoginClaim{ID: 7, Username: "guest"}).SignedString(Secret)
+			So(err, ShouldBeNil)
+
+			// The played route has no jwt middleware, the client sends its token anyway.
+			req := httptest.NewRequest(echo.POST, "/api/songs/1/played", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			So(SongController.Played(c), ShouldBeNil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			count := 0
+			db.DB.Model(&models.Play{}).Where("user_id = ?", 7).Count(&count)
+			So(count, ShouldEqual, 2)
+			db.DB.Model(&models.PendingScrobble{}).Count(&count)
+			So(count, ShouldEqual, 2)
+		})
+
+		Convey("Unlinking removes the queued plays.", t, func() {
+			rec := request(ScrobbleController.Unlink, echo.DELETE, "/api/scrobble/accounts/listenbrainz", "", []string{"service"}, []string{"listenbrainz"})
+			So(rec.Code, ShouldEqual, http.StatusNoContent)
+
+			count := -1
+			db.DB.Model(&models.PendingScrobble{}).Count(&count)
+			So(count, ShouldEqual, 0)
+		})
+	})
+}
@@ -2,57 +2,222 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cadenzr/cadenzr/config"
 	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/history"
 	"github.com/cadenzr/cadenzr/log"
 	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/nowplaying"
+	"github.com/cadenzr/cadenzr/replaygain"
+	"github.com/cadenzr/cadenzr/scrobble"
 	"github.com/cadenzr/cadenzr/streamers"
+	"github.com/cadenzr/cadenzr/transcoders"
 	"github.com/jinzhu/gorm"
 	"github.com/labstack/echo"
 )
 
+// publishNowPlaying reports that a song plays from position seconds. Songs of known users
+// go into the now playing registry, which publishes the event when the song changes, or
+// always when start is set. Anonymous plays are only published when they start.
+func publishNowPlaying(ctx echo.Context, song *models.Song, position float64, start bool) {
+	claim := optionalUser(ctx)
+	if claim == nil || nowplaying.Default == nil {
+		if start {
+			data := echo.Map{
+				"song_id": song.ID,
+			}
+			if claim != nil {
+				data["user_id"] = claim.ID
+				data["username"] = claim.Username
+			}
+			events.PublishLibrary(events.NowPlaying, uint(song.LibraryID.Int64), data)
+		}
+		return
+	}
+
+	nowplaying.Default.Update(&nowplaying.Report{
+		UserID:   claim.ID,
+		Username: claim.Username,
+		Client:   clientName(ctx),
+		SongID:   song.ID,
+		Duration: song.Duration.Float64,
+		Position: position,
+		Start:    start,
+	})
+}
+
+// clientName returns the name of the client of a request: the 'client' query parameter,
+// else its user agent.
+func clientName(ctx echo.Context) string {
+	if client := ctx.QueryParam("client"); len(client) != 0 {
+		return client
+	}
+
+	return ctx.Request().UserAgent()
+}
+
+// firstRequest returns whether a stream request starts at the beginning of the file.
+func firstRequest(ctx echo.Context) bool {
+	rangeHeader := ctx.Request().Header.Get("Range")
+	return len(rangeHeader) == 0 || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
 type songController struct {
 }
 
 func (c *songController) FileStream(ctx echo.Context) error {
 	id := StrToUint(ctx.Param("id"))
 	song := &models.Song{}
-	gormDB := db.DB.First(song, "id = ?", id)
+	gormDB := WhereSongsInScope(ctx, db.DB).First(song, "id = ?", id)
 	if gormDB.RecordNotFound() {
-		log.Debugf("Could not start streaming because song '%d' is not in database.", id)
+		log.Debugf("Could not start streaming because song '%d' is not in database or not accessible.", id)
 		return ctx.NoContent(http.StatusNotFound)
 	} else if gormDB.Error != nil {
 		log.Errorf("Could not start streaming. Database failed: %v", gormDB.Error)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	streamer, err := streamers.NewFileStreamer(song.Path)
+	mode := config.Config.ReplayGain
+	if m := ctx.QueryParam("replaygain"); len(m) != 0 {
+		mode = m
+	}
+
+	// Seeking to a time needs a transcoding that starts there.
+	start, _ := strconv.ParseFloat(ctx.QueryParam("start"), 64)
+
+	gain, hasGain := c.gain(song, mode)
+	profile, err := transcoders.Choose(c.transcodeRequest(ctx, song, hasGain || start > 0))
+	if err == transcoders.ErrUnknownProfile {
+		log.Debugf("Could not start streaming because profile '%s' does not exist.", ctx.QueryParam("profile"))
+		return ctx.NoContent(http.StatusBadRequest)
+	} else if err != nil {
+		log.Errorf("Could not choose transcoding profile: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if profile != nil && start > 0 {
+		publishNowPlaying(ctx, song, start, false)
+		return c.seekStream(ctx, song, profile, gain, start)
+	}
+
+	name := song.Name
+	var streamer streamers.Streamer
+	if profile != nil {
+		streamer, err = streamers.NewTranscodeStreamer(song, profile, gain)
+		name += profile.Extension()
+		ctx.Response().Header().Set(echo.HeaderContentType, profile.ContentType())
+	} else {
+		streamer, err = streamers.NewFileStreamer(song.Path)
+	}
 	if err != nil {
 		log.Errorf("Could not create streamer: %v", err)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 	defer streamer.Close()
 
-	// Since we don't know when songs have been played from m3u8. We just update it at the start.
-	if ctx.FormValue("from") == "m3u8" {
-		db.DB.Table("songs").Where("id = ?", song.ID).Update("played", gorm.Expr("played+1"))
+	// Players fetch the rest of the file and seek with more Range requests, only the first
+	// request of a play is reported.
+	if firstRequest(ctx) {
+		// Since we don't know when songs have been played from m3u8. We just update it at the start.
+		fromM3U := ctx.FormValue("from") == "m3u8"
+		if fromM3U {
+			db.DB.Table("songs").Where("id = ?", song.ID).Update("played", gorm.Expr("played+1"))
+		}
+		publishNowPlaying(ctx, song, 0, fromM3U)
+	}
+
+	if growing, ok := streamer.(*streamers.GrowingStreamer); ok {
+		return serveGrowing(ctx, name, growing)
 	}
 
 	// TODO: set the correct time so browser can cache.
-	http.ServeContent(ctx.Response(), ctx.Request(), song.Name, time.Time{}, streamer)
+	http.ServeContent(ctx.Response(), ctx.Request(), name, time.Time{}, streamer)
 	return nil
 }
 
+// seekStream sends a song transcoded from start seconds. It is not cached and ffmpeg is
+// stopped when the client goes away.
+func (c *songController) seekStream(ctx echo.Context, song *models.Song, profile *transcoders.Profile, gain float64, start float64) error {
+	stream, err := streamers.NewSeekStreamer(ctx.Request().Context(), song, profile, gain, start)
+	if err != nil {
+		log.Errorf("Could not create streamer: %v", err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+	defer stream.Close()
+
+	ctx.Response().Header().Set(echo.HeaderContentType, profile.ContentType())
+	ctx.Response().WriteHeader(http.StatusOK)
+	return copyFlushing(ctx.Response(), stream)
+}
+
+// transcodeRequest describes a stream request for the transcoding policy. Clients can
+// ask for a 'profile' and 'bitrate', and name themselves with 'client'.
+func (c *songController) transcodeRequest(ctx echo.Context, song *models.Song, transcode bool) *transcoders.Request {
+	req := &transcoders.Request{
+		Client:    clientName(ctx),
+		Profile:   ctx.QueryParam("profile"),
+		Bitrate:   int(StrToUint(ctx.QueryParam("bitrate"))),
+		Transcode: transcode,
+	}
+
+	if claim := CurrentUser(ctx); claim != nil {
+		req.Username = claim.Username
+	}
+
+	if song.Duration.Float64 > 0 {
+		req.SourceBitrate = int(float64(song.Size) * 8 / 1000 / song.Duration.Float64)
+	}
+
+	return req
+}
+
+// gain returns the ReplayGain gain of a song for a mode, if there is one to apply.
+func (c *songController) gain(song *models.Song, mode string) (float64, bool) {
+	if mode == config.ReplayGainAlbum && song.AlbumID.Valid {
+		song.Album = &models.Album{}
+		if gormDB := db.DB.First(song.Album, "id = ?", song.AlbumID.Int64); gormDB.Error != nil {
+			song.Album = nil
+		}
+	}
+
+	gain, ok := replaygain.SongGain(song, mode)
+	return gain, ok && gain != 0
+}
+
+// Played counts a play of a song. Plays of a logged in user are also added to their
+// history and scrobbled to their accounts.
 func (c *songController) Played(ctx echo.Context) error {
 	id := StrToUint(ctx.Param("id"))
 
+	song := &models.Song{}
+	if gormDB := WhereSongsInScope(ctx, db.DB).Preload("Artist").Preload("Album").First(song, "id = ?", id); gormDB.RecordNotFound() {
+		log.Debugf("Could not update song played count. Song '%d' was not found or is not accessible.", id)
+		return ctx.NoContent(http.StatusNotFound)
+	} else if gormDB.Error != nil {
+		log.Errorf("Failed to load song '%d': %v", id, gormDB.Error)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
 	if gormDB := db.DB.Table("songs").Where("id = ?", id).Update("played", gorm.Expr("played+1")); gormDB.Error != nil {
 		log.Errorf("Failed to increment played cound for song '%d': %v", id, gormDB.Error)
 		return ctx.NoContent(http.StatusInternalServerError)
-	} else if gormDB.RowsAffected == 0 {
-		log.Debugf("Could not update song played count. Song '%d' was not found.", id)
-		return ctx.NoContent(http.StatusNotFound)
+	}
+	publishNowPlaying(ctx, song, 0, true)
+
+	if claim := optionalUser(ctx); claim != nil {
+		playedAt := time.Now()
+		if _, err := history.Record(db.DB, claim.ID, song.ID, playedAt, models.PlayScrobbled); err != nil {
+			log.Errorf("SongController::Played Could not add song '%d' to the history: %v", id, err)
+		} else if scrobble.Default != nil {
+			if err := scrobble.Default.Scrobble(claim.ID, scrobble.NewTrack(song, playedAt)); err != nil {
+				log.Errorf("SongController::Played Could not scrobble song '%d': %v", id, err)
+			}
+		}
 	}
 
 	return ctx.NoContent(http.StatusOK)
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cadenzr/cadenzr/streamers"
+	"github.com/labstack/echo"
+)
+
+// parseRange returns the first and last byte of a single 'Range: bytes=first-last' header.
+// last is -1 when it is left out. Suffix ranges and multiple ranges are not supported.
+func parseRange(header string) (first int64, last int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, 0, false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return 0, 0, false
+	}
+
+	first, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, false
+	}
+
+	last = -1
+	if s := strings.TrimSpace(parts[1]); len(s) != 0 {
+		if last, err = strconv.ParseInt(s, 10, 64); err != nil || last < first {
+			return 0, 0, false
+		}
+	}
+
+	return first, last, true
+}
+
+// copyFlushing sends r to the client as it is read, instead of when the buffer of the
+// response is full.
+func copyFlushing(res *echo.Response, r io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, writeErr := res.Write(buf[:n]); writeErr != nil {
+				// The client went away.
+				return nil
+			}
+			res.Flush()
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// serveGrowing sends a file that is still being transcoded. Without a range, or from the
+// start, the file is sent as it is written. Other ranges are served from what is written
+// so far. A range past that is not satisfiable, the client can ask for a time with 'start'
+// instead.
+func serveGrowing(ctx echo.Context, name string, s *streamers.GrowingStreamer) error {
+	res := ctx.Response()
+	req := ctx.Request()
+
+	if _, complete := s.Available(); complete {
+		http.ServeContent(res, req, name, time.Time{}, s)
+		return nil
+	}
+
+	res.Header().Set("Accept-Ranges", "bytes")
+
+	first, last, ok := parseRange(req.Header.Get("Range"))
+	if !ok || (first == 0 && last < 0) {
+		res.WriteHeader(http.StatusOK)
+		if req.Method == echo.HEAD {
+			return nil
+		}
+		return copyFlushing(res, s)
+	}
+
+	available, _ := s.Available()
+	if first >= available {
+		res.Header().Set("Content-Range", "bytes */*")
+		return ctx.NoContent(http.StatusRequestedRangeNotSatisfiable)
+	}
+
+	if last < 0 || last >= available {
+		last = available - 1
+	}
+
+	if _, err := s.Seek(first, io.SeekStart); err != nil {
+		return err
+	}
+
+	length := last - first + 1
+	res.Header().Set("Content-Range", "bytes "+strconv.FormatInt(first, 10)+"-"+strconv.FormatInt(last, 10)+"/*")
+	res.Header().Set(echo.HeaderContentLength, strconv.FormatInt(length, 10))
+	res.WriteHeader(http.StatusPartialContent)
+	if req.Method == echo.HEAD {
+		return nil
+	}
+
+	return copyFlushing(res, io.LimitReader(s, length))
+}
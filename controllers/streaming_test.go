@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseRange(t *testing.T) {
+	Convey("Single byte ranges are parsed.", t, func() {
+		first, last, ok := parseRange("bytes=100-")
+		So(ok, ShouldBeTrue)
+		So(first, ShouldEqual, 100)
+		So(last, ShouldEqual, -1)
+
+		first, last, ok = parseRange("bytes=0-499")
+		So(ok, ShouldBeTrue)
+		So(first, ShouldEqual, 0)
+		So(last, ShouldEqual, 499)
+	})
+
+	Convey("Other ranges are not supported.", t, func() {
+		for _, header := range []string{"", "bytes=-500", "bytes=0-1,5-6", "bytes=9-1", "items=0-1"} {
+			_, _, ok := parseRange(header)
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/streamers"
+	"github.com/labstack/echo"
+)
+
+type transcodeCacheController struct {
+}
+
+// Stats returns the number, size and use of the cached transcodings and HLS segments.
+func (c *transcodeCacheController) Stats(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("TranscodeCacheController::Stats Only the administrator can see the cache.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	return ctx.JSON(http.StatusOK, streamers.Transcodings.Stats())
+}
+
+// Purge removes all cached transcodings and HLS segments.
+func (c *transcodeCacheController) Purge(ctx echo.Context) error {
+	if !IsAdmin(ctx) {
+		log.Debug("TranscodeCacheController::Purge Only the administrator can purge the cache.")
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	removed := streamers.Transcodings.Purge()
+	log.Infof("Purged %d cached transcodings.", removed)
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"removed": removed,
+	})
+}
+
+// TranscodeCacheController Contains the actions for the 'transcodings/cache' endpoint.
+var TranscodeCacheController transcodeCacheController
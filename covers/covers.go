@@ -0,0 +1,231 @@
+// Package covers makes resized copies of cover images and placeholders for albums
+// without a cover. Results are cached on disk.
+package covers
+
+import (
+	"errors"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	// Decoders for the cover formats found in audio files.
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// Output formats.
+const (
+	JPEG = "jpeg"
+	WebP = "webp"
+)
+
+// ErrFormat is returned for an unsupported output format.
+var ErrFormat = errors.New("Unsupported image format")
+
+// ErrTooLarge is returned for images that are wider or higher than MaxDimension.
+var ErrTooLarge = errors.New("Image is too large")
+
+// MaxDimension is the largest width and height of an image that is decoded. The size is
+// read from the header first, a small file can claim a size that needs gigabytes.
+const MaxDimension = 8192
+
+// Sizes are the sizes thumbnails are made in. Requested sizes are rounded up to one of
+// these so only a few copies of each cover are cached.
+var Sizes = []int{64, 128, 256, 512, 1024}
+
+// Dir is where thumbnails are cached.
+var Dir = filepath.Join("cache", "covers")
+
+// jpegQuality is the quality of JPEG thumbnails.
+const jpegQuality = 85
+
+// Size returns the thumbnail size used for a requested size. 0 is the largest size.
+func Size(requested int) int {
+	for _, size := range Sizes {
+		if requested > 0 && requested <= size {
+			return size
+		}
+	}
+
+	return Sizes[len(Sizes)-1]
+}
+
+// ValidFormat returns whether thumbnails can be made in format.
+func ValidFormat(format string) bool {
+	return format == JPEG || format == WebP
+}
+
+// ContentType returns the mime type of a format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Resize scales img to fit in a square of size pixels, keeping its aspect ratio.
+// Images that already fit are not scaled up.
+func Resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		w, h = size, h*size/w
+	} else {
+		w, h = w*size/h, size
+	}
+	if w == 0 {
+		w = 1
+	}
+	if h == 0 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img in format.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case WebP:
+		return nativewebp.Encode(w, img, nil)
+	}
+
+	return ErrFormat
+}
+
+// Placeholder draws a record on a background with a color derived from seed, so
+// every album gets its own placeholder.
+func Placeholder(seed string, size int) image.Image {
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	sum := h.Sum32()
+
+	background := color.RGBA{R: 64 + uint8(sum)%128, G: 64 + uint8(sum>>8)%128, B: 64 + uint8(sum>>16)%128, A: 255}
+	record := color.RGBA{R: 24, G: 24, B: 24, A: 255}
+	label := color.RGBA{R: 255 - background.R/2, G: 255 - background.G/2, B: 255 - background.B/2, A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	center := float64(size) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := float64(x)+0.5-center, float64(y)+0.5-center
+			distance := (dx*dx + dy*dy) / (center * center)
+
+			switch {
+			case distance < 0.0025:
+				img.SetRGBA(x, y, background)
+			case distance < 0.09:
+				img.SetRGBA(x, y, label)
+			case distance < 0.64:
+				img.SetRGBA(x, y, record)
+			default:
+				img.SetRGBA(x, y, background)
+			}
+		}
+	}
+
+	return img
+}
+
+// Thumbnail returns the cached file with the image at path resized to size in format.
+// The thumbnail is made if it isn't cached yet. hash identifies the content of path.
+func Thumbnail(path string, hash string, size int, format string) (string, error) {
+	size = Size(size)
+	return cached(hash+"-"+strconv.Itoa(size)+"."+format, format, func() (image.Image, error) {
+		fh, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer fh.Close()
+
+		conf, _, err := image.DecodeConfig(fh)
+		if err != nil {
+			return nil, err
+		}
+		if conf.Width > MaxDimension || conf.Height > MaxDimension {
+			return nil, ErrTooLarge
+		}
+
+		if _, err := fh.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		img, _, err := image.Decode(fh)
+		if err != nil {
+			return nil, err
+		}
+
+		return Resize(img, size), nil
+	})
+}
+
+// PlaceholderFile returns the cached file with the placeholder for seed in size and format.
+func PlaceholderFile(seed string, size int, format string) (string, error) {
+	size = Size(size)
+
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	key := "placeholder-" + strconv.FormatUint(h.Sum64(), 16) + "-" + strconv.Itoa(size) + "." + format
+
+	return cached(key, format, func() (image.Image, error) {
+		return Placeholder(seed, size), nil
+	})
+}
+
+// cached returns the file in the cache named name, writing the image made by create
+// to it first if it doesn't exist.
+func cached(name string, format string, create func() (image.Image, error)) (string, error) {
+	if !ValidFormat(format) {
+		return "", ErrFormat
+	}
+
+	destination := filepath.Join(Dir, name)
+	if _, err := os.Stat(destination); err == nil {
+		return destination, nil
+	}
+
+	img, err := create()
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(Dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Concurrent requests can make the same thumbnail, so write it under a temporary name first.
+	tmp, err := ioutil.TempFile(Dir, name)
+	if err != nil {
+		return "", err
+	}
+
+	err = Encode(tmp, img, format)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), destination)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return destination, nil
+}
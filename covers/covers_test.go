@@ -0,0 +1,109 @@
+package covers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSize(t *testing.T) {
+	Convey("Sizes are rounded up to a thumbnail size.", t, func() {
+		So(Size(1), ShouldEqual, 64)
+		So(Size(64), ShouldEqual, 64)
+		So(Size(200), ShouldEqual, 256)
+		So(Size(0), ShouldEqual, 1024)
+		So(Size(5000), ShouldEqual, 1024)
+	})
+}
+
+func TestResize(t *testing.T) {
+	Convey("Images are scaled down keeping their aspect ratio.", t, func() {
+		img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+		So(Resize(img, 100).Bounds(), ShouldResemble, image.Rect(0, 0, 100, 50))
+
+		img = image.NewRGBA(image.Rect(0, 0, 200, 400))
+		So(Resize(img, 100).Bounds(), ShouldResemble, image.Rect(0, 0, 50, 100))
+	})
+
+	Convey("Small images are not scaled up.", t, func() {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		So(Resize(img, 100).Bounds(), ShouldResemble, img.Bounds())
+	})
+}
+
+func TestThumbnail(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "covers")
+	defer os.RemoveAll(dir)
+	Dir = filepath.Join(dir, "cache")
+
+	src := image.NewRGBA(image.Rect(0, 0, 300, 300))
+	for i := range src.Pix {
+		src.Pix[i] = 200
+	}
+	buf := &bytes.Buffer{}
+	png.Encode(buf, src)
+	path := filepath.Join(dir, "cover.png")
+	ioutil.WriteFile(path, buf.Bytes(), 0644)
+
+	for _, format := range []string{JPEG, WebP} {
+		Convey("Thumbnails are made and cached as "+format+".", t, func() {
+			file, err := Thumbnail(path, "abc", 100, format)
+			So(err, ShouldBeNil)
+			So(filepath.Base(file), ShouldEqual, "abc-128."+format)
+
+			fh, err := os.Open(file)
+			So(err, ShouldBeNil)
+			defer fh.Close()
+
+			img, decoded, err := image.Decode(fh)
+			So(err, ShouldBeNil)
+			So(decoded, ShouldEqual, format)
+			So(img.Bounds().Dx(), ShouldEqual, 128)
+
+			// The cached file is used, even when the source is gone.
+			again, err := Thumbnail(filepath.Join(dir, "missing.png"), "abc", 128, format)
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, file)
+		})
+	}
+
+	Convey("Images that claim a huge size are not decoded.", t, func() {
+		huge := append([]byte{}, buf.Bytes()...)
+		// The width and height in the IHDR chunk, followed by its checksum.
+		binary.BigEndian.PutUint32(huge[16:20], 100000)
+		binary.BigEndian.PutUint32(huge[20:24], 100000)
+		binary.BigEndian.PutUint32(huge[29:33], crc32.ChecksumIEEE(huge[12:29]))
+		hugePath := filepath.Join(dir, "huge.png")
+		ioutil.WriteFile(hugePath, huge, 0644)
+
+		_, err := Thumbnail(hugePath, "huge", 100, JPEG)
+		So(err, ShouldEqual, ErrTooLarge)
+	})
+
+	Convey("Unknown formats are refused.", t, func() {
+		_, err := Thumbnail(path, "abc", 100, "bmp")
+		So(err, ShouldEqual, ErrFormat)
+	})
+
+	Convey("Placeholders depend on the seed.", t, func() {
+		a := Placeholder("a", 64)
+		b := Placeholder("b", 64)
+		So(a.Bounds().Dx(), ShouldEqual, 64)
+		So(a.At(0, 0), ShouldNotResemble, b.At(0, 0))
+		So(a.At(0, 0), ShouldResemble, Placeholder("a", 64).At(0, 0))
+		So(a.At(16, 32), ShouldResemble, color.RGBA{R: 24, G: 24, B: 24, A: 255})
+
+		file, err := PlaceholderFile("a", 64, JPEG)
+		So(err, ShouldBeNil)
+		So(filepath.Base(file), ShouldStartWith, "placeholder-")
+	})
+}
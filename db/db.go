@@ -49,12 +49,28 @@ func SetupSchema() (err error) {
 	log.Info("Updating database schema.")
 
 	db := DB.AutoMigrate(
+		&models.Library{},
 		&models.Artist{},
+		&models.ArtistLink{},
 		&models.User{},
 		&models.Image{},
 		&models.Album{},
 		&models.Song{},
+		&models.Lyrics{},
 		&models.Playlist{},
+		&models.ScrobbleAccount{},
+		&models.PendingScrobble{},
+		&models.Play{},
+		&models.Annotation{},
+		&models.PlayQueue{},
+		&models.PlayQueueEntry{},
+		&models.NowPlaying{},
+		&models.RadioStation{},
+		&models.PodcastChannel{},
+		&models.PodcastEpisode{},
+		&models.PodcastPosition{},
+		&models.Chapter{},
+		&models.BookPosition{},
 	)
 	if db.Error != nil {
 		log.Errorf("Failed to update database schema: %v", err)
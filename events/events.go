@@ -0,0 +1,220 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	// SongAdded is published when a song is added to the library.
+	SongAdded = "song.added"
+	// SongRemoved is published when a song is removed from the library.
+	SongRemoved = "song.removed"
+	// ScanProgress is published while a scan job runs and when it stops.
+	ScanProgress = "scan.progress"
+	// PlaylistChanged is published when a playlist is created, deleted or its songs change.
+	PlaylistChanged = "playlist.changed"
+	// QueueChanged is published to a user when their play queue changes.
+	QueueChanged = "queue.changed"
+	// NowPlaying is published when a song starts playing.
+	NowPlaying = "nowplaying"
+	// NowPlayingStopped is published when a client stops playing, or stopped reporting.
+	NowPlayingStopped = "nowplaying.stopped"
+	// Resync is sent to a subscriber that missed events which are no longer
+	// in the history. The client should reload its state.
+	Resync = "resync"
+)
+
+// Event is a message published on the bus.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+
+	// UserID restricts the event to one user. 0 means everyone.
+	UserID uint `json:"-"`
+	// LibraryID restricts the event to the users that can access a library. 0 means
+	// the event is not about a library.
+	LibraryID uint `json:"-"`
+}
+
+// Subscription receives the events of a bus. C is closed when the subscriber
+// falls too far behind or is unsubscribed.
+type Subscription struct {
+	C <-chan *Event
+
+	c      chan *Event
+	userID uint
+	allows func(libraryID uint) bool
+	types  map[string]bool
+}
+
+func (s *Subscription) wants(e *Event) bool {
+	if e.UserID != 0 && e.UserID != s.userID {
+		return false
+	}
+
+	if e.LibraryID != 0 && s.allows != nil && !s.allows(e.LibraryID) {
+		return false
+	}
+
+	if len(s.types) != 0 && !s.types[e.Type] && e.Type != Resync {
+		return false
+	}
+
+	return true
+}
+
+// subscriptionBuffer is the number of events a subscriber can lag behind before it is dropped.
+const subscriptionBuffer = 64
+
+// Bus delivers published events to subscribers and keeps a history so
+// reconnecting subscribers can catch up on what they missed.
+type Bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []*Event
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBus creates a bus that remembers the last historySize events.
+func NewBus(historySize int) *Bus {
+	return &Bus{
+		nextID:      1,
+		historySize: historySize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish sends an event to all interested subscribers.
+func (b *Bus) Publish(typ string, userID uint, data interface{}) *Event {
+	return b.publish(&Event{Type: typ, Data: data, UserID: userID})
+}
+
+// PublishLibrary sends an event about a library to the interested subscribers that can
+// access it.
+func (b *Bus) PublishLibrary(typ string, libraryID uint, data interface{}) *Event {
+	return b.publish(&Event{Type: typ, Data: data, LibraryID: libraryID})
+}
+
+func (b *Bus) publish(e *Event) *Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.ID = b.nextID
+	e.Time = time.Now()
+	b.nextID++
+
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for s := range b.subscribers {
+		if !s.wants(e) {
+			continue
+		}
+
+		select {
+		case s.c <- e:
+		default:
+			// Too slow. Closing lets the client reconnect with its last event ID.
+			b.remove(s)
+		}
+	}
+
+	return e
+}
+
+// Subscribe returns a subscription for the events of a user. Only events with
+// one of the given types are delivered, or all events if types is empty.
+// If lastID is not 0 the events after lastID that are still in the history are
+// delivered first.
+func (b *Bus) Subscribe(userID uint, lastID uint64, types ...string) *Subscription {
+	return b.SubscribeLibraries(userID, nil, lastID, types...)
+}
+
+// SubscribeLibraries is like Subscribe, but events about a library are only delivered
+// if allows returns true for it. A nil allows delivers the events of all libraries.
+func (b *Bus) SubscribeLibraries(userID uint, allows func(libraryID uint) bool, lastID uint64, types ...string) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan *Event, subscriptionBuffer)
+	s := &Subscription{
+		C:      c,
+		c:      c,
+		userID: userID,
+		allows: allows,
+		types:  map[string]bool{},
+	}
+
+	for _, typ := range types {
+		s.types[typ] = true
+	}
+
+	if lastID != 0 {
+		b.replay(s, lastID)
+	}
+
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+// replay queues the missed events of a new subscriber. b.mu must be held.
+func (b *Bus) replay(s *Subscription, lastID uint64) {
+	missed := []*Event{}
+	// IDs start from 1 again after a restart, so an ID that was not given out yet is
+	// from before it.
+	if lastID >= b.nextID || (len(b.history) > 0 && b.history[0].ID > lastID+1) {
+		missed = append(missed, &Event{Type: Resync, Time: time.Now()})
+	}
+
+	for _, e := range b.history {
+		if e.ID > lastID && s.wants(e) {
+			missed = append(missed, e)
+		}
+	}
+
+	if len(missed) > subscriptionBuffer {
+		missed = []*Event{{Type: Resync, Time: time.Now()}}
+	}
+
+	for _, e := range missed {
+		s.c <- e
+	}
+}
+
+// Unsubscribe stops delivering events to s.
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(s)
+}
+
+// remove deletes a subscriber. b.mu must be held.
+func (b *Bus) remove(s *Subscription) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+
+	delete(b.subscribers, s)
+	close(s.c)
+}
+
+// Default is the bus used by the application.
+var Default = NewBus(1000)
+
+// Publish publishes an event on the default bus.
+func Publish(typ string, userID uint, data interface{}) *Event {
+	return Default.Publish(typ, userID, data)
+}
+
+// PublishLibrary publishes an event about a library on the default bus.
+func PublishLibrary(typ string, libraryID uint, data interface{}) *Event {
+	return Default.PublishLibrary(typ, libraryID, data)
+}
@@ -0,0 +1,93 @@
+package events
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBus(t *testing.T) {
+
+	Convey("Events are delivered to interested subscribers", t, func() {
+		b := NewBus(10)
+
+		all := b.Subscribe(1, 0)
+		other := b.Subscribe(2, 0)
+		songs := b.Subscribe(1, 0, SongAdded)
+
+		b.Publish(SongAdded, 0, "a")
+		b.Publish(PlaylistChanged, 1, "b")
+
+		So((<-all.C).Data, ShouldEqual, "a")
+		So((<-all.C).Data, ShouldEqual, "b")
+		So((<-other.C).Data, ShouldEqual, "a")
+		So(len(other.C), ShouldEqual, 0)
+		So((<-songs.C).Data, ShouldEqual, "a")
+		So(len(songs.C), ShouldEqual, 0)
+
+		b.Unsubscribe(all)
+		_, ok := <-all.C
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Reconnecting subscribers get the events they missed", t, func() {
+		b := NewBus(3)
+		for i := 0; i < 5; i++ {
+			b.Publish(SongAdded, 0, i)
+		}
+
+		sub := b.Subscribe(1, 3)
+		So((<-sub.C).ID, ShouldEqual, 4)
+		So((<-sub.C).ID, ShouldEqual, 5)
+		So(len(sub.C), ShouldEqual, 0)
+
+		sub = b.Subscribe(1, 1)
+		So((<-sub.C).Type, ShouldEqual, Resync)
+		So((<-sub.C).ID, ShouldEqual, 3)
+
+		// An ID from before a restart.
+		sub = b.Subscribe(1, 100)
+		So((<-sub.C).Type, ShouldEqual, Resync)
+		So(len(sub.C), ShouldEqual, 0)
+
+		sub = b.Subscribe(1, 5)
+		So(len(sub.C), ShouldEqual, 0)
+	})
+
+	Convey("Library events are only delivered to subscribers that can access the library", t, func() {
+		b := NewBus(10)
+		b.PublishLibrary(SongAdded, 2, "missed")
+
+		allowed := func(libraryID uint) bool { return libraryID == 1 }
+		restricted := b.SubscribeLibraries(1, allowed, 0)
+		all := b.Subscribe(2, 0)
+
+		b.PublishLibrary(SongAdded, 2, "hidden")
+		b.PublishLibrary(SongAdded, 1, "shown")
+
+		So((<-restricted.C).Data, ShouldEqual, "shown")
+		So(len(restricted.C), ShouldEqual, 0)
+		So((<-all.C).Data, ShouldEqual, "hidden")
+		So((<-all.C).Data, ShouldEqual, "shown")
+
+		replayed := b.SubscribeLibraries(1, allowed, 1)
+		So((<-replayed.C).Data, ShouldEqual, "shown")
+		So(len(replayed.C), ShouldEqual, 0)
+	})
+
+	Convey("Slow subscribers are dropped", t, func() {
+		b := NewBus(10)
+		sub := b.Subscribe(1, 0)
+
+		for i := 0; i < subscriptionBuffer+1; i++ {
+			b.Publish(SongAdded, 0, i)
+		}
+
+		received := 0
+		for range sub.C {
+			received++
+		}
+		So(received, ShouldEqual, subscriptionBuffer)
+	})
+
+}
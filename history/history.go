@@ -0,0 +1,126 @@
+package history
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/jinzhu/gorm"
+)
+
+// maxUnmatched is the number of unmatched songs listed in a report.
+const maxUnmatched = 1000
+
+// Unmatched is a song in an export that is not in the library.
+type Unmatched struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	Album  string `json:"album,omitempty"`
+	MBID   string `json:"mbid,omitempty"`
+	// Plays is the number of listens of the song.
+	Plays int `json:"plays"`
+}
+
+// Report describes the result of an import.
+type Report struct {
+	// Listens is the number of plays in the export.
+	Listens  int `json:"listens"`
+	Imported int `json:"imported"`
+	// Duplicates were already in the history, e.g. from an earlier import.
+	Duplicates int `json:"duplicates"`
+	// Skipped is the number of entries that are not plays.
+	Skipped int `json:"skipped"`
+	// UnmatchedListens is the number of plays of songs that are not in the library.
+	UnmatchedListens int `json:"unmatched_listens"`
+	// Unmatched are the songs that are not in the library, most played first.
+	Unmatched []*Unmatched `json:"unmatched"`
+}
+
+// Record adds a play to the history of a user. It does nothing if the play is already in it.
+func Record(tx *gorm.DB, userID uint, songID uint, playedAt time.Time, source string) (bool, error) {
+	// Times are stored in seconds so imports of the same play are recognized.
+	playedAt = playedAt.UTC().Truncate(time.Second)
+
+	count := 0
+	if gormDB := tx.Model(&models.Play{}).Where("user_id = ? AND song_id = ? AND played_at = ?", userID, songID, playedAt).Count(&count); gormDB.Error != nil {
+		return false, gormDB.Error
+	} else if count != 0 {
+		return false, nil
+	}
+
+	play := &models.Play{UserID: userID, SongID: songID, PlayedAt: playedAt, Source: source}
+	if gormDB := tx.Create(play); gormDB.Error != nil {
+		return false, gormDB.Error
+	}
+
+	return true, nil
+}
+
+// Import adds the listens of an export that match a song to the history of a user. The
+// play counts of the songs are increased by the imported plays.
+func Import(userID uint, export *Export, matcher *Matcher) (*Report, error) {
+	report := &Report{
+		Listens:   len(export.Listens),
+		Skipped:   export.Skipped,
+		Unmatched: []*Unmatched{},
+	}
+
+	unmatched := map[string]*Unmatched{}
+	played := map[uint]int{}
+
+	tx := db.DB.Begin()
+	for _, l := range export.Listens {
+		songID, ok := matcher.Match(l)
+		if !ok {
+			report.UnmatchedListens++
+			k := key(l.Artist, l.Title, l.Album)
+			if u, ok := unmatched[k]; ok {
+				u.Plays++
+			} else {
+				unmatched[k] = &Unmatched{Artist: l.Artist, Title: l.Title, Album: l.Album, MBID: l.MBID, Plays: 1}
+			}
+			continue
+		}
+
+		added, err := Record(tx, userID, songID, l.PlayedAt, models.PlayImported)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if added {
+			report.Imported++
+			played[songID]++
+		} else {
+			report.Duplicates++
+		}
+	}
+
+	for songID, n := range played {
+		if gormDB := tx.Table("songs").Where("id = ?", songID).Update("played", gorm.Expr("played + ?", n)); gormDB.Error != nil {
+			tx.Rollback()
+			return nil, gormDB.Error
+		}
+	}
+
+	if gormDB := tx.Commit(); gormDB.Error != nil {
+		return nil, gormDB.Error
+	}
+
+	for _, u := range unmatched {
+		report.Unmatched = append(report.Unmatched, u)
+	}
+	sort.Slice(report.Unmatched, func(i, j int) bool {
+		a, b := report.Unmatched[i], report.Unmatched[j]
+		if a.Plays != b.Plays {
+			return a.Plays > b.Plays
+		}
+		return a.Artist+a.Title < b.Artist+b.Title
+	})
+	if len(report.Unmatched) > maxUnmatched {
+		report.Unmatched = report.Unmatched[:maxUnmatched]
+	}
+
+	return report, nil
+}
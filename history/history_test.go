@@ -0,0 +1,145 @@
+package history
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const lastFMExport = `[{"recenttracks": {"track": [
+	{"artist": {"#text": "Artist", "mbid": ""}, "name": "Now", "album": {"#text": ""}, "@attr": {"nowplaying": "true"}},
+	{"artist": {"#text": "Artist"}, "name": "One", "mbid": "B1A9C0E9-D987-4042-AE91-78D6A3267D69", "album": {"#text": "Album"}, "date": {"uts": "1500000000"}}
+]}}]`
+
+const listenBrainzExport = `{"listened_at": 1500000100, "track_metadata": {"artist_name": "Artist", "track_name": "Two", "release_name": "Album", "mbid_mapping": {"recording_mbid": "0d5b9c5e-3b4a-4c4f-9e57-2b8a2f6ed0a1"}}}
+{"listened_at": 1500000200, "track_metadata": {"artist_name": "Other", "track_name": "Three"}}`
+
+const scrobblerLog = "#AUDIOSCROBBLER/1.1\n#TZ/UTC\n#CLIENT/Rockbox\n" +
+	"Artist\tAlbum\tOne\t1\t200\tL\t1500000300\t\n" +
+	"Artist\tAlbum\tTwo\t2\t180\tS\t1500000400\t\n"
+
+func TestParse(t *testing.T) {
+	Convey("Last.fm exports are read without the playing song.", t, func() {
+		export, err := Parse(strings.NewReader(lastFMExport))
+		So(err, ShouldBeNil)
+		So(export.Skipped, ShouldEqual, 1)
+		So(len(export.Listens), ShouldEqual, 1)
+		So(export.Listens[0].Artist, ShouldEqual, "Artist")
+		So(export.Listens[0].Album, ShouldEqual, "Album")
+		So(export.Listens[0].MBID, ShouldEqual, "b1a9c0e9-d987-4042-ae91-78d6a3267d69")
+		So(export.Listens[0].PlayedAt.Unix(), ShouldEqual, 1500000000)
+	})
+
+	Convey("ListenBrainz exports are read as lines of listens.", t, func() {
+		export, err := Parse(strings.NewReader(listenBrainzExport))
+		So(err, ShouldBeNil)
+		So(len(export.Listens), ShouldEqual, 2)
+		So(export.Listens[0].MBID, ShouldEqual, "0d5b9c5e-3b4a-4c4f-9e57-2b8a2f6ed0a1")
+		So(export.Listens[1].Title, ShouldEqual, "Three")
+	})
+
+	Convey("Skipped songs in a .scrobbler.log are not plays.", t, func() {
+		export, err := Parse(strings.NewReader(scrobblerLog))
+		So(err, ShouldBeNil)
+		So(export.Skipped, ShouldEqual, 1)
+		So(len(export.Listens), ShouldEqual, 1)
+		So(export.Listens[0].Title, ShouldEqual, "One")
+		So(export.Listens[0].PlayedAt.Unix(), ShouldEqual, 1500000300)
+	})
+
+	Convey("Other files are refused.", t, func() {
+		_, err := Parse(strings.NewReader("artist,title\n"))
+		So(err, ShouldEqual, ErrUnknownFormat)
+	})
+}
+
+func TestMatcher(t *testing.T) {
+	artist := &models.Artist{Name: "The Artist"}
+	album := &models.Album{Name: "Album"}
+	deluxe := &models.Album{Name: "Album (Deluxe)"}
+	songs := []*models.Song{
+		{Name: "One", Artist: artist, Album: album, MBID: "b1a9c0e9-d987-4042-ae91-78d6a3267d69"},
+		{Name: "Two!", Artist: artist, Album: album},
+		{Name: "Two!", Artist: artist, Album: deluxe},
+	}
+	for i, song := range songs {
+		song.ID = uint(i + 1)
+	}
+	m := NewMatcher(songs)
+
+	Convey("Songs are matched by MBID first.", t, func() {
+		id, ok := m.Match(&Listen{Artist: "Someone", Title: "Else", MBID: "b1a9c0e9-d987-4042-ae91-78d6a3267d69"})
+		So(ok, ShouldBeTrue)
+		So(id, ShouldEqual, 1)
+	})
+
+	Convey("Names are matched without case or punctuation.", t, func() {
+		id, ok := m.Match(&Listen{Artist: "artist", Title: "two", Album: "ALBUM (deluxe)"})
+		So(ok, ShouldBeTrue)
+		So(id, ShouldEqual, 3)
+
+		id, ok = m.Match(&Listen{Artist: "The Artist", Title: "Two", Album: "Live"})
+		So(ok, ShouldBeTrue)
+		So(id, ShouldEqual, 2)
+
+		_, ok = m.Match(&Listen{Artist: "The Artist", Title: "Three"})
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestImport(t *testing.T) {
+	Convey("Matched plays are added to the history once.", t, func() {
+		So(db.SetupConnection(db.SQLITE, "file:history?mode=memory&cache=shared"), ShouldBeNil)
+		defer db.Shutdown()
+		So(db.SetupSchema(), ShouldBeNil)
+
+		artist := &models.Artist{Name: "Artist"}
+		So(db.DB.Create(artist).Error, ShouldBeNil)
+		song := &models.Song{Name: "One", Mime: "audio/mpeg", Path: "one.mp3", Artist: artist}
+		So(db.DB.Create(song).Error, ShouldBeNil)
+		m := NewMatcher([]*models.Song{song})
+
+		played := time.Unix(1500000000, 0)
+		export := &Export{
+			Listens: []*Listen{
+				{Artist: "Artist", Title: "One", PlayedAt: played},
+				{Artist: "Artist", Title: "One", PlayedAt: played.Add(time.Hour)},
+				{Artist: "Artist", Title: "Missing", PlayedAt: played},
+				{Artist: "Artist", Title: "Missing", PlayedAt: played.Add(time.Hour)},
+			},
+			Skipped: 1,
+		}
+
+		report, err := Import(1, export, m)
+		So(err, ShouldBeNil)
+		So(report.Listens, ShouldEqual, 4)
+		So(report.Imported, ShouldEqual, 2)
+		So(report.Skipped, ShouldEqual, 1)
+		So(report.UnmatchedListens, ShouldEqual, 2)
+		So(len(report.Unmatched), ShouldEqual, 1)
+		So(report.Unmatched[0].Plays, ShouldEqual, 2)
+
+		stored := &models.Song{}
+		db.DB.First(stored, song.ID)
+		So(stored.Played, ShouldEqual, 2)
+
+		report, err = Import(1, export, m)
+		So(err, ShouldBeNil)
+		So(report.Imported, ShouldEqual, 0)
+		So(report.Duplicates, ShouldEqual, 2)
+
+		// Histories are per user.
+		report, err = Import(2, export, m)
+		So(err, ShouldBeNil)
+		So(report.Imported, ShouldEqual, 2)
+
+		count := 0
+		db.DB.Model(&models.Play{}).Count(&count)
+		So(count, ShouldEqual, 4)
+	})
+}
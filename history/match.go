@@ -0,0 +1,85 @@
+package history
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// normalize makes names that differ in case, punctuation or spacing equal.
+func normalize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, s)
+
+	return strings.TrimPrefix(strings.Join(strings.Fields(s), " "), "the ")
+}
+
+func key(parts ...string) string {
+	for i := range parts {
+		parts[i] = normalize(parts[i])
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+// Matcher finds the songs of listens in a library.
+type Matcher struct {
+	mbids  map[string]uint
+	albums map[string]uint
+	titles map[string]uint
+}
+
+// NewMatcher returns a matcher for songs. Artist and Album of the songs must be loaded.
+func NewMatcher(songs []*models.Song) *Matcher {
+	m := &Matcher{
+		mbids:  map[string]uint{},
+		albums: map[string]uint{},
+		titles: map[string]uint{},
+	}
+
+	for _, song := range songs {
+		if len(song.MBID) != 0 {
+			m.mbids[song.MBID] = song.ID
+		}
+
+		if song.Artist == nil {
+			continue
+		}
+
+		album := ""
+		if song.Album != nil {
+			album = song.Album.Name
+		}
+
+		// The first song wins when the same song is on more than one album.
+		titleKey := key(song.Artist.Name, song.Name)
+		if _, ok := m.titles[titleKey]; !ok {
+			m.titles[titleKey] = song.ID
+		}
+		m.albums[key(song.Artist.Name, song.Name, album)] = song.ID
+	}
+
+	return m
+}
+
+// Match returns the song of a listen: by MBID, else by artist, title and album, else by
+// artist and title.
+func (m *Matcher) Match(l *Listen) (uint, bool) {
+	if id, ok := m.mbids[l.MBID]; ok && len(l.MBID) != 0 {
+		return id, true
+	}
+
+	if len(l.Album) != 0 {
+		if id, ok := m.albums[key(l.Artist, l.Title, l.Album)]; ok {
+			return id, true
+		}
+	}
+
+	id, ok := m.titles[key(l.Artist, l.Title)]
+	return id, ok
+}
@@ -0,0 +1,245 @@
+// Package history keeps the play history of users and imports the history of other
+// players: Last.fm and ListenBrainz exports and the .scrobbler.log of portable players.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownFormat is returned for files that are not a supported export.
+var ErrUnknownFormat = errors.New("Unknown history format")
+
+// Listen is a play in an export.
+type Listen struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	Album  string `json:"album,omitempty"`
+	// MBID is the MusicBrainz recording id, if the export has it.
+	MBID     string    `json:"mbid,omitempty"`
+	PlayedAt time.Time `json:"played_at"`
+}
+
+// Export is the content of a parsed export.
+type Export struct {
+	Listens []*Listen
+	// Skipped is the number of entries that are not plays, like skipped songs or songs
+	// that were playing when the export was made, or that could not be read.
+	Skipped int
+}
+
+// add adds a listen, or skips it if it lacks what is needed to match it.
+func (e *Export) add(l *Listen) {
+	l.Artist = strings.TrimSpace(l.Artist)
+	l.Title = strings.TrimSpace(l.Title)
+	l.Album = strings.TrimSpace(l.Album)
+	l.MBID = strings.ToLower(strings.TrimSpace(l.MBID))
+
+	if (len(l.Artist) == 0 || len(l.Title) == 0) && len(l.MBID) == 0 || l.PlayedAt.Unix() <= 0 {
+		e.Skipped++
+		return
+	}
+
+	l.PlayedAt = l.PlayedAt.UTC()
+	e.Listens = append(e.Listens, l)
+}
+
+// Parse reads an export. The format is recognized by the content: JSON exports of
+// Last.fm (pages of user.getRecentTracks) or ListenBrainz (an array or lines of
+// listens), or an Audioscrobbler .scrobbler.log.
+func Parse(r io.Reader) (*Export, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, ErrUnknownFormat
+		}
+
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+			continue
+		case '{', '[':
+			return parseJSON(br)
+		case '#':
+			return parseScrobblerLog(br)
+		default:
+			return nil, ErrUnknownFormat
+		}
+	}
+}
+
+// parseJSON reads one or more JSON values with listens.
+func parseJSON(r io.Reader) (*Export, error) {
+	e := &Export{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for {
+		var v interface{}
+		if err := decoder.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		e.value(v)
+	}
+
+	return e, nil
+}
+
+// value adds the listens in a JSON value.
+func (e *Export) value(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			e.value(item)
+		}
+	case map[string]interface{}:
+		if tracks, ok := v["recenttracks"].(map[string]interface{}); ok {
+			// A page of user.getRecentTracks.
+			e.value(tracks["track"])
+		} else if payload, ok := v["payload"]; ok {
+			// A ListenBrainz API response or submission.
+			e.value(payload)
+		} else if listens, ok := v["listens"]; ok {
+			e.value(listens)
+		} else if metadata, ok := v["track_metadata"].(map[string]interface{}); ok {
+			e.add(listenBrainzListen(v, metadata))
+		} else if _, ok := v["name"]; ok && v["artist"] != nil {
+			e.add(lastFMTrack(v))
+		} else {
+			e.Skipped++
+		}
+	default:
+		e.Skipped++
+	}
+}
+
+// text returns a JSON string, or the "#text" or "name" of a Last.fm object.
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if s, ok := v["#text"].(string); ok {
+			return s
+		}
+		return text(v["name"])
+	}
+
+	return ""
+}
+
+// unix returns the time of Unix seconds in a JSON number or string.
+func unix(v interface{}) time.Time {
+	s := ""
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	}
+
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0)
+}
+
+// lastFMTrack returns the listen of a track of user.getRecentTracks. The track that is
+// playing now has no date and is skipped.
+func lastFMTrack(v map[string]interface{}) *Listen {
+	l := &Listen{
+		Artist: text(v["artist"]),
+		Title:  text(v["name"]),
+		Album:  text(v["album"]),
+		MBID:   text(v["mbid"]),
+	}
+
+	if date, ok := v["date"].(map[string]interface{}); ok {
+		l.PlayedAt = unix(date["uts"])
+	}
+
+	return l
+}
+
+// listenBrainzListen returns a listen of ListenBrainz. Playing now listens have no time
+// and are skipped.
+func listenBrainzListen(v map[string]interface{}, metadata map[string]interface{}) *Listen {
+	l := &Listen{
+		Artist:   text(metadata["artist_name"]),
+		Title:    text(metadata["track_name"]),
+		Album:    text(metadata["release_name"]),
+		PlayedAt: unix(v["listened_at"]),
+	}
+
+	if info, ok := metadata["additional_info"].(map[string]interface{}); ok {
+		l.MBID = text(info["recording_mbid"])
+	}
+	if mapping, ok := metadata["mbid_mapping"].(map[string]interface{}); ok && len(l.MBID) == 0 {
+		l.MBID = text(mapping["recording_mbid"])
+	}
+
+	return l
+}
+
+// parseScrobblerLog reads an Audioscrobbler portable player log. Lines are tab
+// separated: artist, album, title, track number, duration, rating, timestamp and
+// optionally the MBID. A rating of "S" is a skipped song.
+func parseScrobblerLog(r io.Reader) (*Export, error) {
+	e := &Export{}
+	local := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			// Players without a clock in UTC write the local time of the player.
+			if strings.HasPrefix(line, "#TZ/") {
+				local = strings.TrimPrefix(line, "#TZ/") != "UTC"
+			}
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 || fields[5] == "S" {
+			e.Skipped++
+			continue
+		}
+
+		l := &Listen{
+			Artist:   fields[0],
+			Album:    fields[1],
+			Title:    fields[2],
+			PlayedAt: unix(fields[6]),
+		}
+		if len(fields) > 7 {
+			l.MBID = fields[7]
+		}
+
+		if local {
+			t := l.PlayedAt.UTC()
+			l.PlayedAt = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+		}
+
+		e.add(l)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
@@ -0,0 +1,192 @@
+// Package hls serves songs over HTTP Live Streaming. Songs are split into segments
+// of SegmentDuration seconds that are transcoded to AAC in MPEG-TS when they are
+// first requested, so a player that seeks only waits for the segment it needs.
+// Segments are kept in the transcoding cache, which limits their size and age.
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+
+	"github.com/cadenzr/cadenzr/streamers"
+)
+
+// SegmentDuration is the length of a segment in seconds.
+const SegmentDuration = 10.0
+
+// ContentType is the MIME type of playlists.
+const ContentType = "application/vnd.apple.mpegurl"
+
+// SegmentContentType is the MIME type of segments.
+const SegmentContentType = "video/mp2t"
+
+// SegmentExtension is the extension of segment files.
+const SegmentExtension = ".ts"
+
+// ErrSegment is returned for a segment that is not part of a song.
+var ErrSegment = errors.New("Segment out of range")
+
+// Variant is a bitrate that songs are available in.
+type Variant struct {
+	Name string
+	// Bitrate is in bits per second.
+	Bitrate int
+}
+
+// Variants are the bitrates players can choose from, lowest first.
+var Variants = []Variant{
+	{Name: "low", Bitrate: 64000},
+	{Name: "medium", Bitrate: 128000},
+	{Name: "high", Bitrate: 256000},
+}
+
+// FindVariant returns the variant with a name.
+func FindVariant(name string) (Variant, bool) {
+	for _, v := range Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+
+	return Variant{}, false
+}
+
+// VariantsUpTo returns the variants with a bitrate of at most max kbit/s, or the lowest
+// if there are none. 0 means no limit.
+func VariantsUpTo(max int) []Variant {
+	if max <= 0 {
+		return Variants
+	}
+
+	r := []Variant{}
+	for _, v := range Variants {
+		if v.Bitrate <= max*1000 {
+			r = append(r, v)
+		}
+	}
+
+	if len(r) == 0 {
+		return Variants[:1]
+	}
+
+	return r
+}
+
+// Segments returns the durations of the segments of a song that lasts duration seconds.
+func Segments(duration float64) []float64 {
+	segments := []float64{}
+	for start := 0.0; start < duration; start += SegmentDuration {
+		segments = append(segments, math.Min(SegmentDuration, duration-start))
+	}
+
+	return segments
+}
+
+// MasterPlaylist lists variants, uri returns the location of the media playlist of one.
+func MasterPlaylist(variants []Variant, uri func(v Variant) string) string {
+	b := &bytes.Buffer{}
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	for _, v := range variants {
+		// The bitrate of AAC in MPEG-TS is about 10% higher because of the container.
+		b.WriteString("#EXT-X-STREAM-INF:BANDWIDTH=" + strconv.Itoa(v.Bitrate*11/10) + ",CODECS=\"mp4a.40.2\"\n")
+		b.WriteString(uri(v) + "\n")
+	}
+
+	return b.String()
+}
+
+// Track is a song in a media playlist.
+type Track struct {
+	Duration float64
+	// URI returns the location of a segment.
+	URI func(segment int) string
+}
+
+// MediaPlaylist lists the segments of tracks that are played after each other.
+func MediaPlaylist(tracks []Track) string {
+	b := &bytes.Buffer{}
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString("#EXT-X-TARGETDURATION:" + strconv.Itoa(int(math.Ceil(SegmentDuration))) + "\n")
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, track := range tracks {
+		// Time stamps start over with every song.
+		if i > 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+
+		for segment, duration := range Segments(track.Duration) {
+			b.WriteString("#EXTINF:" + strconv.FormatFloat(duration, 'f', 3, 64) + ",\n")
+			b.WriteString(track.URI(segment) + "\n")
+		}
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	return b.String()
+}
+
+// Source is a song to segment.
+type Source struct {
+	Path     string
+	Duration float64
+	// Key identifies the song and its content, e.g. its ID and hash. Segments of
+	// different keys are cached separately.
+	Key string
+}
+
+// encode writes one segment of source to w. It is replaced in tests.
+var encode = func(ctx context.Context, source string, start float64, duration float64, v Variant, w io.Writer) error {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-hide_banner",
+		"-loglevel", "error",
+		"-y",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-t", strconv.FormatFloat(duration, 'f', 3, 64),
+		"-i", source,
+		"-vn",
+		"-codec:a", "aac",
+		"-b:a", strconv.Itoa(v.Bitrate),
+		"-ac", "2",
+		"-ar", "44100",
+		// Segments are encoded on their own, the offset makes their time stamps continue.
+		"-output_ts_offset", strconv.FormatFloat(start, 'f', 3, 64),
+		"-muxdelay", "0",
+		"-f", "mpegts",
+		"pipe:1",
+	)
+
+	output := &bytes.Buffer{}
+	cmd.Stdout = w
+	cmd.Stderr = output
+	if err := cmd.Run(); err != nil {
+		return errors.New(err.Error() + ": " + output.String())
+	}
+
+	return nil
+}
+
+// Segment returns the file of a segment of a song in a variant, it is encoded if it isn't cached.
+func Segment(source *Source, v Variant, segment int) (string, error) {
+	segments := Segments(source.Duration)
+	if segment < 0 || segment >= len(segments) {
+		return "", ErrSegment
+	}
+
+	name := "hls-" + source.Key + "-" + v.Name + "-" + strconv.Itoa(segment) + SegmentExtension
+	start := float64(segment) * SegmentDuration
+	return streamers.Transcodings.Get(name, func(ctx context.Context, w io.Writer) error {
+		return encode(ctx, source.Path, start, segments[segment], v, w)
+	})
+}
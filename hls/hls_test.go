@@ -0,0 +1,101 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/streamers"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPlaylists(t *testing.T) {
+	Convey("Songs are split in segments of equal length.", t, func() {
+		So(Segments(25), ShouldResemble, []float64{10, 10, 5})
+		So(Segments(20), ShouldResemble, []float64{10, 10})
+		So(len(Segments(0)), ShouldEqual, 0)
+	})
+
+	Convey("The master playlist lists every variant.", t, func() {
+		master := MasterPlaylist(Variants, func(v Variant) string {
+			return v.Name + "/index.m3u8"
+		})
+
+		So(master, ShouldStartWith, "#EXTM3U\n")
+		So(master, ShouldContainSubstring, "#EXT-X-STREAM-INF:BANDWIDTH=140800,CODECS=\"mp4a.40.2\"\nmedium/index.m3u8\n")
+		So(strings.Count(master, "#EXT-X-STREAM-INF"), ShouldEqual, len(Variants))
+	})
+
+	Convey("Variants above a maximum bitrate are left out.", t, func() {
+		So(len(VariantsUpTo(0)), ShouldEqual, len(Variants))
+		So(VariantsUpTo(128), ShouldResemble, Variants[:2])
+		So(VariantsUpTo(32), ShouldResemble, Variants[:1])
+	})
+
+	Convey("Songs in a media playlist are separated by discontinuities.", t, func() {
+		track := func(name string, duration float64) Track {
+			return Track{Duration: duration, URI: func(segment int) string {
+				return name + "/" + string(rune('0'+segment)) + ".ts"
+			}}
+		}
+
+		media := MediaPlaylist([]Track{track("a", 15), track("b", 5)})
+		So(media, ShouldContainSubstring, "#EXT-X-TARGETDURATION:10\n")
+		So(media, ShouldContainSubstring, "#EXTINF:10.000,\na/0.ts\n#EXTINF:5.000,\na/1.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:5.000,\nb/0.ts\n")
+		So(media, ShouldEndWith, "#EXT-X-ENDLIST\n")
+	})
+}
+
+func TestSegment(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hls")
+	defer os.RemoveAll(dir)
+	transcodings := streamers.Transcodings
+	defer func() { streamers.Transcodings = transcodings }()
+	streamers.Transcodings = streamers.NewCache(dir, 1<<20, time.Hour)
+
+	encoded := []float64{}
+	encode = func(ctx context.Context, source string, start float64, duration float64, v Variant, w io.Writer) error {
+		if source == "broken.mp3" {
+			return errors.New("broken")
+		}
+		encoded = append(encoded, start, duration)
+		_, err := w.Write([]byte(v.Name))
+		return err
+	}
+
+	source := &Source{Path: "song.mp3", Duration: 25, Key: "1"}
+	medium, _ := FindVariant("medium")
+
+	Convey("Segments are encoded once and cached.", t, func() {
+		file, err := Segment(source, medium, 2)
+		So(err, ShouldBeNil)
+		So(file, ShouldEndWith, "hls-1-medium-2.ts")
+		content, _ := ioutil.ReadFile(file)
+		So(string(content), ShouldEqual, "medium")
+
+		_, err = Segment(source, medium, 2)
+		So(err, ShouldBeNil)
+		So(encoded, ShouldResemble, []float64{20, 5})
+
+		// They count towards the transcoding cache and are purged with it.
+		So(streamers.Transcodings.Stats().Files, ShouldEqual, 1)
+		So(streamers.Transcodings.Purge(), ShouldEqual, 1)
+	})
+
+	Convey("Segments outside the song and failed encodings are errors.", t, func() {
+		_, err := Segment(source, medium, 3)
+		So(err, ShouldEqual, ErrSegment)
+
+		_, err = Segment(&Source{Path: "broken.mp3", Duration: 25, Key: "2"}, medium, 0)
+		So(err, ShouldNotBeNil)
+
+		files, _ := ioutil.ReadDir(dir)
+		So(len(files), ShouldEqual, 0)
+	})
+}
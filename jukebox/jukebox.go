@@ -0,0 +1,359 @@
+// Package jukebox plays a queue of songs on the audio output of the server, for speakers
+// that are wired to it. Pausing, seeking and changing the volume restart the output at
+// the position the track had reached.
+package jukebox
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/log"
+)
+
+// Errors of the jukebox controls.
+var (
+	ErrEmptyQueue      = errors.New("The jukebox queue is empty")
+	ErrInvalidIndex    = errors.New("There is no track at that index in the queue")
+	ErrInvalidPosition = errors.New("The position is outside the track")
+	ErrInvalidVolume   = errors.New("The volume must be between 0 and 100")
+)
+
+// MaxVolume is the volume at which tracks play unchanged.
+const MaxVolume = 100
+
+// Track is a song in the queue.
+type Track struct {
+	SongID uint
+	Path   string
+	// Duration in seconds, 0 if it is unknown.
+	Duration float64
+}
+
+// Status is the state of the jukebox.
+type Status struct {
+	Tracks []*Track
+	// Current is the index in Tracks of the track that plays or plays next.
+	Current int
+	Playing bool
+	// Position is the time in seconds in the current track.
+	Position float64
+	Volume   int
+}
+
+// Jukebox plays a queue of tracks on an output.
+type Jukebox struct {
+	mu      sync.Mutex
+	output  Output
+	tracks  []*Track
+	current int
+	playing bool
+	volume  int
+	// position is where playback of the current track started, or was stopped.
+	position float64
+	started  time.Time
+	playback Playback
+
+	// users can control the jukebox, besides the administrator.
+	users map[string]bool
+}
+
+// Default is the jukebox of the server, nil when it is off.
+var Default *Jukebox
+
+// New returns a jukebox with an empty queue that plays on output.
+func New(output Output) *Jukebox {
+	return &Jukebox{
+		output: output,
+		volume: MaxVolume,
+		users:  map[string]bool{},
+	}
+}
+
+// Setup creates the Default jukebox from the configuration. It stays nil when the
+// jukebox is off.
+func Setup(conf config.Jukebox) error {
+	output, err := NewOutput(conf)
+	if err != nil || output == nil {
+		return err
+	}
+
+	j := New(output)
+	for _, username := range conf.Users {
+		j.users[username] = true
+	}
+
+	log.Infof("Jukebox plays on '%s'.", output)
+	Default = j
+	return nil
+}
+
+// Allows returns whether a user other than the administrator can control the jukebox.
+func (j *Jukebox) Allows(username string) bool {
+	return j.users[username]
+}
+
+// elapsed returns the position in the current track. It is called with mu held.
+func (j *Jukebox) elapsed() float64 {
+	if j.playback == nil {
+		return j.position
+	}
+
+	position := j.position + time.Since(j.started).Seconds()
+	if d := j.tracks[j.current].Duration; d > 0 && position > d {
+		position = d
+	}
+
+	return position
+}
+
+// stop stops the output and remembers the position. It is called with mu held.
+func (j *Jukebox) stop() {
+	if j.playback == nil {
+		return
+	}
+
+	j.position = j.elapsed()
+	p := j.playback
+	j.playback = nil
+	p.Stop()
+}
+
+// start plays the current track from the position. At the end of the queue playback
+// stops and the queue starts over. It is called with mu held.
+func (j *Jukebox) start() error {
+	j.stop()
+	if j.current >= len(j.tracks) {
+		j.current = 0
+		j.position = 0
+		j.playing = false
+		return nil
+	}
+
+	p, err := j.output.Start(j.tracks[j.current], j.position, j.volume)
+	if err != nil {
+		j.playing = false
+		return err
+	}
+
+	j.playback = p
+	j.playing = true
+	j.started = time.Now()
+	go j.wait(p)
+	return nil
+}
+
+// wait moves on to the next track when a playback ends by itself.
+func (j *Jukebox) wait(p Playback) {
+	<-p.Done()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Playback that was stopped on purpose was replaced already.
+	if j.playback != p {
+		return
+	}
+
+	if err := p.Err(); err != nil {
+		log.Errorf("Jukebox could not play '%s': %v", j.tracks[j.current].Path, err)
+	}
+
+	j.playback = nil
+	j.current++
+	j.position = 0
+	if err := j.start(); err != nil {
+		log.Errorf("Jukebox could not start the next track: %v", err)
+	}
+}
+
+// restart stops the output, changes the jukebox and plays again if it was playing. It
+// is called with mu held.
+func (j *Jukebox) restart(change func()) error {
+	j.stop()
+	change()
+	if j.playing {
+		return j.start()
+	}
+
+	return nil
+}
+
+// Status returns the state of the jukebox.
+func (j *Jukebox) Status() *Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return &Status{
+		Tracks:   append([]*Track{}, j.tracks...),
+		Current:  j.current,
+		Playing:  j.playing,
+		Position: j.elapsed(),
+		Volume:   j.volume,
+	}
+}
+
+// Play starts or resumes playing the current track.
+func (j *Jukebox) Play() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.tracks) == 0 {
+		return ErrEmptyQueue
+	}
+
+	if j.playing {
+		return nil
+	}
+
+	return j.start()
+}
+
+// Pause stops playing and keeps the position.
+func (j *Jukebox) Pause() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.stop()
+	j.playing = false
+}
+
+// Skip moves to the start of the track at index.
+func (j *Jukebox) Skip(index int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if index < 0 || index >= len(j.tracks) {
+		return ErrInvalidIndex
+	}
+
+	return j.restart(func() {
+		j.current = index
+		j.position = 0
+	})
+}
+
+// Next moves to the start of the next track. After the last track playback stops.
+func (j *Jukebox) Next() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.tracks) == 0 {
+		return ErrEmptyQueue
+	}
+
+	j.stop()
+	j.current++
+	j.position = 0
+	if j.playing {
+		return j.start()
+	} else if j.current >= len(j.tracks) {
+		j.current = 0
+	}
+
+	return nil
+}
+
+// Seek moves to a position in seconds in the current track.
+func (j *Jukebox) Seek(position float64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.tracks) == 0 {
+		return ErrEmptyQueue
+	}
+
+	if d := j.tracks[j.current].Duration; position < 0 || (d > 0 && position > d) {
+		return ErrInvalidPosition
+	}
+
+	return j.restart(func() {
+		j.position = position
+	})
+}
+
+// SetVolume changes the volume, from 0 to MaxVolume.
+func (j *Jukebox) SetVolume(volume int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if volume < 0 || volume > MaxVolume {
+		return ErrInvalidVolume
+	}
+
+	if volume == j.volume {
+		return nil
+	}
+
+	return j.restart(func() {
+		j.volume = volume
+	})
+}
+
+// Set replaces the queue and moves to the start of the track at current.
+func (j *Jukebox) Set(tracks []*Track, current int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if current < 0 || (current >= len(tracks) && len(tracks) != 0) {
+		return ErrInvalidIndex
+	}
+
+	return j.restart(func() {
+		j.tracks = append([]*Track{}, tracks...)
+		j.current = current
+		j.position = 0
+		if len(j.tracks) == 0 {
+			j.playing = false
+		}
+	})
+}
+
+// Add appends tracks to the queue.
+func (j *Jukebox) Add(tracks ...*Track) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.tracks = append(j.tracks, tracks...)
+}
+
+// Remove removes the track at index from the queue. Removing the current track moves
+// to the next.
+func (j *Jukebox) Remove(index int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if index < 0 || index >= len(j.tracks) {
+		return ErrInvalidIndex
+	}
+
+	if index != j.current {
+		j.tracks = append(j.tracks[:index], j.tracks[index+1:]...)
+		if index < j.current {
+			j.current--
+		}
+		return nil
+	}
+
+	return j.restart(func() {
+		j.tracks = append(j.tracks[:index], j.tracks[index+1:]...)
+		j.position = 0
+		if j.current >= len(j.tracks) {
+			j.current = 0
+			j.playing = false
+		}
+	})
+}
+
+// Clear stops playing and empties the queue.
+func (j *Jukebox) Clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.stop()
+	j.tracks = nil
+	j.current = 0
+	j.position = 0
+	j.playing = false
+}
@@ -0,0 +1,117 @@
+package jukebox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// waitFor polls the status until cond holds or a second passed.
+func waitFor(j *Jukebox, cond func(*Status) bool) *Status {
+	deadline := time.Now().Add(time.Second)
+	for {
+		status := j.Status()
+		if cond(status) || time.Now().After(deadline) {
+			return status
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJukebox(t *testing.T) {
+	j := New(&nullOutput{})
+	tracks := []*Track{
+		{SongID: 1, Path: "/one.mp3", Duration: 0.05},
+		{SongID: 2, Path: "/two.mp3", Duration: 60},
+		{SongID: 3, Path: "/three.mp3", Duration: 0.05},
+	}
+
+	Convey("An empty queue can't play.", t, func() {
+		So(j.Play(), ShouldEqual, ErrEmptyQueue)
+		So(j.Status().Playing, ShouldBeFalse)
+	})
+
+	Convey("Tracks play one after another.", t, func() {
+		So(j.Set(tracks, 0), ShouldBeNil)
+		So(j.Play(), ShouldBeNil)
+
+		status := waitFor(j, func(s *Status) bool { return s.Current == 1 })
+		So(status.Current, ShouldEqual, 1)
+		So(status.Playing, ShouldBeTrue)
+		So(status.Volume, ShouldEqual, MaxVolume)
+	})
+
+	Convey("Pausing keeps the position and seeking moves it.", t, func() {
+		j.Pause()
+		So(j.Status().Playing, ShouldBeFalse)
+
+		So(j.Seek(30), ShouldBeNil)
+		So(j.Status().Position, ShouldEqual, 30)
+		So(j.Seek(90), ShouldEqual, ErrInvalidPosition)
+
+		So(j.Play(), ShouldBeNil)
+		So(j.Status().Position, ShouldBeGreaterThanOrEqualTo, 30)
+	})
+
+	Convey("The volume is limited.", t, func() {
+		So(j.SetVolume(50), ShouldBeNil)
+		So(j.SetVolume(101), ShouldEqual, ErrInvalidVolume)
+		status := j.Status()
+		So(status.Volume, ShouldEqual, 50)
+		So(status.Playing, ShouldBeTrue)
+	})
+
+	Convey("Removing tracks before the current one keeps it playing.", t, func() {
+		So(j.Remove(0), ShouldBeNil)
+		So(j.Remove(5), ShouldEqual, ErrInvalidIndex)
+		status := j.Status()
+		So(status.Current, ShouldEqual, 0)
+		So(status.Tracks[0].SongID, ShouldEqual, 2)
+		So(status.Position, ShouldBeGreaterThanOrEqualTo, 30)
+	})
+
+	Convey("The queue stops after the last track.", t, func() {
+		j.Add(&Track{SongID: 4, Path: "/four.mp3", Duration: 0.05})
+		So(j.Next(), ShouldBeNil)
+		So(j.Status().Tracks[1].SongID, ShouldEqual, 3)
+
+		status := waitFor(j, func(s *Status) bool { return !s.Playing })
+		So(status.Playing, ShouldBeFalse)
+		So(status.Current, ShouldEqual, 0)
+		So(len(status.Tracks), ShouldEqual, 3)
+	})
+
+	Convey("Skipping and clearing.", t, func() {
+		So(j.Skip(2), ShouldBeNil)
+		So(j.Skip(3), ShouldEqual, ErrInvalidIndex)
+		So(j.Status().Current, ShouldEqual, 2)
+
+		j.Clear()
+		status := j.Status()
+		So(len(status.Tracks), ShouldEqual, 0)
+		So(status.Playing, ShouldBeFalse)
+	})
+}
+
+func TestOutputs(t *testing.T) {
+	Convey("The jukebox is off without an output.", t, func() {
+		output, err := NewOutput(config.Jukebox{})
+		So(err, ShouldBeNil)
+		So(output, ShouldBeNil)
+	})
+
+	Convey("ffmpeg plays to the configured device.", t, func() {
+		o := &ffmpegOutput{ffmpeg: "ffmpeg", format: "alsa", device: "default"}
+		So(o.args("/a.flac", 12.5, 50), ShouldResemble, []string{
+			"-nostdin", "-loglevel", "error", "-ss", "12.500", "-i", "/a.flac", "-vn", "-af", "volume=0.50", "-f", "alsa", "default",
+		})
+
+		o = &ffmpegOutput{ffmpeg: "ffmpeg", format: "wav", device: "/tmp/out.wav", realtime: true}
+		So(o.args("/a.flac", 0, 100), ShouldResemble, []string{
+			"-nostdin", "-loglevel", "error", "-re", "-i", "/a.flac", "-vn", "-af", "volume=1.00", "-f", "wav", "-y", "/tmp/out.wav",
+		})
+	})
+}
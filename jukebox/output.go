@@ -0,0 +1,190 @@
+package jukebox
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+)
+
+// Output plays tracks on an audio device.
+type Output interface {
+	// Start plays a track from offset seconds, at a volume from 0 to 100.
+	Start(track *Track, offset float64, volume int) (Playback, error)
+
+	fmt.Stringer
+}
+
+// Playback is a track playing on an output.
+type Playback interface {
+	// Done is closed when the track ended or was stopped.
+	Done() <-chan struct{}
+	// Err returns why playback failed, after Done is closed. It is nil when the track
+	// played to the end or was stopped.
+	Err() error
+	// Stop stops playing and returns when the output is done.
+	Stop()
+}
+
+// NewOutput returns the output of the configuration, nil if the jukebox is off.
+func NewOutput(conf config.Jukebox) (Output, error) {
+	switch conf.Output {
+	case "":
+		return nil, nil
+	case config.JukeboxNull:
+		return &nullOutput{}, nil
+	}
+
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+
+	output := &ffmpegOutput{
+		ffmpeg: ffmpeg,
+		device: conf.Device,
+	}
+	switch conf.Output {
+	case config.JukeboxALSA:
+		output.format = "alsa"
+		if len(output.device) == 0 {
+			output.device = "default"
+		}
+	case config.JukeboxPulse:
+		output.format = "pulse"
+	case config.JukeboxFile:
+		output.format = "wav"
+		// A file takes the audio as fast as ffmpeg decodes it.
+		output.realtime = true
+	}
+
+	return output, nil
+}
+
+// ffmpegOutput plays tracks with an ffmpeg process for each.
+type ffmpegOutput struct {
+	ffmpeg string
+	// format is the ffmpeg output format.
+	format string
+	device string
+	// realtime reads the input at its playing speed.
+	realtime bool
+}
+
+func (o *ffmpegOutput) String() string {
+	return "ffmpeg " + o.format + " " + o.device
+}
+
+// args returns the ffmpeg arguments to play a file from offset seconds at a volume.
+func (o *ffmpegOutput) args(path string, offset float64, volume int) []string {
+	args := []string{"-nostdin", "-loglevel", "error"}
+	if o.realtime {
+		args = append(args, "-re")
+	}
+	if offset > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(offset, 'f', 3, 64))
+	}
+	args = append(args, "-i", path, "-vn", "-af", "volume="+strconv.FormatFloat(float64(volume)/100, 'f', 2, 64))
+
+	switch o.format {
+	case "pulse":
+		if len(o.device) != 0 {
+			args = append(args, "-device", o.device)
+		}
+		return append(args, "-f", "pulse", "cadenzr")
+	case "wav":
+		return append(args, "-f", "wav", "-y", o.device)
+	default:
+		return append(args, "-f", o.format, o.device)
+	}
+}
+
+func (o *ffmpegOutput) Start(track *Track, offset float64, volume int) (Playback, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, o.ffmpeg, o.args(track.Path, offset, volume)...)
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	p := &ffmpegPlayback{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	go func() {
+		err := cmd.Wait()
+		// ffmpeg is killed when it is stopped, which is not a failure.
+		if ctx.Err() == nil {
+			p.err = err
+		}
+		cancel()
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+type ffmpegPlayback struct {
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+}
+
+func (p *ffmpegPlayback) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *ffmpegPlayback) Err() error {
+	return p.err
+}
+
+func (p *ffmpegPlayback) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+// nullOutput plays nothing, but takes as long as the tracks last. It is meant for testing.
+type nullOutput struct {
+}
+
+func (o *nullOutput) String() string {
+	return "null"
+}
+
+func (o *nullOutput) Start(track *Track, offset float64, volume int) (Playback, error) {
+	remaining := track.Duration - offset
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	p := &timerPlayback{done: make(chan struct{})}
+	p.timer = time.AfterFunc(time.Duration(remaining*float64(time.Second)), p.close)
+	return p, nil
+}
+
+type timerPlayback struct {
+	timer *time.Timer
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (p *timerPlayback) close() {
+	p.once.Do(func() { close(p.done) })
+}
+
+func (p *timerPlayback) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *timerPlayback) Err() error {
+	return nil
+}
+
+func (p *timerPlayback) Stop() {
+	p.timer.Stop()
+	p.close()
+}
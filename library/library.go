@@ -0,0 +1,240 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// ErrInvalidPath is returned when a path is not inside a library.
+var ErrInvalidPath = errors.New("Path is not inside the library")
+
+// Library is a configured directory with music.
+type Library struct {
+	ID       uint
+	Name     string
+	Path     string
+	ReadOnly bool
+	// Audiobooks is set when every file in the library is an audiobook.
+	Audiobooks bool
+
+	include []string
+	exclude []string
+	users   map[string]bool
+}
+
+// New creates a library from its configuration. The ID is 0 until it is stored.
+func New(conf config.Library) *Library {
+	l := &Library{
+		Name:       conf.Name,
+		Path:       filepath.Clean(conf.Path),
+		ReadOnly:   conf.ReadOnly,
+		Audiobooks: conf.Audiobooks,
+		include:    conf.Include,
+		exclude:    conf.Exclude,
+		users:      map[string]bool{},
+	}
+
+	for _, user := range conf.Users {
+		l.users[user] = true
+	}
+
+	return l
+}
+
+// Allows returns whether a user can access the library.
+func (l *Library) Allows(username string) bool {
+	return len(l.users) == 0 || l.users[username]
+}
+
+// Contains returns whether path is inside the library.
+func (l *Library) Contains(path string) bool {
+	rel, err := filepath.Rel(l.Path, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Resolve returns the existing directory or file for a path relative to the library root.
+func (l *Library) Resolve(subpath string) (string, error) {
+	// Cleaning an absolute path removes all '..' elements, so the result stays inside the library.
+	subpath = filepath.Clean("/" + filepath.ToSlash(strings.TrimSpace(subpath)))
+	path := filepath.Join(l.Path, filepath.FromSlash(subpath))
+
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrInvalidPath
+	}
+
+	return path, nil
+}
+
+// Excluded returns whether a file or directory in the library is skipped because
+// of the exclude patterns.
+func (l *Library) Excluded(path string) bool {
+	return matchAny(l.exclude, l.relative(path))
+}
+
+// Included returns whether a file in the library should be added.
+func (l *Library) Included(path string) bool {
+	rel := l.relative(path)
+	if len(l.include) != 0 && !matchAny(l.include, rel) {
+		return false
+	}
+
+	return !matchAny(l.exclude, rel)
+}
+
+// relative returns path relative to the library root with '/' separators.
+func (l *Library) relative(path string) string {
+	rel, err := filepath.Rel(l.Path, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// matchAny returns whether a relative path matches one of the patterns.
+// Patterns without a '/' are matched against every element of the path, other
+// patterns against the path and each of its parent directories.
+func matchAny(patterns []string, rel string) bool {
+	elements := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			for _, element := range elements {
+				if ok, _ := filepath.Match(pattern, element); ok {
+					return true
+				}
+			}
+			continue
+		}
+
+		for i := len(elements); i > 0; i-- {
+			if ok, _ := filepath.Match(pattern, strings.Join(elements[:i], "/")); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+var mu sync.RWMutex
+var libraries = []*Library{}
+
+// Setup stores the configured libraries in the database and makes them available.
+// Songs without a library that are inside a library root are assigned to it.
+func Setup(confs []config.Library) error {
+	libs := []*Library{}
+	for _, conf := range confs {
+		l := New(conf)
+
+		model := &models.Library{}
+		if gormDB := db.DB.Where(models.Library{Name: l.Name}).Assign(models.Library{Path: l.Path}).FirstOrCreate(model); gormDB.Error != nil {
+			return gormDB.Error
+		}
+		l.ID = model.ID
+
+		gormDB := db.DB.Table("songs").Where("library_id IS NULL AND (path = ? OR path LIKE ?)", l.Path, l.Path+string(filepath.Separator)+"%").UpdateColumn("library_id", l.ID)
+		if gormDB.Error != nil {
+			return gormDB.Error
+		}
+		if gormDB.RowsAffected != 0 {
+			log.WithFields(log.Fields{"library": l.Name, "songs": gormDB.RowsAffected}).Info("Assigned songs to library.")
+		}
+
+		log.WithFields(log.Fields{"id": l.ID, "name": l.Name, "path": l.Path, "read_only": l.ReadOnly}).Info("Registered library.")
+		libs = append(libs, l)
+	}
+
+	Set(libs...)
+	return nil
+}
+
+// Set replaces the available libraries.
+func Set(libs ...*Library) {
+	mu.Lock()
+	libraries = libs
+	mu.Unlock()
+}
+
+// All returns the available libraries.
+func All() []*Library {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return append([]*Library{}, libraries...)
+}
+
+// Get returns a library by ID, or nil.
+func Get(id uint) *Library {
+	for _, l := range All() {
+		if l.ID == id {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// ByName returns a library by name, or nil.
+func ByName(name string) *Library {
+	for _, l := range All() {
+		if l.Name == name {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// ForPath returns the library that contains path, or nil.
+func ForPath(path string) *Library {
+	var found *Library
+	for _, l := range All() {
+		// Prefer the deepest root when libraries are nested.
+		if l.Contains(path) && (found == nil || len(l.Path) > len(found.Path)) {
+			found = l
+		}
+	}
+
+	return found
+}
+
+// Accessible returns the libraries a user can access.
+func Accessible(username string) []*Library {
+	libs := []*Library{}
+	for _, l := range All() {
+		if l.Allows(username) {
+			libs = append(libs, l)
+		}
+	}
+
+	return libs
+}
+
+// Writable returns the first library that is not read only, or nil.
+func Writable() *Library {
+	for _, l := range All() {
+		if !l.ReadOnly {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// IDs returns the IDs of libraries.
+func IDs(libs []*Library) []uint {
+	ids := []uint{}
+	for _, l := range libs {
+		ids = append(ids, l.ID)
+	}
+
+	return ids
+}
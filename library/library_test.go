@@ -0,0 +1,90 @@
+package library
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPatterns(t *testing.T) {
+
+	Convey("Include and exclude patterns", t, func() {
+		l := New(config.Library{
+			Name:    "music",
+			Path:    "/music",
+			Include: []string{"*.mp3", "*.flac"},
+			Exclude: []string{"Podcasts", "*/Demos/*"},
+		})
+
+		So(l.Included("/music/Artist/Album/01 Song.mp3"), ShouldBeTrue)
+		So(l.Included("/music/Artist/Album/cover.jpg"), ShouldBeFalse)
+		So(l.Included("/music/Podcasts/Show/1.mp3"), ShouldBeFalse)
+		So(l.Excluded("/music/Podcasts"), ShouldBeTrue)
+		So(l.Included("/music/Artist/Demos/1.mp3"), ShouldBeFalse)
+		So(l.Included("/music/Artist/Demos.mp3"), ShouldBeTrue)
+	})
+
+	Convey("Paths inside a library", t, func() {
+		l := New(config.Library{Name: "music", Path: "/music"})
+
+		So(l.Contains("/music/a.mp3"), ShouldBeTrue)
+		So(l.Contains("/music"), ShouldBeTrue)
+		So(l.Contains("/musicals/a.mp3"), ShouldBeFalse)
+		So(l.Contains("/music/../etc/passwd"), ShouldBeFalse)
+	})
+
+	Convey("Access per user", t, func() {
+		open := New(config.Library{Name: "open", Path: "/open"})
+		private := New(config.Library{Name: "private", Path: "/private", Users: []string{"admin"}})
+		Set(open, private)
+		defer Set()
+
+		So(len(Accessible("admin")), ShouldEqual, 2)
+		So(Accessible("guest"), ShouldResemble, []*Library{open})
+		So(ForPath("/private/a.mp3"), ShouldEqual, private)
+		So(ForPath("/elsewhere/a.mp3"), ShouldBeNil)
+	})
+
+}
+
+func TestSetup(t *testing.T) {
+
+	Convey("Libraries are stored and claim their songs", t, func() {
+		if err := db.SetupConnection(db.SQLITE, "file:librarysetup?mode=memory&cache=shared"); err != nil {
+			So(err, ShouldBeNil)
+		}
+		defer db.Shutdown()
+		defer Set()
+
+		if err := db.SetupSchema(); err != nil {
+			So(err, ShouldBeNil)
+		}
+
+		dir, err := ioutil.TempDir("", "cadenzr")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		song := &models.Song{Name: "song", Mime: "audio/mpeg", Path: filepath.Join(dir, "a.mp3")}
+		So(db.DB.Create(song).Error, ShouldBeNil)
+
+		So(Setup([]config.Library{{Name: "music", Path: dir}}), ShouldBeNil)
+		So(Setup([]config.Library{{Name: "music", Path: dir}}), ShouldBeNil)
+
+		var count uint64
+		db.DB.Table("libraries").Count(&count)
+		So(count, ShouldEqual, 1)
+
+		l := ByName("music")
+		So(l, ShouldNotBeNil)
+		So(db.DB.First(song, song.ID).Error, ShouldBeNil)
+		So(song.LibraryID.Int64, ShouldEqual, l.ID)
+	})
+
+}
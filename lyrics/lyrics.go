@@ -0,0 +1,273 @@
+// Package lyrics parses plain and time-synced lyrics: LRC text and ID3 SYLT frames.
+package lyrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Line is a line of lyrics. Time is when the line starts, it is 0 for plain lyrics.
+type Line struct {
+	Time time.Duration
+	Text string
+}
+
+// Lyrics are the lines of a song.
+type Lyrics struct {
+	// Synced is whether the lines have times.
+	Synced bool
+	Lines  []Line
+}
+
+// Text returns the lines without times.
+func (l *Lyrics) Text() string {
+	lines := make([]string, 0, len(l.Lines))
+	for _, line := range l.Lines {
+		lines = append(lines, line.Text)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// LRC formats the lyrics as LRC. Plain lyrics are returned as text.
+func (l *Lyrics) LRC() string {
+	if !l.Synced {
+		return l.Text()
+	}
+
+	lines := make([]string, 0, len(l.Lines))
+	for _, line := range l.Lines {
+		lines = append(lines, FormatTime(line.Time)+line.Text)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// FormatTime returns an LRC time tag: [mm:ss.xx].
+func FormatTime(t time.Duration) string {
+	if t < 0 {
+		t = 0
+	}
+
+	centiseconds := int64(t / (10 * time.Millisecond))
+	return fmt.Sprintf("[%02d:%02d.%02d]", centiseconds/6000, centiseconds/100%60, centiseconds%100)
+}
+
+// timeTag matches an LRC time tag like [01:23.45], [01:23:45] or [1:23].
+var timeTag = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+
+// infoTag matches an LRC information tag like [ar:Artist].
+var infoTag = regexp.MustCompile(`^\[([a-zA-Z#]+):(.*)\]$`)
+
+// wordTag matches the word times of enhanced LRC like <01:23.45>.
+var wordTag = regexp.MustCompile(`<\d+:\d{1,2}(?:[.:]\d{1,3})?>`)
+
+// parseTime returns the time of a matched time tag.
+func parseTime(match []string) time.Duration {
+	minutes, _ := strconv.Atoi(match[1])
+	seconds, _ := strconv.Atoi(match[2])
+
+	t := time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	if len(match[3]) != 0 {
+		// The fraction is hundredths in most files, but can have 1 or 3 digits.
+		fraction, _ := strconv.Atoi(match[3])
+		for i := len(match[3]); i < 3; i++ {
+			fraction *= 10
+		}
+		t += time.Duration(fraction) * time.Millisecond
+	}
+
+	return t
+}
+
+// IsLRC returns whether text contains at least one line with an LRC time tag.
+func IsLRC(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if timeTag.MatchString(strings.TrimSpace(line)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse returns the lyrics in text. Text with LRC time tags is parsed as LRC,
+// other text as plain lyrics.
+func Parse(text string) *Lyrics {
+	text = strings.TrimPrefix(strings.Replace(text, "\r\n", "\n", -1), "\ufeff")
+	if IsLRC(text) {
+		return ParseLRC(text)
+	}
+
+	l := &Lyrics{}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		l.Lines = append(l.Lines, Line{Text: strings.TrimRight(line, " \t\r")})
+	}
+
+	return l
+}
+
+// ParseLRC parses LRC lyrics. A line can have several time tags, it is repeated at each
+// time. The [offset:ms] tag moves all lines, a positive offset shows them earlier.
+// Lines without a time tag are ignored.
+func ParseLRC(text string) *Lyrics {
+	l := &Lyrics{Synced: true}
+	offset := time.Duration(0)
+
+	for _, raw := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(raw)
+
+		if match := infoTag.FindStringSubmatch(line); match != nil && !timeTag.MatchString(line) {
+			if strings.ToLower(match[1]) == "offset" {
+				if ms, err := strconv.Atoi(strings.TrimSpace(match[2])); err == nil {
+					offset = time.Duration(ms) * time.Millisecond
+				}
+			}
+			continue
+		}
+
+		times := []time.Duration{}
+		for {
+			match := timeTag.FindStringSubmatch(line)
+			if match == nil {
+				break
+			}
+
+			times = append(times, parseTime(match))
+			line = line[len(match[0]):]
+		}
+
+		text := strings.TrimSpace(wordTag.ReplaceAllString(line, ""))
+		for _, t := range times {
+			l.Lines = append(l.Lines, Line{Time: t, Text: text})
+		}
+	}
+
+	for i := range l.Lines {
+		l.Lines[i].Time -= offset
+		if l.Lines[i].Time < 0 {
+			l.Lines[i].Time = 0
+		}
+	}
+
+	sort.SliceStable(l.Lines, func(i, j int) bool {
+		return l.Lines[i].Time < l.Lines[j].Time
+	})
+
+	return l
+}
+
+// ErrSYLTFormat is returned for SYLT frames that can't be read.
+var ErrSYLTFormat = errors.New("Unsupported SYLT frame")
+
+// ID3 text encodings.
+const (
+	encodingISO88591 = 0
+	encodingUTF16    = 1
+	encodingUTF16BE  = 2
+	encodingUTF8     = 3
+)
+
+// syltMilliseconds is the SYLT time stamp format with absolute times in milliseconds.
+const syltMilliseconds = 2
+
+// splitText returns the text at the start of b that ends with the terminator of
+// the encoding, and the rest of b.
+func splitText(b []byte, encoding byte) (string, []byte) {
+	if encoding == encodingUTF16 || encoding == encodingUTF16BE {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return decodeUTF16(b[:i], encoding), b[i+2:]
+			}
+		}
+		return decodeUTF16(b, encoding), nil
+	}
+
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return decodeSingleByte(b, encoding), nil
+	}
+
+	return decodeSingleByte(b[:i], encoding), b[i+1:]
+}
+
+func decodeSingleByte(b []byte, encoding byte) string {
+	if encoding == encodingUTF8 {
+		return string(b)
+	}
+
+	// ISO-8859-1 maps directly to the first 256 code points.
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+
+	return string(runes)
+}
+
+func decodeUTF16(b []byte, encoding byte) string {
+	var order binary.ByteOrder = binary.BigEndian
+	if encoding == encodingUTF16 && len(b) >= 2 {
+		if b[0] == 0xFF && b[1] == 0xFE {
+			order = binary.LittleEndian
+		}
+		if (b[0] == 0xFF && b[1] == 0xFE) || (b[0] == 0xFE && b[1] == 0xFF) {
+			b = b[2:]
+		}
+	}
+
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[2*i:])
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// ParseSYLT parses the data of an ID3v2 SYLT frame. Only times in milliseconds are supported.
+func ParseSYLT(b []byte) (*Lyrics, error) {
+	// Encoding, language, time stamp format and content type.
+	if len(b) < 6 {
+		return nil, ErrSYLTFormat
+	}
+
+	encoding := b[0]
+	if encoding > encodingUTF8 || b[4] != syltMilliseconds {
+		return nil, ErrSYLTFormat
+	}
+
+	// Skip the content descriptor.
+	_, b = splitText(b[6:], encoding)
+
+	l := &Lyrics{Synced: true}
+	for len(b) != 0 {
+		var text string
+		text, b = splitText(b, encoding)
+		if len(b) < 4 {
+			return nil, ErrSYLTFormat
+		}
+
+		ms := binary.BigEndian.Uint32(b)
+		b = b[4:]
+
+		// Lines usually start with a newline in SYLT frames.
+		l.Lines = append(l.Lines, Line{
+			Time: time.Duration(ms) * time.Millisecond,
+			Text: strings.TrimSpace(text),
+		})
+	}
+
+	sort.SliceStable(l.Lines, func(i, j int) bool {
+		return l.Lines[i].Time < l.Lines[j].Time
+	})
+
+	return l, nil
+}
@@ -0,0 +1,81 @@
+package lyrics
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+	Convey("Text without time tags is plain lyrics.", t, func() {
+		l := Parse("\ufeffFirst line\r\nSecond line\n")
+		So(l.Synced, ShouldBeFalse)
+		So(l.Lines, ShouldResemble, []Line{{Text: "First line"}, {Text: "Second line"}})
+		So(l.LRC(), ShouldEqual, "First line\nSecond line")
+	})
+
+	Convey("LRC lines are sorted by time.", t, func() {
+		l := Parse(`[ar:Artist]
+[ti:Title]
+[00:12.30]Second
+[00:01.5][01:02.345]First and <00:02.00>again
+
+not synced`)
+
+		So(l.Synced, ShouldBeTrue)
+		So(l.Lines, ShouldResemble, []Line{
+			{Time: 1500 * time.Millisecond, Text: "First and again"},
+			{Time: 12300 * time.Millisecond, Text: "Second"},
+			{Time: 62345 * time.Millisecond, Text: "First and again"},
+		})
+		So(l.Text(), ShouldEqual, "First and again\nSecond\nFirst and again")
+		So(l.LRC(), ShouldEqual, "[00:01.50]First and again\n[00:12.30]Second\n[01:02.34]First and again")
+	})
+
+	Convey("The offset tag moves all lines.", t, func() {
+		l := ParseLRC("[offset:+500]\n[00:00.20]Start\n[00:10.00]Later")
+		So(l.Lines[0].Time, ShouldEqual, 0)
+		So(l.Lines[1].Time, ShouldEqual, 9500*time.Millisecond)
+
+		l = ParseLRC("[offset:-250]\n[00:10.00]Later")
+		So(l.Lines[0].Time, ShouldEqual, 10250*time.Millisecond)
+	})
+}
+
+func TestParseSYLT(t *testing.T) {
+	Convey("SYLT frames with millisecond times are read.", t, func() {
+		frame := []byte{encodingUTF8, 'e', 'n', 'g', syltMilliseconds, 1}
+		frame = append(frame, "descriptor\x00"...)
+		frame = append(frame, "\nSecond\x00"...)
+		frame = append(frame, 0, 0, 0x07, 0xD0)
+		frame = append(frame, "First\x00"...)
+		frame = append(frame, 0, 0, 0x03, 0xE8)
+
+		l, err := ParseSYLT(frame)
+		So(err, ShouldBeNil)
+		So(l.Synced, ShouldBeTrue)
+		So(l.Lines, ShouldResemble, []Line{
+			{Time: time.Second, Text: "First"},
+			{Time: 2 * time.Second, Text: "Second"},
+		})
+	})
+
+	Convey("UTF-16 text is decoded.", t, func() {
+		frame := []byte{encodingUTF16, 'e', 'n', 'g', syltMilliseconds, 1, 0xFF, 0xFE, 0, 0}
+		frame = append(frame, 0xFF, 0xFE, 'H', 0, 0xE9, 0, 0, 0)
+		frame = append(frame, 0, 0, 0, 10)
+
+		l, err := ParseSYLT(frame)
+		So(err, ShouldBeNil)
+		So(l.Lines, ShouldResemble, []Line{{Time: 10 * time.Millisecond, Text: "Hé"}})
+	})
+
+	Convey("Times in MPEG frames are not supported.", t, func() {
+		_, err := ParseSYLT([]byte{encodingUTF8, 'e', 'n', 'g', 1, 1, 0})
+		So(err, ShouldEqual, ErrSYLTFormat)
+
+		_, err = ParseSYLT([]byte{encodingUTF8, 'e', 'n', 'g', syltMilliseconds, 1, 0, 'x', 0, 1})
+		So(err, ShouldEqual, ErrSYLTFormat)
+	})
+}
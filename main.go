@@ -6,12 +6,20 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/cadenzr/cadenzr/config"
 	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/jukebox"
+	"github.com/cadenzr/cadenzr/library"
 	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/nowplaying"
+	"github.com/cadenzr/cadenzr/podcasts"
 	"github.com/cadenzr/cadenzr/probers"
-	"github.com/cadenzr/cadenzr/scan"
+	"github.com/cadenzr/cadenzr/radio"
+	"github.com/cadenzr/cadenzr/replaygain"
+	"github.com/cadenzr/cadenzr/scrobble"
+	"github.com/cadenzr/cadenzr/transcoders"
 
 	"github.com/cadenzr/cadenzr/log"
 
@@ -27,7 +35,6 @@ func handleInterrupt(stopProgram chan struct{}) {
 	}()
 }
 
-var scanCh chan (chan struct{})
 var configFile string = "./config.json"
 
 func main() {
@@ -60,6 +67,10 @@ func main() {
 		log.Fatalf("Failed to initialize database schema: %v", err)
 	}
 
+	if err := library.Setup(config.Config.Libraries); err != nil {
+		log.Fatalf("Failed to set up libraries: %v", err)
+	}
+
 	// Create admin user.
 	shaSum := sha256.Sum256([]byte(config.Config.Password))
 	hash := hex.EncodeToString(shaSum[:])
@@ -79,14 +90,44 @@ func main() {
 		}
 	}
 
+	if err := transcoders.Setup(config.Config.TranscodeProfiles, config.Config.BitrateRules); err != nil {
+		log.Fatalf("Failed to set up transcoding: %v", err)
+	}
+
 	probers.Initialize()
 
+	if !config.Config.DisableLoudnessAnalysis {
+		if analyzer, err := replaygain.NewAnalyzer(); err != nil {
+			log.Warnf("Songs without ReplayGain tags are not measured: %v", err)
+		} else {
+			go analyzer.Run(nil)
+		}
+	}
+
+	scrobble.Setup(config.Config)
+	go scrobble.Default.Run(nil)
+
+	if err := nowplaying.Setup(time.Duration(config.Config.NowPlayingTimeout) * time.Second); err != nil {
+		log.Fatalf("Failed to load the songs that are playing: %v", err)
+	}
+	go nowplaying.Default.Run(nil)
+
+	if err := jukebox.Setup(config.Config.Jukebox); err != nil {
+		log.Fatalf("Failed to set up the jukebox: %v", err)
+	}
+
+	if err := radio.Setup(config.Config.Radio); err != nil {
+		log.Fatalf("Failed to set up the radio stations: %v", err)
+	}
+
+	if err := podcasts.Setup(config.Config.Podcasts); err != nil {
+		log.Fatalf("Failed to set up podcasts: %v", err)
+	}
+	go podcasts.Default.Run(nil)
+
 	stopProgram := make(chan struct{})
 	handleInterrupt(stopProgram)
 
-	scanCh = make(chan (chan struct{}))
-	go scan.ScanHandler(scanCh)
-
 	go startAPI()
 
 	<-stopProgram
@@ -81,7 +81,7 @@ func TestApi(t *testing.T) {
 
 	Convey("Test scan", t, func() {
 
-		_, status, err := Do("POST", endpoint+"/api/scan")
+		_, status, err := Do("POST", endpoint+"/api/scan?wait=true")
 		So(err, ShouldEqual, nil)
 		So(status, ShouldEqual, 200)
 
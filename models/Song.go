@@ -19,6 +19,9 @@ type Song struct {
 	Cover   *Image `gorm:"ForeignKey:CoverID"`
 	CoverID NullInt64
 
+	Library   *Library  `gorm:"ForeignKey:LibraryID"`
+	LibraryID NullInt64 `gorm:"index"`
+
 	Year        NullInt64
 	Track       NullInt64
 	TotalTracks NullInt64
@@ -28,4 +31,21 @@ type Song struct {
 	Mime     string `gorm:"not null"`
 	Path     string `gorm:"not null"`
 	Played   uint   `gorm:"not null"`
+
+	// Hash is the hex encoded SHA-256 of the file.
+	Hash string `gorm:"index"`
+	Size int64
+
+	// MBID is the MusicBrainz recording id from the tags. It is NULL for songs that were
+	// scanned before ids were read.
+	MBID string `gorm:"column:mbid;index"`
+
+	// TrackGain is the ReplayGain track gain in dB and TrackPeak the largest sample.
+	TrackGain NullFloat64
+	TrackPeak NullFloat64
+	// Loudness is the integrated loudness in LUFS. It is used to compute album gains.
+	Loudness NullFloat64
+
+	// Audiobook songs are listed as audiobooks, with their album as the book.
+	Audiobook bool `gorm:"not null;index"`
 }
@@ -15,5 +15,9 @@ type Album struct {
 
 	Year NullInt64
 
+	// Gain is the ReplayGain album gain in dB and Peak the largest sample of all songs.
+	Gain NullFloat64
+	Peak NullFloat64
+
 	Songs []*Song `gorm:"ForeignKey:AlbumID"`
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Kinds of annotated items.
+const (
+	AnnotationSong   = "song"
+	AnnotationAlbum  = "album"
+	AnnotationArtist = "artist"
+)
+
+// Annotation is what a user thinks of a song, album or artist.
+type Annotation struct {
+	gorm.Model
+
+	UserID   uint   `gorm:"not null;unique_index:idx_annotation"`
+	ItemType string `gorm:"not null;unique_index:idx_annotation"`
+	ItemID   uint   `gorm:"not null;unique_index:idx_annotation"`
+
+	// Starred is when the item was made a favorite, nil if it isn't one.
+	Starred *time.Time
+	// Rating is from 1 to 5, 0 if the item isn't rated.
+	Rating int `gorm:"not null"`
+}
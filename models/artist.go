@@ -10,4 +10,18 @@ type Artist struct {
 
 	Name  string `gorm:"not null,unique_index"`
 	Songs []*Song
+
+	Image     *Image `gorm:"ForeignKey:ImageID"`
+	ImageID   NullInt64
+	Biography NullString
+	Links     []*ArtistLink `gorm:"ForeignKey:ArtistID"`
+}
+
+// ArtistLink is a website about an artist.
+type ArtistLink struct {
+	gorm.Model
+
+	ArtistID uint   `gorm:"not null;index"`
+	Name     string `gorm:"not null"`
+	URL      string `gorm:"not null"`
 }
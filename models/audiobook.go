@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Chapter model. Start and End are in seconds from the start of the song.
+type Chapter struct {
+	gorm.Model
+
+	SongID uint    `gorm:"not null;index"`
+	Title  string  `gorm:"not null"`
+	Start  float64 `gorm:"not null"`
+	End    float64 `gorm:"not null"`
+}
+
+// BookPosition model. An audiobook is an album of audiobook songs, so users resume
+// it at a position in one of its songs, at the speed they listened to it.
+type BookPosition struct {
+	gorm.Model
+
+	UserID   uint    `gorm:"not null;unique_index:idx_book_position"`
+	AlbumID  uint    `gorm:"not null;unique_index:idx_book_position"`
+	SongID   uint    `gorm:"not null"`
+	Position float64 `gorm:"not null"`
+	Speed    float64 `gorm:"not null"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Library model. Libraries are configured in the configuration file, the
+// table gives them a stable ID.
+type Library struct {
+	gorm.Model
+
+	Name string `gorm:"not null;unique_index"`
+	Path string `gorm:"not null"`
+}
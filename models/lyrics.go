@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Lyrics sources.
+const (
+	LyricsEmbedded = "embedded"
+	LyricsSidecar  = "sidecar"
+	LyricsUser     = "user"
+)
+
+// Lyrics model. Text is LRC if the lyrics are synchronized.
+type Lyrics struct {
+	gorm.Model
+
+	SongID uint   `gorm:"not null;unique_index"`
+	Source string `gorm:"not null"`
+	Synced bool
+	Text   string `gorm:"not null"`
+}
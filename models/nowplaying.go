@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// NowPlaying is a song a user is listening to on a client. It is kept so the listeners
+// are still known after a restart.
+type NowPlaying struct {
+	gorm.Model
+
+	UserID   uint   `gorm:"not null;unique_index:idx_now_playing"`
+	Username string `gorm:"not null"`
+	Client   string `gorm:"not null;unique_index:idx_now_playing"`
+	SongID   uint   `gorm:"not null"`
+	// Started is when the song started playing.
+	Started time.Time `gorm:"not null"`
+	// Position is the time in seconds in the song at UpdatedAt.
+	Position float64
+	Paused   bool
+	// Expires is when the client is considered gone if it doesn't report again.
+	Expires time.Time `gorm:"not null;index"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Play sources.
+const (
+	PlayScrobbled = "scrobbled"
+	PlayImported  = "imported"
+)
+
+// Play is a song a user listened to, the play history of users.
+type Play struct {
+	gorm.Model
+
+	UserID   uint      `gorm:"not null;unique_index:idx_play"`
+	Song     *Song     `gorm:"ForeignKey:SongID"`
+	SongID   uint      `gorm:"not null;unique_index:idx_play;index"`
+	PlayedAt time.Time `gorm:"not null;unique_index:idx_play"`
+	// Source is PlayScrobbled for plays in cadenzr or PlayImported for plays of other players.
+	Source string `gorm:"not null"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// PlayQueue is the queue of songs a user is listening to, kept so they can continue on
+// another device. UpdatedAt is when it last changed.
+type PlayQueue struct {
+	gorm.Model
+
+	UserID uint `gorm:"not null;unique_index"`
+	// Current is the index in Entries of the song that is playing.
+	Current int `gorm:"not null"`
+	// Position is the time in seconds in the current song.
+	Position float64 `gorm:"not null"`
+	// ChangedBy is the name of the client that last changed the queue.
+	ChangedBy string
+
+	Entries []*PlayQueueEntry `gorm:"ForeignKey:QueueID"`
+}
+
+// PlayQueueEntry is a song in a play queue. A song can be in a queue more than once.
+type PlayQueueEntry struct {
+	ID uint `gorm:"primary_key"`
+
+	QueueID uint  `gorm:"not null;index"`
+	Song    *Song `gorm:"ForeignKey:SongID"`
+	SongID  uint  `gorm:"not null"`
+	// Index is the place of the song in the queue, from 0.
+	Index int `gorm:"not null"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Podcast episode states.
+const (
+	// EpisodeNew episodes are streamed from their feed.
+	EpisodeNew = "new"
+	// EpisodeDownloading episodes are being downloaded.
+	EpisodeDownloading = "downloading"
+	// EpisodeDownloaded episodes are played from Path.
+	EpisodeDownloaded = "downloaded"
+	// EpisodeFailed episodes could not be downloaded.
+	EpisodeFailed = "failed"
+	// EpisodeRemoved episodes were downloaded and removed by the retention policy. They
+	// are not downloaded again automatically.
+	EpisodeRemoved = "removed"
+)
+
+// PodcastChannel is a podcast feed that is subscribed to.
+type PodcastChannel struct {
+	gorm.Model
+
+	URL         string `gorm:"not null;unique_index"`
+	Title       string `gorm:"not null"`
+	Description string
+	Link        string
+	ImageURL    string
+	// Refreshed is when the feed was last fetched, nil before that.
+	Refreshed *time.Time
+	// Error is why the last refresh failed, empty if it didn't.
+	Error string
+
+	Episodes []*PodcastEpisode `gorm:"ForeignKey:ChannelID"`
+}
+
+// PodcastEpisode is an episode of a podcast channel.
+type PodcastEpisode struct {
+	gorm.Model
+
+	ChannelID uint `gorm:"not null;unique_index:idx_podcast_episode"`
+	// GUID identifies the episode in the feed.
+	GUID        string `gorm:"column:guid;not null;unique_index:idx_podcast_episode"`
+	Title       string `gorm:"not null"`
+	Description string
+	Published   time.Time `gorm:"index"`
+	// Duration in seconds, 0 if the feed doesn't tell.
+	Duration float64
+	// URL is the address of the audio in the feed.
+	URL  string `gorm:"not null"`
+	Mime string
+	Size int64
+	// Status is one of the episode states, e.g. EpisodeDownloaded.
+	Status string `gorm:"not null"`
+	// Path is the downloaded file, empty if there is none.
+	Path string
+}
+
+// PodcastPosition is how far a user listened to an episode.
+type PodcastPosition struct {
+	gorm.Model
+
+	UserID    uint `gorm:"not null;unique_index:idx_podcast_position"`
+	EpisodeID uint `gorm:"not null;unique_index:idx_podcast_position"`
+	// Position is the time in seconds in the episode.
+	Position float64
+	Played   bool
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// RadioStation is an internet radio stream in the catalog. Clients play the stream
+// themselves.
+type RadioStation struct {
+	gorm.Model
+
+	Name        string `gorm:"not null"`
+	StreamURL   string `gorm:"not null;unique_index"`
+	HomepageURL string
+	LogoURL     string
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Scrobbling services.
+const (
+	ScrobbleLastFM       = "lastfm"
+	ScrobbleListenBrainz = "listenbrainz"
+)
+
+// ScrobbleAccount links a user to an account of a scrobbling service.
+type ScrobbleAccount struct {
+	gorm.Model
+
+	UserID  uint   `gorm:"not null;unique_index:idx_scrobble_account"`
+	Service string `gorm:"not null;unique_index:idx_scrobble_account"`
+	// Username is the name of the account at the service.
+	Username string
+	// Token is the Last.fm session key or the ListenBrainz user token.
+	Token string `gorm:"not null"`
+}
+
+// PendingScrobble is a play that still has to be submitted to an account.
+type PendingScrobble struct {
+	gorm.Model
+
+	AccountID uint `gorm:"not null;index"`
+
+	Artist      string `gorm:"not null"`
+	Title       string `gorm:"not null"`
+	Album       string
+	Duration    float64
+	TrackNumber int
+	PlayedAt    time.Time `gorm:"not null"`
+
+	Attempts    int
+	NextAttempt time.Time `gorm:"index"`
+	LastError   string
+}
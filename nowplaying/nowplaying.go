@@ -0,0 +1,281 @@
+// Package nowplaying keeps track of who is listening to what on which client. Clients
+// report when songs start streaming and send heartbeats while they play. Listeners that
+// stop reporting are removed after the song would have ended.
+package nowplaying
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/labstack/echo"
+)
+
+// Entry is a song playing on a client.
+type Entry struct {
+	UserID   uint      `json:"user_id"`
+	Username string    `json:"username"`
+	Client   string    `json:"client"`
+	SongID   uint      `json:"song_id"`
+	Started  time.Time `json:"started"`
+	// Position is the time in seconds in the song at Updated.
+	Position float64   `json:"position"`
+	Paused   bool      `json:"paused"`
+	Updated  time.Time `json:"updated"`
+	Expires  time.Time `json:"-"`
+}
+
+// Report is what a client tells about the song it plays.
+type Report struct {
+	UserID   uint
+	Username string
+	Client   string
+	SongID   uint
+	// Duration of the song in seconds, 0 if it is unknown.
+	Duration float64
+	// Position in seconds.
+	Position float64
+	Paused   bool
+	// Start is set when the song starts from the beginning, also when it was already
+	// playing. Otherwise a new start is only assumed when the song changes.
+	Start bool
+}
+
+type key struct {
+	userID uint
+	client string
+}
+
+// Registry holds the songs that are playing.
+type Registry struct {
+	mu      sync.Mutex
+	entries map[key]*Entry
+	// grace is how long a client may stay silent after its song should have ended, or
+	// while it is paused.
+	grace time.Duration
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// Default is the registry of the server, nil until Setup is called.
+var Default *Registry
+
+// NewRegistry returns an empty registry.
+func NewRegistry(grace time.Duration) *Registry {
+	return &Registry{
+		entries: map[key]*Entry{},
+		grace:   grace,
+		now:     time.Now,
+	}
+}
+
+// Setup creates the Default registry with the listeners that were stored.
+func Setup(grace time.Duration) error {
+	r := NewRegistry(grace)
+	if err := r.Load(); err != nil {
+		return err
+	}
+
+	Default = r
+	return nil
+}
+
+// Load reads the entries that are stored and not expired.
+func (r *Registry) Load() error {
+	stored := []*models.NowPlaying{}
+	if gormDB := db.DB.Where("expires > ?", r.now()).Find(&stored); gormDB.Error != nil {
+		return gormDB.Error
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range stored {
+		r.entries[key{s.UserID, s.Client}] = &Entry{
+			UserID:   s.UserID,
+			Username: s.Username,
+			Client:   s.Client,
+			SongID:   s.SongID,
+			Started:  s.Started,
+			Position: s.Position,
+			Paused:   s.Paused,
+			Updated:  s.UpdatedAt,
+			Expires:  s.Expires,
+		}
+	}
+
+	return nil
+}
+
+// Update records a report of a client and returns its entry.
+func (r *Registry) Update(report *Report) *Entry {
+	now := r.now()
+
+	r.mu.Lock()
+	k := key{report.UserID, report.Client}
+	e, ok := r.entries[k]
+	started := !ok || report.Start || e.SongID != report.SongID
+	if started {
+		e = &Entry{
+			UserID:   report.UserID,
+			Username: report.Username,
+			Client:   report.Client,
+			SongID:   report.SongID,
+			Started:  now,
+		}
+		r.entries[k] = e
+	}
+
+	e.Position = report.Position
+	e.Paused = report.Paused
+	e.Updated = now
+	e.Expires = now.Add(r.grace)
+	if remaining := report.Duration - report.Position; !report.Paused && remaining > 0 {
+		e.Expires = e.Expires.Add(time.Duration(remaining * float64(time.Second)))
+	}
+
+	entry := *e
+	r.mu.Unlock()
+
+	r.store(&entry)
+	if started {
+		events.PublishLibrary(events.NowPlaying, songLibrary(entry.SongID), echo.Map{
+			"song_id":  entry.SongID,
+			"user_id":  entry.UserID,
+			"username": entry.Username,
+			"client":   entry.Client,
+		})
+	}
+
+	return &entry
+}
+
+// songLibrary returns the library of a song, 0 if it is in none.
+func songLibrary(songID uint) uint {
+	ids := []uint{}
+	if gormDB := db.DB.Model(&models.Song{}).Where("id = ? AND library_id IS NOT NULL", songID).Pluck("library_id", &ids); gormDB.Error != nil {
+		log.Errorf("Could not get library of song '%d': %v", songID, gormDB.Error)
+	}
+
+	if len(ids) == 0 {
+		return 0
+	}
+	return ids[0]
+}
+
+// store writes an entry to the database.
+func (r *Registry) store(e *Entry) {
+	stored := &models.NowPlaying{}
+	if gormDB := db.DB.Where(models.NowPlaying{UserID: e.UserID, Client: e.Client}).FirstOrInit(stored); gormDB.Error != nil {
+		log.Errorf("Could not load now playing entry: %v", gormDB.Error)
+		return
+	}
+
+	stored.Username = e.Username
+	stored.SongID = e.SongID
+	stored.Started = e.Started
+	stored.Position = e.Position
+	stored.Paused = e.Paused
+	stored.Expires = e.Expires
+	if gormDB := db.DB.Save(stored); gormDB.Error != nil {
+		log.Errorf("Could not store now playing entry: %v", gormDB.Error)
+	}
+}
+
+// remove deletes the entries with keys from the database and tells the subscribers.
+func (r *Registry) remove(removed []*Entry) {
+	for _, e := range removed {
+		db.DB.Unscoped().Where("user_id = ? AND client = ?", e.UserID, e.Client).Delete(&models.NowPlaying{})
+		events.PublishLibrary(events.NowPlayingStopped, songLibrary(e.SongID), echo.Map{
+			"song_id":  e.SongID,
+			"user_id":  e.UserID,
+			"username": e.Username,
+			"client":   e.Client,
+		})
+	}
+}
+
+// Stop removes the entry of a client. It returns false if the client wasn't playing.
+func (r *Registry) Stop(userID uint, client string) bool {
+	r.mu.Lock()
+	k := key{userID, client}
+	e, ok := r.entries[k]
+	delete(r.entries, k)
+	r.mu.Unlock()
+
+	if ok {
+		r.remove([]*Entry{e})
+	}
+
+	return ok
+}
+
+// Expire removes the entries of clients that stopped reporting.
+func (r *Registry) Expire() {
+	now := r.now()
+
+	r.mu.Lock()
+	removed := []*Entry{}
+	for k, e := range r.entries {
+		if !now.Before(e.Expires) {
+			removed = append(removed, e)
+			delete(r.entries, k)
+		}
+	}
+	r.mu.Unlock()
+
+	r.remove(removed)
+}
+
+// expireInterval is how often Run removes the entries of clients that stopped reporting.
+const expireInterval = time.Minute
+
+// Run removes the entries of clients that stopped reporting every expireInterval,
+// until stop is closed.
+func (r *Registry) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.Expire()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// List returns the songs that are playing, the most recently started first. Positions
+// are moved forward to now for songs that are not paused.
+func (r *Registry) List() []*Entry {
+	now := r.now()
+
+	r.mu.Lock()
+	entries := make([]*Entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		if !now.Before(e.Expires) {
+			continue
+		}
+
+		entry := *e
+		if !entry.Paused {
+			entry.Position += now.Sub(entry.Updated).Seconds()
+		}
+		entries = append(entries, &entry)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Started.Equal(entries[j].Started) {
+			return entries[i].Started.After(entries[j].Started)
+		}
+		return entries[i].Username+entries[i].Client < entries[j].Username+entries[j].Client
+	})
+
+	return entries
+}
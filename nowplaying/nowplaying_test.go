@@ -0,0 +1,90 @@
+package nowplaying
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/labstack/echo"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:nowplaying?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRegistry(2 * time.Minute)
+	r.now = func() time.Time { return now }
+
+	s := events.Default.Subscribe(0, 0, events.NowPlaying, events.NowPlayingStopped)
+	defer events.Default.Unsubscribe(s)
+
+	Convey("A new song is published and stored.", t, func() {
+		e := r.Update(&Report{UserID: 1, Username: "user", Client: "phone", SongID: 1, Duration: 180})
+		So(e.Started, ShouldResemble, now)
+		So(e.Expires, ShouldResemble, now.Add(5*time.Minute))
+
+		event := <-s.C
+		So(event.Type, ShouldEqual, events.NowPlaying)
+		So(event.Data.(echo.Map)["client"], ShouldEqual, "phone")
+
+		count := 0
+		db.DB.Model(&models.NowPlaying{}).Count(&count)
+		So(count, ShouldEqual, 1)
+	})
+
+	Convey("Heartbeats of the same song keep when it started.", t, func() {
+		now = now.Add(time.Minute)
+		e := r.Update(&Report{UserID: 1, Username: "user", Client: "phone", SongID: 1, Duration: 180, Position: 60})
+		So(e.Started, ShouldResemble, now.Add(-time.Minute))
+
+		r.Update(&Report{UserID: 2, Username: "other", Client: "desktop", SongID: 2, Duration: 100, Paused: true})
+		So((<-s.C).Data.(echo.Map)["username"], ShouldEqual, "other")
+	})
+
+	Convey("Positions of playing songs move on with time.", t, func() {
+		now = now.Add(30 * time.Second)
+		list := r.List()
+		So(len(list), ShouldEqual, 2)
+		So(list[0].Username, ShouldEqual, "other")
+		So(list[0].Position, ShouldEqual, 0)
+		So(list[1].Position, ShouldEqual, 90)
+	})
+
+	Convey("Entries are loaded again after a restart.", t, func() {
+		loaded := NewRegistry(2 * time.Minute)
+		loaded.now = r.now
+		So(loaded.Load(), ShouldBeNil)
+		So(len(loaded.List()), ShouldEqual, 2)
+	})
+
+	Convey("Clients that stop reporting expire.", t, func() {
+		now = now.Add(2 * time.Minute)
+		r.Expire()
+
+		event := <-s.C
+		So(event.Type, ShouldEqual, events.NowPlayingStopped)
+		So(event.Data.(echo.Map)["username"], ShouldEqual, "other")
+		So(len(r.List()), ShouldEqual, 1)
+
+		count := 0
+		db.DB.Model(&models.NowPlaying{}).Count(&count)
+		So(count, ShouldEqual, 1)
+	})
+
+	Convey("Stopped clients are removed.", t, func() {
+		So(r.Stop(1, "phone"), ShouldBeTrue)
+		So(r.Stop(1, "phone"), ShouldBeFalse)
+		So((<-s.C).Type, ShouldEqual, events.NowPlayingStopped)
+		So(len(r.List()), ShouldEqual, 0)
+	})
+}
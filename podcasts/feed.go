@@ -0,0 +1,304 @@
+package podcasts
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownFeed is returned for documents that are not RSS or Atom feeds.
+var ErrUnknownFeed = errors.New("The document is not an RSS or Atom feed")
+
+// Feed is a podcast feed.
+type Feed struct {
+	Title       string
+	Description string
+	Link        string
+	ImageURL    string
+	Items       []*Item
+}
+
+// Item is an episode in a feed.
+type Item struct {
+	GUID        string
+	Title       string
+	Description string
+	Published   time.Time
+	// Duration in seconds, 0 if it is unknown.
+	Duration float64
+	URL      string
+	Mime     string
+	Size     int64
+}
+
+type rssImage struct {
+	URL string `xml:"url"`
+	// Href is the attribute of itunes:image.
+	Href string `xml:"href,attr"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+// rssFeed is an RSS 2.0 feed. Elements are matched without their namespace, so 'link'
+// also gets atom:link and 'image' also gets itunes:image.
+type rssFeed struct {
+	Channel struct {
+		Title       string     `xml:"title"`
+		Description string     `xml:"description"`
+		Links       []string   `xml:"link"`
+		Images      []rssImage `xml:"image"`
+		Items       []struct {
+			GUID        string         `xml:"guid"`
+			Title       string         `xml:"title"`
+			Description string         `xml:"description"`
+			PubDate     string         `xml:"pubDate"`
+			Duration    string         `xml:"duration"`
+			Enclosures  []rssEnclosure `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type atomFeed struct {
+	Title    string     `xml:"title"`
+	Subtitle string     `xml:"subtitle"`
+	Links    []atomLink `xml:"link"`
+	Logo     string     `xml:"logo"`
+	Entries  []struct {
+		ID        string     `xml:"id"`
+		Title     string     `xml:"title"`
+		Summary   string     `xml:"summary"`
+		Published string     `xml:"published"`
+		Updated   string     `xml:"updated"`
+		Duration  string     `xml:"duration"`
+		Links     []atomLink `xml:"link"`
+	} `xml:"entry"`
+}
+
+// dateLayouts are the date formats found in feeds.
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339,
+}
+
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+
+	return time.Time{}
+}
+
+// parseDuration reads itunes:duration, in seconds or as [HH:]MM:SS.
+func parseDuration(s string) float64 {
+	seconds := 0.0
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + v
+	}
+
+	return seconds
+}
+
+// charsetReader reads ISO-8859-1 documents, besides the UTF-8 that is read without one.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "latin-1":
+	default:
+		return nil, errors.New("Unsupported feed charset '" + charset + "'")
+	}
+
+	raw, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, b := range raw {
+		buf.WriteRune(rune(b))
+	}
+
+	return buf, nil
+}
+
+// ParseFeed reads an RSS or Atom feed. Items without audio are left out.
+func ParseFeed(r io.Reader) (*Feed, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charsetReader
+	decoder.Strict = false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, ErrUnknownFeed
+		} else if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "rss":
+			rss := &rssFeed{}
+			if err := decoder.DecodeElement(rss, &start); err != nil {
+				return nil, err
+			}
+			return rss.feed(), nil
+		case "feed":
+			atom := &atomFeed{}
+			if err := decoder.DecodeElement(atom, &start); err != nil {
+				return nil, err
+			}
+			return atom.feed(), nil
+		default:
+			return nil, ErrUnknownFeed
+		}
+	}
+}
+
+func (rss *rssFeed) feed() *Feed {
+	c := &rss.Channel
+	f := &Feed{
+		Title:       strings.TrimSpace(c.Title),
+		Description: strings.TrimSpace(c.Description),
+		Items:       []*Item{},
+	}
+
+	for _, link := range c.Links {
+		if link = strings.TrimSpace(link); len(link) != 0 {
+			f.Link = link
+			break
+		}
+	}
+
+	for _, image := range c.Images {
+		if len(image.Href) != 0 {
+			f.ImageURL = image.Href
+			break
+		} else if len(image.URL) != 0 && len(f.ImageURL) == 0 {
+			f.ImageURL = strings.TrimSpace(image.URL)
+		}
+	}
+
+	for _, i := range c.Items {
+		var enclosure *rssEnclosure
+		for j := range i.Enclosures {
+			if len(i.Enclosures[j].URL) != 0 {
+				enclosure = &i.Enclosures[j]
+				break
+			}
+		}
+		if enclosure == nil {
+			continue
+		}
+
+		item := &Item{
+			GUID:        strings.TrimSpace(i.GUID),
+			Title:       strings.TrimSpace(i.Title),
+			Description: strings.TrimSpace(i.Description),
+			Published:   parseDate(i.PubDate),
+			Duration:    parseDuration(i.Duration),
+			URL:         strings.TrimSpace(enclosure.URL),
+			Mime:        enclosure.Type,
+		}
+		item.Size, _ = strconv.ParseInt(strings.TrimSpace(enclosure.Length), 10, 64)
+		f.Items = append(f.Items, item)
+	}
+
+	f.fill()
+	return f
+}
+
+func (atom *atomFeed) feed() *Feed {
+	f := &Feed{
+		Title:       strings.TrimSpace(atom.Title),
+		Description: strings.TrimSpace(atom.Subtitle),
+		ImageURL:    strings.TrimSpace(atom.Logo),
+		Items:       []*Item{},
+	}
+
+	for _, link := range atom.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			f.Link = link.Href
+			break
+		}
+	}
+
+	for _, e := range atom.Entries {
+		var enclosure *atomLink
+		for j := range e.Links {
+			if e.Links[j].Rel == "enclosure" && len(e.Links[j].Href) != 0 {
+				enclosure = &e.Links[j]
+				break
+			}
+		}
+		if enclosure == nil {
+			continue
+		}
+
+		published := e.Published
+		if len(published) == 0 {
+			published = e.Updated
+		}
+
+		item := &Item{
+			GUID:        strings.TrimSpace(e.ID),
+			Title:       strings.TrimSpace(e.Title),
+			Description: strings.TrimSpace(e.Summary),
+			Published:   parseDate(published),
+			Duration:    parseDuration(e.Duration),
+			URL:         strings.TrimSpace(enclosure.Href),
+			Mime:        enclosure.Type,
+		}
+		item.Size, _ = strconv.ParseInt(strings.TrimSpace(enclosure.Length), 10, 64)
+		f.Items = append(f.Items, item)
+	}
+
+	f.fill()
+	return f
+}
+
+// fill sets what is missing in the feed: items without a GUID use their audio URL and
+// items without a title their publication date.
+func (f *Feed) fill() {
+	for _, item := range f.Items {
+		if len(item.GUID) == 0 {
+			item.GUID = item.URL
+		}
+
+		if len(item.Title) == 0 {
+			item.Title = item.Published.Format("2006-01-02")
+		}
+	}
+}
@@ -0,0 +1,545 @@
+// Package podcasts keeps podcast subscriptions up to date. Feeds are refreshed in the
+// background, new episodes can be downloaded into the podcast directory and a retention
+// policy removes old downloads.
+package podcasts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// feedTimeout limits the time fetching a feed may take.
+	feedTimeout = 30 * time.Second
+	// maxFeedSize is the largest feed that is read.
+	maxFeedSize = 16 << 20
+	// episodeTimeout limits the time downloading an episode may take.
+	episodeTimeout = 2 * time.Hour
+	// maxEpisodeSize is the largest episode that is downloaded.
+	maxEpisodeSize = 2 << 30
+	// maxDownloads is the number of episodes that are downloaded at the same time.
+	maxDownloads = 2
+)
+
+// Errors of subscriptions.
+var (
+	ErrSubscribed = errors.New("The feed is subscribed to already")
+	ErrNotFeed    = errors.New("The address must be an http or https URL")
+	ErrTooLarge   = errors.New("The episode is too large")
+	ErrRemoved    = errors.New("The episode was removed")
+)
+
+// Client fetches feeds and episodes. *http.Client implements it.
+type Client interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Manager refreshes feeds and downloads episodes.
+type Manager struct {
+	Client Client
+	// Dir is where episodes are downloaded, in a directory for each channel.
+	Dir string
+	// Download downloads new episodes when a feed is refreshed.
+	Download bool
+	// Keep is the number of downloads kept for each channel, 0 keeps all.
+	Keep int
+	// MaxAge is how long downloads are kept after publication, 0 keeps them forever.
+	MaxAge time.Duration
+	// Interval is the time between refreshes of all feeds.
+	Interval time.Duration
+
+	// now is replaced in tests.
+	now func() time.Time
+	// downloads limits the downloads that run at the same time, pending tracks the
+	// queued ones.
+	downloads chan struct{}
+	pending   sync.WaitGroup
+
+	// mu guards active, the running downloads of each channel. They are stopped when
+	// the channel is unsubscribed.
+	mu     sync.Mutex
+	active map[uint]*channelDownloads
+}
+
+type channelDownloads struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running int
+	done    sync.WaitGroup
+}
+
+// Default is the podcast manager of the server, nil until Setup is called.
+var Default *Manager
+
+// NewManager returns a manager that downloads into dir.
+func NewManager(client Client, dir string) *Manager {
+	return &Manager{
+		Client:    client,
+		Dir:       dir,
+		Interval:  6 * time.Hour,
+		now:       time.Now,
+		downloads: make(chan struct{}, maxDownloads),
+		active:    map[uint]*channelDownloads{},
+	}
+}
+
+// Setup creates the Default manager from the configuration.
+func Setup(conf config.Podcasts) error {
+	if err := os.MkdirAll(conf.Directory, 0755); err != nil {
+		return err
+	}
+
+	m := NewManager(&http.Client{}, conf.Directory)
+	m.Download = conf.Download
+	m.Keep = conf.Keep
+	m.MaxAge = time.Duration(conf.MaxAge) * 24 * time.Hour
+	m.Interval = time.Duration(conf.RefreshInterval) * time.Hour
+
+	Default = m
+	return nil
+}
+
+// get fetches a URL within timeout. The caller closes the body and
+// calls cancel when it is done.
+func (m *Manager) get(parent context.Context, u string, timeout time.Duration) (*http.Response, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "cadenzr")
+
+	res, err := m.Client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		cancel()
+		return nil, nil, errors.New("Fetching '" + u + "' failed: " + res.Status)
+	}
+
+	return res, cancel, nil
+}
+
+// fetch returns the feed at a URL.
+func (m *Manager) fetch(u string) (*Feed, error) {
+	res, cancel, err := m.get(context.Background(), u, feedTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer res.Body.Close()
+
+	return ParseFeed(io.LimitReader(res.Body, maxFeedSize))
+}
+
+// Subscribe adds the feed at a URL with its episodes.
+func (m *Manager) Subscribe(u string) (*models.PodcastChannel, error) {
+	if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return nil, ErrNotFeed
+	}
+
+	count := 0
+	if gormDB := db.DB.Model(&models.PodcastChannel{}).Where("url = ?", u).Count(&count); gormDB.Error != nil {
+		return nil, gormDB.Error
+	} else if count != 0 {
+		return nil, ErrSubscribed
+	}
+
+	feed, err := m.fetch(u)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := &models.PodcastChannel{URL: u}
+	if err := m.update(channel, feed, true); err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}
+
+// Refresh fetches the feed of a channel again. Failures are kept in the channel.
+func (m *Manager) Refresh(channel *models.PodcastChannel) error {
+	feed, err := m.fetch(channel.URL)
+	if err != nil {
+		now := m.now()
+		db.DB.Model(channel).Updates(map[string]interface{}{"refreshed": &now, "error": err.Error()})
+		return err
+	}
+
+	return m.update(channel, feed, false)
+}
+
+// RefreshAll refreshes every channel.
+func (m *Manager) RefreshAll() {
+	channels := []*models.PodcastChannel{}
+	if gormDB := db.DB.Find(&channels); gormDB.Error != nil {
+		log.Errorf("Could not load podcast channels: %v", gormDB.Error)
+		return
+	}
+
+	for _, channel := range channels {
+		if err := m.Refresh(channel); err != nil {
+			log.Warnf("Could not refresh podcast '%s': %v", channel.URL, err)
+		}
+	}
+}
+
+// update stores a channel and the episodes of its feed. New episodes are downloaded
+// when Download is set, only the newest for a new subscription.
+func (m *Manager) update(channel *models.PodcastChannel, feed *Feed, subscribed bool) error {
+	now := m.now()
+	channel.Title = feed.Title
+	if len(channel.Title) == 0 {
+		channel.Title = channel.URL
+	}
+	channel.Description = feed.Description
+	channel.Link = feed.Link
+	channel.ImageURL = feed.ImageURL
+	channel.Refreshed = &now
+	channel.Error = ""
+
+	added := []*models.PodcastEpisode{}
+	err := transaction(func(tx *gorm.DB) error {
+		if gormDB := tx.Save(channel); gormDB.Error != nil {
+			return gormDB.Error
+		}
+
+		for _, item := range feed.Items {
+			episode := &models.PodcastEpisode{}
+			gormDB := tx.Where(models.PodcastEpisode{ChannelID: channel.ID, GUID: item.GUID}).FirstOrInit(episode)
+			if gormDB.Error != nil {
+				return gormDB.Error
+			}
+
+			if episode.ID == 0 {
+				episode.Status = models.EpisodeNew
+				added = append(added, episode)
+			}
+			episode.Title = item.Title
+			episode.Description = item.Description
+			episode.Published = item.Published
+			episode.Duration = item.Duration
+			episode.URL = item.URL
+			episode.Mime = item.Mime
+			if episode.Status != models.EpisodeDownloaded {
+				episode.Size = item.Size
+			}
+
+			if gormDB := tx.Save(episode); gormDB.Error != nil {
+				return gormDB.Error
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if m.Download && len(added) != 0 {
+		sort.Slice(added, func(i, j int) bool { return added[i].Published.After(added[j].Published) })
+		if subscribed {
+			added = added[:1]
+		}
+
+		// The retention runs when the new episodes are downloaded.
+		return m.queue(channel, added...)
+	}
+
+	return m.Retain(channel)
+}
+
+// Queue downloads episodes in the background. They are marked as downloading right
+// away.
+func (m *Manager) Queue(episodes ...*models.PodcastEpisode) error {
+	return m.queue(nil, episodes...)
+}
+
+// queue downloads episodes in the background, one after another, then applies the
+// retention to channel if it is not nil.
+func (m *Manager) queue(channel *models.PodcastChannel, episodes ...*models.PodcastEpisode) error {
+	queued := []models.PodcastEpisode{}
+	for _, episode := range episodes {
+		if gormDB := db.DB.Model(episode).Update("status", models.EpisodeDownloading); gormDB.Error != nil {
+			return gormDB.Error
+		}
+		queued = append(queued, *episode)
+	}
+
+	var retained *models.PodcastChannel
+	if channel != nil {
+		c := *channel
+		retained = &c
+	}
+
+	m.pending.Add(1)
+	go func() {
+		defer m.pending.Done()
+
+		for i := range queued {
+			m.downloads <- struct{}{}
+			if err := m.DownloadEpisode(&queued[i]); err != nil && err != ErrRemoved {
+				log.Warnf("Could not download podcast episode '%s': %v", queued[i].URL, err)
+			}
+			<-m.downloads
+		}
+
+		if retained != nil {
+			if err := m.Retain(retained); err != nil {
+				log.Warnf("Could not remove old downloads of podcast '%s': %v", retained.URL, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Wait waits until the queued downloads are done.
+func (m *Manager) Wait() {
+	m.pending.Wait()
+}
+
+func transaction(f func(tx *gorm.DB) error) error {
+	tx := db.DB.Begin()
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// extension returns the file extension of an episode, from its URL or its type.
+func extension(episode *models.PodcastEpisode) string {
+	if u, err := url.Parse(episode.URL); err == nil {
+		if ext := path.Ext(u.Path); len(ext) > 1 && len(ext) <= 5 {
+			return ext
+		}
+	}
+
+	if exts, err := mime.ExtensionsByType(episode.Mime); err == nil && len(exts) != 0 {
+		return exts[0]
+	}
+
+	return ".mp3"
+}
+
+// start registers a download of an episode. It returns ErrRemoved when the episode was
+// removed with its channel, the download is stopped when that happens later on. done is
+// called when the download is over.
+func (m *Manager) start(episode *models.PodcastEpisode) (ctx context.Context, done func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	if gormDB := db.DB.Model(&models.PodcastEpisode{}).Where("id = ?", episode.ID).Count(&count); gormDB.Error != nil {
+		return nil, nil, gormDB.Error
+	} else if count == 0 {
+		return nil, nil, ErrRemoved
+	}
+
+	d := m.active[episode.ChannelID]
+	if d == nil {
+		d = &channelDownloads{}
+		d.ctx, d.cancel = context.WithCancel(context.Background())
+		m.active[episode.ChannelID] = d
+	}
+	d.running++
+	d.done.Add(1)
+
+	return d.ctx, func() {
+		m.mu.Lock()
+		d.running--
+		if d.running == 0 && m.active[episode.ChannelID] == d {
+			d.cancel()
+			delete(m.active, episode.ChannelID)
+		}
+		m.mu.Unlock()
+		d.done.Done()
+	}, nil
+}
+
+// DownloadEpisode downloads the audio of an episode into the directory of its channel.
+func (m *Manager) DownloadEpisode(episode *models.PodcastEpisode) error {
+	ctx, done, err := m.start(episode)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if gormDB := db.DB.Model(episode).Update("status", models.EpisodeDownloading); gormDB.Error != nil {
+		return gormDB.Error
+	}
+
+	p, size, err := m.download(ctx, episode)
+	if ctx.Err() == context.Canceled {
+		return ErrRemoved
+	} else if err != nil {
+		db.DB.Model(episode).Update("status", models.EpisodeFailed)
+		return err
+	}
+
+	return db.DB.Model(episode).Updates(map[string]interface{}{"status": models.EpisodeDownloaded, "path": p, "size": size}).Error
+}
+
+func (m *Manager) download(ctx context.Context, episode *models.PodcastEpisode) (string, int64, error) {
+	dir := filepath.Join(m.Dir, strconv.Itoa(int(episode.ChannelID)))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+
+	res, cancel, err := m.get(ctx, episode.URL, episodeTimeout)
+	if err != nil {
+		return "", 0, err
+	}
+	defer cancel()
+	defer res.Body.Close()
+
+	tmp, err := ioutil.TempFile(dir, ".download-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if res.ContentLength > maxEpisodeSize {
+		tmp.Close()
+		return "", 0, ErrTooLarge
+	}
+
+	size, err := io.Copy(tmp, io.LimitReader(res.Body, maxEpisodeSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > maxEpisodeSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	p := filepath.Join(dir, strconv.Itoa(int(episode.ID))+extension(episode))
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", 0, err
+	}
+
+	return p, size, nil
+}
+
+// RemoveDownload deletes the file of an episode. It is not downloaded automatically again.
+func (m *Manager) RemoveDownload(episode *models.PodcastEpisode) error {
+	if len(episode.Path) != 0 {
+		if err := os.Remove(episode.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	episode.Path = ""
+	episode.Status = models.EpisodeRemoved
+	return db.DB.Model(episode).Updates(map[string]interface{}{"status": episode.Status, "path": ""}).Error
+}
+
+// Retain removes the downloads of a channel beyond Keep and older than MaxAge.
+func (m *Manager) Retain(channel *models.PodcastChannel) error {
+	if m.Keep == 0 && m.MaxAge == 0 {
+		return nil
+	}
+
+	episodes := []*models.PodcastEpisode{}
+	if gormDB := db.DB.Where("channel_id = ? AND status = ?", channel.ID, models.EpisodeDownloaded).Order("published DESC").Find(&episodes); gormDB.Error != nil {
+		return gormDB.Error
+	}
+
+	oldest := m.now().Add(-m.MaxAge)
+	for i, episode := range episodes {
+		expired := m.MaxAge != 0 && !episode.Published.IsZero() && episode.Published.Before(oldest)
+		if (m.Keep != 0 && i >= m.Keep) || expired {
+			if err := m.RemoveDownload(episode); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Unsubscribe removes a channel with its episodes and downloads. Running downloads of the
+// channel are stopped and queued ones are skipped.
+func (m *Manager) Unsubscribe(channel *models.PodcastChannel) error {
+	// The episodes are removed while no download can start, so that none starts after.
+	m.mu.Lock()
+	err := transaction(func(tx *gorm.DB) error {
+		ids := []uint{}
+		if gormDB := tx.Model(&models.PodcastEpisode{}).Where("channel_id = ?", channel.ID).Pluck("id", &ids); gormDB.Error != nil {
+			return gormDB.Error
+		}
+
+		if len(ids) != 0 {
+			if gormDB := tx.Unscoped().Where("episode_id IN (?)", ids).Delete(&models.PodcastPosition{}); gormDB.Error != nil {
+				return gormDB.Error
+			}
+		}
+
+		if gormDB := tx.Unscoped().Where("channel_id = ?", channel.ID).Delete(&models.PodcastEpisode{}); gormDB.Error != nil {
+			return gormDB.Error
+		}
+
+		return tx.Unscoped().Delete(channel).Error
+	})
+	d := m.active[channel.ID]
+	if err == nil {
+		delete(m.active, channel.ID)
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if d != nil {
+		d.cancel()
+		d.done.Wait()
+	}
+
+	return os.RemoveAll(filepath.Join(m.Dir, strconv.Itoa(int(channel.ID))))
+}
+
+// Run refreshes all feeds every Interval, until stop is closed.
+func (m *Manager) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	m.RefreshAll()
+	for {
+		select {
+		case <-ticker.C:
+			m.RefreshAll()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package podcasts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const rssItem = `<item>
+	<title>%[1]s</title>
+	<guid isPermaLink="false">episode-%[1]s</guid>
+	<pubDate>%[2]s</pubDate>
+	<itunes:duration>%[3]s</itunes:duration>
+	<enclosure url="%[4]s/audio/%[1]s.mp3" length="5" type="audio/mpeg"/>
+</item>`
+
+const rss = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+	<title>Show</title>
+	<atom:link href="%[1]s/feed" rel="self" type="application/rss+xml"/>
+	<link>https://show.example.com/</link>
+	<description>About things</description>
+	<itunes:image href="https://show.example.com/cover.jpg"/>
+	%[2]s
+	<item><title>No audio</title></item>
+</channel>
+</rss>`
+
+const atom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Atom show</title>
+	<link href="https://atom.example.com/"/>
+	<entry>
+		<id>urn:uuid:1</id>
+		<title>First</title>
+		<updated>2017-06-01T10:00:00Z</updated>
+		<link rel="enclosure" type="audio/ogg" length="42" href="https://atom.example.com/1.ogg"/>
+	</entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	Convey("RSS feeds are read with their iTunes extensions.", t, func() {
+		items := fmt.Sprintf(rssItem, "one", "Thu, 1 Jun 2017 10:00:00 +0000", "01:02:03", "http://example.com")
+		feed, err := ParseFeed(strings.NewReader(fmt.Sprintf(rss, "http://example.com", items)))
+		So(err, ShouldBeNil)
+		So(feed.Title, ShouldEqual, "Show")
+		So(feed.Link, ShouldEqual, "https://show.example.com/")
+		So(feed.ImageURL, ShouldEqual, "https://show.example.com/cover.jpg")
+		So(len(feed.Items), ShouldEqual, 1)
+		So(feed.Items[0].GUID, ShouldEqual, "episode-one")
+		So(feed.Items[0].Duration, ShouldEqual, 3723)
+		So(feed.Items[0].Published, ShouldResemble, time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC))
+		So(feed.Items[0].Size, ShouldEqual, 5)
+	})
+
+	Convey("Atom feeds are read from their enclosure links.", t, func() {
+		feed, err := ParseFeed(strings.NewReader(atom))
+		So(err, ShouldBeNil)
+		So(feed.Title, ShouldEqual, "Atom show")
+		So(feed.Link, ShouldEqual, "https://atom.example.com/")
+		So(len(feed.Items), ShouldEqual, 1)
+		So(feed.Items[0].URL, ShouldEqual, "https://atom.example.com/1.ogg")
+		So(feed.Items[0].Mime, ShouldEqual, "audio/ogg")
+	})
+
+	Convey("Other documents are refused.", t, func() {
+		_, err := ParseFeed(strings.NewReader("<html><body></body></html>"))
+		So(err, ShouldEqual, ErrUnknownFeed)
+	})
+}
+
+func TestManager(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:podcasts?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "podcasts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	items := []string{}
+	slow := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/audio/slow.mp3" {
+			close(slow)
+			<-r.Context().Done()
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/audio/") {
+			fmt.Fprint(w, "audio")
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, rss, "http://"+r.Host, strings.Join(items, "\n"))
+	}))
+	defer server.Close()
+
+	publish := func(name string, published time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		items = append(items, fmt.Sprintf(rssItem, name, published.Format(time.RFC1123Z), "60", server.URL))
+	}
+
+	now := time.Date(2017, 6, 10, 12, 0, 0, 0, time.UTC)
+	m := NewManager(server.Client(), dir)
+	m.Download = true
+	m.Keep = 2
+	m.now = func() time.Time { return now }
+
+	episodes := func() []*models.PodcastEpisode {
+		r := []*models.PodcastEpisode{}
+		db.DB.Order("published").Find(&r)
+		return r
+	}
+
+	var channel *models.PodcastChannel
+	Convey("Subscribing stores the episodes and downloads the newest.", t, func() {
+		publish("one", now.Add(-72*time.Hour))
+		publish("two", now.Add(-48*time.Hour))
+
+		channel, err = m.Subscribe(server.URL + "/feed")
+		So(err, ShouldBeNil)
+		So(channel.Title, ShouldEqual, "Show")
+		m.Wait()
+
+		e := episodes()
+		So(len(e), ShouldEqual, 2)
+		So(e[0].Status, ShouldEqual, models.EpisodeNew)
+		So(e[1].Status, ShouldEqual, models.EpisodeDownloaded)
+		So(e[1].Path, ShouldEqual, filepath.Join(dir, "1", "2.mp3"))
+
+		content, err := ioutil.ReadFile(e[1].Path)
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "audio")
+
+		_, err = m.Subscribe(server.URL + "/feed")
+		So(err, ShouldEqual, ErrSubscribed)
+	})
+
+	Convey("Refreshing downloads new episodes and applies the retention.", t, func() {
+		So(m.DownloadEpisode(episodes()[0]), ShouldBeNil)
+		publish("three", now.Add(-24*time.Hour))
+
+		So(m.Refresh(channel), ShouldBeNil)
+		m.Wait()
+		e := episodes()
+		So(len(e), ShouldEqual, 3)
+		So(e[0].Status, ShouldEqual, models.EpisodeRemoved)
+		So(e[0].Path, ShouldEqual, "")
+		So(e[1].Status, ShouldEqual, models.EpisodeDownloaded)
+		So(e[2].Status, ShouldEqual, models.EpisodeDownloaded)
+
+		_, err := os.Stat(filepath.Join(dir, "1", "1.mp3"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Downloads older than the maximum age are removed.", t, func() {
+		m.MaxAge = 36 * time.Hour
+		So(m.Retain(channel), ShouldBeNil)
+		e := episodes()
+		So(e[1].Status, ShouldEqual, models.EpisodeRemoved)
+		So(e[2].Status, ShouldEqual, models.EpisodeDownloaded)
+	})
+
+	Convey("Failed refreshes are kept on the channel.", t, func() {
+		failing := *channel
+		failing.URL = server.URL + "/audio/none"
+		So(m.Refresh(&failing), ShouldNotBeNil)
+
+		stored := &models.PodcastChannel{}
+		db.DB.First(stored, channel.ID)
+		So(stored.Error, ShouldNotBeEmpty)
+	})
+
+	Convey("Unsubscribing removes the episodes and downloads.", t, func() {
+		So(m.Unsubscribe(channel), ShouldBeNil)
+		So(len(episodes()), ShouldEqual, 0)
+
+		_, err := os.Stat(filepath.Join(dir, "1"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Unsubscribing stops the downloads of the channel.", t, func() {
+		publish("slow", now)
+
+		channel, err := m.Subscribe(server.URL + "/slow")
+		So(err, ShouldBeNil)
+		<-slow
+
+		So(m.Unsubscribe(channel), ShouldBeNil)
+		m.Wait()
+		So(len(episodes()), ShouldEqual, 0)
+
+		_, err = os.Stat(filepath.Join(dir, strconv.Itoa(int(channel.ID))))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,207 @@
+package probers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/dhowden/tag"
+)
+
+// Chapter is a part of a file with a title, like the chapter of an audiobook.
+type Chapter struct {
+	Title string
+	// Start and End are in seconds.
+	Start float64
+	End   float64
+}
+
+var errInvalidFrame = errors.New("Invalid ID3 frame")
+
+// cutString returns the null terminated string at the start of b and the rest of b.
+func cutString(b []byte) (string, []byte, error) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, errInvalidFrame
+	}
+
+	return string(b[:i]), b[i+1:], nil
+}
+
+// decodeText decodes the text of an ID3 text frame, which starts with its encoding.
+func decodeText(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	encoding, b := b[0], b[1:]
+	switch encoding {
+	case 0:
+		// ISO-8859-1 maps directly to the first code points.
+		runes := make([]rune, 0, len(b))
+		for _, c := range b {
+			if c == 0 {
+				break
+			}
+			runes = append(runes, rune(c))
+		}
+		return string(runes)
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(b) >= 2 && (b[0] == 0xFE && b[1] == 0xFF || b[0] == 0xFF && b[1] == 0xFE) {
+			bigEndian = b[0] == 0xFE
+			b = b[2:]
+		}
+
+		units := []uint16{}
+		for i := 0; i+1 < len(b); i += 2 {
+			u := binary.LittleEndian.Uint16(b[i:])
+			if bigEndian {
+				u = binary.BigEndian.Uint16(b[i:])
+			}
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		return string(utf16.Decode(units))
+	}
+
+	return string(bytes.TrimRight(b, "\x00"))
+}
+
+// parseCHAP parses an ID3 CHAP frame. The title comes from its TIT2 sub-frame, whose size
+// is syncsafe in ID3v2.4.
+func parseCHAP(b []byte, syncsafe bool) (string, *Chapter, error) {
+	id, b, err := cutString(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Start and end time in milliseconds, followed by byte offsets that are not used.
+	if len(b) < 16 {
+		return "", nil, errInvalidFrame
+	}
+	chapter := &Chapter{
+		Start: float64(binary.BigEndian.Uint32(b)) / 1000,
+		End:   float64(binary.BigEndian.Uint32(b[4:])) / 1000,
+	}
+	b = b[16:]
+
+	for len(b) >= 10 && b[0] != 0 {
+		name := string(b[:4])
+		size := int(binary.BigEndian.Uint32(b[4:]))
+		if syncsafe {
+			size = int(b[4])<<21 | int(b[5])<<14 | int(b[6])<<7 | int(b[7])
+		}
+		b = b[10:]
+		if size > len(b) {
+			return "", nil, errInvalidFrame
+		}
+
+		if name == "TIT2" {
+			chapter.Title = strings.TrimSpace(decodeText(b[:size]))
+		}
+		b = b[size:]
+	}
+
+	return id, chapter, nil
+}
+
+// parseCTOC parses an ID3 CTOC frame and returns whether it is the top-level table of
+// contents and the element ids of its entries.
+func parseCTOC(b []byte) (bool, []string, error) {
+	_, b, err := cutString(b)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if len(b) < 2 {
+		return false, nil, errInvalidFrame
+	}
+	topLevel := b[0]&0x02 != 0
+	count := int(b[1])
+	b = b[2:]
+
+	entries := []string{}
+	for i := 0; i < count; i++ {
+		var entry string
+		if entry, b, err = cutString(b); err != nil {
+			return false, nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return topLevel, entries, nil
+}
+
+// readID3Chapters returns the chapters in the raw ID3 frames of a file, in the order of the
+// top-level table of contents or else by their start.
+func readID3Chapters(raw map[string]interface{}, format tag.Format) []Chapter {
+	byID := map[string]*Chapter{}
+	var order []string
+	for name, value := range raw {
+		b, ok := value.([]byte)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "CHAP" || strings.HasPrefix(name, "CHAP_"):
+			id, chapter, err := parseCHAP(b, format == tag.ID3v2_4)
+			if err == nil {
+				byID[id] = chapter
+			}
+		case name == "CTOC" || strings.HasPrefix(name, "CTOC_"):
+			if topLevel, entries, err := parseCTOC(b); err == nil && topLevel {
+				order = entries
+			}
+		}
+	}
+
+	chapters := []Chapter{}
+	if order != nil {
+		for _, id := range order {
+			if chapter, ok := byID[id]; ok {
+				chapters = append(chapters, *chapter)
+				delete(byID, id)
+			}
+		}
+	}
+
+	if len(chapters) == 0 {
+		for _, chapter := range byID {
+			chapters = append(chapters, *chapter)
+		}
+		sort.Slice(chapters, func(i, j int) bool {
+			return chapters[i].Start < chapters[j].Start
+		})
+	}
+
+	return chapters
+}
+
+// CompleteChapters gives chapters without a title a number as title and lets chapters
+// without an end run until the next chapter, or the end of the file at duration.
+func CompleteChapters(chapters []Chapter, duration float64) []Chapter {
+	for i := range chapters {
+		c := &chapters[i]
+		if len(c.Title) == 0 {
+			c.Title = "Chapter " + strconv.Itoa(i+1)
+		}
+
+		if c.End <= c.Start {
+			if i+1 < len(chapters) {
+				c.End = chapters[i+1].Start
+			} else if duration > c.Start {
+				c.End = duration
+			}
+		}
+	}
+
+	return chapters
+}
@@ -0,0 +1,75 @@
+package probers
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/dhowden/tag"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// chap builds an ID3v2.3 CHAP frame with a TIT2 sub-frame.
+func chap(id string, start uint32, end uint32, title string) []byte {
+	b := append([]byte(id), 0)
+	times := make([]byte, 16)
+	binary.BigEndian.PutUint32(times, start)
+	binary.BigEndian.PutUint32(times[4:], end)
+	binary.BigEndian.PutUint32(times[8:], 0xFFFFFFFF)
+	binary.BigEndian.PutUint32(times[12:], 0xFFFFFFFF)
+	b = append(b, times...)
+
+	if len(title) != 0 {
+		text := append([]byte{3}, title...)
+		header := make([]byte, 10)
+		copy(header, "TIT2")
+		binary.BigEndian.PutUint32(header[4:], uint32(len(text)))
+		b = append(append(b, header...), text...)
+	}
+
+	return b
+}
+
+func TestReadID3Chapters(t *testing.T) {
+	Convey("Chapters are ordered by the top-level table of contents.", t, func() {
+		ctoc := append([]byte("toc\x00"), 0x03, 2)
+		ctoc = append(ctoc, "ch2\x00ch1\x00"...)
+
+		chapters := readID3Chapters(map[string]interface{}{
+			"CHAP":   chap("ch1", 0, 60000, "Prologue"),
+			"CHAP_0": chap("ch2", 60000, 90500, "Später"),
+			"CTOC":   ctoc,
+		}, tag.ID3v2_3)
+
+		So(chapters, ShouldResemble, []Chapter{
+			{Title: "Später", Start: 60, End: 90.5},
+			{Title: "Prologue", Start: 0, End: 60},
+		})
+	})
+
+	Convey("Without a table of contents chapters are ordered by their start.", t, func() {
+		chapters := readID3Chapters(map[string]interface{}{
+			"CHAP":   chap("b", 30000, 0, ""),
+			"CHAP_0": chap("a", 0, 30000, "One"),
+		}, tag.ID3v2_3)
+
+		So(len(chapters), ShouldEqual, 2)
+		So(chapters[0].Title, ShouldEqual, "One")
+
+		chapters = CompleteChapters(chapters, 100)
+		So(chapters[1], ShouldResemble, Chapter{Title: "Chapter 2", Start: 30, End: 100})
+	})
+
+	Convey("Broken frames are skipped.", t, func() {
+		chapters := readID3Chapters(map[string]interface{}{
+			"CHAP": []byte("broken"),
+		}, tag.ID3v2_4)
+
+		So(len(chapters), ShouldEqual, 0)
+	})
+
+	Convey("UTF-16 titles are decoded.", t, func() {
+		So(decodeText([]byte{1, 0xFF, 0xFE, 'O', 0, 'k', 0, 0, 0}), ShouldEqual, "Ok")
+		So(decodeText([]byte{0, 'S', 0xE9, 0}), ShouldEqual, "Sé")
+	})
+}
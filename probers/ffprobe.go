@@ -51,6 +51,7 @@ func (p *ffprobeAudioProber) ProbeAudio(file string) (meta *AudioMeta, err error
 	cmd := exec.Command(ffprobe,
 		"-print_format", "json",
 		"-show_entries", "format=duration:format_tags",
+		"-show_chapters",
 		file,
 	)
 
@@ -75,6 +76,24 @@ func (p *ffprobeAudioProber) ProbeAudio(file string) (meta *AudioMeta, err error
 				TotalTracks string `json:"totaltracks"`
 				Date        string `json:"date"`
 				AlbumArtist string `json:"album_artist"`
+				Lyrics      string `json:"lyrics"`
+				// Tag names are matched without case, FLAC files usually have upper case names.
+				TrackGain     string `json:"replaygain_track_gain"`
+				TrackPeak     string `json:"replaygain_track_peak"`
+				AlbumGain     string `json:"replaygain_album_gain"`
+				AlbumPeak     string `json:"replaygain_album_peak"`
+				R128TrackGain string `json:"r128_track_gain"`
+				R128AlbumGain string `json:"r128_album_gain"`
+				MBID          string `json:"musicbrainz_trackid"`
+				MP4MBID       string `json:"musicbrainz track id"`
+			}
+		}
+		// Chapters are read from MP4 chapter lists and ID3 CHAP frames.
+		Chapters []struct {
+			StartTime string `json:"start_time"`
+			EndTime   string `json:"end_time"`
+			Tags      struct {
+				Title string `json:"title"`
 			}
 		}
 	}{}
@@ -97,8 +116,33 @@ func (p *ffprobeAudioProber) ProbeAudio(file string) (meta *AudioMeta, err error
 	meta.TotalTracks = parseInt(response.Format.Tags.TotalTracks)
 	meta.Year = parseInt(response.Format.Tags.Date)
 	meta.AlbumArtist = response.Format.Tags.AlbumArtist
+	meta.Lyrics = response.Format.Tags.Lyrics
 	meta.Duration, _ = strconv.ParseFloat(response.Format.Duration, 64)
 
+	tags := map[string]string{
+		"replaygain_track_gain": response.Format.Tags.TrackGain,
+		"replaygain_track_peak": response.Format.Tags.TrackPeak,
+		"replaygain_album_gain": response.Format.Tags.AlbumGain,
+		"replaygain_album_peak": response.Format.Tags.AlbumPeak,
+		"r128_track_gain":       response.Format.Tags.R128TrackGain,
+		"r128_album_gain":       response.Format.Tags.R128AlbumGain,
+		"musicbrainz_trackid":   response.Format.Tags.MBID,
+		"musicbrainz track id":  response.Format.Tags.MP4MBID,
+	}
+	get := func(name string) string {
+		return tags[name]
+	}
+	meta.TrackGain = readGain(get, "track")
+	meta.AlbumGain = readGain(get, "album")
+	meta.MBID = readMBID(get)
+
+	for _, c := range response.Chapters {
+		chapter := Chapter{Title: c.Tags.Title}
+		chapter.Start, _ = strconv.ParseFloat(c.StartTime, 64)
+		chapter.End, _ = strconv.ParseFloat(c.EndTime, 64)
+		meta.Chapters = append(meta.Chapters, chapter)
+	}
+
 	p.getCover(file, meta)
 
 	return
@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"github.com/badgerodon/mp3"
+	"github.com/cadenzr/cadenzr/lyrics"
 	"github.com/dhowden/tag"
 )
 
@@ -30,6 +31,11 @@ func (p *genericTagAudioProber) ProbeAudio(file string) (meta *AudioMeta, err er
 	meta.Genre = m.Genre()
 	meta.Track, meta.TotalTracks = m.Track()
 
+	meta.Lyrics = readLyrics(m)
+	meta.TrackGain, meta.AlbumGain = readReplayGain(m.Raw())
+	meta.MBID = readRawMBID(m.Raw())
+	meta.Chapters = readID3Chapters(m.Raw(), m.Format())
+
 	if m.Picture() != nil {
 		meta.CoverBufer = m.Picture().Data
 	}
@@ -43,6 +49,25 @@ func (p *genericTagAudioProber) ProbeAudio(file string) (meta *AudioMeta, err er
 	return
 }
 
+// readLyrics returns the synchronized lyrics of an ID3 SYLT frame as LRC, or else the
+// plain lyrics of an USLT frame or LYRICS comment.
+func readLyrics(m tag.Metadata) string {
+	raw := m.Raw()
+	if sylt, ok := raw["SYLT"].([]byte); ok {
+		if l, err := lyrics.ParseSYLT(sylt); err == nil && len(l.Lines) != 0 {
+			return l.LRC()
+		}
+	}
+
+	if text := m.Lyrics(); len(text) != 0 {
+		return text
+	}
+
+	// Some taggers use this name in Vorbis comments.
+	text, _ := raw["unsyncedlyrics"].(string)
+	return text
+}
+
 func (p *genericTagAudioProber) String() string {
 	return "genericTagAudioProber"
 }
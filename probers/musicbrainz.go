@@ -0,0 +1,49 @@
+package probers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// musicBrainzProvider is the owner of the ID3 UFID frame with the recording id.
+const musicBrainzProvider = "http://musicbrainz.org"
+
+var mbidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// parseMBID returns the MusicBrainz id in s, or "" if it isn't one.
+func parseMBID(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if !mbidPattern.MatchString(s) {
+		return ""
+	}
+
+	return s
+}
+
+// readMBID returns the MusicBrainz recording id from the tags that get returns by lower
+// case name: MUSICBRAINZ_TRACKID in Vorbis comments, "MusicBrainz Track Id" in MP4 atoms.
+func readMBID(get func(name string) string) string {
+	for _, name := range []string{"musicbrainz_trackid", "musicbrainz track id"} {
+		if mbid := parseMBID(get(name)); len(mbid) != 0 {
+			return mbid
+		}
+	}
+
+	return ""
+}
+
+// readRawMBID returns the MusicBrainz recording id in the raw tags of a file. ID3 tags
+// keep it in a UFID frame.
+func readRawMBID(raw map[string]interface{}) string {
+	for _, value := range raw {
+		if ufid, ok := value.(*tag.UFID); ok && ufid.Provider == musicBrainzProvider {
+			if mbid := parseMBID(string(ufid.Identifier)); len(mbid) != 0 {
+				return mbid
+			}
+		}
+	}
+
+	return readMBID(rawTagValues(raw))
+}
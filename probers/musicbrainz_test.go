@@ -0,0 +1,23 @@
+package probers
+
+import (
+	"testing"
+
+	"github.com/dhowden/tag"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadMBID(t *testing.T) {
+	Convey("The recording id is read from ID3 UFID frames.", t, func() {
+		mbid := readRawMBID(map[string]interface{}{
+			"UFID": &tag.UFID{Provider: "http://musicbrainz.org", Identifier: []byte("B1A9C0E9-D987-4042-AE91-78D6A3267D69")},
+		})
+		So(mbid, ShouldEqual, "b1a9c0e9-d987-4042-ae91-78d6a3267d69")
+	})
+
+	Convey("The recording id is read from Vorbis comments.", t, func() {
+		So(readRawMBID(map[string]interface{}{"MUSICBRAINZ_TRACKID": "b1a9c0e9-d987-4042-ae91-78d6a3267d69"}), ShouldEqual, "b1a9c0e9-d987-4042-ae91-78d6a3267d69")
+		So(readRawMBID(map[string]interface{}{"MUSICBRAINZ_TRACKID": "not an id"}), ShouldEqual, "")
+	})
+}
@@ -23,6 +23,15 @@ type AudioMeta struct {
 	AlbumArtist string
 	Genre       string
 	Duration    float64
+	// Lyrics are plain text, or LRC if they are synchronized.
+	Lyrics string
+	// TrackGain and AlbumGain are the ReplayGain values in the tags.
+	TrackGain Gain
+	AlbumGain Gain
+	// MBID is the MusicBrainz recording id.
+	MBID string
+	// Chapters are in the order of the file, usually of an audiobook.
+	Chapters []Chapter
 
 	CoverBufer []byte
 }
@@ -79,6 +88,26 @@ func (a *AudioMeta) Merge(b *AudioMeta) {
 		a.Duration = b.Duration
 	}
 
+	if len(a.Lyrics) == 0 {
+		a.Lyrics = b.Lyrics
+	}
+
+	if !a.TrackGain.Valid {
+		a.TrackGain = b.TrackGain
+	}
+
+	if !a.AlbumGain.Valid {
+		a.AlbumGain = b.AlbumGain
+	}
+
+	if len(a.MBID) == 0 {
+		a.MBID = b.MBID
+	}
+
+	if len(a.Chapters) == 0 {
+		a.Chapters = b.Chapters
+	}
+
 	if a.CoverBufer == nil {
 		a.CoverBufer = b.CoverBufer
 	}
@@ -109,12 +138,20 @@ func Initialize() {
 
 	mp3Probers := []AudioProber{}
 	flacProbers := []AudioProber{}
+	mp4Probers := []AudioProber{}
 
 	mp3Probers = append(mp3Probers, genericTagProber)
 
 	if ffProber.hasFFprobe() {
 		mp3Probers = append(mp3Probers, ffProber)
 		flacProbers = append(flacProbers, ffProber)
+		// Only ffprobe reads the chapters of MP4 files.
+		mp4Probers = append(mp4Probers, ffProber)
+	}
+
+	// Audiobooks are usually M4B files, which are not in every mime table.
+	if len(mime.TypeByExtension(".m4b")) == 0 {
+		mime.AddExtensionType(".m4b", "audio/mp4")
 	}
 
 	probers = append(probers, &prober{
@@ -127,6 +164,11 @@ func Initialize() {
 		Probers: flacProbers,
 	})
 
+	probers = append(probers, &prober{
+		Mime:    regexp.MustCompile("audio/(mp4|x-m4a|x-m4b)"),
+		Probers: mp4Probers,
+	})
+
 	for _, prober := range probers {
 		log.Infof("Registered probes for '%s': %s", prober.Mime, prober.Probers)
 	}
@@ -0,0 +1,89 @@
+package probers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// Gain is a ReplayGain value from the tags of a file.
+type Gain struct {
+	// Gain is in dB, relative to a loudness of -18 LUFS.
+	Gain float64
+	// Peak is the largest sample, 1 is full scale. 0 means it is unknown.
+	Peak float64
+
+	Valid bool
+}
+
+// r128Offset is the difference in dB between the ReplayGain reference and
+// the -23 LUFS that R128 gains are relative to.
+const r128Offset = 5
+
+// parseGain parses a ReplayGain value like "-6.50 dB".
+func parseGain(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[len(s)-2:], "db") {
+		s = strings.TrimSpace(s[:len(s)-2])
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+// parseR128Gain parses an R128_*_GAIN value, a Q7.8 number relative to -23 LUFS.
+func parseR128Gain(s string) (float64, bool) {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, false
+	}
+
+	return float64(v)/256 + r128Offset, true
+}
+
+// readGain returns the gain of "track" or "album" from the tags that get returns
+// by lower case name. ReplayGain tags are preferred over R128 tags.
+func readGain(get func(name string) string, kind string) Gain {
+	g := Gain{}
+
+	if v, ok := parseGain(get("replaygain_" + kind + "_gain")); ok {
+		g.Gain, g.Valid = v, true
+	} else if v, ok := parseR128Gain(get("r128_" + kind + "_gain")); ok {
+		g.Gain, g.Valid = v, true
+	}
+
+	if g.Valid {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(get("replaygain_"+kind+"_peak")), 64); err == nil && v > 0 {
+			g.Peak = v
+		}
+	}
+
+	return g
+}
+
+// readReplayGain returns the track and album gain in the raw tags of a file:
+// ID3 TXXX frames, Vorbis comments or MP4 freeform atoms.
+func readReplayGain(raw map[string]interface{}) (track Gain, album Gain) {
+	get := rawTagValues(raw)
+	return readGain(get, "track"), readGain(get, "album")
+}
+
+// rawTagValues returns a function that looks up the text tags in the raw tags of a file
+// by lower case name.
+func rawTagValues(raw map[string]interface{}) func(name string) string {
+	values := map[string]string{}
+	for name, value := range raw {
+		switch v := value.(type) {
+		case *tag.Comm:
+			// TXXX frames are stored as TXXX, TXXX_0, ... with the name in the description.
+			values[strings.ToLower(v.Description)] = v.Text
+		case string:
+			values[strings.ToLower(name)] = v
+		}
+	}
+
+	return func(name string) string {
+		return values[name]
+	}
+}
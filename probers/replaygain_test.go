@@ -0,0 +1,36 @@
+package probers
+
+import (
+	"testing"
+
+	"github.com/dhowden/tag"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadReplayGain(t *testing.T) {
+	Convey("ReplayGain is read from ID3 TXXX frames.", t, func() {
+		track, album := readReplayGain(map[string]interface{}{
+			"TXXX":   &tag.Comm{Description: "REPLAYGAIN_TRACK_GAIN", Text: "-6.50 dB"},
+			"TXXX_0": &tag.Comm{Description: "replaygain_track_peak", Text: "0.988"},
+			"TXXX_1": &tag.Comm{Description: "REPLAYGAIN_ALBUM_GAIN", Text: "+1.2 dB"},
+		})
+
+		So(track.Valid, ShouldBeTrue)
+		So(track.Gain, ShouldEqual, -6.5)
+		So(track.Peak, ShouldEqual, 0.988)
+		So(album.Valid, ShouldBeTrue)
+		So(album.Gain, ShouldEqual, 1.2)
+		So(album.Peak, ShouldEqual, 0)
+	})
+
+	Convey("R128 gains are converted to the ReplayGain reference.", t, func() {
+		track, album := readReplayGain(map[string]interface{}{
+			"r128_track_gain": "-512",
+		})
+
+		So(track.Valid, ShouldBeTrue)
+		So(track.Gain, ShouldEqual, 3)
+		So(album.Valid, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,73 @@
+package probers
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// canonicalMimes maps alternative names of audio mime types to the name used by the probers.
+var canonicalMimes = map[string]string{
+	"audio/mp3":    "audio/mpeg",
+	"audio/x-mp3":  "audio/mpeg",
+	"audio/x-flac": "audio/flac",
+	"audio/x-wav":  "audio/wav",
+	"audio/x-m4a":  "audio/mp4",
+}
+
+// CanonicalMime returns the common name of an audio mime type.
+func CanonicalMime(mime string) string {
+	if canonical, ok := canonicalMimes[mime]; ok {
+		return canonical
+	}
+
+	return mime
+}
+
+// SniffAudio returns the mime type of audio data by looking at its first bytes.
+// Returns an empty string if the data is not recognized as audio.
+func SniffAudio(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("ID3")):
+		return "audio/mpeg"
+	case len(b) >= 2 && b[0] == 0xFF && b[1]&0xE0 == 0xE0:
+		// MPEG audio frame sync.
+		return "audio/mpeg"
+	case bytes.HasPrefix(b, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return "audio/ogg"
+	case len(b) >= 12 && bytes.HasPrefix(b, []byte("RIFF")) && bytes.Equal(b[8:12], []byte("WAVE")):
+		return "audio/wav"
+	case len(b) >= 12 && bytes.Equal(b[4:8], []byte("ftyp")) && mp4Brands[string(b[8:12])]:
+		return "audio/mp4"
+	}
+
+	return ""
+}
+
+// mp4Brands are the major brands of MP4 files with audio. Some encoders write the
+// generic 'mp42' or 'isom' brands instead of 'M4A '.
+var mp4Brands = map[string]bool{
+	"M4A ": true,
+	"M4B ": true,
+	"mp42": true,
+	"isom": true,
+}
+
+// SniffAudioFile returns the mime type of an audio file by looking at its content.
+func SniffAudioFile(file string) (string, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	b := make([]byte, 512)
+	n, err := io.ReadFull(fh, b)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return SniffAudio(b[:n]), nil
+}
@@ -0,0 +1,26 @@
+package probers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSniffAudio(t *testing.T) {
+
+	Convey("Audio is recognized by its content", t, func() {
+		So(SniffAudio([]byte("ID3\x03\x00")), ShouldEqual, "audio/mpeg")
+		So(SniffAudio([]byte{0xFF, 0xFB, 0x90}), ShouldEqual, "audio/mpeg")
+		So(SniffAudio([]byte("fLaC\x00")), ShouldEqual, "audio/flac")
+		So(SniffAudio([]byte("OggS\x00")), ShouldEqual, "audio/ogg")
+		So(SniffAudio([]byte("RIFF\x00\x00\x00\x00WAVEfmt ")), ShouldEqual, "audio/wav")
+		So(SniffAudio([]byte("\x00\x00\x00\x20ftypM4A ")), ShouldEqual, "audio/mp4")
+		So(SniffAudio([]byte("\x00\x00\x00\x20ftypmp42")), ShouldEqual, "audio/mp4")
+		So(SniffAudio([]byte("\x00\x00\x00\x20ftypisom")), ShouldEqual, "audio/mp4")
+		So(SniffAudio([]byte("\x00\x00\x00\x20ftypqt  ")), ShouldEqual, "")
+		So(SniffAudio([]byte("<html>")), ShouldEqual, "")
+		So(SniffAudio(nil), ShouldEqual, "")
+		So(CanonicalMime("audio/x-flac"), ShouldEqual, "audio/flac")
+	})
+
+}
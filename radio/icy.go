@@ -0,0 +1,75 @@
+package radio
+
+import (
+	"io"
+	"strings"
+)
+
+// MetaInt is the number of audio bytes between ICY metadata blocks.
+const MetaInt = 16000
+
+// icyWriter inserts ICY metadata with the stream title into audio, for players that
+// ask for it with the 'Icy-MetaData: 1' header.
+type icyWriter struct {
+	w io.Writer
+	// title returns the title of what is playing.
+	title func() string
+	// left is the number of audio bytes until the next metadata block.
+	left int
+	// sent is the last title that was sent. Blocks repeat it with an empty block.
+	sent string
+}
+
+// NewICYWriter returns a writer that inserts the title returned by title into the audio
+// written to w.
+func NewICYWriter(w io.Writer, title func() string) io.Writer {
+	return &icyWriter{w: w, title: title, left: MetaInt}
+}
+
+// metadata returns a metadata block: its length in 16 byte units, followed by the
+// padded text.
+func metadata(title string) []byte {
+	// Quotes end the title, players don't unescape them.
+	text := "StreamTitle='" + strings.Replace(title, "'", "’", -1) + "';"
+	if len(text) > 255*16 {
+		text = text[:255*16]
+	}
+
+	units := (len(text) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], text)
+	return block
+}
+
+func (w *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > w.left {
+			n = w.left
+		}
+
+		if _, err := w.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		w.left -= n
+		p = p[n:]
+
+		if w.left == 0 {
+			block := []byte{0}
+			if title := w.title(); title != w.sent {
+				block = metadata(title)
+				w.sent = title
+			}
+
+			if _, err := w.w.Write(block); err != nil {
+				return written, err
+			}
+			w.left = MetaInt
+		}
+	}
+
+	return written, nil
+}
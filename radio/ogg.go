@@ -0,0 +1,64 @@
+package radio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// oggHeaderSize is the size of an Ogg page header without its segment table.
+	oggHeaderSize = 27
+	// maxOggHeaders is the most that is kept of the header pages of a song.
+	maxOggHeaders = 256 * 1024
+)
+
+var oggCapture = []byte("OggS")
+
+// ErrOggPage is returned when a stream is not made of Ogg pages.
+var ErrOggPage = errors.New("Invalid Ogg page")
+
+// oggPage is an Ogg page with its header.
+type oggPage []byte
+
+// granule returns the granule position of the page. Pages that only carry the codec
+// headers have position 0.
+func (p oggPage) granule() uint64 {
+	return binary.LittleEndian.Uint64(p[6:14])
+}
+
+// readOggPage reads the next page of an Ogg stream.
+func readOggPage(r *bufio.Reader) (oggPage, error) {
+	header := make([]byte, oggHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrOggPage
+		}
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:4], oggCapture) || header[4] != 0 {
+		return nil, ErrOggPage
+	}
+
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(r, segments); err != nil {
+		return nil, ErrOggPage
+	}
+
+	size := 0
+	for _, s := range segments {
+		size += int(s)
+	}
+
+	page := make([]byte, oggHeaderSize+len(segments)+size)
+	copy(page, header)
+	copy(page[oggHeaderSize:], segments)
+	if _, err := io.ReadFull(r, page[oggHeaderSize+len(segments):]); err != nil {
+		return nil, ErrOggPage
+	}
+
+	return page, nil
+}
@@ -0,0 +1,130 @@
+package radio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when the proxy would connect to an address that is not
+// public, like the server itself or its local network.
+var ErrPrivateAddress = errors.New("The stream is not at a public address")
+
+// maxProxyRedirects is the number of redirects the proxy follows to a stream.
+const maxProxyRedirects = 5
+
+// privateNetworks are the networks besides loopback, link-local and multicast addresses
+// that are not reachable through the proxy.
+var privateNetworks = []*net.IPNet{}
+
+func init() {
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "198.18.0.0/15", "64:ff9b::/96", "fc00::/7", "fec0::/10"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		privateNetworks = append(privateNetworks, network)
+	}
+}
+
+// IsPublicIP returns whether ip is a public unicast address.
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// icyConn reads the 'ICY 200 OK' status line of SHOUTcast v1 servers as an HTTP/1.0
+// one, which net/http refuses otherwise.
+type icyConn struct {
+	net.Conn
+	checked bool
+	head    []byte
+	err     error
+}
+
+func (c *icyConn) Read(b []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		c.head = make([]byte, 4)
+		n, err := io.ReadFull(c.Conn, c.head)
+		c.head, c.err = c.head[:n], err
+		if bytes.Equal(c.head, []byte("ICY ")) {
+			c.head = []byte("HTTP/1.0 ")
+		}
+	}
+
+	if len(c.head) != 0 {
+		n := copy(b, c.head)
+		c.head = c.head[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		if c.err == io.ErrUnexpectedEOF {
+			return 0, io.EOF
+		}
+		return 0, c.err
+	}
+
+	return c.Conn.Read(b)
+}
+
+// NewProxyClient returns the client that fetches the streams of the station catalog.
+// It only connects to public addresses. The address is checked when connecting, so
+// redirects and host names that resolve to private addresses are refused too. Streams
+// don't end, so there is no timeout. SHOUTcast v1 servers, which answer with an 'ICY'
+// status line, are supported.
+func NewProxyClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		// Without a Proxy the environment can't send requests around the address check.
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				conn, err := dialer.DialContext(ctx, network, address)
+				if err != nil {
+					return nil, err
+				}
+				return &icyConn{Conn: conn}, nil
+			},
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxProxyRedirects {
+				return errors.New("The stream redirects too often")
+			}
+
+			if !IsStreamURL(req.URL.String()) {
+				return errors.New("The stream redirects to an address that is not http or https")
+			}
+			return nil
+		},
+	}
+}
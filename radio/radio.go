@@ -0,0 +1,483 @@
+// Package radio streams live stations that all listeners hear at the same time. A station
+// plays the songs queued on it, or a playlist on shuffle when the queue is empty, and
+// encodes them one after another into a single stream. Stations only play while someone
+// listens.
+package radio
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/replaygain"
+	"github.com/cadenzr/cadenzr/transcoders"
+)
+
+const (
+	// chunkSize is the most that is sent to listeners at once. Ogg streams are sent a
+	// page at a time instead.
+	chunkSize = 4 * 1024
+	// listenerBuffer is the number of chunks a listener can fall behind before it is
+	// dropped.
+	listenerBuffer = 256
+	// retryWait is how long a station waits after a song failed to encode, or when it
+	// has nothing to play.
+	retryWait = 10 * time.Second
+)
+
+// Track is a song playing on a station.
+type Track struct {
+	SongID uint `json:"song_id"`
+	// Title is the artist and name of the song, as sent in the stream.
+	Title   string    `json:"title"`
+	Started time.Time `json:"started"`
+}
+
+// Listener receives the audio of a station.
+type Listener struct {
+	// C is closed when the listener fell too far behind.
+	C <-chan []byte
+	c chan []byte
+	// username decides which libraries the station shuffles.
+	username string
+}
+
+// Station is a live stream.
+type Station struct {
+	Name    string
+	Profile *transcoders.Profile
+	// playlist is played on shuffle when the queue is empty, all songs if it is empty.
+	playlist string
+
+	mu        sync.Mutex
+	queue     []uint
+	shuffled  []uint
+	current   *Track
+	listeners map[*Listener]bool
+	skip      context.CancelFunc
+	// header holds the header pages of the Ogg stream of the current song, which new
+	// listeners need before they can decode it.
+	header []byte
+	// wake is signalled when songs are queued or a listener arrives.
+	wake chan struct{}
+
+	// encode returns the stream of a song. It is replaced in tests.
+	encode func(ctx context.Context, song *models.Song) (io.ReadCloser, error)
+}
+
+// NewStation returns a station that encodes with a profile.
+func NewStation(name string, profile *transcoders.Profile, playlist string) *Station {
+	return &Station{
+		Name:      name,
+		Profile:   profile,
+		playlist:  playlist,
+		listeners: map[*Listener]bool{},
+		wake:      make(chan struct{}, 1),
+		encode: func(ctx context.Context, song *models.Song) (io.ReadCloser, error) {
+			gain, _ := replaygain.SongGain(song, config.Config.ReplayGain)
+			return transcoders.TranscodeLive(ctx, song.Path, profile, gain)
+		},
+	}
+}
+
+var stations = map[string]*Station{}
+
+// Setup creates the stations of the configuration and starts them.
+func Setup(confs []config.RadioStation) error {
+	r := map[string]*Station{}
+	for _, conf := range confs {
+		profile, ok := transcoders.FindProfile(conf.Profile)
+		if !ok {
+			return errors.New("Radio station '" + conf.Name + "' uses unknown profile '" + conf.Profile + "'")
+		}
+
+		if profile.Container != transcoders.ContainerMP3 && profile.Container != transcoders.ContainerOgg {
+			return errors.New("Radio station '" + conf.Name + "' needs a profile with the mp3 or ogg container")
+		}
+
+		r[conf.Name] = NewStation(conf.Name, profile, conf.Playlist)
+	}
+
+	stations = r
+	for _, s := range stations {
+		go s.Run(nil)
+	}
+
+	return nil
+}
+
+// Find returns the station with a name.
+func Find(name string) (*Station, bool) {
+	s, ok := stations[name]
+	return s, ok
+}
+
+// All returns the stations.
+func All() []*Station {
+	r := []*Station{}
+	for _, s := range stations {
+		r = append(r, s)
+	}
+
+	return r
+}
+
+func (s *Station) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Listen returns a listener for a user that receives the stream from now on. Ogg
+// streams start with the headers of the current song.
+func (s *Station) Listen(username string) *Listener {
+	c := make(chan []byte, listenerBuffer)
+	l := &Listener{C: c, c: c, username: username}
+
+	s.mu.Lock()
+	if len(s.header) != 0 {
+		c <- s.header
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	s.signal()
+	return l
+}
+
+// Leave stops sending the stream to a listener.
+func (s *Station) Leave(l *Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listeners[l] {
+		delete(s.listeners, l)
+		close(l.c)
+	}
+}
+
+// broadcast sends a chunk to every listener. Listeners that fell behind are dropped.
+// Header chunks are also kept for the listeners that arrive later.
+func (s *Station) broadcast(chunk []byte, header bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if header {
+		s.header = append(s.header, chunk...)
+	}
+
+	for l := range s.listeners {
+		select {
+		case l.c <- chunk:
+		default:
+			delete(s.listeners, l)
+			close(l.c)
+		}
+	}
+}
+
+// Title returns the title of the song that is playing, empty if none is.
+func (s *Station) Title() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		return ""
+	}
+
+	return s.current.Title
+}
+
+// Status returns the song that is playing, nil if none is, the queued songs and the
+// number of listeners.
+func (s *Station) Status() (*Track, []uint, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *Track
+	if s.current != nil {
+		track := *s.current
+		current = &track
+	}
+
+	return current, append([]uint{}, s.queue...), len(s.listeners)
+}
+
+// Enqueue adds songs to the queue.
+func (s *Station) Enqueue(ids ...uint) {
+	s.mu.Lock()
+	s.queue = append(s.queue, ids...)
+	s.mu.Unlock()
+
+	s.signal()
+}
+
+// SetQueue replaces the queue.
+func (s *Station) SetQueue(ids []uint) {
+	s.mu.Lock()
+	s.queue = append([]uint{}, ids...)
+	s.mu.Unlock()
+
+	s.signal()
+}
+
+// Skip stops the song that is playing, the station moves on to the next.
+func (s *Station) Skip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.skip != nil {
+		s.skip()
+	}
+}
+
+// libraries returns the IDs of the libraries every listener can access, and false
+// when there are no libraries to limit the songs to.
+func (s *Station) libraries() ([]uint, bool) {
+	libs := library.All()
+	if len(libs) == 0 {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []uint{}
+	for _, lib := range libs {
+		allowed := true
+		for l := range s.listeners {
+			allowed = allowed && lib.Allows(l.username)
+		}
+		if allowed {
+			ids = append(ids, lib.ID)
+		}
+	}
+
+	return ids, true
+}
+
+// allows returns whether every listener can access a library.
+func allows(ids []uint, id models.NullInt64) bool {
+	for _, allowed := range ids {
+		if id.Valid && uint(id.Int64) == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shuffle returns the songs of the playlist of the station in random order. Without
+// a playlist all songs are played, except audiobooks. Only songs in the libraries
+// every listener can access are played.
+func (s *Station) shuffle() ([]uint, error) {
+	ids := []uint{}
+	query := db.DB.Model(&models.Song{})
+	if libs, filtered := s.libraries(); filtered {
+		query = query.Where("library_id IN (?)", libs)
+	}
+
+	if len(s.playlist) == 0 {
+		if gormDB := query.Where("audiobook = ?", false).Pluck("id", &ids); gormDB.Error != nil {
+			return nil, gormDB.Error
+		}
+	} else {
+		playlist := &models.Playlist{}
+		gormDB := db.DB.First(playlist, "name = ?", s.playlist)
+		if gormDB.RecordNotFound() {
+			return nil, errors.New("Playlist '" + s.playlist + "' does not exist")
+		} else if gormDB.Error != nil {
+			return nil, gormDB.Error
+		}
+
+		query = query.Where("id IN (SELECT song_id FROM playlist_songs WHERE playlist_id = ?)", playlist.ID)
+		if gormDB := query.Pluck("id", &ids); gormDB.Error != nil {
+			return nil, gormDB.Error
+		}
+	}
+
+	shuffled := make([]uint, len(ids))
+	for i, j := range rand.Perm(len(ids)) {
+		shuffled[i] = ids[j]
+	}
+
+	return shuffled, nil
+}
+
+// next returns the song to play next, nil if there is none. Songs that were removed
+// from the library are skipped, and so are songs that a listener can't access, also
+// when they were queued.
+func (s *Station) next() (*models.Song, error) {
+	for {
+		libs, filtered := s.libraries()
+
+		s.mu.Lock()
+		if len(s.queue) == 0 && len(s.shuffled) == 0 {
+			s.mu.Unlock()
+
+			shuffled, err := s.shuffle()
+			if err != nil || len(shuffled) == 0 {
+				return nil, err
+			}
+
+			s.mu.Lock()
+			s.shuffled = shuffled
+		}
+
+		var id uint
+		if len(s.queue) != 0 {
+			id, s.queue = s.queue[0], s.queue[1:]
+		} else {
+			id, s.shuffled = s.shuffled[0], s.shuffled[1:]
+		}
+		s.mu.Unlock()
+
+		song := &models.Song{}
+		gormDB := db.DB.Preload("Artist").Preload("Album").First(song, "id = ?", id)
+		if gormDB.RecordNotFound() {
+			continue
+		} else if gormDB.Error != nil {
+			return nil, gormDB.Error
+		}
+
+		if filtered && !allows(libs, song.LibraryID) {
+			continue
+		}
+
+		return song, nil
+	}
+}
+
+// title returns the artist and name of a song.
+func title(song *models.Song) string {
+	if song.Artist == nil || len(song.Artist.Name) == 0 {
+		return song.Name
+	}
+
+	return song.Artist.Name + " - " + song.Name
+}
+
+// play encodes a song and sends it to the listeners until it ends or is skipped.
+func (s *Station) play(ctx context.Context, song *models.Song) error {
+	songCtx, skip := context.WithCancel(ctx)
+	defer skip()
+
+	r, err := s.encode(songCtx, song)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	s.mu.Lock()
+	s.current = &Track{SongID: song.ID, Title: title(song), Started: time.Now()}
+	s.skip = skip
+	s.header = nil
+	s.mu.Unlock()
+
+	if s.Profile != nil && s.Profile.Container == transcoders.ContainerOgg {
+		return s.playOgg(songCtx, r)
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			s.broadcast(append([]byte{}, buf[:n]...), false)
+		}
+
+		if songCtx.Err() != nil || err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// playOgg sends an Ogg stream a page at a time, so that listeners who tune in later
+// start at a page. The pages before the first audio are the headers of the stream.
+func (s *Station) playOgg(ctx context.Context, r io.Reader) error {
+	br := bufio.NewReader(r)
+	headers := 0
+	inHeader := true
+	for {
+		page, err := readOggPage(br)
+		if page != nil {
+			inHeader = inHeader && page.granule() == 0 && headers+len(page) <= maxOggHeaders
+			if inHeader {
+				headers += len(page)
+			}
+			s.broadcast(page, inHeader)
+		}
+
+		if ctx.Err() != nil || err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// idle clears the song that is playing and waits until there might be something to
+// play, or until ctx is done.
+func (s *Station) idle(ctx context.Context, wait time.Duration) {
+	s.mu.Lock()
+	s.current = nil
+	s.skip = nil
+	s.header = nil
+	s.mu.Unlock()
+
+	select {
+	case <-s.wake:
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+}
+
+// Run plays songs while there are listeners, until stop is closed.
+func (s *Station) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if stop != nil {
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		listeners := len(s.listeners)
+		s.mu.Unlock()
+		if listeners == 0 {
+			s.idle(ctx, retryWait)
+			continue
+		}
+
+		song, err := s.next()
+		if err != nil {
+			log.Errorf("Radio station '%s' could not choose a song: %v", s.Name, err)
+		}
+		if song == nil {
+			s.idle(ctx, retryWait)
+			continue
+		}
+
+		if err := s.play(ctx, song); err != nil {
+			log.Errorf("Radio station '%s' could not play '%s': %v", s.Name, song.Path, err)
+			s.idle(ctx, retryWait)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package radio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/transcoders"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// liveReader sends its data, then blocks until the song is skipped.
+type liveReader struct {
+	ctx  context.Context
+	data []byte
+}
+
+func (r *liveReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		<-r.ctx.Done()
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestICYWriter(t *testing.T) {
+	Convey("Metadata is inserted every MetaInt bytes, when the title changes.", t, func() {
+		title := "Artist - It's"
+		buf := &bytes.Buffer{}
+		w := NewICYWriter(buf, func() string { return title })
+
+		audio := bytes.Repeat([]byte{'a'}, MetaInt*2+10)
+		n, err := w.Write(audio)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, len(audio))
+
+		out := buf.Bytes()
+		block := metadata(title)
+		So(block[0], ShouldEqual, 2)
+		So(string(bytes.TrimRight(block[1:], "\x00")), ShouldEqual, "StreamTitle='Artist - It’s';")
+		So(out[MetaInt:MetaInt+len(block)], ShouldResemble, block)
+
+		// The same title is not sent again.
+		second := MetaInt + len(block) + MetaInt
+		So(out[second], ShouldEqual, 0)
+		So(len(out), ShouldEqual, len(audio)+len(block)+1)
+	})
+}
+
+func TestStation(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:radio?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	artist := &models.Artist{Name: "Artist"}
+	db.DB.Create(artist)
+	for _, name := range []string{"One", "Two"} {
+		db.DB.Create(&models.Song{Name: name, Mime: "audio/mpeg", Path: "/" + name + ".mp3", Artist: artist})
+	}
+
+	s := NewStation("office", nil, "")
+	s.encode = func(ctx context.Context, song *models.Song) (io.ReadCloser, error) {
+		return ioutil.NopCloser(&liveReader{ctx: ctx, data: []byte(strconv.Itoa(int(song.ID)))}), nil
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Run(stop)
+
+	Convey("Queued songs play before the shuffled ones.", t, func() {
+		s.Enqueue(2, 1)
+		l := s.Listen("")
+		defer s.Leave(l)
+
+		So(string(<-l.C), ShouldEqual, "2")
+		So(s.Title(), ShouldEqual, "Artist - Two")
+
+		current, queue, listeners := s.Status()
+		So(current.SongID, ShouldEqual, 2)
+		So(queue, ShouldResemble, []uint{1})
+		So(listeners, ShouldEqual, 1)
+
+		s.Skip()
+		So(string(<-l.C), ShouldEqual, "1")
+
+		s.Skip()
+		chunk := string(<-l.C)
+		So(chunk == "1" || chunk == "2", ShouldBeTrue)
+	})
+
+	Convey("Listeners that leave stop receiving.", t, func() {
+		l := s.Listen("")
+		s.Leave(l)
+		_, ok := <-l.C
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Only songs of libraries every listener can access are shuffled.", t, func() {
+		open := library.New(config.Library{Name: "open", Path: "/open"})
+		open.ID = 1
+		private := library.New(config.Library{Name: "private", Path: "/private", Users: []string{"admin"}})
+		private.ID = 2
+		library.Set(open, private)
+		defer library.Set()
+
+		ids := []uint{}
+		for _, l := range []*library.Library{open, private} {
+			song := &models.Song{Name: l.Name, Mime: "audio/mpeg", Path: l.Path + "/song.mp3"}
+			song.LibraryID.Set(int64(l.ID))
+			db.DB.Create(song)
+			ids = append(ids, song.ID)
+		}
+
+		station := NewStation("shared", nil, "")
+		admin := station.Listen("admin")
+		shuffled, err := station.shuffle()
+		So(err, ShouldBeNil)
+		So(shuffled, ShouldContain, ids[1])
+
+		guest := station.Listen("guest")
+		shuffled, err = station.shuffle()
+		So(err, ShouldBeNil)
+		So(shuffled, ShouldResemble, []uint{ids[0]})
+
+		// Songs shuffled before the guest tuned in are skipped.
+		station.shuffled = []uint{ids[1]}
+		song, err := station.next()
+		So(err, ShouldBeNil)
+		So(song.ID, ShouldEqual, ids[0])
+
+		// And so are songs that were queued.
+		station.Enqueue(ids[1])
+		station.shuffled = []uint{ids[0]}
+		song, err = station.next()
+		So(err, ShouldBeNil)
+		So(song.ID, ShouldEqual, ids[0])
+
+		station.Leave(guest)
+		station.Leave(admin)
+	})
+}
+
+// oggTestPage returns an Ogg page with a granule position and a payload.
+func oggTestPage(granule uint64, payload string) []byte {
+	page := make([]byte, oggHeaderSize, oggHeaderSize+1+len(payload))
+	copy(page, oggCapture)
+	binary.LittleEndian.PutUint64(page[6:14], granule)
+	page[26] = 1
+	page = append(page, byte(len(payload)))
+	return append(page, payload...)
+}
+
+func TestOggHeaders(t *testing.T) {
+	Convey("Listeners that tune in late get the headers of the song first.", t, func() {
+		headers := append(oggTestPage(0, "id"), oggTestPage(0, "setup")...)
+		stream := append(append([]byte{}, headers...), oggTestPage(960, "audio")...)
+		stream = append(stream, oggTestPage(1920, "more")...)
+
+		s := NewStation("ogg", &transcoders.Profile{Container: transcoders.ContainerOgg}, "")
+		s.encode = func(ctx context.Context, song *models.Song) (io.ReadCloser, error) {
+			return ioutil.NopCloser(&liveReader{ctx: ctx, data: stream}), nil
+		}
+
+		first := s.Listen("")
+		defer s.Leave(first)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- s.play(ctx, &models.Song{Name: "Song"})
+		}()
+
+		// Pages are sent whole.
+		So(<-first.C, ShouldResemble, oggTestPage(0, "id"))
+		So(<-first.C, ShouldResemble, oggTestPage(0, "setup"))
+		So(<-first.C, ShouldResemble, oggTestPage(960, "audio"))
+		So(<-first.C, ShouldResemble, oggTestPage(1920, "more"))
+
+		late := s.Listen("")
+		defer s.Leave(late)
+		So(<-late.C, ShouldResemble, headers)
+
+		cancel()
+		So(<-done, ShouldBeNil)
+	})
+
+	Convey("Streams that are not Ogg fail.", t, func() {
+		_, err := readOggPage(bufio.NewReader(strings.NewReader("ID3 not an ogg page at all")))
+		So(err, ShouldEqual, ErrOggPage)
+	})
+}
+
+func TestParseStationList(t *testing.T) {
+	Convey("M3U lists are read with their titles and logos.", t, func() {
+		list := "#EXTM3U\n" +
+			"#EXTINF:-1 tvg-logo=\"https://example.com/one.png\",Radio One\n" +
+			"http://one.example.com/stream\n" +
+			"\n" +
+			"https://two.example.com/live.mp3\n" +
+			"file:///etc/passwd\n"
+		stations, err := ParseStationList(strings.NewReader(list))
+		So(err, ShouldBeNil)
+		So(len(stations), ShouldEqual, 2)
+		So(stations[0].Name, ShouldEqual, "Radio One")
+		So(stations[0].LogoURL, ShouldEqual, "https://example.com/one.png")
+		So(stations[1].Name, ShouldEqual, "https://two.example.com/live.mp3")
+	})
+
+	Convey("PLS lists are read in the order of their numbers.", t, func() {
+		list := "[playlist]\nNumberOfEntries=2\nFile2=http://two.example.com/\nTitle2=Two\n" +
+			"File1=http://one.example.com/\nTitle1=One\nLength1=-1\nVersion=2\n"
+		stations, err := ParseStationList(strings.NewReader(list))
+		So(err, ShouldBeNil)
+		So(len(stations), ShouldEqual, 2)
+		So(stations[0].Name, ShouldEqual, "One")
+		So(stations[1].StreamURL, ShouldEqual, "http://two.example.com/")
+	})
+
+	Convey("Lists without streams are refused.", t, func() {
+		_, err := ParseStationList(strings.NewReader("#EXTM3U\n/music/song.mp3\n"))
+		So(err, ShouldEqual, ErrNoStations)
+	})
+}
+
+func TestProxyClient(t *testing.T) {
+	Convey("Only public addresses are public.", t, func() {
+		for _, ip := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.20.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0", "::ffff:127.0.0.1", "64:ff9b::a00:1", "fec0::1"} {
+			So(IsPublicIP(net.ParseIP(ip)), ShouldBeFalse)
+		}
+
+		for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888", "172.32.0.1"} {
+			So(IsPublicIP(net.ParseIP(ip)), ShouldBeTrue)
+		}
+	})
+
+	Convey("SHOUTcast v1 status lines are read as HTTP/1.0.", t, func() {
+		client, server := net.Pipe()
+		defer client.Close()
+		go func() {
+			server.Write([]byte("ICY 200 OK\r\nicy-name: Upstream\r\n\r\naudio"))
+			server.Close()
+		}()
+
+		res, err := http.ReadResponse(bufio.NewReader(&icyConn{Conn: client}), nil)
+		So(err, ShouldBeNil)
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+		So(res.Header.Get("icy-name"), ShouldEqual, "Upstream")
+		body, _ := ioutil.ReadAll(res.Body)
+		So(string(body), ShouldEqual, "audio")
+	})
+
+	Convey("The proxy doesn't connect to the server itself.", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		_, err := NewProxyClient().Get(server.URL)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, ErrPrivateAddress.Error())
+	})
+}
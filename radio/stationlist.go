@@ -0,0 +1,159 @@
+package radio
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoStations is returned for station lists without streams.
+var ErrNoStations = errors.New("The list has no streams")
+
+// ListedStation is a stream in an M3U or PLS station list.
+type ListedStation struct {
+	Name      string
+	StreamURL string
+	LogoURL   string
+}
+
+// IsStreamURL returns whether s is an absolute http or https URL.
+func IsStreamURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) != 0
+}
+
+// ParseStationList reads an M3U or PLS list of streams. Entries that are not http or
+// https URLs are skipped. Streams without a title are named after their address.
+func ParseStationList(r io.Reader) ([]*ListedStation, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if len(line) != 0 {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var listed []*ListedStation
+	if len(lines) != 0 && strings.EqualFold(lines[0], "[playlist]") {
+		listed = parsePLS(lines[1:])
+	} else {
+		listed = parseM3U(lines)
+	}
+
+	stations := []*ListedStation{}
+	for _, s := range listed {
+		if !IsStreamURL(s.StreamURL) {
+			continue
+		}
+
+		if len(s.Name) == 0 {
+			s.Name = s.StreamURL
+		}
+		stations = append(stations, s)
+	}
+
+	if len(stations) == 0 {
+		return nil, ErrNoStations
+	}
+
+	return stations, nil
+}
+
+// parseM3U reads '#EXTINF:<duration> <attributes>,<title>' lines followed by the URL.
+// The logo comes from the 'tvg-logo' attribute.
+func parseM3U(lines []string) []*ListedStation {
+	stations := []*ListedStation{}
+	next := &ListedStation{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXTINF:") {
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			if i := strings.LastIndex(info, ","); i >= 0 {
+				next.Name = strings.TrimSpace(info[i+1:])
+				info = info[:i]
+			}
+			next.LogoURL = attribute(info, "tvg-logo")
+			continue
+		} else if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		next.StreamURL = line
+		stations = append(stations, next)
+		next = &ListedStation{}
+	}
+
+	return stations
+}
+
+// attribute returns the value of name="value" in s.
+func attribute(s string, name string) string {
+	i := strings.Index(s, name+`="`)
+	if i < 0 {
+		return ""
+	}
+
+	value := s[i+len(name)+2:]
+	if end := strings.Index(value, `"`); end >= 0 {
+		return value[:end]
+	}
+
+	return ""
+}
+
+// parsePLS reads 'FileN=' and 'TitleN=' keys, in the order of N.
+func parsePLS(lines []string) []*ListedStation {
+	byNumber := map[int]*ListedStation{}
+	numbers := []int{}
+	for _, line := range lines {
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+
+		key, value := strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])
+		var field string
+		switch {
+		case strings.HasPrefix(key, "file"):
+			field = "file"
+		case strings.HasPrefix(key, "title"):
+			field = "title"
+		default:
+			continue
+		}
+
+		n, err := strconv.Atoi(key[len(field):])
+		if err != nil {
+			continue
+		}
+
+		s, ok := byNumber[n]
+		if !ok {
+			s = &ListedStation{}
+			byNumber[n] = s
+			numbers = append(numbers, n)
+		}
+
+		if field == "file" {
+			s.StreamURL = value
+		} else {
+			s.Name = value
+		}
+	}
+
+	sort.Ints(numbers)
+
+	stations := []*ListedStation{}
+	for _, n := range numbers {
+		stations = append(stations, byNumber[n])
+	}
+
+	return stations
+}
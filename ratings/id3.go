@@ -0,0 +1,180 @@
+package ratings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// email identifies the POPM frame written by cadenzr.
+	email = "cadenzr"
+	// fmpsDescription is the description of the TXXX frame with the FMPS rating.
+	fmpsDescription = "FMPS_Rating"
+	// padding is left after the frames of a new tag, so players can change it in place.
+	padding = 1024
+)
+
+func syncsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
+
+func putSyncsafe(b []byte, n int) {
+	b[0] = byte(n >> 21 & 0x7f)
+	b[1] = byte(n >> 14 & 0x7f)
+	b[2] = byte(n >> 7 & 0x7f)
+	b[3] = byte(n & 0x7f)
+}
+
+// id3Frame is a frame of an ID3v2.3 or ID3v2.4 tag.
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// ours returns whether the frame holds a rating that Write replaces.
+func (f *id3Frame) ours() bool {
+	switch f.id {
+	case "POPM":
+		i := bytes.IndexByte(f.data, 0)
+		return i >= 0 && string(f.data[:i]) == email
+	case "TXXX":
+		if len(f.data) < 2 {
+			return false
+		}
+		// Only descriptions in ISO-8859-1 or UTF-8 are recognized, like FMPS_Rating is written.
+		if f.data[0] != 0 && f.data[0] != 3 {
+			return false
+		}
+		i := bytes.IndexByte(f.data[1:], 0)
+		return i >= 0 && strings.EqualFold(string(f.data[1:1+i]), fmpsDescription)
+	}
+
+	return false
+}
+
+// readID3 returns the version and frames of the ID3v2 tag at the start of r, and the size
+// of the tag. A file without a tag has version 0. Tags with unsynchronisation or extended
+// headers are not supported.
+func readID3(r io.Reader) (version byte, frames []*id3Frame, size int, err error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, nil, 0, nil
+	} else if err != nil {
+		return 0, nil, 0, err
+	}
+
+	if string(header[:3]) != "ID3" {
+		return 0, nil, 0, nil
+	}
+
+	version = header[3]
+	if (version != 3 && version != 4) || header[5]&0xd0 != 0 {
+		return 0, nil, 0, ErrUnsupported
+	}
+
+	size = 10 + syncsafe(header[6:10])
+	body := make([]byte, size-10)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, 0, err
+	}
+
+	for pos := 0; pos+10 <= len(body) && body[pos] != 0; {
+		frameSize := int(binary.BigEndian.Uint32(body[pos+4 : pos+8]))
+		if version == 4 {
+			frameSize = syncsafe(body[pos+4 : pos+8])
+		}
+
+		end := pos + 10 + frameSize
+		if frameSize < 0 || end > len(body) {
+			return 0, nil, 0, ErrUnsupported
+		}
+
+		frames = append(frames, &id3Frame{
+			id:    string(body[pos : pos+4]),
+			flags: [2]byte{body[pos+8], body[pos+9]},
+			data:  body[pos+10 : end],
+		})
+		pos = end
+	}
+
+	return version, frames, size, nil
+}
+
+// ratingFrames returns the frames that hold a rating.
+func ratingFrames(rating int) []*id3Frame {
+	if rating == 0 {
+		return nil
+	}
+
+	txxx := append([]byte{0}, fmpsDescription...)
+	txxx = append(txxx, 0)
+	txxx = append(txxx, fmps(rating)...)
+
+	return []*id3Frame{
+		{id: "POPM", data: append([]byte(email+"\x00"), popmRatings[rating])},
+		{id: "TXXX", data: txxx},
+	}
+}
+
+// writeID3 copies the MP3 file at path to w with the rating in its ID3v2 tag. A tag is
+// added if the file has none.
+func writeID3(path string, w io.Writer, rating int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	version, frames, size, err := readID3(f)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		version = 3
+	}
+
+	body := &bytes.Buffer{}
+	for _, frame := range frames {
+		if !frame.ours() {
+			writeFrame(body, version, frame)
+		}
+	}
+	for _, frame := range ratingFrames(rating) {
+		writeFrame(body, version, frame)
+	}
+	body.Write(make([]byte, padding))
+
+	header := []byte{'I', 'D', '3', version, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(header[6:], body.Len())
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := body.WriteTo(w); err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(int64(size), io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func writeFrame(w *bytes.Buffer, version byte, frame *id3Frame) {
+	header := make([]byte, 10)
+	copy(header, frame.id)
+	if version == 4 {
+		putSyncsafe(header[4:8], len(frame.data))
+	} else {
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(frame.data)))
+	}
+	header[8], header[9] = frame.flags[0], frame.flags[1]
+
+	w.Write(header)
+	w.Write(frame.data)
+}
@@ -0,0 +1,108 @@
+// Package ratings writes the ratings of songs into their tags, so other players see them:
+// a POPM frame and an FMPS_Rating frame in ID3v2 tags, an FMPS_RATING tag in other formats.
+package ratings
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MaxRating is the highest rating, ratings go from 1 to MaxRating. 0 is no rating.
+const MaxRating = 5
+
+// ErrUnsupported is returned for files whose tags can't be written.
+var ErrUnsupported = errors.New("Ratings can't be written to this file")
+
+// popmRatings are the POPM values of ratings, as most players read them.
+var popmRatings = [MaxRating + 1]byte{0, 1, 64, 128, 196, 255}
+
+// ffmpegFormats are the formats ffmpeg writes for the extensions of files with tags
+// that aren't ID3, and the flags needed to write an FMPS_RATING tag.
+var ffmpegFormats = map[string][]string{
+	".flac": {"-f", "flac"},
+	".ogg":  {"-f", "ogg"},
+	".oga":  {"-f", "ogg"},
+	".opus": {"-f", "opus"},
+	".m4a":  {"-f", "ipod", "-movflags", "use_metadata_tags"},
+	".mp4":  {"-f", "mp4", "-movflags", "use_metadata_tags"},
+}
+
+// fmps returns the FMPS value of a rating, from 0 to 1.
+func fmps(rating int) string {
+	return strconv.FormatFloat(float64(rating)/MaxRating, 'f', -1, 64)
+}
+
+// Write sets the rating in the tags of a file. A rating of 0 removes it. The file is
+// replaced, readers that have it open keep reading the old file.
+func Write(path string, rating int) error {
+	if rating < 0 || rating > MaxRating {
+		return errors.New("Rating must be between 0 and " + strconv.Itoa(MaxRating))
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".mp3" {
+		return replace(path, func(tmp *os.File) error {
+			return writeID3(path, tmp, rating)
+		})
+	}
+
+	format, ok := ffmpegFormats[ext]
+	if !ok {
+		return ErrUnsupported
+	}
+
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return err
+	}
+
+	return replace(path, func(tmp *os.File) error {
+		value := ""
+		if rating > 0 {
+			value = fmps(rating)
+		}
+
+		args := []string{"-v", "error", "-y", "-i", path, "-map", "0", "-c", "copy", "-map_metadata", "0", "-metadata", "FMPS_RATING=" + value}
+		args = append(args, format...)
+		args = append(args, tmp.Name())
+
+		if out, err := exec.Command(ffmpeg, args...).CombinedOutput(); err != nil {
+			return errors.New("ffmpeg failed: " + strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+}
+
+// replace writes a new version of a file next to it with write, then renames it over
+// the file. The name of the new file has no audio extension so scans skip it.
+func replace(path string, write func(tmp *os.File) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".rating-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
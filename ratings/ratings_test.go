@@ -0,0 +1,79 @@
+package ratings
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dhowden/tag"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// audio stands in for the MPEG frames after the tag.
+var audio = []byte("\xff\xfbaudio")
+
+// id3File returns an MP3 file with an ID3v2.3 tag that has a title.
+func id3File() []byte {
+	frames := &bytes.Buffer{}
+	writeFrame(frames, 3, &id3Frame{id: "TIT2", data: []byte("\x00Title")})
+
+	header := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(header[6:], frames.Len())
+
+	return append(append(header, frames.Bytes()...), audio...)
+}
+
+func TestWrite(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ratings")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "song.mp3")
+
+	read := func() tag.Metadata {
+		f, err := os.Open(path)
+		So(err, ShouldBeNil)
+		defer f.Close()
+
+		m, err := tag.ReadFrom(f)
+		So(err, ShouldBeNil)
+		return m
+	}
+
+	Convey("Ratings are written into ID3 tags.", t, func() {
+		So(ioutil.WriteFile(path, id3File(), 0644), ShouldBeNil)
+
+		So(Write(path, 4), ShouldBeNil)
+		m := read()
+		So(m.Title(), ShouldEqual, "Title")
+		So(m.Raw()["POPM"], ShouldResemble, []byte("cadenzr\x00\xc4"))
+		So(m.Raw()["TXXX"].(*tag.Comm).Text, ShouldEqual, "0.8")
+
+		// The rating is replaced, not added.
+		So(Write(path, 5), ShouldBeNil)
+		m = read()
+		So(m.Raw()["POPM"], ShouldResemble, []byte("cadenzr\x00\xff"))
+		So(m.Raw()["TXXX_0"], ShouldBeNil)
+
+		raw, _ := ioutil.ReadFile(path)
+		So(bytes.HasSuffix(raw, audio), ShouldBeTrue)
+
+		So(Write(path, 0), ShouldBeNil)
+		m = read()
+		So(m.Raw()["POPM"], ShouldBeNil)
+		So(m.Title(), ShouldEqual, "Title")
+	})
+
+	Convey("A tag is added to files without one.", t, func() {
+		So(ioutil.WriteFile(path, audio, 0644), ShouldBeNil)
+
+		So(Write(path, 1), ShouldBeNil)
+		So(read().Raw()["POPM"], ShouldResemble, []byte("cadenzr\x00\x01"))
+	})
+
+	Convey("Invalid ratings and unknown formats are refused.", t, func() {
+		So(Write(path, 6), ShouldNotBeNil)
+		So(Write(filepath.Join(dir, "song.wav"), 3), ShouldEqual, ErrUnsupported)
+	})
+}
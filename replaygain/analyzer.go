@@ -0,0 +1,199 @@
+package replaygain
+
+import (
+	"os/exec"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// analyzeBatchSize is the number of songs loaded from the database at once.
+const analyzeBatchSize = 50
+
+// Analyzer measures the songs without a track gain and computes the album gains that
+// are missing, in the background.
+type Analyzer struct {
+	trigger chan struct{}
+
+	// analyze measures a file. It is replaced in tests.
+	analyze func(path string) (*Result, error)
+	// failed are the songs that could not be measured. They are tried again after a restart.
+	failed map[uint]bool
+}
+
+// NewAnalyzer returns an analyzer that uses ffmpeg. It fails if ffmpeg isn't installed.
+func NewAnalyzer() (*Analyzer, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+
+	return newAnalyzer(func(path string) (*Result, error) {
+		return Analyze(ffmpeg, path)
+	}), nil
+}
+
+func newAnalyzer(analyze func(path string) (*Result, error)) *Analyzer {
+	return &Analyzer{
+		trigger: make(chan struct{}, 1),
+		analyze: analyze,
+		failed:  map[uint]bool{},
+	}
+}
+
+// Trigger makes the analyzer look for songs to measure. It does not block.
+func (a *Analyzer) Trigger() {
+	select {
+	case a.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run analyzes the library when it starts and again after songs are added, until stop is closed.
+func (a *Analyzer) Run(stop <-chan struct{}) {
+	go a.watch(stop)
+
+	a.Trigger()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-a.trigger:
+			a.Pass(stop)
+		}
+	}
+}
+
+// watch triggers the analyzer for every added song.
+func (a *Analyzer) watch(stop <-chan struct{}) {
+	for {
+		s := events.Default.Subscribe(0, 0, events.SongAdded)
+		for open := true; open; {
+			select {
+			case <-stop:
+				events.Default.Unsubscribe(s)
+				return
+			case _, open = <-s.C:
+				a.Trigger()
+			}
+		}
+		// The bus drops subscribers that fall behind. Songs may have been added meanwhile.
+	}
+}
+
+// Pass measures all songs without a track gain, then computes the missing album gains.
+func (a *Analyzer) Pass(stop <-chan struct{}) {
+	measured := 0
+	for {
+		songs := []*models.Song{}
+		query := db.DB.Where("track_gain IS NULL").Order("id").Limit(analyzeBatchSize)
+		if len(a.failed) != 0 {
+			ids := []uint{}
+			for id := range a.failed {
+				ids = append(ids, id)
+			}
+			query = query.Where("id NOT IN (?)", ids)
+		}
+
+		if gormDB := query.Find(&songs); gormDB.Error != nil {
+			log.Errorf("Could not load songs to analyze: %v", gormDB.Error)
+			return
+		}
+
+		if len(songs) == 0 {
+			break
+		}
+
+		for _, song := range songs {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if a.measure(song) {
+				measured++
+			}
+		}
+	}
+
+	if measured != 0 {
+		log.Infof("Measured the loudness of %d songs.", measured)
+	}
+
+	if err := UpdateAlbums(); err != nil {
+		log.Errorf("Could not update album gains: %v", err)
+	}
+}
+
+// measure stores the track gain of a song. It returns whether that succeeded.
+func (a *Analyzer) measure(song *models.Song) bool {
+	r, err := a.analyze(song.Path)
+	if err != nil {
+		log.WithFields(log.Fields{"reason": err.Error(), "file": song.Path}).Warn("Could not measure loudness.")
+		a.failed[song.ID] = true
+		return false
+	}
+
+	gormDB := db.DB.Model(song).UpdateColumns(map[string]interface{}{
+		"track_gain": r.Gain(),
+		"track_peak": r.Peak,
+		"loudness":   r.Loudness,
+	})
+	if gormDB.Error != nil {
+		log.Errorf("Could not store loudness of song '%d': %v", song.ID, gormDB.Error)
+		a.failed[song.ID] = true
+		return false
+	}
+
+	return true
+}
+
+// UpdateAlbums computes the gain of albums without one whose songs all have a loudness.
+func UpdateAlbums() error {
+	albums := []*models.Album{}
+	gormDB := db.DB.Where("gain IS NULL").Preload("Songs").Find(&albums)
+	if gormDB.Error != nil {
+		return gormDB.Error
+	}
+
+	for _, album := range albums {
+		if len(album.Songs) == 0 {
+			continue
+		}
+
+		loudness := []float64{}
+		durations := []float64{}
+		peak := 0.0
+		for _, song := range album.Songs {
+			if !song.Loudness.Valid {
+				break
+			}
+
+			loudness = append(loudness, song.Loudness.Float64)
+			durations = append(durations, song.Duration.Float64)
+			if song.TrackPeak.Float64 > peak {
+				peak = song.TrackPeak.Float64
+			}
+		}
+
+		if len(loudness) != len(album.Songs) {
+			continue
+		}
+
+		values := map[string]interface{}{
+			"gain": GainFromLoudness(AlbumLoudness(loudness, durations)),
+		}
+		if peak > 0 {
+			values["peak"] = peak
+		}
+
+		if gormDB := db.DB.Model(album).UpdateColumns(values); gormDB.Error != nil {
+			return gormDB.Error
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+// Package replaygain computes the ReplayGain values of songs and albums from their
+// EBU R128 loudness, measured with ffmpeg's ebur128 filter.
+package replaygain
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// Reference is the loudness in LUFS that ReplayGain 2.0 gains bring songs to.
+const Reference = -18.0
+
+// ErrNoMeasurement is returned when ffmpeg did not print a loudness summary.
+var ErrNoMeasurement = errors.New("No loudness measurement in ffmpeg output")
+
+// Result is the measured loudness of a song.
+type Result struct {
+	// Loudness is the integrated loudness in LUFS.
+	Loudness float64
+	// Peak is the true peak, 1 is full scale.
+	Peak float64
+}
+
+// Gain returns the ReplayGain gain in dB.
+func (r *Result) Gain() float64 {
+	return GainFromLoudness(r.Loudness)
+}
+
+// GainFromLoudness returns the gain in dB that brings a loudness in LUFS to Reference.
+func GainFromLoudness(loudness float64) float64 {
+	return Reference - loudness
+}
+
+// LoudnessFromGain is the inverse of GainFromLoudness.
+func LoudnessFromGain(gain float64) float64 {
+	return Reference - gain
+}
+
+// minimumLevel is the level of silence, the lowest loudness ffmpeg measures.
+const minimumLevel = -70.0
+
+var (
+	integrated = regexp.MustCompile(`\sI:\s+(-?[\d.]+|-inf) LUFS`)
+	truePeak   = regexp.MustCompile(`\sPeak:\s+(-?[\d.]+|-inf) dBFS`)
+)
+
+// parseLevel parses a level printed by ffmpeg, "-inf" is returned as the quietest level.
+func parseLevel(s string) (float64, error) {
+	if s == "-inf" {
+		return minimumLevel, nil
+	}
+
+	return strconv.ParseFloat(s, 64)
+}
+
+// ParseEBUR128 returns the measurement in the summary that ffmpeg's ebur128 filter
+// prints to stderr when it is done.
+func ParseEBUR128(output []byte) (*Result, error) {
+	// The same values are printed for every frame before the summary.
+	i := bytes.LastIndex(output, []byte("Summary:"))
+	if i < 0 {
+		return nil, ErrNoMeasurement
+	}
+	output = output[i:]
+
+	match := integrated.FindSubmatch(output)
+	if match == nil {
+		return nil, ErrNoMeasurement
+	}
+
+	r := &Result{}
+	var err error
+	if r.Loudness, err = parseLevel(string(match[1])); err != nil {
+		return nil, err
+	}
+
+	// The peak is only printed with peak=true.
+	if match := truePeak.FindSubmatch(output); match != nil {
+		db, err := parseLevel(string(match[1]))
+		if err != nil {
+			return nil, err
+		}
+		if db > minimumLevel {
+			r.Peak = math.Pow(10, db/20)
+		}
+	}
+
+	return r, nil
+}
+
+// Analyze measures the loudness and true peak of a file with ffmpeg.
+func Analyze(ffmpeg string, path string) (*Result, error) {
+	cmd := exec.Command(ffmpeg,
+		"-hide_banner",
+		"-nostats",
+		"-i", path,
+		"-vn",
+		"-filter_complex", "ebur128=peak=true",
+		"-f", "null",
+		"-",
+	)
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return ParseEBUR128(stderr.Bytes())
+}
+
+// AlbumLoudness returns the loudness of songs played after each other, given their
+// loudness in LUFS and durations. Louder and longer songs count more, as if the
+// album was measured as a whole.
+func AlbumLoudness(loudness []float64, durations []float64) float64 {
+	energy := 0.0
+	total := 0.0
+	for i := range loudness {
+		d := 1.0
+		if i < len(durations) && durations[i] > 0 {
+			d = durations[i]
+		}
+
+		energy += d * math.Pow(10, loudness[i]/10)
+		total += d
+	}
+
+	if total == 0 || energy == 0 {
+		return minimumLevel
+	}
+
+	return 10 * math.Log10(energy/total)
+}
+
+// SongGain returns the gain in dB to play a song with for a ReplayGain mode from
+// the configuration. The album gain falls back to the track gain when the album has
+// none, song.Album must be loaded for it. The gain is lowered so the peak does not clip.
+// The second return value is false if there is no gain to apply.
+func SongGain(song *models.Song, mode string) (float64, bool) {
+	var gain, peak models.NullFloat64
+	switch mode {
+	case config.ReplayGainAlbum:
+		if song.Album != nil && song.Album.Gain.Valid {
+			gain, peak = song.Album.Gain, song.Album.Peak
+		} else {
+			gain, peak = song.TrackGain, song.TrackPeak
+		}
+	case config.ReplayGainTrack:
+		gain, peak = song.TrackGain, song.TrackPeak
+	default:
+		return 0, false
+	}
+
+	if !gain.Valid {
+		return 0, false
+	}
+
+	g := gain.Float64
+	if peak.Valid && peak.Float64 > 0 {
+		if limit := -20 * math.Log10(peak.Float64); g > limit {
+			g = limit
+		}
+	}
+
+	return g, true
+}
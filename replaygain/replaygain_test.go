@@ -0,0 +1,125 @@
+package replaygain
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const ebur128Output = `Input #0, mp3, from 'song.mp3':
+[Parsed_ebur128_0 @ 0x55d5c0] t: 0.1       TARGET:-23 LUFS    M:-120.7 S:-120.7     I: -70.0 LUFS       LRA:   0.0 LU  FTPK: -inf dBFS  TPK: -inf dBFS
+[Parsed_ebur128_0 @ 0x55d5c0] Summary:
+
+  Integrated loudness:
+    I:         -12.4 LUFS
+    Threshold: -22.6 LUFS
+
+  Loudness range:
+    LRA:         4.9 LU
+    Threshold: -32.5 LUFS
+    LRA low:   -15.6 LUFS
+    LRA high:  -10.7 LUFS
+
+  True peak:
+    Peak:        0.8 dBFS
+`
+
+func TestParseEBUR128(t *testing.T) {
+	Convey("The summary of the ebur128 filter is parsed.", t, func() {
+		r, err := ParseEBUR128([]byte(ebur128Output))
+		So(err, ShouldBeNil)
+		So(r.Loudness, ShouldEqual, -12.4)
+		So(r.Gain(), ShouldAlmostEqual, -5.6)
+		So(r.Peak, ShouldAlmostEqual, math.Pow(10, 0.04))
+	})
+
+	Convey("Output without a summary is an error.", t, func() {
+		_, err := ParseEBUR128([]byte("Invalid data found when processing input"))
+		So(err, ShouldEqual, ErrNoMeasurement)
+	})
+}
+
+func TestGains(t *testing.T) {
+	Convey("Album loudness is weighted by duration.", t, func() {
+		So(AlbumLoudness([]float64{-10, -10}, []float64{100, 300}), ShouldAlmostEqual, -10)
+		// A silent song that is as long as a loud one lowers the loudness by 3 dB.
+		So(AlbumLoudness([]float64{-10, -70}, []float64{100, 100}), ShouldAlmostEqual, -13.01, 0.01)
+	})
+
+	Convey("Song gains depend on the mode and don't clip.", t, func() {
+		song := &models.Song{}
+		song.TrackGain.Set(-3)
+		song.Album = &models.Album{}
+		song.Album.Gain.Set(6)
+		song.Album.Peak.Set(0.5)
+
+		_, ok := SongGain(song, config.ReplayGainOff)
+		So(ok, ShouldBeFalse)
+
+		gain, ok := SongGain(song, config.ReplayGainTrack)
+		So(ok, ShouldBeTrue)
+		So(gain, ShouldEqual, -3)
+
+		// A peak of 0.5 can only be raised by about 6.02 dB.
+		song.Album.Gain.Set(10)
+		gain, _ = SongGain(song, config.ReplayGainAlbum)
+		So(gain, ShouldAlmostEqual, 6.02, 0.01)
+
+		song.Album = nil
+		gain, _ = SongGain(song, config.ReplayGainAlbum)
+		So(gain, ShouldEqual, -3)
+	})
+}
+
+func TestAnalyzer(t *testing.T) {
+	Convey("Songs without gain are measured and album gains computed.", t, func() {
+		So(db.SetupConnection(db.SQLITE, "file:replaygain?mode=memory&cache=shared"), ShouldBeNil)
+		defer db.Shutdown()
+		So(db.SetupSchema(), ShouldBeNil)
+
+		album := &models.Album{Name: "Album"}
+		So(db.DB.Create(album).Error, ShouldBeNil)
+
+		tagged := &models.Song{Name: "Tagged", Mime: "audio/mpeg", Path: "tagged.mp3"}
+		tagged.AlbumID.Set(int64(album.ID))
+		tagged.TrackGain.Set(-2)
+		tagged.Loudness.Set(-16)
+		untagged := &models.Song{Name: "Untagged", Mime: "audio/mpeg", Path: "untagged.mp3"}
+		untagged.AlbumID.Set(int64(album.ID))
+		broken := &models.Song{Name: "Broken", Mime: "audio/mpeg", Path: "broken.mp3"}
+		for _, song := range []*models.Song{tagged, untagged, broken} {
+			So(db.DB.Create(song).Error, ShouldBeNil)
+		}
+
+		analyzed := []string{}
+		a := newAnalyzer(func(path string) (*Result, error) {
+			analyzed = append(analyzed, path)
+			if path == "broken.mp3" {
+				return nil, errors.New("broken")
+			}
+			return &Result{Loudness: -16, Peak: 0.9}, nil
+		})
+		a.Pass(nil)
+		// Songs that failed are not tried again.
+		a.Pass(nil)
+
+		So(analyzed, ShouldResemble, []string{"untagged.mp3", "broken.mp3"})
+
+		stored := &models.Song{}
+		So(db.DB.First(stored, untagged.ID).Error, ShouldBeNil)
+		So(stored.TrackGain.Float64, ShouldEqual, -2)
+		So(stored.TrackPeak.Float64, ShouldEqual, 0.9)
+
+		storedAlbum := &models.Album{}
+		So(db.DB.First(storedAlbum, album.ID).Error, ShouldBeNil)
+		So(storedAlbum.Gain.Valid, ShouldBeTrue)
+		So(storedAlbum.Gain.Float64, ShouldAlmostEqual, -2)
+		So(storedAlbum.Peak.Float64, ShouldEqual, 0.9)
+	})
+}
@@ -0,0 +1,427 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/jinzhu/gorm"
+)
+
+// Sidecar files in artist folders.
+const (
+	artistImageName = "artist"
+	artistNFOFile   = "artist.nfo"
+	artistBioFile   = "bio.txt"
+)
+
+// maxBiographySize is the size in bytes of the largest biography file that is read.
+const maxBiographySize = 1 << 20
+
+// ArtistInfo is what the sidecar files in an artist folder tell about the artist.
+type ArtistInfo struct {
+	Image     *models.Image
+	Biography string
+	Links     []*models.ArtistLink
+}
+
+// artistNFO is the part of a Kodi style artist.nfo that is used.
+type artistNFO struct {
+	XMLName       xml.Name `xml:"artist"`
+	Biography     string   `xml:"biography"`
+	MusicBrainzID string   `xml:"musicBrainzArtistID"`
+	URLs          []string `xml:"url"`
+}
+
+// newLink returns a link named after its host, or nil if s is not a web address.
+func newLink(s string) *models.ArtistLink {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil
+	}
+
+	return &models.ArtistLink{
+		Name: strings.TrimPrefix(u.Host, "www."),
+		URL:  u.String(),
+	}
+}
+
+// parseBiography splits a plain text biography into the text and the lines that are
+// only a web address.
+func parseBiography(raw []byte) (string, []*models.ArtistLink) {
+	text := []string{}
+	links := []*models.ArtistLink{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64*1024), maxBiographySize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if link := newLink(line); link != nil {
+			links = append(links, link)
+			continue
+		}
+
+		text = append(text, line)
+	}
+
+	return strings.TrimSpace(strings.Join(text, "\n")), links
+}
+
+// parseNFO reads an artist.nfo. Files that are not XML are read as plain text.
+func parseNFO(raw []byte) (string, []*models.ArtistLink) {
+	nfo := &artistNFO{}
+	if err := xml.Unmarshal(raw, nfo); err != nil {
+		return parseBiography(raw)
+	}
+
+	links := []*models.ArtistLink{}
+	if id := strings.TrimSpace(nfo.MusicBrainzID); len(id) != 0 {
+		links = append(links, &models.ArtistLink{
+			Name: "MusicBrainz",
+			URL:  "https://musicbrainz.org/artist/" + url.PathEscape(id),
+		})
+	}
+
+	for _, u := range nfo.URLs {
+		if link := newLink(u); link != nil {
+			links = append(links, link)
+		}
+	}
+
+	return strings.TrimSpace(nfo.Biography), links
+}
+
+// readSidecar returns the content of a small file in dir, or nil if it doesn't exist.
+func readSidecar(dir string, name string) []byte {
+	path := filepath.Join(dir, name)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	if len(raw) > maxBiographySize {
+		log.WithFields(log.Fields{"file": path}).Warn("Artist biography is too large.")
+		return nil
+	}
+
+	return raw
+}
+
+// ReadArtistInfo reads the sidecar files in an artist folder: an image named 'artist'
+// and a biography with links in artist.nfo or bio.txt. Returns nil if there are none.
+func ReadArtistInfo(dir string) *ArtistInfo {
+	info := &ArtistInfo{}
+
+	if path := FolderCover(dir, []string{artistImageName}); len(path) != 0 {
+		info.Image = loadCover(path)
+	}
+
+	if raw := readSidecar(dir, artistNFOFile); raw != nil {
+		info.Biography, info.Links = parseNFO(raw)
+	}
+
+	if len(info.Biography) == 0 {
+		if raw := readSidecar(dir, artistBioFile); raw != nil {
+			var links []*models.ArtistLink
+			info.Biography, links = parseBiography(raw)
+			info.Links = append(info.Links, links...)
+		}
+	}
+
+	if info.Image == nil && len(info.Biography) == 0 && len(info.Links) == 0 {
+		return nil
+	}
+
+	return info
+}
+
+// sidecarsModified returns when the artist sidecars in dir were last changed, the zero
+// time if there are none.
+func sidecarsModified(dir string) time.Time {
+	var modified time.Time
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return modified
+	}
+
+	for _, file := range files {
+		name := strings.ToLower(file.Name())
+		ext := filepath.Ext(name)
+		sidecar := name == artistNFOFile || name == artistBioFile ||
+			(strings.TrimSuffix(name, ext) == artistImageName && isImage(mime.TypeByExtension(ext)))
+		if sidecar && !file.IsDir() && file.ModTime().After(modified) {
+			modified = file.ModTime()
+		}
+	}
+
+	return modified
+}
+
+// artistFolder is a folder that was looked up for an artist.
+type artistFolder struct {
+	dir    string
+	artist uint
+}
+
+// visitedFolders remembers the folders that were looked up for artists, with the time
+// their sidecars were changed. Artists whose folder had nothing to add are left
+// incomplete, so later scans only look them up again when the sidecars change.
+type visitedFolders struct {
+	mu      sync.Mutex
+	folders map[artistFolder]time.Time
+}
+
+// unchanged returns whether folder was looked up before with sidecars changed at modified.
+func (v *visitedFolders) unchanged(folder artistFolder, modified time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	visited, ok := v.folders[folder]
+	return ok && visited.Equal(modified)
+}
+
+func (v *visitedFolders) add(folders map[artistFolder]time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for folder, modified := range folders {
+		v.folders[folder] = modified
+	}
+}
+
+// artistFoldersVisited are the folders looked up by the scans since the server started.
+var artistFoldersVisited = &visitedFolders{folders: map[artistFolder]time.Time{}}
+
+// artistFolders finds the artist sidecars of songs after a scan. Each directory is
+// only read once.
+type artistFolders struct {
+	library  *library.Library
+	cache    *dirCache
+	owners   *dirCache
+	modified *dirCache
+
+	// visited skips the folders that were looked up by earlier scans when it is set,
+	// looked collects the folders that are looked up now.
+	visited *visitedFolders
+	looked  map[artistFolder]time.Time
+}
+
+func newArtistFolders(lib *library.Library) *artistFolders {
+	return &artistFolders{
+		library:  lib,
+		cache:    newDirCache(),
+		owners:   newDirCache(),
+		modified: newDirCache(),
+		looked:   map[artistFolder]time.Time{},
+	}
+}
+
+// owner returns the artist of all songs in dir and below, 0 if they are by several
+// artists or not all have one.
+func (a *artistFolders) owner(dir string) uint {
+	id, _ := a.owners.get(dir, func() interface{} {
+		ids := []sql.NullInt64{}
+		prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+		if gormDB := db.DB.Model(&models.Song{}).Where("path LIKE ?", prefix+"%").Pluck("DISTINCT artist_id", &ids); gormDB.Error != nil {
+			log.WithFields(log.Fields{"reason": gormDB.Error.Error(), "directory": dir}).Error("Could not get artists of folder.")
+			return uint(0)
+		}
+
+		if len(ids) != 1 || !ids[0].Valid {
+			return uint(0)
+		}
+		return uint(ids[0].Int64)
+	}).(uint)
+
+	return id
+}
+
+// find returns the artist info for a song by artist in dir. Songs are expected in
+// 'Artist/Album/' or directly in 'Artist/', so dir and its parent are tried. The
+// library root is never an artist folder, and neither is a folder with songs of other
+// artists, e.g. a compilation.
+func (a *artistFolders) find(dir string, artist uint) *ArtistInfo {
+	for _, candidate := range []string{dir, filepath.Dir(dir)} {
+		if a.library != nil && (!a.library.Contains(candidate) || filepath.Clean(candidate) == a.library.Path) {
+			continue
+		}
+
+		// Folders without sidecars have nothing to add, the songs in them are not looked up.
+		modified := a.modified.get(candidate, func() interface{} {
+			return sidecarsModified(candidate)
+		}).(time.Time)
+		if modified.IsZero() {
+			continue
+		}
+
+		folder := artistFolder{dir: candidate, artist: artist}
+		if a.visited != nil && a.visited.unchanged(folder, modified) {
+			continue
+		}
+		a.looked[folder] = modified
+
+		if a.owner(candidate) != artist {
+			continue
+		}
+
+		info, _ := a.cache.get(candidate, func() interface{} {
+			return ReadArtistInfo(candidate)
+		}).(*ArtistInfo)
+
+		if info != nil {
+			// Every song gets its own copy of the image, the writer fills in the database fields.
+			found := *info
+			if info.Image != nil {
+				image := *info.Image
+				found.Image = &image
+			}
+			return &found
+		}
+	}
+
+	return nil
+}
+
+// storeImage returns image from the database, inserting it if it is new.
+func storeImage(tx *gorm.DB, image *models.Image) *models.Image {
+	gormDB := tx.Table("images").Where("hash = ?", image.Hash).First(image)
+	if gormDB.RecordNotFound() {
+		gormDB = tx.Create(image)
+	}
+
+	if gormDB.Error != nil {
+		log.WithFields(log.Fields{"reason": gormDB.Error.Error(), "file": image.Path}).Error("Could not get or insert image.")
+		return nil
+	}
+
+	return image
+}
+
+// updateArtist fills in the image, biography and links of an artist that are not known yet.
+func updateArtist(tx *gorm.DB, artist *models.Artist, info *ArtistInfo) error {
+	updates := map[string]interface{}{}
+	if !artist.ImageID.Valid && info.Image != nil {
+		if image := storeImage(tx, info.Image); image != nil {
+			updates["image_id"] = image.ID
+			artist.ImageID.Set(int64(image.ID))
+		}
+	}
+
+	if !artist.Biography.Valid && len(info.Biography) != 0 {
+		updates["biography"] = info.Biography
+		artist.Biography.Set(info.Biography)
+	}
+
+	if len(updates) != 0 {
+		if gormDB := tx.Model(artist).UpdateColumns(updates); gormDB.Error != nil {
+			return gormDB.Error
+		}
+	}
+
+	if len(info.Links) == 0 {
+		return nil
+	}
+
+	count := 0
+	if gormDB := tx.Model(&models.ArtistLink{}).Where("artist_id = ?", artist.ID).Count(&count); gormDB.Error != nil {
+		return gormDB.Error
+	}
+	if count != 0 {
+		return nil
+	}
+
+	for _, link := range info.Links {
+		if gormDB := tx.Create(&models.ArtistLink{ArtistID: artist.ID, Name: link.Name, URL: link.URL}); gormDB.Error != nil {
+			return gormDB.Error
+		}
+	}
+
+	return nil
+}
+
+// updateArtists fills in the image, biography and links of the artists of the songs
+// under mediaDir from their artist folders, also for songs that were scanned before the
+// sidecars were added. Only artists that miss an image or biography are looked up, in
+// the folders whose sidecars changed since the last scan. It returns the number of
+// artists that were updated.
+func updateArtists(ctx context.Context, lib *library.Library, mediaDir string) (int, error) {
+	songs := []*models.Song{}
+	prefix := strings.TrimSuffix(mediaDir, string(filepath.Separator)) + string(filepath.Separator)
+	gormDB := db.DB.Where("(path = ? OR path LIKE ?) AND artist_id IN (SELECT id FROM artists WHERE deleted_at IS NULL AND (image_id IS NULL OR biography IS NULL))", mediaDir, prefix+"%").
+		Order("path").Find(&songs)
+	if gormDB.Error != nil {
+		return 0, gormDB.Error
+	}
+
+	folders := newArtistFolders(lib)
+	folders.visited = artistFoldersVisited
+	done := map[uint]bool{}
+	updated := 0
+	for _, song := range songs {
+		if ctx.Err() != nil {
+			return updated, ctx.Err()
+		}
+
+		id := uint(song.ArtistID.Int64)
+		if done[id] {
+			continue
+		}
+
+		info := folders.find(filepath.Dir(song.Path), id)
+		if info == nil {
+			continue
+		}
+		done[id] = true
+
+		stored, err := storeArtistInfo(id, info)
+		if err != nil {
+			return updated, err
+		}
+		if stored {
+			updated++
+		}
+	}
+
+	artistFoldersVisited.add(folders.looked)
+	return updated, nil
+}
+
+// storeArtistInfo fills in what an artist misses from info. It returns whether info
+// had anything to add.
+func storeArtistInfo(id uint, info *ArtistInfo) (bool, error) {
+	tx := db.DB.Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	artist := &models.Artist{}
+	if gormDB := tx.First(artist, "id = ?", id); gormDB.Error != nil {
+		tx.Rollback()
+		return false, gormDB.Error
+	}
+
+	if (artist.ImageID.Valid || info.Image == nil) && (artist.Biography.Valid || len(info.Biography) == 0) {
+		tx.Rollback()
+		return false, nil
+	}
+
+	if err := updateArtist(tx, artist, info); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit().Error
+}
@@ -0,0 +1,185 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseArtistSidecars(t *testing.T) {
+	Convey("Biographies are read from Kodi style nfo files.", t, func() {
+		bio, links := parseNFO([]byte(`<?xml version="1.0"?>
+<artist>
+	<name>Band</name>
+	<biography>  A band.  </biography>
+	<musicBrainzArtistID>1234-abcd</musicBrainzArtistID>
+	<url>https://www.band.example/</url>
+	<url>not a link</url>
+</artist>`))
+
+		So(bio, ShouldEqual, "A band.")
+		So(len(links), ShouldEqual, 2)
+		So(links[0].Name, ShouldEqual, "MusicBrainz")
+		So(links[0].URL, ShouldEqual, "https://musicbrainz.org/artist/1234-abcd")
+		So(links[1].Name, ShouldEqual, "band.example")
+	})
+
+	Convey("Lines with only a link are taken out of plain text biographies.", t, func() {
+		bio, links := parseBiography([]byte("A band\r\nfrom somewhere.\n\nhttp://en.wikipedia.org/wiki/Band\n"))
+		So(bio, ShouldEqual, "A band\nfrom somewhere.")
+		So(len(links), ShouldEqual, 1)
+		So(links[0].URL, ShouldEqual, "http://en.wikipedia.org/wiki/Band")
+
+		// An nfo that is not XML is plain text.
+		bio, _ = parseNFO([]byte("Just text."))
+		So(bio, ShouldEqual, "Just text.")
+	})
+}
+
+func TestArtistFolders(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:scanartistfolders?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	artistDir := filepath.Join(dir, "Band")
+	albumDir := filepath.Join(artistDir, "Album")
+	os.MkdirAll(albumDir, 0755)
+	ioutil.WriteFile(filepath.Join(artistDir, "bio.txt"), []byte("A band."), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "bio.txt"), []byte("Not an artist."), 0644)
+
+	compilationDir := filepath.Join(dir, "Various", "Hits")
+	os.MkdirAll(compilationDir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Various", "bio.txt"), []byte("A label."), 0644)
+
+	// The songs were scanned before the sidecars were put next to them.
+	band := &models.Artist{Name: "Band"}
+	db.DB.Create(band)
+	singer := &models.Artist{Name: "Singer"}
+	db.DB.Create(singer)
+	for _, song := range []struct {
+		path   string
+		artist *models.Artist
+	}{
+		{filepath.Join(albumDir, "song.mp3"), band},
+		{filepath.Join(compilationDir, "band.mp3"), band},
+		{filepath.Join(compilationDir, "singer.mp3"), singer},
+	} {
+		s := &models.Song{Name: filepath.Base(song.path), Mime: "audio/mpeg", Path: song.path}
+		s.ArtistID.Set(int64(song.artist.ID))
+		db.DB.Create(s)
+	}
+
+	lib := library.New(config.Library{Name: "test", Path: dir})
+	artists := newArtistFolders(lib)
+
+	Convey("Artist info is found in the song's folder or its parent.", t, func() {
+		So(artists.find(albumDir, band.ID).Biography, ShouldEqual, "A band.")
+		So(artists.find(artistDir, band.ID).Biography, ShouldEqual, "A band.")
+	})
+
+	Convey("The library root is not an artist folder.", t, func() {
+		So(artists.find(dir, band.ID), ShouldBeNil)
+		So(ReadArtistInfo(albumDir), ShouldBeNil)
+	})
+
+	Convey("Folders with songs of several artists are not artist folders.", t, func() {
+		So(artists.find(compilationDir, band.ID), ShouldBeNil)
+		So(artists.find(compilationDir, singer.ID), ShouldBeNil)
+		So(artists.find(albumDir, singer.ID), ShouldBeNil)
+	})
+
+	Convey("Artists that were scanned before get the info in their folder.", t, func() {
+		updated, err := updateArtists(context.Background(), lib, dir)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 1)
+
+		stored := &models.Artist{}
+		db.DB.First(stored, band.ID)
+		So(stored.Biography.String, ShouldEqual, "A band.")
+		storedSinger := &models.Artist{}
+		db.DB.First(storedSinger, singer.ID)
+		So(storedSinger.Biography.Valid, ShouldBeFalse)
+
+		// Artists that have their info are not looked up again.
+		updated, err = updateArtists(context.Background(), lib, dir)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 0)
+	})
+
+	Convey("Artist folders are only read again when their sidecars change.", t, func() {
+		singerDir := filepath.Join(dir, "Singer")
+		os.MkdirAll(singerDir, 0755)
+		s := &models.Song{Name: "solo.mp3", Mime: "audio/mpeg", Path: filepath.Join(singerDir, "solo.mp3")}
+		s.ArtistID.Set(int64(singer.ID))
+		db.DB.Create(s)
+
+		bio := filepath.Join(singerDir, "bio.txt")
+		ioutil.WriteFile(bio, []byte(""), 0644)
+		updated, err := updateArtists(context.Background(), lib, dir)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 0)
+
+		stat, _ := os.Stat(bio)
+		modified := stat.ModTime()
+		ioutil.WriteFile(bio, []byte("A singer."), 0644)
+		os.Chtimes(bio, modified, modified)
+		updated, err = updateArtists(context.Background(), lib, dir)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 0)
+
+		modified = modified.Add(time.Second)
+		os.Chtimes(bio, modified, modified)
+		updated, err = updateArtists(context.Background(), lib, dir)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 1)
+
+		stored := &models.Artist{}
+		db.DB.First(stored, singer.ID)
+		So(stored.Biography.String, ShouldEqual, "A singer.")
+	})
+}
+
+func TestUpdateArtist(t *testing.T) {
+	Convey("Missing artist info is filled in.", t, func() {
+		So(db.SetupConnection(db.SQLITE, "file:scanartists?mode=memory&cache=shared"), ShouldBeNil)
+		defer db.Shutdown()
+		So(db.SetupSchema(), ShouldBeNil)
+
+		artist := &models.Artist{Name: "Band"}
+		artist.Biography.Set("Written by hand.")
+		db.DB.Create(artist)
+
+		info := &ArtistInfo{
+			Image:     &models.Image{Path: "images/a.jpg", Link: "/images/a.jpg", Mime: "image/jpeg", Hash: "a"},
+			Biography: "From a file.",
+			Links:     []*models.ArtistLink{{Name: "band.example", URL: "https://band.example"}},
+		}
+		So(updateArtist(db.DB, artist, info), ShouldBeNil)
+		// Links are only added once.
+		So(updateArtist(db.DB, artist, info), ShouldBeNil)
+
+		stored := &models.Artist{}
+		So(db.DB.Preload("Image").Preload("Links").First(stored, artist.ID).Error, ShouldBeNil)
+		So(stored.Biography.String, ShouldEqual, "Written by hand.")
+		So(stored.Image.Hash, ShouldEqual, "a")
+		So(len(stored.Links), ShouldEqual, 1)
+		So(stored.Links[0].URL, ShouldEqual, "https://band.example")
+	})
+}
@@ -0,0 +1,136 @@
+package scan
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/probers"
+)
+
+// isAudiobook returns whether the file at path is an audiobook: it is in an audiobook
+// library, below a directory named like one of the audiobook folders, or has one of the
+// audiobook genres. Folders are only looked for inside the library if lib is not nil.
+func isAudiobook(lib *library.Library, path string, genre string, conf config.Audiobooks) bool {
+	if lib != nil && lib.Audiobooks {
+		return true
+	}
+
+	for _, g := range conf.Genres {
+		if strings.EqualFold(strings.TrimSpace(genre), g) {
+			return true
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if lib != nil {
+		if rel, err := filepath.Rel(lib.Path, dir); err == nil {
+			dir = rel
+		}
+	}
+
+	for _, element := range strings.Split(filepath.ToSlash(dir), "/") {
+		for _, folder := range conf.Folders {
+			if strings.EqualFold(element, folder) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// newChapters returns the chapters of a song from the chapters of its file.
+func newChapters(chapters []probers.Chapter, duration float64) []*models.Chapter {
+	r := []*models.Chapter{}
+	for _, c := range probers.CompleteChapters(chapters, duration) {
+		r = append(r, &models.Chapter{Title: c.Title, Start: c.Start, End: c.End})
+	}
+
+	return r
+}
+
+// updateAudiobooks updates the songs under mediaDir that were scanned before, after the
+// audiobook configuration changed. Songs that become audiobooks get the chapters of
+// their file, and the song itself as book when it has no album.
+func updateAudiobooks(ctx context.Context, lib *library.Library, mediaDir string) (int, error) {
+	songs := []*models.Song{}
+	prefix := strings.TrimSuffix(mediaDir, string(filepath.Separator)) + string(filepath.Separator)
+	if gormDB := db.DB.Where("path = ? OR path LIKE ?", mediaDir, prefix+"%").Find(&songs); gormDB.Error != nil {
+		return 0, gormDB.Error
+	}
+
+	updated := 0
+	for _, song := range songs {
+		if ctx.Err() != nil {
+			return updated, ctx.Err()
+		}
+
+		audiobook := isAudiobook(lib, song.Path, song.Genre.String, config.Config.Audiobooks)
+		if audiobook == song.Audiobook {
+			continue
+		}
+
+		var chapters []*models.Chapter
+		if audiobook {
+			meta, err := probers.ProbeAudioFile(song.Path)
+			if err != nil {
+				log.WithFields(log.Fields{"reason": err.Error(), "file": song.Path}).Warn("Could not read chapters of audiobook.")
+			} else {
+				chapters = newChapters(meta.Chapters, song.Duration.Float64)
+			}
+		}
+
+		if err := storeAudiobook(song, audiobook, chapters); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+// storeAudiobook stores whether a song is an audiobook, with its chapters.
+func storeAudiobook(song *models.Song, audiobook bool, chapters []*models.Chapter) error {
+	tx := db.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	values := map[string]interface{}{"audiobook": audiobook}
+	if audiobook && !song.AlbumID.Valid {
+		album := &models.Album{Name: song.Name, Year: song.Year}
+		if gormDB := tx.FirstOrCreate(album, "name = ?", album.Name); gormDB.Error != nil {
+			tx.Rollback()
+			return gormDB.Error
+		}
+		values["album_id"] = album.ID
+	}
+
+	if gormDB := tx.Model(song).UpdateColumns(values); gormDB.Error != nil {
+		tx.Rollback()
+		return gormDB.Error
+	}
+
+	if audiobook {
+		if gormDB := tx.Unscoped().Where("song_id = ?", song.ID).Delete(&models.Chapter{}); gormDB.Error != nil {
+			tx.Rollback()
+			return gormDB.Error
+		}
+
+		for _, chapter := range chapters {
+			chapter.SongID = song.ID
+			if gormDB := tx.Create(chapter); gormDB.Error != nil {
+				tx.Rollback()
+				return gormDB.Error
+			}
+		}
+	}
+
+	return tx.Commit().Error
+}
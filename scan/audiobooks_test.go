@@ -0,0 +1,114 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/probers"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIsAudiobook(t *testing.T) {
+	conf := config.Audiobooks{Genres: []string{"Audiobook"}, Folders: []string{"Audiobooks"}}
+	music := library.New(config.Library{Name: "music", Path: "/music"})
+	books := library.New(config.Library{Name: "books", Path: "/books", Audiobooks: true})
+
+	Convey("Files in audiobook libraries are audiobooks.", t, func() {
+		So(isAudiobook(books, "/books/Author/Book.mp3", "Pop", conf), ShouldBeTrue)
+		So(isAudiobook(music, "/music/Artist/Song.mp3", "Pop", conf), ShouldBeFalse)
+	})
+
+	Convey("Audiobooks are found by genre without case.", t, func() {
+		So(isAudiobook(music, "/music/Author/Book.m4b", " audiobook", conf), ShouldBeTrue)
+	})
+
+	Convey("Audiobooks are found by folder inside the library.", t, func() {
+		So(isAudiobook(music, "/music/audiobooks/Author/Book.mp3", "", conf), ShouldBeTrue)
+		So(isAudiobook(library.New(config.Library{Name: "old", Path: "/Audiobooks/music"}), "/Audiobooks/music/Song.mp3", "", conf), ShouldBeFalse)
+		So(isAudiobook(nil, "/Audiobooks/Book.mp3", "", conf), ShouldBeTrue)
+	})
+
+	Convey("Chapters without an end run until the next one.", t, func() {
+		chapters := newChapters([]probers.Chapter{{Start: 0}, {Title: "Two", Start: 10}}, 25)
+		So(chapters[0].Title, ShouldEqual, "Chapter 1")
+		So(chapters[0].End, ShouldEqual, 10)
+		So(chapters[1].End, ShouldEqual, 25)
+	})
+}
+
+func TestUpdateAudiobooks(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:scanaudiobooks?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	probers.Initialize()
+	conf := config.Config.Audiobooks
+	defer func() { config.Config.Audiobooks = conf }()
+	config.Config.Audiobooks = config.Audiobooks{}
+
+	path := filepath.Join(dir, "Book.mp3")
+	ioutil.WriteFile(path, []byte("ID3"), 0644)
+
+	// The song was scanned before audiobooks were detected.
+	song := &models.Song{Name: "Book", Mime: "audio/mpeg", Path: path}
+	song.Genre.Set("Audiobook")
+	song.Duration.Set(120)
+	db.DB.Create(song)
+
+	Convey("Songs scanned before are left alone while the configuration is the same.", t, func() {
+		_, err := Scan(context.Background(), nil, dir, Options{Workers: 1})
+		So(err, ShouldBeNil)
+
+		stored := &models.Song{}
+		db.DB.First(stored, song.ID)
+		So(stored.Audiobook, ShouldBeFalse)
+	})
+
+	Convey("Songs become audiobooks after the configuration changed.", t, func() {
+		config.Config.Audiobooks.Genres = []string{"Audiobook"}
+		_, err := Scan(context.Background(), nil, dir, Options{Workers: 1})
+		So(err, ShouldBeNil)
+
+		stored := &models.Song{}
+		db.DB.Preload("Album").First(stored, song.ID)
+		So(stored.Audiobook, ShouldBeTrue)
+		So(stored.Album, ShouldNotBeNil)
+		So(stored.Album.Name, ShouldEqual, "Book")
+	})
+
+	Convey("Chapters of audiobooks replace the ones stored before.", t, func() {
+		for _, titles := range [][]string{{"Old"}, {"One", "Two"}} {
+			chapters := []probers.Chapter{}
+			for i, title := range titles {
+				chapters = append(chapters, probers.Chapter{Title: title, Start: float64(i * 60)})
+			}
+			So(storeAudiobook(song, true, newChapters(chapters, 120)), ShouldBeNil)
+		}
+
+		chapters := []*models.Chapter{}
+		db.DB.Where("song_id = ?", song.ID).Order("start").Find(&chapters)
+		So(len(chapters), ShouldEqual, 2)
+		So(chapters[0].Title, ShouldEqual, "One")
+		So(chapters[1].End, ShouldEqual, 120)
+
+		So(storeAudiobook(song, false, nil), ShouldBeNil)
+		stored := &models.Song{}
+		db.DB.First(stored, song.ID)
+		So(stored.Audiobook, ShouldBeFalse)
+	})
+}
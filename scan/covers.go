@@ -0,0 +1,235 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// maxFolderCoverSize is the size in bytes of the largest image that is used as a folder cover.
+const maxFolderCoverSize = 20 << 20
+
+// FolderCover returns the image in dir that is used as cover for the songs in it, or
+// an empty string. The image is found by its name without extension, ignoring case.
+// Earlier names are preferred.
+func FolderCover(dir string, names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.WithFields(log.Fields{"reason": err.Error(), "directory": dir}).Debug("Could not read directory for cover.")
+		return ""
+	}
+
+	found := ""
+	foundRank := len(names)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		ext := filepath.Ext(file.Name())
+		if !isImage(mime.TypeByExtension(ext)) {
+			continue
+		}
+
+		base := strings.ToLower(strings.TrimSuffix(file.Name(), ext))
+		for rank, name := range names[:foundRank] {
+			if base == strings.ToLower(name) {
+				found = filepath.Join(dir, file.Name())
+				foundRank = rank
+				break
+			}
+		}
+	}
+
+	return found
+}
+
+// dirCache remembers a value per directory that is loaded once during a scan.
+type dirCache struct {
+	mu   sync.Mutex
+	dirs map[string]*dirValue
+}
+
+type dirValue struct {
+	once  sync.Once
+	value interface{}
+}
+
+func newDirCache() *dirCache {
+	return &dirCache{
+		dirs: map[string]*dirValue{},
+	}
+}
+
+// get returns the value for dir, calling load if it is the first time dir is asked for.
+// Concurrent calls for the same directory wait for the first one.
+func (c *dirCache) get(dir string, load func() interface{}) interface{} {
+	c.mu.Lock()
+	v, ok := c.dirs[dir]
+	if !ok {
+		v = &dirValue{}
+		c.dirs[dir] = v
+	}
+	c.mu.Unlock()
+
+	v.once.Do(func() {
+		v.value = load()
+	})
+
+	return v.value
+}
+
+// folderCovers finds the covers next to songs during a scan. Each directory is only
+// read once.
+type folderCovers struct {
+	names        []string
+	preferFolder bool
+	cache        *dirCache
+}
+
+func newFolderCovers() *folderCovers {
+	return &folderCovers{
+		names:        config.Config.CoverNames,
+		preferFolder: config.Config.CoverPreference != config.CoverEmbedded,
+		cache:        newDirCache(),
+	}
+}
+
+// find returns the folder cover for the songs in dir, or nil.
+// The returned image is not yet in the database.
+func (f *folderCovers) find(dir string) *models.Image {
+	if len(f.names) == 0 {
+		return nil
+	}
+
+	cover, _ := f.cache.get(dir, func() interface{} {
+		if path := FolderCover(dir, f.names); len(path) != 0 {
+			return loadCover(path)
+		}
+		return (*models.Image)(nil)
+	}).(*models.Image)
+
+	if cover == nil {
+		return nil
+	}
+
+	// Every song gets its own copy, the writer fills in the database fields.
+	image := *cover
+	return &image
+}
+
+// choose returns the cover of a song given its embedded picture and its folder cover.
+func (f *folderCovers) choose(embedded []byte, dir string) *models.Image {
+	var cover *models.Image
+	if f.preferFolder {
+		cover = f.find(dir)
+	}
+
+	if cover == nil && embedded != nil {
+		cover = storeCover(embedded)
+	}
+
+	if cover == nil && !f.preferFolder {
+		cover = f.find(dir)
+	}
+
+	return cover
+}
+
+// loadCover stores an image file as cover. Returns nil if it can't be used.
+func loadCover(path string) *models.Image {
+	fh, err := os.Open(path)
+	if err != nil {
+		log.WithFields(log.Fields{"reason": err.Error(), "file": path}).Warn("Could not open folder cover.")
+		return nil
+	}
+	defer fh.Close()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(fh, maxFolderCoverSize+1))
+	if err != nil {
+		log.WithFields(log.Fields{"reason": err.Error(), "file": path}).Warn("Could not read folder cover.")
+		return nil
+	}
+
+	if len(buf) > maxFolderCoverSize {
+		log.WithFields(log.Fields{"file": path}).Warn("Folder cover is too large.")
+		return nil
+	}
+
+	cover := storeCover(buf)
+	if cover == nil {
+		log.WithFields(log.Fields{"file": path}).Warn("Folder cover is not an image.")
+	}
+
+	return cover
+}
+
+// updateCovers gives the songs under mediaDir without a cover the cover of their folder,
+// so songs that were scanned before a cover was put next to them get it. Afterwards
+// albums without a cover get the cover of one of their songs. It returns the number of
+// songs that got a cover.
+func updateCovers(ctx context.Context, mediaDir string) (int, error) {
+	covers := newFolderCovers()
+
+	songs := []*models.Song{}
+	prefix := strings.TrimSuffix(mediaDir, string(filepath.Separator)) + string(filepath.Separator)
+	if gormDB := db.DB.Where("(path = ? OR path LIKE ?) AND cover_id IS NULL", mediaDir, prefix+"%").Find(&songs); gormDB.Error != nil {
+		return 0, gormDB.Error
+	}
+
+	updated := 0
+	for _, song := range songs {
+		if ctx.Err() != nil {
+			return updated, ctx.Err()
+		}
+
+		cover := covers.find(filepath.Dir(song.Path))
+		if cover == nil {
+			continue
+		}
+
+		if err := storeSongCover(song, cover); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	gormDB := db.DB.Exec("UPDATE albums SET cover_id = (SELECT songs.cover_id FROM songs WHERE songs.album_id = albums.id AND songs.cover_id IS NOT NULL AND songs.deleted_at IS NULL ORDER BY songs.path LIMIT 1) " +
+		"WHERE cover_id IS NULL AND EXISTS (SELECT 1 FROM songs WHERE songs.album_id = albums.id AND songs.cover_id IS NOT NULL AND songs.deleted_at IS NULL)")
+	return updated, gormDB.Error
+}
+
+// storeSongCover stores the cover of a song that had none.
+func storeSongCover(song *models.Song, cover *models.Image) error {
+	tx := db.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if cover = storeImage(tx, cover); cover == nil {
+		tx.Rollback()
+		return errors.New("Could not store cover of '" + song.Path + "'")
+	}
+
+	if gormDB := tx.Model(song).UpdateColumn("cover_id", cover.ID); gormDB.Error != nil {
+		tx.Rollback()
+		return gormDB.Error
+	}
+
+	return tx.Commit().Error
+}
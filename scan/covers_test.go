@@ -0,0 +1,146 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func pngImage(size int) []byte {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewGray(image.Rect(0, 0, size, size)))
+	return buf.Bytes()
+}
+
+func TestFolderCover(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"Front.JPG", "folder.png", "cover.txt", "back.jpg"} {
+		ioutil.WriteFile(filepath.Join(dir, name), pngImage(1), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "cover.jpg"), 0755)
+
+	Convey("Folder covers are found by name in order of preference.", t, func() {
+		So(FolderCover(dir, []string{"cover", "folder", "front"}), ShouldEqual, filepath.Join(dir, "folder.png"))
+		So(FolderCover(dir, []string{"FRONT", "folder"}), ShouldEqual, filepath.Join(dir, "Front.JPG"))
+		So(FolderCover(dir, []string{"cover"}), ShouldEqual, "")
+		So(FolderCover(dir, nil), ShouldEqual, "")
+	})
+}
+
+func TestFolderCoversChoose(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	// Covers are stored in the images directory of the working directory.
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	os.Mkdir("images", 0755)
+
+	album := filepath.Join(dir, "album")
+	os.Mkdir(album, 0755)
+	folder := pngImage(2)
+	ioutil.WriteFile(filepath.Join(album, "cover.png"), folder, 0644)
+	embedded := pngImage(3)
+
+	Convey("The folder cover is used over embedded pictures by default.", t, func() {
+		covers := &folderCovers{names: []string{"cover"}, preferFolder: true, cache: newDirCache()}
+
+		cover := covers.choose(embedded, album)
+		So(cover, ShouldNotBeNil)
+		stored, _ := ioutil.ReadFile(cover.Path)
+		So(stored, ShouldResemble, folder)
+
+		// Songs get their own copy of the same image.
+		other := covers.choose(nil, album)
+		So(other.Hash, ShouldEqual, cover.Hash)
+		So(other, ShouldNotPointTo, cover)
+	})
+
+	Convey("Embedded pictures can be preferred.", t, func() {
+		covers := &folderCovers{names: []string{"cover"}, cache: newDirCache()}
+
+		cover := covers.choose(embedded, album)
+		stored, _ := ioutil.ReadFile(cover.Path)
+		So(stored, ShouldResemble, embedded)
+
+		cover = covers.choose(nil, album)
+		stored, _ = ioutil.ReadFile(cover.Path)
+		So(stored, ShouldResemble, folder)
+	})
+
+	Convey("Songs without any cover have none.", t, func() {
+		covers := &folderCovers{names: []string{"cover"}, preferFolder: true, cache: newDirCache()}
+		So(covers.choose(nil, dir), ShouldBeNil)
+	})
+}
+
+func TestUpdateCovers(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:scancovers?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	os.Mkdir("images", 0755)
+
+	names := config.Config.CoverNames
+	defer func() { config.Config.CoverNames = names }()
+	config.Config.CoverNames = []string{"cover"}
+
+	// The songs were scanned before the cover was put next to them.
+	albumDir := filepath.Join(dir, "album")
+	os.Mkdir(albumDir, 0755)
+	album := &models.Album{Name: "Album"}
+	db.DB.Create(album)
+	song := &models.Song{Name: "Song", Mime: "audio/mpeg", Path: filepath.Join(albumDir, "song.mp3")}
+	song.AlbumID.Set(int64(album.ID))
+	db.DB.Create(song)
+	bare := &models.Song{Name: "Bare", Mime: "audio/mpeg", Path: filepath.Join(dir, "bare.mp3")}
+	db.DB.Create(bare)
+
+	ioutil.WriteFile(filepath.Join(albumDir, "cover.png"), pngImage(4), 0644)
+
+	Convey("Songs without a cover get the cover of their folder, and so do their albums.", t, func() {
+		updated, err := updateCovers(context.Background(), dir)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 1)
+
+		stored := &models.Song{}
+		db.DB.First(stored, song.ID)
+		So(stored.CoverID.Valid, ShouldBeTrue)
+		storedBare := &models.Song{}
+		db.DB.First(storedBare, bare.ID)
+		So(storedBare.CoverID.Valid, ShouldBeFalse)
+
+		storedAlbum := &models.Album{}
+		db.DB.First(storedAlbum, album.ID)
+		So(storedAlbum.CoverID, ShouldResemble, stored.CoverID)
+
+		// Nothing changes the second time.
+		updated, err = updateCovers(context.Background(), dir)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,265 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/probers"
+)
+
+// Ingest statuses.
+const (
+	IngestAdded     = "added"
+	IngestDuplicate = "duplicate"
+	IngestRejected  = "rejected"
+)
+
+// Ingested is the outcome of ingesting a file.
+type Ingested struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	SongID uint   `json:"song_id,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func rejected(name string, reason string) *Ingested {
+	return &Ingested{
+		Name:   name,
+		Status: IngestRejected,
+		Reason: reason,
+	}
+}
+
+// ingestMu makes sure two copies of the same file can't both pass the duplicate check.
+var ingestMu sync.Mutex
+
+// Ingest adds a file that is not yet in a library, e.g. an upload, to lib.
+//
+// name is the original name of the file, its extension decides the file type. The
+// content has to match that type and must not be in the database already. The file is
+// moved to "Artist/Album/NN Title.ext" in lib and added to the database. The staged
+// file is always moved or removed.
+func Ingest(ctx context.Context, lib *library.Library, staged string, name string) *Ingested {
+	ingestMu.Lock()
+	defer ingestMu.Unlock()
+
+	result := ingest(ctx, lib, staged, name)
+	if result.Status != IngestAdded {
+		os.Remove(staged)
+	}
+
+	log.WithFields(log.Fields{"name": name, "status": result.Status, "reason": result.Reason, "song": result.SongID}).Info("Ingested file.")
+	return result
+}
+
+func ingest(ctx context.Context, lib *library.Library, staged string, name string) *Ingested {
+	ext := strings.ToLower(filepath.Ext(name))
+	mimeType := probers.CanonicalMime(mime.TypeByExtension(ext))
+	if !probers.HasProber(mimeType) {
+		return rejected(name, "Unsupported file type")
+	}
+
+	sniffed, err := probers.SniffAudioFile(staged)
+	if err != nil {
+		return rejected(name, err.Error())
+	}
+	if sniffed != mimeType {
+		return rejected(name, "Content is not "+mimeType)
+	}
+
+	hash, _, err := HashFile(staged)
+	if err != nil {
+		return rejected(name, err.Error())
+	}
+
+	existing := &models.Song{}
+	gormDB := db.DB.Where("hash = ?", hash).First(existing)
+	if gormDB.Error == nil {
+		return &Ingested{
+			Name:   name,
+			Status: IngestDuplicate,
+			SongID: existing.ID,
+		}
+	} else if !gormDB.RecordNotFound() {
+		return rejected(name, gormDB.Error.Error())
+	}
+
+	// The probers use the extension to pick a prober.
+	if filepath.Ext(staged) != ext {
+		if err := os.Rename(staged, staged+ext); err != nil {
+			return rejected(name, err.Error())
+		}
+		staged = staged + ext
+		defer os.Remove(staged)
+	}
+
+	meta, err := probers.ProbeAudioFile(staged)
+	if err != nil {
+		return rejected(name, err.Error())
+	}
+
+	destination, err := uniquePath(filepath.Join(lib.Path, Destination(meta, name)))
+	if err != nil {
+		return rejected(name, err.Error())
+	}
+
+	if !lib.Included(destination) {
+		return rejected(name, "Excluded by library '"+lib.Name+"'")
+	}
+
+	if err = os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return rejected(name, err.Error())
+	}
+
+	if err = moveFile(staged, destination); err != nil {
+		return rejected(name, err.Error())
+	}
+
+	var song *models.Song
+	stats, err := Scan(ctx, lib, destination, Options{
+		Workers:   1,
+		BatchSize: 1,
+		Added: func(s *models.Song) {
+			song = s
+		},
+	})
+
+	if song == nil {
+		os.Remove(destination)
+
+		reason := "Could not add song"
+		if err != nil {
+			reason = err.Error()
+		} else if len(stats.Errors) != 0 {
+			reason = stats.Errors[0].Reason
+		}
+
+		return rejected(name, reason)
+	}
+
+	return &Ingested{
+		Name:   name,
+		Status: IngestAdded,
+		SongID: song.ID,
+		Path:   destination,
+	}
+}
+
+// maxNameLength is the maximum number of characters of a directory or file name made from tags.
+const maxNameLength = 100
+
+// sanitize makes a tag usable as a single path element. Returns fallback if nothing is left.
+func sanitize(s string, fallback string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, s)
+
+	s = strings.Join(strings.Fields(s), " ")
+	// Leading dots make hidden files or '..', trailing dots are dropped by Windows.
+	s = strings.Trim(s, ". ")
+
+	if utf8.RuneCountInString(s) > maxNameLength {
+		s = strings.TrimSpace(string([]rune(s)[:maxNameLength]))
+	}
+
+	if len(s) == 0 {
+		return fallback
+	}
+
+	return s
+}
+
+// Destination returns where a file is stored in a library based on its tags:
+// "Artist/Album/NN Title.ext". name is the original file name.
+func Destination(meta *probers.AudioMeta, name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+
+	artist := meta.AlbumArtist
+	if len(strings.TrimSpace(artist)) == 0 {
+		artist = meta.Artist
+	}
+
+	file := sanitize(meta.Title, sanitize(base, "Unknown Title"))
+	if meta.Track > 0 {
+		file = fmt.Sprintf("%02d %s", meta.Track, file)
+	}
+
+	return filepath.Join(
+		sanitize(artist, "Unknown Artist"),
+		sanitize(meta.Album, "Unknown Album"),
+		file+ext,
+	)
+}
+
+// uniquePath returns path, or path with a number added if that file already exists.
+func uniquePath(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	for i := 1; i < 1000; i++ {
+		candidate := path
+		if i > 1 {
+			candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+		}
+
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("Too many files named '%s'", path)
+}
+
+// moveFile renames a file, or copies it when it has to move to another filesystem.
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	if err = out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
@@ -0,0 +1,98 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/probers"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDestination(t *testing.T) {
+
+	Convey("Destinations are made from tags", t, func() {
+		meta := &probers.AudioMeta{
+			Title:       "Curse the Day (Radio Edit)",
+			Artist:      "Brain Purist",
+			AlbumArtist: "",
+			Album:       "Curse the Day",
+			Track:       1,
+		}
+		So(Destination(meta, "upload.MP3"), ShouldEqual, filepath.Join("Brain Purist", "Curse the Day", "01 Curse the Day (Radio Edit).mp3"))
+
+		meta = &probers.AudioMeta{
+			Title:  "../../etc/passwd",
+			Artist: "AC/DC",
+			Album:  "..",
+		}
+		So(Destination(meta, "x.flac"), ShouldEqual, filepath.Join("AC_DC", "Unknown Album", "_.._etc_passwd.flac"))
+
+		So(Destination(&probers.AudioMeta{}, "../song.mp3"), ShouldEqual, filepath.Join("Unknown Artist", "Unknown Album", "song.mp3"))
+	})
+
+	Convey("Names are cleaned up", t, func() {
+		So(sanitize("  a\tb\x00c  ", "x"), ShouldEqual, "a bc")
+		So(sanitize("...", "x"), ShouldEqual, "x")
+		So(len(sanitize(string(make([]byte, 300, 300))+"a", "x")), ShouldEqual, 1)
+	})
+
+}
+
+func TestIngest(t *testing.T) {
+
+	Convey("Ingesting files", t, func() {
+		if err := db.SetupConnection(db.SQLITE, "file:scaningest?mode=memory&cache=shared"); err != nil {
+			So(err, ShouldBeNil)
+		}
+		defer db.Shutdown()
+
+		if err := db.SetupSchema(); err != nil {
+			So(err, ShouldBeNil)
+		}
+
+		probers.Initialize()
+
+		dir, err := ioutil.TempDir("", "cadenzr")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		lib := library.New(config.Library{Name: "test", Path: filepath.Join(dir, "library")})
+		staged := filepath.Join(dir, "staged")
+
+		Convey("Files that are not audio are rejected", func() {
+			So(ioutil.WriteFile(staged, []byte("<html></html>"), 0644), ShouldBeNil)
+
+			result := Ingest(context.Background(), lib, staged, "song.mp3")
+			So(result.Status, ShouldEqual, IngestRejected)
+			So(result.Reason, ShouldEqual, "Content is not audio/mpeg")
+
+			_, err := os.Stat(staged)
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			So(ioutil.WriteFile(staged, []byte("text"), 0644), ShouldBeNil)
+			So(Ingest(context.Background(), lib, staged, "notes.txt").Status, ShouldEqual, IngestRejected)
+		})
+
+		Convey("Files that are already in the library are duplicates", func() {
+			So(ioutil.WriteFile(staged, []byte("ID3 some mp3"), 0644), ShouldBeNil)
+			hash, _, err := HashFile(staged)
+			So(err, ShouldBeNil)
+
+			song := &models.Song{Name: "song", Mime: "audio/mpeg", Path: "elsewhere.mp3", Hash: hash}
+			So(db.DB.Create(song).Error, ShouldBeNil)
+
+			result := Ingest(context.Background(), lib, staged, "song.mp3")
+			So(result.Status, ShouldEqual, IngestDuplicate)
+			So(result.SongID, ShouldEqual, song.ID)
+		})
+	})
+
+}
@@ -0,0 +1,434 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/log"
+)
+
+// JobState is the state of a scan job.
+type JobState string
+
+// Scan job states.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobFinished  JobState = "finished"
+	JobCancelled JobState = "cancelled"
+	JobFailed    JobState = "failed"
+)
+
+// ErrInvalidPath is returned when a scan is requested for a path that is not in a library.
+var ErrInvalidPath = library.ErrInvalidPath
+
+// ErrLibraryNotFound is returned when a scan is requested for a library that does not exist.
+var ErrLibraryNotFound = errors.New("Library not found")
+
+// ErrJobNotFound is returned when a job does not exist.
+var ErrJobNotFound = errors.New("Scan job not found")
+
+// maxHistory is the number of finished jobs that are remembered.
+const maxHistory = 50
+
+// progressInterval is how often the progress of a running job is published.
+const progressInterval = time.Second
+
+// target is a directory to scan and the library it is in.
+type target struct {
+	library *library.Library
+	dir     string
+}
+
+// Job is a requested scan of (a part of) one or all libraries.
+type Job struct {
+	ID uint64
+	// Library is nil if all libraries are scanned.
+	Library *library.Library
+	// Path is relative to the library.
+	Path string
+	// Force removes the songs whose file is gone even when most of them are.
+	Force bool
+
+	targets []target
+
+	mu       sync.Mutex
+	state    JobState
+	err      error
+	queued   time.Time
+	started  time.Time
+	finished time.Time
+	progress *Progress
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// JobInfo is a snapshot of a job.
+type JobInfo struct {
+	ID       uint64      `json:"id"`
+	Library  *string     `json:"library"`
+	Path     string      `json:"path"`
+	Force    bool        `json:"force"`
+	State    JobState    `json:"state"`
+	Error    string      `json:"error,omitempty"`
+	Queued   time.Time   `json:"queued"`
+	Started  *time.Time  `json:"started"`
+	Finished *time.Time  `json:"finished"`
+	Seen     int         `json:"seen"`
+	Probed   int         `json:"probed"`
+	Added    int         `json:"added"`
+	Removed  int         `json:"removed"`
+	Failed   int         `json:"failed"`
+	Current  string      `json:"current"`
+	ETA      *float64    `json:"eta"`
+	Errors   []FileError `json:"errors"`
+}
+
+// Done is closed when the job has stopped.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Info returns a snapshot of the job.
+func (j *Job) Info() *JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	stats := j.progress.Stats()
+	info := &JobInfo{
+		ID:      j.ID,
+		Path:    j.Path,
+		Force:   j.Force,
+		State:   j.state,
+		Queued:  j.queued,
+		Seen:    stats.Seen,
+		Probed:  stats.Probed,
+		Added:   stats.Added,
+		Removed: stats.Removed,
+		Failed:  stats.Failed,
+		Errors:  stats.Errors,
+	}
+
+	if j.Library != nil {
+		name := j.Library.Name
+		info.Library = &name
+	}
+
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+
+	if !j.started.IsZero() {
+		started := j.started
+		info.Started = &started
+	}
+
+	if !j.finished.IsZero() {
+		finished := j.finished
+		info.Finished = &finished
+	}
+
+	if j.state == JobRunning {
+		info.Current = j.progress.Current()
+		info.ETA = eta(stats, j.progress.Walked(), time.Since(j.started))
+	}
+
+	return info
+}
+
+// eta estimates the remaining seconds of a scan.
+// Returns nil while the total number of files is unknown or nothing has been done yet.
+func eta(stats Stats, walked bool, elapsed time.Duration) *float64 {
+	done := stats.Probed + stats.Failed
+	if !walked || done == 0 {
+		return nil
+	}
+
+	remaining := float64(stats.Seen-done) * elapsed.Seconds() / float64(done)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &remaining
+}
+
+// Manager runs scan jobs one at a time and remembers finished jobs.
+type Manager struct {
+	opts func() Options
+
+	mu      sync.Mutex
+	nextID  uint64
+	current *Job
+	queue   []*Job
+	history []*Job
+}
+
+// NewManager creates a manager that scans with the default options.
+func NewManager() *Manager {
+	return &Manager{
+		opts:   DefaultOptions,
+		nextID: 1,
+	}
+}
+
+// Jobs is the manager used by the API.
+var Jobs = NewManager()
+
+// targets returns what to scan for a library ID and a path relative to that library.
+// Library ID 0 means all libraries, in which case subpath must be empty.
+func targets(libraryID uint, subpath string) (*library.Library, []target, error) {
+	if libraryID == 0 {
+		if len(subpath) != 0 {
+			return nil, nil, ErrInvalidPath
+		}
+
+		all := []target{}
+		for _, l := range library.All() {
+			all = append(all, target{library: l, dir: l.Path})
+		}
+
+		return nil, all, nil
+	}
+
+	l := library.Get(libraryID)
+	if l == nil {
+		return nil, nil, ErrLibraryNotFound
+	}
+
+	dir, err := l.Resolve(subpath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l, []target{{library: l, dir: dir}}, nil
+}
+
+// sameScan returns whether a job scans the same as a request.
+func (j *Job) sameScan(l *library.Library, subpath string, force bool) bool {
+	return j.Library == l && j.Path == subpath && j.Force == force
+}
+
+// Start queues a scan of subpath in a library. Library ID 0 scans all libraries.
+// If the same scan is already waiting or running that job is returned instead.
+// With force the songs whose file is gone are removed even when most of them are.
+func (m *Manager) Start(libraryID uint, subpath string, force bool) (*Job, error) {
+	l, targets, err := targets(libraryID, subpath)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != nil && m.current.sameScan(l, subpath, force) {
+		return m.current, nil
+	}
+
+	for _, job := range m.queue {
+		if job.sameScan(l, subpath, force) {
+			return job, nil
+		}
+	}
+
+	job := &Job{
+		ID:       m.nextID,
+		Library:  l,
+		Path:     subpath,
+		Force:    force,
+		targets:  targets,
+		state:    JobQueued,
+		queued:   time.Now(),
+		progress: &Progress{},
+		done:     make(chan struct{}),
+	}
+	m.nextID++
+	m.queue = append(m.queue, job)
+
+	log.WithFields(log.Fields{"id": job.ID, "path": job.Path}).Info("Scan queued.")
+
+	if m.current == nil {
+		m.runNext()
+	}
+
+	return job, nil
+}
+
+// runNext starts the first queued job. m.mu must be held.
+func (m *Manager) runNext() {
+	if len(m.queue) == 0 {
+		m.current = nil
+		return
+	}
+
+	job := m.queue[0]
+	m.queue = m.queue[1:]
+	m.current = job
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job.mu.Lock()
+	job.state = JobRunning
+	job.started = time.Now()
+	job.cancel = cancel
+	job.mu.Unlock()
+
+	opts := m.opts()
+	opts.Progress = job.progress
+	opts.Force = job.Force
+
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				publishProgress(job)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		var err error
+		for _, t := range job.targets {
+			if _, err = Scan(ctx, t.library, t.dir, opts); err != nil {
+				break
+			}
+		}
+		cancel()
+		m.finish(job, err)
+	}()
+
+	publishProgress(job)
+}
+
+// publishProgress publishes the state of a job. The error list is left out, it can be
+// large and is available from the job itself.
+func publishProgress(job *Job) {
+	info := job.Info()
+	info.Errors = nil
+	events.Publish(events.ScanProgress, 0, info)
+}
+
+// finish records a stopped job and starts the next one.
+func (m *Manager) finish(job *Job, err error) {
+	job.mu.Lock()
+	job.finished = time.Now()
+	switch {
+	case err == context.Canceled:
+		job.state = JobCancelled
+	case err != nil:
+		job.state = JobFailed
+		job.err = err
+	default:
+		job.state = JobFinished
+	}
+	job.mu.Unlock()
+
+	stats := job.progress.Stats()
+	log.WithFields(log.Fields{"id": job.ID, "path": job.Path, "state": job.state}).Infof("Added %d new songs in %.2f seconds. %d files failed.", stats.Added, job.finished.Sub(job.started).Seconds(), stats.Failed)
+
+	m.mu.Lock()
+	m.remember(job)
+	m.runNext()
+	m.mu.Unlock()
+
+	publishProgress(job)
+	close(job.done)
+}
+
+// remember adds a stopped job to the history. m.mu must be held.
+func (m *Manager) remember(job *Job) {
+	m.history = append(m.history, job)
+	if len(m.history) > maxHistory {
+		m.history = m.history[len(m.history)-maxHistory:]
+	}
+}
+
+// Cancel stops a running job or removes it from the queue.
+func (m *Manager) Cancel(id uint64) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != nil && m.current.ID == id {
+		m.current.mu.Lock()
+		m.current.cancel()
+		m.current.mu.Unlock()
+		return m.current, nil
+	}
+
+	for i, job := range m.queue {
+		if job.ID == id {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+
+			job.mu.Lock()
+			job.state = JobCancelled
+			job.finished = time.Now()
+			job.mu.Unlock()
+
+			m.remember(job)
+			publishProgress(job)
+			close(job.done)
+			return job, nil
+		}
+	}
+
+	return nil, ErrJobNotFound
+}
+
+// Current returns the running job or nil.
+func (m *Manager) Current() *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.current
+}
+
+// Queued returns the jobs waiting to run.
+func (m *Manager) Queued() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*Job{}, m.queue...)
+}
+
+// History returns the stopped jobs, most recent first.
+func (m *Manager) History() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]*Job, 0, len(m.history))
+	for i := len(m.history) - 1; i >= 0; i-- {
+		jobs = append(jobs, m.history[i])
+	}
+
+	return jobs
+}
+
+// Get returns a job by ID.
+func (m *Manager) Get(id uint64) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != nil && m.current.ID == id {
+		return m.current, nil
+	}
+
+	for _, job := range m.queue {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+
+	for _, job := range m.history {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+
+	return nil, ErrJobNotFound
+}
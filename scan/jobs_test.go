@@ -0,0 +1,104 @@
+package scan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestManager(t *testing.T) {
+
+	Convey("Scan jobs", t, func() {
+		if err := db.SetupConnection(db.SQLITE, "file:scanjobs?mode=memory&cache=shared"); err != nil {
+			So(err, ShouldBeNil)
+		}
+		defer db.Shutdown()
+
+		if err := db.SetupSchema(); err != nil {
+			So(err, ShouldBeNil)
+		}
+
+		mediaDir, err := ioutil.TempDir("", "cadenzr")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(mediaDir)
+		So(os.Mkdir(filepath.Join(mediaDir, "sub"), 0755), ShouldBeNil)
+
+		lib := library.New(config.Library{Name: "test", Path: mediaDir})
+		lib.ID = 1
+		library.Set(lib)
+		defer library.Set()
+
+		m := NewManager()
+
+		Convey("Scans need an existing library and path", func() {
+			_, err := m.Start(2, "", false)
+			So(err, ShouldEqual, ErrLibraryNotFound)
+
+			_, err = m.Start(1, "does-not-exist", false)
+			So(err, ShouldEqual, ErrInvalidPath)
+
+			_, err = m.Start(0, "sub", false)
+			So(err, ShouldEqual, ErrInvalidPath)
+		})
+
+		Convey("A finished job ends up in the history", func() {
+			job, err := m.Start(1, "sub", false)
+			So(err, ShouldBeNil)
+
+			select {
+			case <-job.Done():
+			case <-time.After(5 * time.Second):
+				So("scan did not finish", ShouldBeEmpty)
+			}
+
+			So(job.Info().State, ShouldEqual, JobFinished)
+			So(m.Current(), ShouldBeNil)
+			So(len(m.History()), ShouldEqual, 1)
+
+			found, err := m.Get(job.ID)
+			So(err, ShouldBeNil)
+			So(found, ShouldEqual, job)
+		})
+
+		Convey("Queued jobs can be cancelled", func() {
+			running := &Job{ID: 100, Path: "busy", progress: &Progress{}, done: make(chan struct{})}
+			m.current = running
+
+			job, err := m.Start(0, "", false)
+			So(err, ShouldBeNil)
+			So(job.Info().State, ShouldEqual, JobQueued)
+
+			same, err := m.Start(0, "", false)
+			So(err, ShouldBeNil)
+			So(same, ShouldEqual, job)
+			So(len(m.Queued()), ShouldEqual, 1)
+
+			_, err = m.Cancel(job.ID)
+			So(err, ShouldBeNil)
+			So(job.Info().State, ShouldEqual, JobCancelled)
+			So(len(m.Queued()), ShouldEqual, 0)
+
+			_, err = m.Cancel(12345)
+			So(err, ShouldEqual, ErrJobNotFound)
+		})
+	})
+
+}
+
+func TestETA(t *testing.T) {
+
+	Convey("ETA is only known after walking", t, func() {
+		So(eta(Stats{Seen: 10, Probed: 5}, false, 10*time.Second), ShouldBeNil)
+		So(eta(Stats{Seen: 10}, true, 10*time.Second), ShouldBeNil)
+		So(*eta(Stats{Seen: 10, Probed: 4, Failed: 1}, true, 10*time.Second), ShouldEqual, 10)
+	})
+
+}
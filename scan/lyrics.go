@@ -0,0 +1,71 @@
+package scan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/lyrics"
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// maxLyricsSize is the size in bytes of the largest lyrics file that is read.
+const maxLyricsSize = 1 << 20
+
+// lyricsExtensions are the extensions of lyrics files next to songs, in order of preference.
+var lyricsExtensions = []string{".lrc", ".txt"}
+
+// readLyricsFile returns the content of the lyrics file for a song with the given
+// extension, or an empty string.
+func readLyricsFile(songPath string, ext string) string {
+	path := strings.TrimSuffix(songPath, filepath.Ext(songPath)) + ext
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return ""
+	}
+
+	if info.Size() > maxLyricsSize {
+		log.WithFields(log.Fields{"file": path}).Warn("Lyrics file is too large.")
+		return ""
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithFields(log.Fields{"reason": err.Error(), "file": path}).Warn("Could not read lyrics file.")
+		return ""
+	}
+
+	return strings.TrimSpace(string(raw))
+}
+
+// findLyrics returns the lyrics of a song from the files next to it or embedded in it.
+// Synchronized lyrics are preferred over plain lyrics, files next to the song over
+// embedded lyrics. The returned lyrics are not yet in the database.
+func findLyrics(songPath string, embedded string) *models.Lyrics {
+	candidates := []*models.Lyrics{}
+	for _, ext := range lyricsExtensions {
+		if text := readLyricsFile(songPath, ext); len(text) != 0 {
+			candidates = append(candidates, &models.Lyrics{Source: models.LyricsSidecar, Text: text})
+		}
+	}
+
+	if text := strings.TrimSpace(embedded); len(text) != 0 {
+		candidates = append(candidates, &models.Lyrics{Source: models.LyricsEmbedded, Text: text})
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	for _, candidate := range candidates {
+		candidate.Synced = lyrics.IsLRC(candidate.Text)
+		if candidate.Synced {
+			return candidate
+		}
+	}
+
+	return candidates[0]
+}
@@ -0,0 +1,50 @@
+package scan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFindLyrics(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	song := filepath.Join(dir, "01 Song.mp3")
+	write := func(name string, text string) {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+	}
+
+	Convey("Songs without lyrics have none.", t, func() {
+		So(findLyrics(song, " "), ShouldBeNil)
+	})
+
+	Convey("Embedded lyrics are used without files.", t, func() {
+		l := findLyrics(song, "Embedded")
+		So(l.Source, ShouldEqual, models.LyricsEmbedded)
+		So(l.Synced, ShouldBeFalse)
+	})
+
+	Convey("Files next to the song are preferred.", t, func() {
+		write("01 Song.txt", "Plain file")
+		l := findLyrics(song, "Embedded")
+		So(l.Source, ShouldEqual, models.LyricsSidecar)
+		So(l.Text, ShouldEqual, "Plain file")
+	})
+
+	Convey("Synchronized lyrics are preferred over plain lyrics.", t, func() {
+		l := findLyrics(song, "[00:01.00]Embedded")
+		So(l.Source, ShouldEqual, models.LyricsEmbedded)
+		So(l.Synced, ShouldBeTrue)
+
+		write("01 Song.lrc", "[00:01.00]Synced file")
+		l = findLyrics(song, "[00:01.00]Embedded")
+		So(l.Source, ShouldEqual, models.LyricsSidecar)
+		So(l.Text, ShouldEqual, "[00:01.00]Synced file")
+	})
+}
@@ -0,0 +1,110 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// maxMissingShare is the largest share of the songs under a directory that is removed
+// by a scan without the force option.
+const maxMissingShare = 0.5
+
+// ErrAllMissing is returned when none of the songs under a directory are left.
+var ErrAllMissing = errors.New("None of the songs are left, the directory may not be mounted")
+
+// ErrTooManyMissing is returned when more songs are gone than a scan removes without force.
+var ErrTooManyMissing = errors.New("Too many songs are gone, scan with force to remove them")
+
+// removeMissing deletes the songs under mediaDir whose file is gone. Nothing is removed
+// when mediaDir itself is missing or none of its songs are left, e.g. because a share is
+// not mounted and its mount point is empty. Without force nothing is removed either when
+// more than maxMissingShare of the songs are gone.
+func removeMissing(ctx context.Context, mediaDir string, force bool) (int, error) {
+	if _, err := os.Stat(mediaDir); err != nil {
+		return 0, err
+	}
+
+	songs := []*models.Song{}
+	prefix := strings.TrimSuffix(mediaDir, string(filepath.Separator)) + string(filepath.Separator)
+	if gormDB := db.DB.Where("path = ? OR path LIKE ?", mediaDir, prefix+"%").Find(&songs); gormDB.Error != nil {
+		return 0, gormDB.Error
+	}
+
+	missing := []*models.Song{}
+	for _, song := range songs {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		if _, err := os.Stat(song.Path); os.IsNotExist(err) {
+			missing = append(missing, song)
+		}
+	}
+
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	if len(missing) == len(songs) {
+		return 0, ErrAllMissing
+	}
+
+	if !force && float64(len(missing)) > maxMissingShare*float64(len(songs)) {
+		return 0, ErrTooManyMissing
+	}
+
+	if err := removeSongs(missing); err != nil {
+		return 0, err
+	}
+
+	return len(missing), nil
+}
+
+// removeSongs deletes songs with their chapters, lyrics, ratings and playlist entries.
+// The history and play queues keep them, they skip songs that are gone.
+func removeSongs(songs []*models.Song) error {
+	ids := []uint{}
+	for _, song := range songs {
+		ids = append(ids, song.ID)
+	}
+
+	tx := db.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, table := range []string{"chapters", "lyrics", "playlist_songs"} {
+		if gormDB := tx.Exec("DELETE FROM "+table+" WHERE song_id IN (?)", ids); gormDB.Error != nil {
+			tx.Rollback()
+			return gormDB.Error
+		}
+	}
+
+	if gormDB := tx.Exec("DELETE FROM annotations WHERE item_type = ? AND item_id IN (?)", models.AnnotationSong, ids); gormDB.Error != nil {
+		tx.Rollback()
+		return gormDB.Error
+	}
+
+	// Songs are deleted for good, so the file is added again if it comes back.
+	if gormDB := tx.Exec("DELETE FROM songs WHERE id IN (?)", ids); gormDB.Error != nil {
+		tx.Rollback()
+		return gormDB.Error
+	}
+
+	if gormDB := tx.Commit(); gormDB.Error != nil {
+		return gormDB.Error
+	}
+
+	for _, song := range songs {
+		events.PublishLibrary(events.SongRemoved, uint(song.LibraryID.Int64), newSongEvent(song))
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRemoveMissing(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:scanmissing?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	present := &models.Song{Name: "Present", Mime: "audio/mpeg", Path: filepath.Join(dir, "present.mp3")}
+	ioutil.WriteFile(present.Path, []byte("ID3"), 0644)
+	gone := &models.Song{Name: "Gone", Mime: "audio/mpeg", Path: filepath.Join(dir, "gone.mp3")}
+	db.DB.Create(present)
+	db.DB.Create(gone)
+	db.DB.Create(&models.Lyrics{SongID: gone.ID, Source: models.LyricsUser, Text: "la"})
+	db.DB.Exec("INSERT INTO playlist_songs (playlist_id, song_id) VALUES(?,?)", 1, gone.ID)
+
+	Convey("Songs whose file is gone are removed.", t, func() {
+		s := events.Default.Subscribe(0, 0, events.SongRemoved)
+		defer events.Default.Unsubscribe(s)
+
+		removed, err := removeMissing(context.Background(), dir, false)
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 1)
+
+		songs := []*models.Song{}
+		db.DB.Unscoped().Find(&songs)
+		So(len(songs), ShouldEqual, 1)
+		So(songs[0].ID, ShouldEqual, present.ID)
+
+		count := 0
+		db.DB.Model(&models.Lyrics{}).Count(&count)
+		So(count, ShouldEqual, 0)
+		db.DB.Table("playlist_songs").Count(&count)
+		So(count, ShouldEqual, 0)
+
+		e := <-s.C
+		So(e.Type, ShouldEqual, events.SongRemoved)
+		So(e.Data.(*songEvent).ID, ShouldEqual, gone.ID)
+	})
+
+	Convey("Nothing is removed when the directory is missing.", t, func() {
+		_, err := removeMissing(context.Background(), filepath.Join(dir, "unmounted"), false)
+		So(err, ShouldNotBeNil)
+
+		count := 0
+		db.DB.Model(&models.Song{}).Count(&count)
+		So(count, ShouldEqual, 1)
+	})
+
+	Convey("Nothing is removed when the mount point is left empty.", t, func() {
+		mountPoint, _ := ioutil.TempDir("", "cadenzr")
+		defer os.RemoveAll(mountPoint)
+		db.DB.Create(&models.Song{Name: "Unmounted", Mime: "audio/mpeg", Path: filepath.Join(mountPoint, "album", "song.mp3")})
+
+		_, err := removeMissing(context.Background(), mountPoint, true)
+		So(err, ShouldEqual, ErrAllMissing)
+
+		count := 0
+		db.DB.Model(&models.Song{}).Where("path LIKE ?", mountPoint+"%").Count(&count)
+		So(count, ShouldEqual, 1)
+	})
+
+	Convey("Most of the songs are only removed with force.", t, func() {
+		mostDir, _ := ioutil.TempDir("", "cadenzr")
+		defer os.RemoveAll(mostDir)
+		left := &models.Song{Name: "Left", Mime: "audio/mpeg", Path: filepath.Join(mostDir, "left.mp3")}
+		ioutil.WriteFile(left.Path, []byte("ID3"), 0644)
+		db.DB.Create(left)
+		for _, name := range []string{"a.mp3", "b.mp3"} {
+			db.DB.Create(&models.Song{Name: name, Mime: "audio/mpeg", Path: filepath.Join(mostDir, name)})
+		}
+
+		_, err := removeMissing(context.Background(), mostDir, false)
+		So(err, ShouldEqual, ErrTooManyMissing)
+
+		removed, err := removeMissing(context.Background(), mostDir, true)
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 2)
+	})
+}
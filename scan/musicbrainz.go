@@ -0,0 +1,46 @@
+package scan
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/probers"
+)
+
+// updateMBIDs reads the MusicBrainz recording ids of the songs under mediaDir that were
+// scanned before ids were read. Their mbid is NULL, songs without an id in their tags get
+// an empty one so they are only read once. It returns the number of songs that got an id.
+func updateMBIDs(ctx context.Context, mediaDir string) (int, error) {
+	songs := []*models.Song{}
+	prefix := strings.TrimSuffix(mediaDir, string(filepath.Separator)) + string(filepath.Separator)
+	if gormDB := db.DB.Where("(path = ? OR path LIKE ?) AND mbid IS NULL", mediaDir, prefix+"%").Find(&songs); gormDB.Error != nil {
+		return 0, gormDB.Error
+	}
+
+	updated := 0
+	for _, song := range songs {
+		if ctx.Err() != nil {
+			return updated, ctx.Err()
+		}
+
+		meta, err := probers.ProbeAudioFile(song.Path)
+		if err != nil {
+			log.WithFields(log.Fields{"reason": err.Error(), "file": song.Path}).Warn("Could not read MusicBrainz id.")
+			continue
+		}
+
+		if gormDB := db.DB.Model(song).UpdateColumn("mbid", meta.MBID); gormDB.Error != nil {
+			return updated, gormDB.Error
+		}
+
+		if len(meta.MBID) != 0 {
+			updated++
+		}
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,51 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/probers"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpdateMBIDs(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:scanmbids?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := ioutil.TempDir("", "cadenzr")
+	defer os.RemoveAll(dir)
+
+	probers.Initialize()
+
+	// The first song was scanned before MusicBrainz ids were read.
+	before := &models.Song{Name: "Before", Mime: "audio/mpeg", Path: filepath.Join(dir, "before.mp3")}
+	ioutil.WriteFile(before.Path, []byte("ID3"), 0644)
+	db.DB.Create(before)
+	db.DB.Exec("UPDATE songs SET mbid = NULL WHERE id = ?", before.ID)
+	known := &models.Song{Name: "Known", Mime: "audio/mpeg", Path: filepath.Join(dir, "known.mp3"), MBID: "b1a9c0e9-d987-4042-ae91-78d6a3267d69"}
+	db.DB.Create(known)
+
+	Convey("Songs scanned before ids were read are read once.", t, func() {
+		_, err := updateMBIDs(context.Background(), dir)
+		So(err, ShouldBeNil)
+
+		count := 0
+		db.DB.Model(&models.Song{}).Where("mbid IS NULL").Count(&count)
+		So(count, ShouldEqual, 0)
+
+		stored := &models.Song{}
+		db.DB.First(stored, known.ID)
+		So(stored.MBID, ShouldEqual, known.MBID)
+	})
+}
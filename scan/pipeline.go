@@ -0,0 +1,747 @@
+package scan
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/events"
+	"github.com/cadenzr/cadenzr/library"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/probers"
+	"github.com/cadenzr/cadenzr/replaygain"
+	"github.com/jinzhu/gorm"
+)
+
+// sequenceWindow is the number of files per worker that can be probed ahead of the
+// oldest file that is not written yet.
+const sequenceWindow = 16
+
+// Options configures a filesystem scan.
+type Options struct {
+	// Workers is the number of files that are probed concurrently.
+	Workers int
+	// BatchSize is the number of songs inserted per database transaction.
+	BatchSize int
+	// Progress is updated while scanning if not nil.
+	Progress *Progress
+	// Added is called for every song once it is committed, if not nil.
+	Added func(song *models.Song)
+	// Force removes the songs whose file is gone even when most of them are.
+	Force bool
+}
+
+// DefaultOptions returns the scan options from the configuration.
+func DefaultOptions() Options {
+	return Options{
+		Workers:   config.Config.ScanWorkers,
+		BatchSize: config.Config.ScanBatchSize,
+	}
+}
+
+func (o *Options) cleanUp() {
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+
+	if o.Progress == nil {
+		o.Progress = &Progress{}
+	}
+}
+
+// FileError is a file that could not be added to the library.
+type FileError struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Stats summarizes a scan.
+type Stats struct {
+	Seen   int
+	Probed int
+	Added  int
+	// Removed is the number of songs whose file is gone.
+	Removed int
+	Failed  int
+	Errors  []FileError
+}
+
+func (s *Stats) fail(path string, reason string) {
+	s.Failed++
+	s.Errors = append(s.Errors, FileError{Path: path, Reason: reason})
+}
+
+// Progress is the state of a running scan. It is safe for concurrent use.
+type Progress struct {
+	mu      sync.Mutex
+	stats   Stats
+	current string
+	walked  bool
+}
+
+// Stats returns a copy of the stats so far.
+func (p *Progress) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.stats
+	s.Errors = append([]FileError{}, p.stats.Errors...)
+	return s
+}
+
+// Current returns the file that was most recently picked up for probing.
+func (p *Progress) Current() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.current
+}
+
+// Walked returns whether all files have been found, so Stats().Seen is final.
+func (p *Progress) Walked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.walked
+}
+
+func (p *Progress) update(f func(p *Progress)) {
+	p.mu.Lock()
+	f(p)
+	p.mu.Unlock()
+}
+
+// job is a file found by the walker. seq is the position of the file in walk order.
+type job struct {
+	seq  int
+	path string
+	mime string
+}
+
+// result is a probed job.
+type result struct {
+	job
+	meta   *probers.AudioMeta
+	cover  *models.Image
+	lyrics *models.Lyrics
+	hash   string
+	size   int64
+	err    error
+}
+
+// Scan adds all new audio files under mediaDir to the database.
+//
+// If lib is not nil mediaDir must be inside it. The include and exclude patterns
+// of the library are applied and the songs are assigned to it.
+//
+// Files are walked by a single goroutine, probed by opts.Workers goroutines and
+// written to the database by the calling goroutine. The writer handles results in
+// walk order so the database ends up exactly as it would after a serial scan.
+// When ctx is cancelled the songs written so far are committed and ctx.Err() is returned.
+// Afterwards the songs whose file is gone are removed, artists get the info in their
+// artist folders, and the songs that were scanned before get the covers that were added
+// next to them and their MusicBrainz ids, and are updated to the audiobook configuration.
+func Scan(ctx context.Context, lib *library.Library, mediaDir string, opts Options) (*Stats, error) {
+	opts.cleanUp()
+	progress := opts.Progress
+
+	known, err := knownPaths(mediaDir)
+	if err != nil {
+		return &Stats{}, err
+	}
+
+	jobs := make(chan job, opts.Workers)
+	results := make(chan *result, opts.Workers)
+	// The walker waits when the files after a slow one would pile up in the sequencer.
+	seq := newSequencer(sequenceWindow * opts.Workers)
+
+	go func() {
+		defer close(jobs)
+		walk(ctx, lib, mediaDir, known, seq, jobs, progress)
+		progress.update(func(p *Progress) {
+			p.walked = true
+		})
+	}()
+
+	covers := newFolderCovers()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				progress.update(func(p *Progress) {
+					p.current = j.path
+				})
+
+				r := probe(j, covers)
+				select {
+				case results <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	w := &writer{
+		batchSize: opts.BatchSize,
+		progress:  progress,
+		library:   lib,
+		added:     opts.Added,
+	}
+
+	for r := range results {
+		if ctx.Err() != nil {
+			// Keep draining so the workers can stop.
+			continue
+		}
+
+		for _, r := range seq.push(r.seq, r) {
+			w.write(r.(*result))
+		}
+	}
+
+	w.flush()
+
+	if ctx.Err() == nil {
+		if removed, err := removeMissing(ctx, mediaDir, opts.Force); err != nil {
+			log.WithFields(log.Fields{"reason": err.Error(), "path": mediaDir}).Error("Could not remove missing songs.")
+		} else if removed != 0 {
+			log.Infof("Removed %d songs whose file is gone.", removed)
+			progress.update(func(p *Progress) {
+				p.stats.Removed = removed
+			})
+		}
+
+		if updated, err := updateArtists(ctx, lib, mediaDir); err != nil {
+			log.WithFields(log.Fields{"reason": err.Error(), "path": mediaDir}).Error("Could not update artists.")
+		} else if updated != 0 {
+			log.Infof("Added the artist folder info of %d artists.", updated)
+		}
+
+		if updated, err := updateCovers(ctx, mediaDir); err != nil {
+			log.WithFields(log.Fields{"reason": err.Error(), "path": mediaDir}).Error("Could not update covers.")
+		} else if updated != 0 {
+			log.Infof("Added folder covers to %d songs.", updated)
+		}
+
+		if updated, err := updateMBIDs(ctx, mediaDir); err != nil {
+			log.WithFields(log.Fields{"reason": err.Error(), "path": mediaDir}).Error("Could not update MusicBrainz ids.")
+		} else if updated != 0 {
+			log.Infof("Added the MusicBrainz ids of %d songs.", updated)
+		}
+
+		// Songs that were scanned before are not probed again, but the audiobook
+		// configuration may have changed since.
+		if updated, err := updateAudiobooks(ctx, lib, mediaDir); err != nil {
+			log.WithFields(log.Fields{"reason": err.Error(), "path": mediaDir}).Error("Could not update audiobooks.")
+		} else if updated != 0 {
+			log.Infof("Updated %d songs after the audiobook configuration changed.", updated)
+		}
+	}
+
+	stats := progress.Stats()
+	return &stats, ctx.Err()
+}
+
+// knownPaths returns the paths of the songs under mediaDir that are already in the database.
+func knownPaths(mediaDir string) (map[string]struct{}, error) {
+	paths := []string{}
+	prefix := strings.TrimSuffix(mediaDir, string(filepath.Separator)) + string(filepath.Separator)
+	if gormDB := db.DB.Table("songs").Where("path = ? OR path LIKE ?", mediaDir, prefix+"%").Pluck("path", &paths); gormDB.Error != nil {
+		return nil, gormDB.Error
+	}
+
+	known := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		known[path] = struct{}{}
+	}
+
+	return known, nil
+}
+
+var errCancelled = errors.New("scan cancelled")
+
+// walk sends every new audio file under mediaDir to jobs, once order lets it start.
+func walk(ctx context.Context, lib *library.Library, mediaDir string, known map[string]struct{}, order *sequencer, jobs chan<- job, progress *Progress) {
+	seq := 0
+	filepath.Walk(mediaDir, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return errCancelled
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{"reason": err.Error(), "path": path}).Error("Failed to handle file/dir.")
+			return nil
+		}
+
+		if info.IsDir() {
+			if lib != nil && path != mediaDir && lib.Excluded(path) {
+				log.WithFields(log.Fields{"path": path, "library": lib.Name}).Debug("Skipping excluded directory.")
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if lib != nil && !lib.Included(path) {
+			log.WithFields(log.Fields{"path": path, "library": lib.Name}).Debug("Skipping excluded file.")
+			return nil
+		}
+
+		mimeType := mime.TypeByExtension(filepath.Ext(path))
+		if !probers.HasProber(mimeType) {
+			log.WithFields(log.Fields{"path": path, "mime": mimeType}).Debug("Skipping file. Unknown mime.")
+			return nil
+		}
+
+		if _, ok := known[path]; ok {
+			log.Debugf("Skipping file. Path already in database: %s.", path)
+			return nil
+		}
+
+		log.WithFields(log.Fields{"path": path, "mime": mimeType}).Debug("Found file.")
+
+		if !order.acquire(ctx) {
+			return errCancelled
+		}
+
+		select {
+		case jobs <- job{seq: seq, path: path, mime: mimeType}:
+			seq++
+			progress.update(func(p *Progress) {
+				p.stats.Seen++
+			})
+		case <-ctx.Done():
+			return errCancelled
+		}
+
+		return nil
+	})
+}
+
+// probe reads the metadata of a file and the sidecar files next to it, and stores
+// its cover on disk.
+func probe(j job, covers *folderCovers) *result {
+	r := &result{job: j}
+
+	r.hash, r.size, r.err = HashFile(j.path)
+	if r.err != nil {
+		return r
+	}
+
+	r.meta, r.err = probers.ProbeAudioFile(j.path)
+	if r.err != nil {
+		return r
+	}
+
+	r.cover = covers.choose(r.meta.CoverBufer, filepath.Dir(j.path))
+	r.lyrics = findLyrics(j.path, r.meta.Lyrics)
+
+	return r
+}
+
+// HashFile returns the hex encoded SHA-256 and the size of a file.
+func HashFile(path string) (hash string, size int64, err error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+
+	h := sha256.New()
+	if size, err = io.Copy(h, fh); err != nil {
+		return
+	}
+
+	hash = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// storeCover writes a cover to the images directory.
+// The returned image is not yet in the database. Returns nil if buf is not a usable image.
+func storeCover(buf []byte) *models.Image {
+	mimeCover := http.DetectContentType(buf)
+	if !isImage(mimeCover) {
+		return nil
+	}
+
+	extensions, _ := mime.ExtensionsByType(mimeCover)
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	md5Sum := md5.Sum(buf)
+	hash := hex.EncodeToString(md5Sum[:])
+	destination := "images/" + hash + extensions[0]
+
+	if _, err := os.Stat(destination); os.IsNotExist(err) {
+		// Multiple workers can find the same cover, so write it under a temporary name first.
+		tmp, err := ioutil.TempFile("images", hash)
+		if err != nil {
+			log.WithFields(log.Fields{"reason": err.Error(), "destination": destination}).Error("Failed to write cover to disk.")
+			return nil
+		}
+
+		_, err = tmp.Write(buf)
+		tmp.Close()
+		if err == nil {
+			err = os.Rename(tmp.Name(), destination)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			log.WithFields(log.Fields{"reason": err.Error(), "destination": destination}).Error("Failed to write cover to disk.")
+			return nil
+		}
+	}
+
+	return &models.Image{
+		Path: destination,
+		Link: "/" + destination,
+		Mime: mimeCover,
+		Hash: hash,
+	}
+}
+
+// writer inserts probed files into the database in batched transactions.
+type writer struct {
+	batchSize int
+	progress  *Progress
+	library   *library.Library
+	added     func(song *models.Song)
+
+	tx      *gorm.DB
+	pending []*models.Song
+}
+
+func (w *writer) fail(path string, reason string) {
+	w.progress.update(func(p *Progress) {
+		p.stats.fail(path, reason)
+	})
+}
+
+func (w *writer) write(r *result) {
+	if r.err != nil {
+		log.WithFields(log.Fields{"reason": r.err.Error(), "file": r.path}).Error("Probing file failed.")
+		w.fail(r.path, r.err.Error())
+		return
+	}
+	w.progress.update(func(p *Progress) {
+		p.stats.Probed++
+	})
+
+	if w.tx == nil {
+		w.tx = db.DB.Begin()
+		if w.tx.Error != nil {
+			log.Errorf("Could not start scan transaction: %v", w.tx.Error)
+			w.fail(r.path, w.tx.Error.Error())
+			w.tx = nil
+			return
+		}
+	}
+
+	// A song that fails halfway must not leave its album, artist or chapters in the
+	// batch, or the next scan would skip the file.
+	if gormDB := w.tx.Exec("SAVEPOINT song"); gormDB.Error != nil {
+		log.Errorf("Could not start savepoint for '%s': %v", r.path, gormDB.Error)
+		w.fail(r.path, gormDB.Error.Error())
+		return
+	}
+
+	song, err := storeSong(w.tx, w.library, r)
+	if err != nil {
+		if gormDB := w.tx.Exec("ROLLBACK TO SAVEPOINT song"); gormDB.Error != nil {
+			log.Errorf("Could not roll back '%s': %v", r.path, gormDB.Error)
+		}
+		w.fail(r.path, err.Error())
+		return
+	}
+
+	if gormDB := w.tx.Exec("RELEASE SAVEPOINT song"); gormDB.Error != nil {
+		log.Errorf("Could not release savepoint for '%s': %v", r.path, gormDB.Error)
+	}
+
+	w.pending = append(w.pending, song)
+	if len(w.pending) >= w.batchSize {
+		w.flush()
+	}
+}
+
+// flush commits the current transaction.
+func (w *writer) flush() {
+	if w.tx == nil {
+		return
+	}
+
+	gormDB := w.tx.Commit()
+	if gormDB.Error != nil {
+		w.tx.Rollback()
+		log.Errorf("Could not commit %d scanned songs: %v", len(w.pending), gormDB.Error)
+	}
+
+	pending := len(w.pending)
+	w.progress.update(func(p *Progress) {
+		if gormDB.Error != nil {
+			p.stats.Failed += pending
+		} else {
+			p.stats.Added += pending
+		}
+	})
+
+	if gormDB.Error == nil {
+		for _, song := range w.pending {
+			events.PublishLibrary(events.SongAdded, uint(song.LibraryID.Int64), newSongEvent(song))
+			if w.added != nil {
+				w.added(song)
+			}
+		}
+	}
+
+	w.tx = nil
+	w.pending = nil
+}
+
+// songEvent is the data of the events.SongAdded and events.SongRemoved events.
+type songEvent struct {
+	ID        uint             `json:"id"`
+	Name      string           `json:"name"`
+	AlbumID   models.NullInt64 `json:"album_id"`
+	ArtistID  models.NullInt64 `json:"artist_id"`
+	LibraryID models.NullInt64 `json:"library_id"`
+}
+
+func newSongEvent(song *models.Song) *songEvent {
+	e := &songEvent{
+		ID:        song.ID,
+		Name:      song.Name,
+		AlbumID:   song.AlbumID,
+		ArtistID:  song.ArtistID,
+		LibraryID: song.LibraryID,
+	}
+
+	if song.Album != nil {
+		e.AlbumID.Set(int64(song.Album.ID))
+	}
+
+	if song.Artist != nil {
+		e.ArtistID.Set(int64(song.Artist.ID))
+	}
+
+	return e
+}
+
+// storeSong inserts the song of a probed file together with its album, artist and cover.
+func storeSong(tx *gorm.DB, lib *library.Library, r *result) (*models.Song, error) {
+	meta := r.meta
+	path := r.path
+
+	cover := r.cover
+	if cover != nil {
+		cover = storeImage(tx, cover)
+	}
+
+	song := &models.Song{}
+	song.Name = meta.Title
+	if len(song.Name) == 0 {
+		log.WithFields(log.Fields{"file": path}).Error("Could not get name of song.")
+		return nil, errors.New("Could not get name of song")
+	}
+	song.Mime = r.mime
+	song.Path = path
+	song.Hash = r.hash
+	song.Size = r.size
+	if lib != nil {
+		song.LibraryID.Set(int64(lib.ID))
+	}
+	if cover != nil {
+		song.Cover = cover
+	}
+	if len(meta.Genre) > 0 {
+		song.Genre.Set(meta.Genre)
+	} else {
+		log.WithFields(log.Fields{"file": path}).Debug("No genre found.")
+	}
+	if meta.Year != 0 {
+		song.Year.Set(int64(meta.Year))
+	} else {
+		log.WithFields(log.Fields{"file": path}).Debug("No year found.")
+	}
+	if meta.Track != 0 {
+		song.Track.Set(int64(meta.Track))
+	} else {
+		log.WithFields(log.Fields{"file": path}).Debug("No track found.")
+	}
+	if meta.TotalTracks != 0 {
+		song.TotalTracks.Set(int64(meta.TotalTracks))
+	} else {
+		log.WithFields(log.Fields{"file": path}).Debug("No total tracks found.")
+	}
+	song.Audiobook = isAudiobook(lib, path, meta.Genre, config.Config.Audiobooks)
+	albumName := meta.Album
+	if len(albumName) == 0 && song.Audiobook {
+		// The book of a single file without an album is the file itself.
+		albumName = meta.Title
+	}
+	if len(albumName) > 0 {
+		album := &models.Album{
+			Name: albumName,
+			Year: song.Year,
+		}
+		if cover != nil {
+			album.Cover = cover
+		}
+		if gormDB := tx.FirstOrCreate(album, "name = ?", album.Name); gormDB.Error != nil {
+			log.Errorf("Could not create/get album '%s': %v", album.Name, gormDB.Error)
+			return nil, gormDB.Error
+		}
+
+		// The gain of an album is that of all its songs, so a new song replaces it with
+		// its album gain tag, or clears it to have the album measured again.
+		if meta.AlbumGain.Valid || album.Gain.Valid {
+			album.Gain = models.NullFloat64{}
+			album.Peak = models.NullFloat64{}
+			if meta.AlbumGain.Valid {
+				album.Gain.Set(meta.AlbumGain.Gain)
+				if meta.AlbumGain.Peak > 0 {
+					album.Peak.Set(meta.AlbumGain.Peak)
+				}
+			}
+
+			values := map[string]interface{}{"gain": album.Gain, "peak": album.Peak}
+			if gormDB := tx.Model(album).UpdateColumns(values); gormDB.Error != nil {
+				log.Errorf("Could not store gain of album '%s': %v", album.Name, gormDB.Error)
+				return nil, gormDB.Error
+			}
+		}
+
+		song.Album = album
+	} else {
+		log.WithFields(log.Fields{"file": path}).Debug("No album found.")
+	}
+	if len(meta.Artist) > 0 {
+		artist := &models.Artist{
+			Name: meta.Artist,
+		}
+
+		if gormDB := tx.FirstOrCreate(artist, "name = ?", artist.Name); gormDB.Error != nil {
+			log.Errorf("Could not create/get artist '%s': %v", artist.Name, gormDB.Error)
+			return nil, gormDB.Error
+		}
+
+		song.Artist = artist
+	} else {
+		log.WithFields(log.Fields{"file": path}).Debug("No Artist found.")
+	}
+
+	if meta.Duration != 0 {
+		song.Duration.Set(meta.Duration)
+	} else {
+		log.WithFields(log.Fields{"file": path}).Debug("No duration found.")
+	}
+
+	// Songs without a gain are measured later by the replaygain analyzer.
+	if meta.TrackGain.Valid {
+		song.TrackGain.Set(meta.TrackGain.Gain)
+		song.Loudness.Set(replaygain.LoudnessFromGain(meta.TrackGain.Gain))
+		if meta.TrackGain.Peak > 0 {
+			song.TrackPeak.Set(meta.TrackGain.Peak)
+		}
+	}
+
+	song.MBID = meta.MBID
+
+	if gormDB := tx.Create(song); gormDB.Error != nil {
+		log.Errorf("Could not create song '%s': %v", song.Name, gormDB.Error)
+		return nil, gormDB.Error
+	}
+
+	for _, chapter := range newChapters(meta.Chapters, meta.Duration) {
+		chapter.SongID = song.ID
+		if gormDB := tx.Create(chapter); gormDB.Error != nil {
+			log.Errorf("Could not create chapter of song '%s': %v", song.Name, gormDB.Error)
+			return nil, gormDB.Error
+		}
+	}
+
+	if r.lyrics != nil {
+		r.lyrics.SongID = song.ID
+		if gormDB := tx.Create(r.lyrics); gormDB.Error != nil {
+			log.Errorf("Could not create lyrics of song '%s': %v", song.Name, gormDB.Error)
+			return nil, gormDB.Error
+		}
+	}
+
+	return song, nil
+}
+
+// sequencer releases out-of-order values in sequence order. At most size values are
+// acquired and not released yet, so the values that wait for a slow one stay few.
+type sequencer struct {
+	next    int
+	pending map[int]interface{}
+	window  chan struct{}
+}
+
+func newSequencer(size int) *sequencer {
+	return &sequencer{
+		pending: map[int]interface{}{},
+		window:  make(chan struct{}, size),
+	}
+}
+
+// acquire waits until another value may be started. It returns false if ctx is done first.
+func (s *sequencer) acquire(ctx context.Context) bool {
+	select {
+	case s.window <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// push adds the value with sequence number seq and returns all values that are now in order.
+func (s *sequencer) push(seq int, v interface{}) []interface{} {
+	s.pending[seq] = v
+
+	ready := []interface{}{}
+	for {
+		v, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+
+		delete(s.pending, s.next)
+		ready = append(ready, v)
+		s.next++
+
+		select {
+		case <-s.window:
+		default:
+		}
+	}
+
+	return ready
+}
@@ -0,0 +1,165 @@
+package scan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/probers"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSequencer(t *testing.T) {
+
+	Convey("Values are released in sequence order", t, func() {
+		s := newSequencer(10)
+
+		So(s.push(2, "c"), ShouldBeEmpty)
+		So(s.push(1, "b"), ShouldBeEmpty)
+		So(s.push(0, "a"), ShouldResemble, []interface{}{"a", "b", "c"})
+		So(s.push(3, "d"), ShouldResemble, []interface{}{"d"})
+		So(s.push(5, "f"), ShouldBeEmpty)
+		So(s.pending, ShouldContainKey, 5)
+	})
+
+	Convey("Values wait when too many are not released yet", t, func() {
+		s := newSequencer(2)
+		So(s.acquire(context.Background()), ShouldBeTrue)
+		So(s.acquire(context.Background()), ShouldBeTrue)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		So(s.acquire(ctx), ShouldBeFalse)
+
+		So(s.push(1, "b"), ShouldBeEmpty)
+		So(s.acquire(ctx), ShouldBeFalse)
+		So(s.push(0, "a"), ShouldResemble, []interface{}{"a", "b"})
+		So(s.acquire(context.Background()), ShouldBeTrue)
+	})
+
+}
+
+func TestScanCancelled(t *testing.T) {
+
+	Convey("Cancelled scan", t, func() {
+		if err := db.SetupConnection(db.SQLITE, "file:scancancel?mode=memory&cache=shared"); err != nil {
+			So(err, ShouldBeNil)
+		}
+		defer db.Shutdown()
+
+		if err := db.SetupSchema(); err != nil {
+			So(err, ShouldBeNil)
+		}
+
+		probers.Initialize()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		stats, err := Scan(ctx, nil, "../media", Options{Workers: 4, BatchSize: 2})
+		So(err, ShouldEqual, context.Canceled)
+		So(stats.Added, ShouldEqual, 0)
+
+		var count uint64
+		db.DB.Table("songs").Count(&count)
+		So(count, ShouldEqual, 0)
+	})
+
+}
+
+func TestWriterFailedSong(t *testing.T) {
+
+	Convey("A song that fails halfway leaves nothing behind", t, func() {
+		if err := db.SetupConnection(db.SQLITE, "file:scanwriter?mode=memory&cache=shared"); err != nil {
+			So(err, ShouldBeNil)
+		}
+		defer db.Shutdown()
+
+		if err := db.SetupSchema(); err != nil {
+			So(err, ShouldBeNil)
+		}
+
+		// The lyrics of the first song can't be inserted, after the song is.
+		existing := &models.Lyrics{SongID: 100, Source: models.LyricsUser, Text: "la"}
+		So(db.DB.Create(existing).Error, ShouldBeNil)
+		lyrics := &models.Lyrics{Source: models.LyricsEmbedded, Text: "la la"}
+		lyrics.ID = existing.ID
+
+		progress := &Progress{}
+		w := &writer{batchSize: 10, progress: progress}
+		w.write(&result{
+			job:    job{path: "/music/broken.mp3", mime: "audio/mpeg"},
+			meta:   &probers.AudioMeta{Title: "Broken", Artist: "Broken Artist", Album: "Broken Album"},
+			lyrics: lyrics,
+		})
+		w.write(&result{
+			job:  job{path: "/music/fine.mp3", mime: "audio/mpeg"},
+			meta: &probers.AudioMeta{Title: "Fine", Artist: "Fine Artist", Album: "Fine Album"},
+		})
+		w.flush()
+
+		stats := progress.Stats()
+		So(stats.Added, ShouldEqual, 1)
+		So(stats.Failed, ShouldEqual, 1)
+
+		songs := []*models.Song{}
+		db.DB.Find(&songs)
+		So(len(songs), ShouldEqual, 1)
+		So(songs[0].Path, ShouldEqual, "/music/fine.mp3")
+
+		count := 0
+		db.DB.Model(&models.Album{}).Where("name = ?", "Broken Album").Count(&count)
+		So(count, ShouldEqual, 0)
+		db.DB.Model(&models.Artist{}).Where("name = ?", "Broken Artist").Count(&count)
+		So(count, ShouldEqual, 0)
+	})
+
+}
+
+func TestWriterAlbumGain(t *testing.T) {
+	if err := db.SetupConnection(db.SQLITE, "file:scanalbumgain?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+	if err := db.SetupSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The album was measured with only its first song.
+	album := &models.Album{Name: "Album"}
+	album.Gain.Set(-7)
+	album.Peak.Set(0.9)
+	db.DB.Create(album)
+
+	Convey("A song added to a measured album clears its gain, so it is measured again.", t, func() {
+		w := &writer{batchSize: 10, progress: &Progress{}}
+		w.write(&result{
+			job:  job{path: "/music/second.mp3", mime: "audio/mpeg"},
+			meta: &probers.AudioMeta{Title: "Second", Album: "Album"},
+		})
+		w.flush()
+
+		stored := &models.Album{}
+		db.DB.First(stored, album.ID)
+		So(stored.Gain.Valid, ShouldBeFalse)
+		So(stored.Peak.Valid, ShouldBeFalse)
+	})
+
+	Convey("A song with an album gain tag gives its album that gain.", t, func() {
+		meta := &probers.AudioMeta{Title: "Third", Album: "Album"}
+		meta.AlbumGain = probers.Gain{Valid: true, Gain: -5, Peak: 0.8}
+		w := &writer{batchSize: 10, progress: &Progress{}}
+		w.write(&result{
+			job:  job{path: "/music/third.mp3", mime: "audio/mpeg"},
+			meta: meta,
+		})
+		w.flush()
+
+		stored := &models.Album{}
+		db.DB.First(stored, album.ID)
+		So(stored.Gain.Float64, ShouldEqual, -5)
+		So(stored.Peak.Float64, ShouldEqual, 0.8)
+	})
+}
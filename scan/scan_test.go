@@ -1,7 +1,10 @@
 package scan
 
 import (
+	"context"
+
 	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/library"
 	"github.com/cadenzr/cadenzr/models"
 	"github.com/cadenzr/cadenzr/probers"
 
@@ -23,8 +26,8 @@ func TestScan(t *testing.T) {
 		}
 
 		probers.Initialize()
-		go ScanFilesystem("../media/0demo/")
-		<-ScanDone
+		_, err := Scan(context.Background(), library.ForPath("../media/0demo/"), "../media/0demo/", DefaultOptions())
+		So(err, ShouldBeNil)
 
 		song := &models.Song{}
 		gormDB := db.DB.First(&song, "id = ?", 1)
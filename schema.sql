@@ -7,15 +7,34 @@ CREATE TABLE IF NOT EXISTS `images` (
   `mime` TEXT NOT NULL,
   `hash` TEXT NOT NULL UNIQUE
 );
+CREATE TABLE IF NOT EXISTS `libraries` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `name`	TEXT NOT NULL UNIQUE,
+  `path`	TEXT NOT NULL
+);
 CREATE TABLE IF NOT EXISTS `artists` (
   `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
-  `name`	TEXT NOT NULL UNIQUE
+  `name`	TEXT NOT NULL UNIQUE,
+  `image_id`	INTEGER,
+  `biography`	TEXT,
+
+  FOREIGN KEY(`image_id`) REFERENCES `images`(`id`)
+);
+CREATE TABLE IF NOT EXISTS `artist_links` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `artist_id`	INTEGER NOT NULL,
+  `name`	TEXT NOT NULL,
+  `url`	TEXT NOT NULL,
+
+  FOREIGN KEY(`artist_id`) REFERENCES `artists`(`id`) ON DELETE CASCADE
 );
 CREATE TABLE IF NOT EXISTS "albums" (
   `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
   `name`	TEXT NOT NULL UNIQUE,
   `cover_id`	INTEGER,
   `year`	INTEGER,
+  `gain`	REAL,
+  `peak`	REAL,
 
   FOREIGN KEY(`cover_id`) REFERENCES `images`(`id`)
 
@@ -31,11 +50,30 @@ CREATE TABLE IF NOT EXISTS "songs" (
   `mime`	TEXT NOT NULL,
   `path`	TEXT NOT NULL,
   `cover_id`	INTEGER,
+  `library_id`	INTEGER,
   `played` INTEGER NOT NULL,
+  `hash`	TEXT,
+  `size`	INTEGER,
+  `track_gain`	REAL,
+  `track_peak`	REAL,
+  `loudness`	REAL,
+  `mbid`	TEXT,
+  `audiobook`	BOOL NOT NULL DEFAULT 0,
 
   FOREIGN KEY(`artist_id`) REFERENCES `artists`(`id`),
   FOREIGN KEY(`album_id`) REFERENCES `albums`(`id`),
-  FOREIGN KEY(`cover_id`) REFERENCES `images`(`id`)
+  FOREIGN KEY(`cover_id`) REFERENCES `images`(`id`),
+  FOREIGN KEY(`library_id`) REFERENCES `libraries`(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `lyrics` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `song_id`	INTEGER NOT NULL UNIQUE,
+  `source`	TEXT NOT NULL,
+  `synced`	INTEGER NOT NULL,
+  `text`	TEXT NOT NULL,
+
+  FOREIGN KEY(`song_id`) REFERENCES `songs`(`id`) ON DELETE CASCADE
 );
 
 CREATE TABLE IF NOT EXISTS `users` (
@@ -44,6 +82,174 @@ CREATE TABLE IF NOT EXISTS `users` (
   `password`	TEXT NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS `scrobble_accounts` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `user_id`	INTEGER NOT NULL,
+  `service`	TEXT NOT NULL,
+  `username`	TEXT,
+  `token`	TEXT NOT NULL,
+
+  FOREIGN KEY(`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE,
+
+  UNIQUE(`user_id`, `service`)
+);
+
+CREATE TABLE IF NOT EXISTS `pending_scrobbles` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `account_id`	INTEGER NOT NULL,
+  `artist`	TEXT NOT NULL,
+  `title`	TEXT NOT NULL,
+  `album`	TEXT,
+  `duration`	REAL,
+  `track_number`	INTEGER,
+  `played_at`	DATETIME NOT NULL,
+  `attempts`	INTEGER,
+  `next_attempt`	DATETIME,
+  `last_error`	TEXT,
+
+  FOREIGN KEY(`account_id`) REFERENCES `scrobble_accounts`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `plays` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `user_id`	INTEGER NOT NULL,
+  `song_id`	INTEGER NOT NULL,
+  `played_at`	DATETIME NOT NULL,
+  `source`	TEXT NOT NULL,
+
+  FOREIGN KEY(`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY(`song_id`) REFERENCES `songs`(`id`) ON DELETE CASCADE,
+
+  UNIQUE(`user_id`, `song_id`, `played_at`)
+);
+
+CREATE TABLE IF NOT EXISTS `annotations` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `user_id`	INTEGER NOT NULL,
+  `item_type`	TEXT NOT NULL,
+  `item_id`	INTEGER NOT NULL,
+  `starred`	DATETIME,
+  `rating`	INTEGER NOT NULL,
+
+  FOREIGN KEY(`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE,
+
+  UNIQUE(`user_id`, `item_type`, `item_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `play_queues` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `user_id`	INTEGER NOT NULL UNIQUE,
+  `current`	INTEGER NOT NULL,
+  `position`	REAL NOT NULL,
+  `changed_by`	TEXT,
+  `updated_at`	DATETIME,
+
+  FOREIGN KEY(`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `play_queue_entries` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `queue_id`	INTEGER NOT NULL,
+  `song_id`	INTEGER NOT NULL,
+  `index`	INTEGER NOT NULL,
+
+  FOREIGN KEY(`queue_id`) REFERENCES `play_queues`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY(`song_id`) REFERENCES `songs`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `now_playings` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `user_id`	INTEGER NOT NULL,
+  `username`	TEXT NOT NULL,
+  `client`	TEXT NOT NULL,
+  `song_id`	INTEGER NOT NULL,
+  `started`	DATETIME NOT NULL,
+  `position`	REAL,
+  `paused`	BOOL,
+  `expires`	DATETIME NOT NULL,
+  `updated_at`	DATETIME,
+
+  FOREIGN KEY(`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY(`song_id`) REFERENCES `songs`(`id`) ON DELETE CASCADE,
+  UNIQUE(`user_id`, `client`)
+);
+
+CREATE TABLE IF NOT EXISTS `radio_stations` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `name`	TEXT NOT NULL,
+  `stream_url`	TEXT NOT NULL UNIQUE,
+  `homepage_url`	TEXT,
+  `logo_url`	TEXT,
+  `updated_at`	DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS `podcast_channels` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `url`	TEXT NOT NULL UNIQUE,
+  `title`	TEXT NOT NULL,
+  `description`	TEXT,
+  `link`	TEXT,
+  `image_url`	TEXT,
+  `refreshed`	DATETIME,
+  `error`	TEXT
+);
+
+CREATE TABLE IF NOT EXISTS `podcast_episodes` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `channel_id`	INTEGER NOT NULL,
+  `guid`	TEXT NOT NULL,
+  `title`	TEXT NOT NULL,
+  `description`	TEXT,
+  `published`	DATETIME,
+  `duration`	REAL,
+  `url`	TEXT NOT NULL,
+  `mime`	TEXT,
+  `size`	INTEGER,
+  `status`	TEXT NOT NULL,
+  `path`	TEXT,
+
+  FOREIGN KEY(`channel_id`) REFERENCES `podcast_channels`(`id`) ON DELETE CASCADE,
+  UNIQUE(`channel_id`, `guid`)
+);
+
+CREATE TABLE IF NOT EXISTS `podcast_positions` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `user_id`	INTEGER NOT NULL,
+  `episode_id`	INTEGER NOT NULL,
+  `position`	REAL,
+  `played`	BOOL,
+  `updated_at`	DATETIME,
+
+  FOREIGN KEY(`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY(`episode_id`) REFERENCES `podcast_episodes`(`id`) ON DELETE CASCADE,
+  UNIQUE(`user_id`, `episode_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `chapters` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `song_id`	INTEGER NOT NULL,
+  `title`	TEXT NOT NULL,
+  `start`	REAL NOT NULL,
+  `end`	REAL NOT NULL,
+
+  FOREIGN KEY(`song_id`) REFERENCES `songs`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `book_positions` (
+  `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
+  `user_id`	INTEGER NOT NULL,
+  `album_id`	INTEGER NOT NULL,
+  `song_id`	INTEGER NOT NULL,
+  `position`	REAL NOT NULL,
+  `speed`	REAL NOT NULL,
+  `updated_at`	DATETIME,
+
+  FOREIGN KEY(`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY(`album_id`) REFERENCES `albums`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY(`song_id`) REFERENCES `songs`(`id`) ON DELETE CASCADE,
+  UNIQUE(`user_id`, `album_id`)
+);
+
 CREATE TABLE IF NOT EXISTS `playlists` (
   `id`	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE,
   `name`	TEXT NOT NULL UNIQUE
@@ -0,0 +1,171 @@
+package scrobble
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// lastFMTemporaryErrors are the Last.fm error codes after which a request can be retried:
+// operation failed, service offline, temporarily unavailable and rate limit exceeded.
+var lastFMTemporaryErrors = map[int]bool{8: true, 11: true, 16: true, 29: true}
+
+// LastFM talks to the Last.fm 2.0 API, or a service that implements it like Libre.fm.
+type LastFM struct {
+	// URL is the API root, e.g. https://ws.audioscrobbler.com/2.0/.
+	URL string
+	// AuthURL is where users allow cadenzr to scrobble, e.g. https://www.last.fm/api/auth/.
+	AuthURL string
+	APIKey  string
+	Secret  string
+
+	Client *http.Client
+}
+
+// sign adds the API key and the signature to the parameters of a call.
+func (l *LastFM) sign(params url.Values) {
+	params.Set("api_key", l.APIKey)
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	s := ""
+	for _, key := range keys {
+		s += key + params.Get(key)
+	}
+
+	sum := md5.Sum([]byte(s + l.Secret))
+	params.Set("api_sig", hex.EncodeToString(sum[:]))
+	// The format is not part of the signature.
+	params.Set("format", "json")
+}
+
+// call posts a signed call and decodes the response into v, which may be nil.
+func (l *LastFM) call(params url.Values, v interface{}) error {
+	l.sign(params)
+
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.PostForm(l.URL, params)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body := struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}{}
+	raw := json.RawMessage{}
+	if err := json.NewDecoder(res.Body).Decode(&raw); err != nil {
+		if res.StatusCode != http.StatusOK {
+			return statusError(res.StatusCode, "Last.fm responded with "+res.Status)
+		}
+		return err
+	}
+
+	json.Unmarshal(raw, &body)
+	if body.Error != 0 {
+		return &Error{
+			Temporary: lastFMTemporaryErrors[body.Error],
+			Message:   "Last.fm error " + strconv.Itoa(body.Error) + ": " + body.Message,
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return statusError(res.StatusCode, "Last.fm responded with "+res.Status)
+	}
+
+	if v != nil {
+		return json.Unmarshal(raw, v)
+	}
+
+	return nil
+}
+
+// AuthorizeURL returns the page where a user allows cadenzr to scrobble. Last.fm sends
+// the user back to callback with a token that is exchanged with Session.
+func (l *LastFM) AuthorizeURL(callback string) string {
+	params := url.Values{}
+	params.Set("api_key", l.APIKey)
+	if len(callback) != 0 {
+		params.Set("cb", callback)
+	}
+
+	return l.AuthURL + "?" + params.Encode()
+}
+
+// Session exchanges the token of an authorization for a session key and the username.
+func (l *LastFM) Session(token string) (username string, key string, err error) {
+	params := url.Values{}
+	params.Set("method", "auth.getSession")
+	params.Set("token", token)
+
+	response := struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}{}
+	if err := l.call(params, &response); err != nil {
+		return "", "", err
+	}
+
+	return response.Session.Name, response.Session.Key, nil
+}
+
+// setTrack adds the parameters of a track, with a suffix like "[0]" for batches.
+func setTrack(params url.Values, track *Track, suffix string) {
+	params.Set("artist"+suffix, track.Artist)
+	params.Set("track"+suffix, track.Title)
+	if len(track.Album) != 0 {
+		params.Set("album"+suffix, track.Album)
+	}
+	if track.TrackNumber > 0 {
+		params.Set("trackNumber"+suffix, strconv.Itoa(track.TrackNumber))
+	}
+	if track.Duration > 0 {
+		params.Set("duration"+suffix, strconv.Itoa(int(track.Duration)))
+	}
+}
+
+// NowPlaying calls track.updateNowPlaying.
+func (l *LastFM) NowPlaying(account *models.ScrobbleAccount, track *Track) error {
+	params := url.Values{}
+	params.Set("method", "track.updateNowPlaying")
+	params.Set("sk", account.Token)
+	setTrack(params, track, "")
+
+	return l.call(params, nil)
+}
+
+// Scrobble calls track.scrobble with up to BatchSize tracks.
+func (l *LastFM) Scrobble(account *models.ScrobbleAccount, tracks []*Track) error {
+	params := url.Values{}
+	params.Set("method", "track.scrobble")
+	params.Set("sk", account.Token)
+	for i, track := range tracks {
+		suffix := "[" + strconv.Itoa(i) + "]"
+		setTrack(params, track, suffix)
+		params.Set("timestamp"+suffix, strconv.FormatInt(track.PlayedAt.Unix(), 10))
+	}
+
+	return l.call(params, nil)
+}
+
+// BatchSize is the most tracks Last.fm accepts in one call.
+func (l *LastFM) BatchSize() int {
+	return 50
+}
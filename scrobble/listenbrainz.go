@@ -0,0 +1,159 @@
+package scrobble
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// Listen types of ListenBrainz submissions.
+const (
+	listenPlayingNow = "playing_now"
+	listenSingle     = "single"
+	listenImport     = "import"
+)
+
+// ListenBrainz talks to the ListenBrainz API, or a compatible server like Maloja.
+type ListenBrainz struct {
+	// URL is the API root, e.g. https://api.listenbrainz.org.
+	URL string
+
+	Client *http.Client
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64                     `json:"listened_at,omitempty"`
+	TrackMetadata listenBrainzTrackMetadata `json:"track_metadata"`
+}
+
+type listenBrainzTrackMetadata struct {
+	ArtistName     string                 `json:"artist_name"`
+	TrackName      string                 `json:"track_name"`
+	ReleaseName    string                 `json:"release_name,omitempty"`
+	AdditionalInfo map[string]interface{} `json:"additional_info,omitempty"`
+}
+
+func (l *ListenBrainz) client() *http.Client {
+	if l.Client == nil {
+		return http.DefaultClient
+	}
+
+	return l.Client
+}
+
+// do sends a request with the user token and decodes the response into v, which may be nil.
+func (l *ListenBrainz) do(method string, path string, token string, body interface{}, v interface{}) error {
+	raw := []byte(nil)
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(l.URL, "/")+path, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := l.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		response := struct {
+			Error string `json:"error"`
+		}{}
+		json.NewDecoder(res.Body).Decode(&response)
+		if len(response.Error) == 0 {
+			response.Error = res.Status
+		}
+
+		return statusError(res.StatusCode, "ListenBrainz: "+response.Error)
+	}
+
+	if v != nil {
+		return json.NewDecoder(res.Body).Decode(v)
+	}
+
+	return nil
+}
+
+// Validate returns the username of a user token.
+func (l *ListenBrainz) Validate(token string) (string, error) {
+	response := struct {
+		Valid    bool   `json:"valid"`
+		UserName string `json:"user_name"`
+		Message  string `json:"message"`
+	}{}
+	if err := l.do(http.MethodGet, "/1/validate-token", token, nil, &response); err != nil {
+		return "", err
+	}
+
+	if !response.Valid {
+		return "", &Error{Message: "ListenBrainz: " + response.Message}
+	}
+
+	return response.UserName, nil
+}
+
+// submit posts listens of a type.
+func (l *ListenBrainz) submit(account *models.ScrobbleAccount, listenType string, tracks []*Track) error {
+	listens := make([]listenBrainzListen, 0, len(tracks))
+	for _, track := range tracks {
+		listen := listenBrainzListen{
+			TrackMetadata: listenBrainzTrackMetadata{
+				ArtistName:     track.Artist,
+				TrackName:      track.Title,
+				ReleaseName:    track.Album,
+				AdditionalInfo: map[string]interface{}{"submission_client": "cadenzr"},
+			},
+		}
+		if listenType != listenPlayingNow {
+			listen.ListenedAt = track.PlayedAt.Unix()
+		}
+		if track.TrackNumber > 0 {
+			listen.TrackMetadata.AdditionalInfo["tracknumber"] = track.TrackNumber
+		}
+		if track.Duration > 0 {
+			listen.TrackMetadata.AdditionalInfo["duration_ms"] = int64(track.Duration * 1000)
+		}
+
+		listens = append(listens, listen)
+	}
+
+	body := map[string]interface{}{
+		"listen_type": listenType,
+		"payload":     listens,
+	}
+
+	return l.do(http.MethodPost, "/1/submit-listens", account.Token, body, nil)
+}
+
+// NowPlaying submits a playing_now listen.
+func (l *ListenBrainz) NowPlaying(account *models.ScrobbleAccount, track *Track) error {
+	return l.submit(account, listenPlayingNow, []*Track{track})
+}
+
+// Scrobble submits a single listen, or an import of several.
+func (l *ListenBrainz) Scrobble(account *models.ScrobbleAccount, tracks []*Track) error {
+	if len(tracks) == 1 {
+		return l.submit(account, listenSingle, tracks)
+	}
+
+	return l.submit(account, listenImport, tracks)
+}
+
+// BatchSize is the most listens ListenBrainz accepts in one import.
+func (l *ListenBrainz) BatchSize() int {
+	return 100
+}
@@ -0,0 +1,100 @@
+// Package scrobble submits what users play to Last.fm and ListenBrainz. Plays are kept
+// in a queue in the database until the service accepts them, so nothing is lost while
+// a service or the network is down.
+package scrobble
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cadenzr/cadenzr/models"
+)
+
+// MinDuration is the length of the shortest song that is scrobbled, as Last.fm requires.
+const MinDuration = 30 * time.Second
+
+// Track is a played song.
+type Track struct {
+	Artist      string
+	Title       string
+	Album       string
+	TrackNumber int
+	// Duration is in seconds, 0 if it is unknown.
+	Duration float64
+	PlayedAt time.Time
+}
+
+// NewTrack returns the track of a song. song.Artist and song.Album must be loaded.
+func NewTrack(song *models.Song, playedAt time.Time) *Track {
+	t := &Track{
+		Title:       song.Name,
+		TrackNumber: int(song.Track.Int64),
+		Duration:    song.Duration.Float64,
+		PlayedAt:    playedAt,
+	}
+
+	if song.Artist != nil {
+		t.Artist = song.Artist.Name
+	}
+
+	if song.Album != nil {
+		t.Album = song.Album.Name
+	}
+
+	return t
+}
+
+// Scrobbleable returns whether the track can be submitted: it needs an artist and title
+// and must not be too short.
+func (t *Track) Scrobbleable() bool {
+	if len(t.Artist) == 0 || len(t.Title) == 0 {
+		return false
+	}
+
+	return t.Duration == 0 || t.Duration >= MinDuration.Seconds()
+}
+
+// Error is an error of a service.
+type Error struct {
+	// Temporary errors are retried later.
+	Temporary bool
+	Message   string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// IsTemporary returns whether the submission that failed with err should be retried.
+// Errors that don't come from the service, like network errors, are temporary.
+func IsTemporary(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Temporary
+	}
+
+	return true
+}
+
+// statusError returns the error for an HTTP status of a service. Server errors and rate
+// limits are temporary.
+func statusError(status int, message string) *Error {
+	return &Error{
+		Temporary: status >= 500 || status == http.StatusTooManyRequests,
+		Message:   message,
+	}
+}
+
+// ErrNotConfigured is returned for a service that is not set up in the configuration.
+var ErrNotConfigured = errors.New("Scrobbling service is not configured")
+
+// Service submits plays to a scrobbling service for an account.
+type Service interface {
+	// NowPlaying tells the service that the user started playing a track.
+	NowPlaying(account *models.ScrobbleAccount, track *Track) error
+	// Scrobble submits played tracks. The service may limit how many tracks can be
+	// submitted at once, see BatchSize.
+	Scrobble(account *models.ScrobbleAccount, tracks []*Track) error
+	// BatchSize is the number of tracks that can be submitted at once.
+	BatchSize() int
+}
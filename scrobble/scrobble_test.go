@@ -0,0 +1,218 @@
+package scrobble
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLastFM(t *testing.T) {
+	var form map[string][]string
+	errorCode := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		if errorCode != 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": errorCode, "message": "Failed"})
+			return
+		}
+		w.Write([]byte(`{"session": {"name": "someone", "key": "session-key"}}`))
+	}))
+	defer server.Close()
+
+	lastFM := &LastFM{URL: server.URL, AuthURL: "https://www.last.fm/api/auth/", APIKey: "key", Secret: "secret"}
+	account := &models.ScrobbleAccount{Token: "session-key"}
+
+	Convey("Calls are signed.", t, func() {
+		username, key, err := lastFM.Session("token")
+		So(err, ShouldBeNil)
+		So(username, ShouldEqual, "someone")
+		So(key, ShouldEqual, "session-key")
+
+		// md5("api_keykeymethodauth.getSessiontokentokensecret")
+		So(form["api_sig"], ShouldResemble, []string{"9ac306496295a8866c4a8673395540eb"})
+		So(form["format"], ShouldResemble, []string{"json"})
+	})
+
+	Convey("Scrobbles are submitted in batches.", t, func() {
+		played := time.Unix(1500000000, 0)
+		tracks := []*Track{
+			{Artist: "Artist", Title: "One", Album: "Album", TrackNumber: 1, Duration: 200.5, PlayedAt: played},
+			{Artist: "Artist", Title: "Two", PlayedAt: played.Add(time.Minute)},
+		}
+		So(lastFM.Scrobble(account, tracks), ShouldBeNil)
+		So(form["method"], ShouldResemble, []string{"track.scrobble"})
+		So(form["sk"], ShouldResemble, []string{"session-key"})
+		So(form["track[0]"], ShouldResemble, []string{"One"})
+		So(form["duration[0]"], ShouldResemble, []string{"200"})
+		So(form["trackNumber[0]"], ShouldResemble, []string{"1"})
+		So(form["timestamp[1]"], ShouldResemble, []string{"1500000060"})
+		So(form["album[1]"], ShouldBeNil)
+	})
+
+	Convey("Errors tell whether to retry.", t, func() {
+		errorCode = 9
+		err := lastFM.NowPlaying(account, &Track{Artist: "Artist", Title: "One"})
+		So(err, ShouldNotBeNil)
+		So(IsTemporary(err), ShouldBeFalse)
+
+		errorCode = 16
+		So(IsTemporary(lastFM.NowPlaying(account, &Track{Artist: "Artist", Title: "One"})), ShouldBeTrue)
+		errorCode = 0
+	})
+}
+
+func TestListenBrainz(t *testing.T) {
+	var body map[string]interface{}
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/1/validate-token":
+			if authorization != "Token good" {
+				w.Write([]byte(`{"valid": false, "message": "Invalid token"}`))
+				return
+			}
+			w.Write([]byte(`{"valid": true, "user_name": "someone"}`))
+		case "/1/submit-listens":
+			body = nil
+			json.NewDecoder(r.Body).Decode(&body)
+			w.Write([]byte(`{"status": "ok"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	listenBrainz := &ListenBrainz{URL: server.URL + "/"}
+	account := &models.ScrobbleAccount{Token: "good"}
+
+	Convey("Tokens are validated.", t, func() {
+		username, err := listenBrainz.Validate("good")
+		So(err, ShouldBeNil)
+		So(username, ShouldEqual, "someone")
+
+		_, err = listenBrainz.Validate("bad")
+		So(err, ShouldNotBeNil)
+		So(IsTemporary(err), ShouldBeFalse)
+	})
+
+	Convey("Listens have a type.", t, func() {
+		track := &Track{Artist: "Artist", Title: "One", Duration: 1.5, PlayedAt: time.Unix(1500000000, 0)}
+
+		So(listenBrainz.NowPlaying(account, track), ShouldBeNil)
+		So(authorization, ShouldEqual, "Token good")
+		So(body["listen_type"], ShouldEqual, "playing_now")
+		listen := body["payload"].([]interface{})[0].(map[string]interface{})
+		So(listen["listened_at"], ShouldBeNil)
+
+		So(listenBrainz.Scrobble(account, []*Track{track}), ShouldBeNil)
+		So(body["listen_type"], ShouldEqual, "single")
+		listen = body["payload"].([]interface{})[0].(map[string]interface{})
+		So(listen["listened_at"], ShouldEqual, 1500000000)
+		info := listen["track_metadata"].(map[string]interface{})["additional_info"].(map[string]interface{})
+		So(info["duration_ms"], ShouldEqual, 1500)
+
+		So(listenBrainz.Scrobble(account, []*Track{track, track}), ShouldBeNil)
+		So(body["listen_type"], ShouldEqual, "import")
+	})
+}
+
+// fakeService records scrobbles and fails while err is set.
+type fakeService struct {
+	mu        sync.Mutex
+	err       error
+	scrobbled [][]*Track
+}
+
+func (s *fakeService) NowPlaying(account *models.ScrobbleAccount, track *Track) error {
+	return s.err
+}
+
+func (s *fakeService) Scrobble(account *models.ScrobbleAccount, tracks []*Track) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.scrobbled = append(s.scrobbled, tracks)
+	return nil
+}
+
+func (s *fakeService) BatchSize() int {
+	return 2
+}
+
+func TestScrobbler(t *testing.T) {
+	Convey("Plays are queued until they are submitted.", t, func() {
+		So(db.SetupConnection(db.SQLITE, "file:scrobble?mode=memory&cache=shared"), ShouldBeNil)
+		defer db.Shutdown()
+		So(db.SetupSchema(), ShouldBeNil)
+
+		account := &models.ScrobbleAccount{UserID: 1, Service: models.ScrobbleListenBrainz, Token: "token"}
+		So(db.DB.Create(account).Error, ShouldBeNil)
+		// Accounts of services that are not configured are ignored.
+		So(db.DB.Create(&models.ScrobbleAccount{UserID: 1, Service: models.ScrobbleLastFM, Token: "key"}).Error, ShouldBeNil)
+
+		service := &fakeService{err: &Error{Temporary: true, Message: "Offline"}}
+		s := NewScrobbler(map[string]Service{models.ScrobbleListenBrainz: service})
+		now := time.Unix(1500000000, 0)
+		s.now = func() time.Time { return now }
+
+		played := now.Add(-time.Hour)
+		for _, title := range []string{"One", "Two", "Three"} {
+			So(s.Scrobble(1, &Track{Artist: "Artist", Title: title, Duration: 60, PlayedAt: played}), ShouldBeNil)
+			played = played.Add(time.Minute)
+		}
+		// Too short.
+		So(s.Scrobble(1, &Track{Artist: "Artist", Title: "Intro", Duration: 10, PlayedAt: played}), ShouldBeNil)
+
+		count := 0
+		db.DB.Model(&models.PendingScrobble{}).Count(&count)
+		So(count, ShouldEqual, 3)
+
+		s.Flush()
+		pending := &models.PendingScrobble{}
+		db.DB.Order("played_at").First(pending)
+		So(pending.Attempts, ShouldEqual, 1)
+		So(pending.LastError, ShouldEqual, "Offline")
+		So(pending.NextAttempt.Equal(now.Add(retryDelay)), ShouldBeTrue)
+
+		// Not due yet.
+		service.err = nil
+		s.Flush()
+		So(len(service.scrobbled), ShouldEqual, 0)
+
+		now = now.Add(retryDelay)
+		s.Flush()
+		So(len(service.scrobbled), ShouldEqual, 2)
+		So(len(service.scrobbled[0]), ShouldEqual, 2)
+		So(service.scrobbled[0][0].Title, ShouldEqual, "One")
+		So(service.scrobbled[1][0].Title, ShouldEqual, "Three")
+
+		db.DB.Model(&models.PendingScrobble{}).Count(&count)
+		So(count, ShouldEqual, 0)
+
+		// Refused plays are not retried.
+		service.err = &Error{Message: "Invalid"}
+		So(s.Scrobble(1, &Track{Artist: "Artist", Title: "Four", PlayedAt: now}), ShouldBeNil)
+		s.Flush()
+		db.DB.Model(&models.PendingScrobble{}).Count(&count)
+		So(count, ShouldEqual, 0)
+	})
+
+	Convey("Retries wait longer after every attempt.", t, func() {
+		So(backoff(1), ShouldEqual, time.Minute)
+		So(backoff(3), ShouldEqual, 4*time.Minute)
+		So(backoff(100), ShouldEqual, maxRetryDelay)
+	})
+}
@@ -0,0 +1,268 @@
+package scrobble
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/db"
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// retryDelay is how long the first retry of a failed submission waits. It doubles
+	// with every attempt up to maxRetryDelay.
+	retryDelay    = time.Minute
+	maxRetryDelay = 6 * time.Hour
+	// flushInterval is how often the queue is checked for submissions to retry.
+	flushInterval = time.Minute
+	// requestTimeout limits the time a request to a service may take.
+	requestTimeout = 30 * time.Second
+)
+
+// Scrobbler relays plays to the accounts of users. Scrobbles go through a queue in the
+// database that is flushed in the background.
+type Scrobbler struct {
+	services map[string]Service
+	trigger  chan struct{}
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// Default is the scrobbler of the server, nil until Setup is called.
+var Default *Scrobbler
+
+// NewScrobbler returns a scrobbler for services by name, see models.ScrobbleLastFM.
+func NewScrobbler(services map[string]Service) *Scrobbler {
+	return &Scrobbler{
+		services: services,
+		trigger:  make(chan struct{}, 1),
+		now:      time.Now,
+	}
+}
+
+// Service returns the service with a name, or nil if it is not configured.
+func (s *Scrobbler) Service(name string) Service {
+	return s.services[name]
+}
+
+// accounts returns the linked accounts of a user with a configured service.
+func (s *Scrobbler) accounts(userID uint) ([]*models.ScrobbleAccount, error) {
+	accounts := []*models.ScrobbleAccount{}
+	if gormDB := db.DB.Where("user_id = ?", userID).Find(&accounts); gormDB.Error != nil {
+		return nil, gormDB.Error
+	}
+
+	configured := accounts[:0]
+	for _, account := range accounts {
+		if s.services[account.Service] != nil {
+			configured = append(configured, account)
+		}
+	}
+
+	return configured, nil
+}
+
+// NowPlaying tells the accounts of a user what they are playing. It is not retried
+// because it doesn't matter anymore after the song.
+func (s *Scrobbler) NowPlaying(userID uint, track *Track) error {
+	if !track.Scrobbleable() {
+		return nil
+	}
+
+	accounts, err := s.accounts(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		if err := s.services[account.Service].NowPlaying(account, track); err != nil {
+			log.WithFields(log.Fields{"service": account.Service, "user": userID, "reason": err.Error()}).Warn("Could not send now playing.")
+		}
+	}
+
+	return nil
+}
+
+// Scrobble queues a play for the accounts of a user and triggers a flush of the queue.
+func (s *Scrobbler) Scrobble(userID uint, track *Track) error {
+	if !track.Scrobbleable() {
+		return nil
+	}
+
+	accounts, err := s.accounts(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		pending := &models.PendingScrobble{
+			AccountID:   account.ID,
+			Artist:      track.Artist,
+			Title:       track.Title,
+			Album:       track.Album,
+			Duration:    track.Duration,
+			TrackNumber: track.TrackNumber,
+			PlayedAt:    track.PlayedAt,
+			NextAttempt: track.PlayedAt,
+		}
+		if gormDB := db.DB.Create(pending); gormDB.Error != nil {
+			return gormDB.Error
+		}
+	}
+
+	if len(accounts) != 0 {
+		s.Trigger()
+	}
+
+	return nil
+}
+
+// Trigger makes the scrobbler flush the queue. It does not block.
+func (s *Scrobbler) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run flushes the queue when it starts, when triggered and periodically to retry
+// failed submissions, until stop is closed.
+func (s *Scrobbler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	s.Trigger()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.trigger:
+			s.Flush()
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// Flush submits the queued plays that are due, per account in batches.
+func (s *Scrobbler) Flush() {
+	now := s.now()
+
+	accountIDs := []uint{}
+	if gormDB := db.DB.Model(&models.PendingScrobble{}).Where("next_attempt <= ?", now).Pluck("DISTINCT account_id", &accountIDs); gormDB.Error != nil {
+		log.Errorf("Could not load queued scrobbles: %v", gormDB.Error)
+		return
+	}
+
+	for _, accountID := range accountIDs {
+		account := &models.ScrobbleAccount{}
+		if gormDB := db.DB.First(account, accountID); gormDB.RecordNotFound() {
+			// The account was unlinked.
+			db.DB.Unscoped().Where("account_id = ?", accountID).Delete(&models.PendingScrobble{})
+			continue
+		} else if gormDB.Error != nil {
+			log.Errorf("Could not load scrobble account: %v", gormDB.Error)
+			continue
+		}
+
+		service := s.services[account.Service]
+		if service == nil {
+			continue
+		}
+
+		s.flushAccount(account, service, now)
+	}
+}
+
+// flushAccount submits the due plays of an account until one fails.
+func (s *Scrobbler) flushAccount(account *models.ScrobbleAccount, service Service, now time.Time) {
+	for {
+		pending := []*models.PendingScrobble{}
+		gormDB := db.DB.Where("account_id = ? AND next_attempt <= ?", account.ID, now).Order("played_at").Limit(service.BatchSize()).Find(&pending)
+		if gormDB.Error != nil {
+			log.Errorf("Could not load queued scrobbles: %v", gormDB.Error)
+			return
+		}
+
+		if len(pending) == 0 {
+			return
+		}
+
+		tracks := make([]*Track, len(pending))
+		ids := make([]uint, len(pending))
+		for i, p := range pending {
+			tracks[i] = &Track{
+				Artist:      p.Artist,
+				Title:       p.Title,
+				Album:       p.Album,
+				Duration:    p.Duration,
+				TrackNumber: p.TrackNumber,
+				PlayedAt:    p.PlayedAt,
+			}
+			ids[i] = p.ID
+		}
+
+		err := service.Scrobble(account, tracks)
+		if err == nil || !IsTemporary(err) {
+			if err != nil {
+				log.WithFields(log.Fields{"service": account.Service, "user": account.UserID, "reason": err.Error()}).Warn("Scrobbles were refused, they are dropped.")
+			}
+
+			db.DB.Unscoped().Where("id IN (?)", ids).Delete(&models.PendingScrobble{})
+			continue
+		}
+
+		log.WithFields(log.Fields{"service": account.Service, "user": account.UserID, "reason": err.Error()}).Info("Could not scrobble, trying again later.")
+		// The service is likely unavailable for all plays of the account, so they all wait.
+		attempts := pending[0].Attempts + 1
+		gormDB = db.DB.Model(&models.PendingScrobble{}).Where("account_id = ? AND next_attempt <= ?", account.ID, now).Updates(map[string]interface{}{
+			"attempts":     gorm.Expr("attempts + 1"),
+			"last_error":   err.Error(),
+			"next_attempt": now.Add(backoff(attempts)),
+		})
+		if gormDB.Error != nil {
+			log.Errorf("Could not update queued scrobbles: %v", gormDB.Error)
+		}
+		return
+	}
+}
+
+// backoff returns how long to wait after a number of failed attempts.
+func backoff(attempts int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+
+	return delay
+}
+
+// Setup creates the Default scrobbler with the services that are configured.
+func Setup(conf *config.Configuration) {
+	services := map[string]Service{}
+	if len(conf.LastFM.APIKey) != 0 && len(conf.LastFM.Secret) != 0 {
+		services[models.ScrobbleLastFM] = &LastFM{
+			URL:     conf.LastFM.URL,
+			AuthURL: conf.LastFM.AuthURL,
+			APIKey:  conf.LastFM.APIKey,
+			Secret:  conf.LastFM.Secret,
+			Client:  &http.Client{Timeout: requestTimeout},
+		}
+	}
+
+	if !conf.ListenBrainz.Disabled {
+		services[models.ScrobbleListenBrainz] = &ListenBrainz{
+			URL:    conf.ListenBrainz.URL,
+			Client: &http.Client{Timeout: requestTimeout},
+		}
+	}
+
+	Default = NewScrobbler(services)
+}
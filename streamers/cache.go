@@ -0,0 +1,282 @@
+package streamers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/log"
+)
+
+// tmpPrefix starts the names of files that are still being written.
+const tmpPrefix = ".tmp-"
+
+// Cache keeps transcoded files on disk. The least recently used files are removed when
+// the cache grows over its size, and files that weren't used for its age. Concurrent
+// requests for the same file share a single transcoding, and can read it while it is
+// being written.
+type Cache struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string]*cacheEntry
+	size    int64
+	flights map[string]*flight
+	hits    int64
+	misses  int64
+}
+
+type cacheEntry struct {
+	name     string
+	size     int64
+	lastUsed time.Time
+}
+
+// CacheStats describes the content of a cache.
+type CacheStats struct {
+	Files   int   `json:"files"`
+	Size    int64 `json:"size"`
+	MaxSize int64 `json:"max_size"`
+	// MaxAge is in seconds.
+	MaxAge     int64 `json:"max_age"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+	InProgress int   `json:"in_progress"`
+}
+
+// NewCache returns a cache in dir. maxSize is in bytes. 0 turns off a limit.
+func NewCache(dir string, maxSize int64, maxAge time.Duration) *Cache {
+	return &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		entries: map[string]*cacheEntry{},
+		flights: map[string]*flight{},
+	}
+}
+
+// load reads the files that are already in the cache directory. c.mu must be held.
+func (c *Cache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	for _, info := range files {
+		if info.IsDir() {
+			continue
+		}
+
+		// Left over from a crash.
+		if strings.HasPrefix(info.Name(), tmpPrefix) {
+			os.Remove(filepath.Join(c.dir, info.Name()))
+			continue
+		}
+
+		// The modification time is updated on use, so it survives restarts.
+		c.entries[info.Name()] = &cacheEntry{name: info.Name(), size: info.Size(), lastUsed: info.ModTime()}
+		c.size += info.Size()
+	}
+}
+
+// CreateFunc writes a file for the cache. It must stop when ctx is done.
+type CreateFunc func(ctx context.Context, w io.Writer) error
+
+// Open returns a streamer of the cached file with a name. If it is not cached, create
+// writes it in the background and a *GrowingStreamer is returned that reads the file
+// as it is written. Only one create runs for a name at a time, other callers read the
+// same file. create is stopped when all its readers are closed before it is done.
+func (c *Cache) Open(name string, create CreateFunc) (Streamer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.load()
+	if entry, ok := c.entries[name]; ok {
+		c.hits++
+		now := time.Now()
+		entry.lastUsed = now
+
+		path := filepath.Join(c.dir, name)
+		os.Chtimes(path, now, now)
+		return NewFileStreamer(path)
+	}
+
+	if f, ok := c.flights[name]; ok {
+		c.hits++
+		return f.open()
+	}
+
+	c.misses++
+	f, err := c.start(name, create)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := f.open()
+	if err != nil {
+		f.cancel()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Get returns the path of the cached file with a name, it waits until create has written it.
+func (c *Cache) Get(name string, create CreateFunc) (string, error) {
+	s, err := c.Open(name, create)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	if growing, ok := s.(*GrowingStreamer); ok {
+		if err := growing.Wait(); err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Join(c.dir, name), nil
+}
+
+// start runs create for a file. c.mu must be held.
+func (c *Cache) start(name string, create CreateFunc) (*flight, error) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// The file is written under a temporary name and renamed when it is complete, so
+	// it is never mistaken for a complete file.
+	tmp, err := ioutil.TempFile(c.dir, tmpPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := newFlight(tmp.Name(), cancel)
+	c.flights[name] = f
+
+	go func() {
+		err := create(ctx, &flightWriter{f: f, w: tmp})
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+
+		c.mu.Lock()
+		delete(c.flights, name)
+		if err == nil {
+			err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+		}
+		if err == nil {
+			size := f.size()
+			c.entries[name] = &cacheEntry{name: name, size: size, lastUsed: time.Now()}
+			c.size += size
+			c.evict(time.Now(), name)
+		} else {
+			// Readers that have the file open can still read it.
+			os.Remove(tmp.Name())
+		}
+		c.mu.Unlock()
+
+		f.finish(err)
+	}()
+
+	return f, nil
+}
+
+// evict removes files that weren't used for the maximum age, then the least recently
+// used files until the cache fits its size. keep is never removed. c.mu must be held.
+func (c *Cache) evict(now time.Time, keep string) {
+	if c.maxSize <= 0 && c.maxAge <= 0 {
+		return
+	}
+
+	entries := make([]*cacheEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.Before(entries[j].lastUsed)
+	})
+
+	for _, entry := range entries {
+		expired := c.maxAge > 0 && now.Sub(entry.lastUsed) > c.maxAge
+		tooLarge := c.maxSize > 0 && c.size > c.maxSize
+		if entry.name == keep || (!expired && !tooLarge) {
+			continue
+		}
+
+		c.remove(entry)
+	}
+}
+
+// remove deletes a file of the cache. Streams that have it open can still read it. c.mu must be held.
+func (c *Cache) remove(entry *cacheEntry) bool {
+	if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{"file": entry.name, "reason": err.Error()}).Warn("Could not remove cached transcoding.")
+		return false
+	}
+
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+	return true
+}
+
+// Evict removes the files that are too old or don't fit in the cache.
+func (c *Cache) Evict(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.load()
+	c.evict(now, "")
+}
+
+// Purge removes all cached files. Files that are being created are kept.
+// It returns the number of removed files.
+func (c *Cache) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.load()
+	removed := 0
+	for _, entry := range c.entries {
+		if c.remove(entry) {
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Stats returns the content of the cache.
+func (c *Cache) Stats() *CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.load()
+	return &CacheStats{
+		Files:      len(c.entries),
+		Size:       c.size,
+		MaxSize:    c.maxSize,
+		MaxAge:     int64(c.maxAge / time.Second),
+		Hits:       c.hits,
+		Misses:     c.misses,
+		InProgress: len(c.flights),
+	}
+}
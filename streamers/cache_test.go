@@ -0,0 +1,200 @@
+package streamers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cadenzr/cadenzr/models"
+	"github.com/cadenzr/cadenzr/transcoders"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "transcodings")
+	defer os.RemoveAll(dir)
+
+	write := func(content string) CreateFunc {
+		return func(ctx context.Context, w io.Writer) error {
+			_, err := io.WriteString(w, content)
+			return err
+		}
+	}
+
+	Convey("Concurrent requests for a file transcode it once.", t, func() {
+		c := NewCache(dir, 0, 0)
+
+		var created int32
+		release := make(chan struct{})
+		wg := sync.WaitGroup{}
+		paths := make([]string, 5)
+		for i := range paths {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				paths[i], _ = c.Get("song.mp3", func(ctx context.Context, w io.Writer) error {
+					atomic.AddInt32(&created, 1)
+					<-release
+					return write("data")(ctx, w)
+				})
+			}(i)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		So(atomic.LoadInt32(&created), ShouldEqual, 1)
+		for _, path := range paths {
+			So(path, ShouldEqual, filepath.Join(dir, "song.mp3"))
+		}
+
+		stats := c.Stats()
+		So(stats.Files, ShouldEqual, 1)
+		So(stats.Size, ShouldEqual, 4)
+		So(stats.Misses, ShouldEqual, 1)
+		So(stats.Hits, ShouldEqual, 4)
+
+		// Files in the directory are known after a restart.
+		c = NewCache(dir, 0, 0)
+		So(c.Stats().Files, ShouldEqual, 1)
+
+		So(c.Purge(), ShouldEqual, 1)
+		files, _ := ioutil.ReadDir(dir)
+		So(len(files), ShouldEqual, 0)
+	})
+
+	Convey("Failed transcodings are not cached.", t, func() {
+		c := NewCache(dir, 0, 0)
+		_, err := c.Get("broken.mp3", func(ctx context.Context, w io.Writer) error {
+			write("part")(ctx, w)
+			return errors.New("ffmpeg failed")
+		})
+		So(err, ShouldNotBeNil)
+
+		files, _ := ioutil.ReadDir(dir)
+		So(len(files), ShouldEqual, 0)
+	})
+
+	Convey("The least recently used files are evicted first.", t, func() {
+		c := NewCache(dir, 10, 0)
+		c.Get("a", write("aaaa"))
+		c.Get("b", write("bbbb"))
+		// Using a makes b the oldest.
+		c.Get("a", write("aaaa"))
+		c.Get("c", write("cccc"))
+
+		_, err := os.Stat(filepath.Join(dir, "b"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		So(c.Stats().Size, ShouldEqual, 8)
+	})
+
+	Convey("Files that weren't used for the maximum age are evicted.", t, func() {
+		c := NewCache(dir, 0, time.Hour)
+		So(c.Stats().Files, ShouldEqual, 2)
+		c.Evict(time.Now().Add(2 * time.Hour))
+		So(c.Stats().Files, ShouldEqual, 0)
+	})
+}
+
+func TestGrowingStreamer(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "transcodings")
+	defer os.RemoveAll(dir)
+
+	Convey("Files can be read while they are written.", t, func() {
+		c := NewCache(dir, 0, 0)
+
+		chunks := make(chan string)
+		s, err := c.Open("song.ogg", func(ctx context.Context, w io.Writer) error {
+			for chunk := range chunks {
+				io.WriteString(w, chunk)
+			}
+			return nil
+		})
+		So(err, ShouldBeNil)
+		growing, ok := s.(*GrowingStreamer)
+		So(ok, ShouldBeTrue)
+
+		_, err = growing.Seek(0, io.SeekEnd)
+		So(err, ShouldEqual, ErrUnknownSize)
+
+		chunks <- "abc"
+		buf := make([]byte, 10)
+		n, err := growing.Read(buf)
+		So(err, ShouldBeNil)
+		So(string(buf[:n]), ShouldEqual, "abc")
+
+		// A second reader shares the transcoding.
+		other, err := c.Open("song.ogg", nil)
+		So(err, ShouldBeNil)
+
+		chunks <- "def"
+		close(chunks)
+		So(growing.Wait(), ShouldBeNil)
+
+		rest, err := ioutil.ReadAll(growing)
+		So(err, ShouldBeNil)
+		So(string(rest), ShouldEqual, "def")
+
+		all, err := ioutil.ReadAll(other)
+		So(err, ShouldBeNil)
+		So(string(all), ShouldEqual, "abcdef")
+
+		growing.Close()
+		other.Close()
+
+		// Once complete the file is served from the cache.
+		s, err = c.Open("song.ogg", nil)
+		So(err, ShouldBeNil)
+		_, ok = s.(*FileStreamer)
+		So(ok, ShouldBeTrue)
+		s.Close()
+	})
+
+	Convey("Transcoding stops when the last reader is closed.", t, func() {
+		c := NewCache(dir, 0, 0)
+
+		stopped := make(chan error)
+		s, err := c.Open("abandoned.ogg", func(ctx context.Context, w io.Writer) error {
+			io.WriteString(w, "abc")
+			<-ctx.Done()
+			stopped <- ctx.Err()
+			return ctx.Err()
+		})
+		So(err, ShouldBeNil)
+
+		s.Close()
+		So(<-stopped, ShouldEqual, context.Canceled)
+
+		// The partial file is not cached.
+		s, err = c.Open("abandoned.ogg", func(ctx context.Context, w io.Writer) error {
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(s.(*GrowingStreamer).Wait(), ShouldBeNil)
+		s.Close()
+		So(c.Stats().Misses, ShouldEqual, 2)
+	})
+}
+
+func TestTranscodeKey(t *testing.T) {
+	Convey("Transcodings are cached per file version, profile and gain.", t, func() {
+		song := &models.Song{}
+		song.ID = 7
+		mp3 := transcoders.DefaultProfile(transcoders.MP3)
+		modified := time.Unix(1500000000, 0)
+
+		key := TranscodeKey(song, modified, mp3, 0)
+		So(key, ShouldEqual, "7_ot27eo_mp3-mp3-mp3-192k.mp3")
+		So(TranscodeKey(song, modified.Add(time.Second), mp3, 0), ShouldNotEqual, key)
+		So(TranscodeKey(song, modified, mp3, -3), ShouldEqual, "7_ot27eo_mp3-mp3-mp3-192k_-3.00dB.mp3")
+	})
+}
@@ -0,0 +1,193 @@
+package streamers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrUnknownSize is returned when seeking from the end of a file that is still being written.
+var ErrUnknownSize = errors.New("Size is not known yet")
+
+// flight is a file of a cache that is being written.
+type flight struct {
+	path   string
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	written  int64
+	finished bool
+	err      error
+	readers  int
+}
+
+func newFlight(path string, cancel context.CancelFunc) *flight {
+	f := &flight{
+		path:   path,
+		cancel: cancel,
+	}
+	f.cond = sync.NewCond(&f.mu)
+
+	return f
+}
+
+func (f *flight) size() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.written
+}
+
+// wrote records that n more bytes are in the file.
+func (f *flight) wrote(n int) {
+	f.mu.Lock()
+	f.written += int64(n)
+	f.mu.Unlock()
+
+	f.cond.Broadcast()
+}
+
+// finish records that the file is complete, or that writing it failed.
+func (f *flight) finish(err error) {
+	f.mu.Lock()
+	f.finished = true
+	f.err = err
+	f.mu.Unlock()
+
+	f.cond.Broadcast()
+}
+
+// open returns a new reader of the file. The cache's lock must be held, so the file
+// isn't renamed or removed before it is opened.
+func (f *flight) open() (*GrowingStreamer, error) {
+	fh, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.readers++
+	f.mu.Unlock()
+
+	return &GrowingStreamer{f: f, file: fh}, nil
+}
+
+// close removes a reader. Writing stops when no one reads the file anymore.
+func (f *flight) close() {
+	f.mu.Lock()
+	f.readers--
+	stop := f.readers == 0 && !f.finished
+	f.mu.Unlock()
+
+	if stop {
+		f.cancel()
+	}
+}
+
+// flightWriter writes a file of a flight and wakes up its readers.
+type flightWriter struct {
+	f *flight
+	w io.Writer
+}
+
+func (w *flightWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.f.wrote(n)
+	return n, err
+}
+
+// GrowingStreamer reads a file that is still being written. Reads wait for more data
+// until the file is complete.
+type GrowingStreamer struct {
+	f      *flight
+	file   *os.File
+	offset int64
+	closed bool
+}
+
+// Available returns the number of bytes that can be read without waiting, and whether
+// the file is complete.
+func (s *GrowingStreamer) Available() (int64, bool) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+
+	return s.f.written, s.f.finished && s.f.err == nil
+}
+
+// Wait blocks until the file is complete. It returns the error of writing it.
+func (s *GrowingStreamer) Wait() error {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+
+	for !s.f.finished {
+		s.f.cond.Wait()
+	}
+
+	return s.f.err
+}
+
+func (s *GrowingStreamer) Read(p []byte) (int, error) {
+	s.f.mu.Lock()
+	for s.offset >= s.f.written && !s.f.finished {
+		s.f.cond.Wait()
+	}
+	written, err := s.f.written, s.f.err
+	s.f.mu.Unlock()
+
+	if s.offset >= written {
+		if err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > written-s.offset {
+		p = p[:written-s.offset]
+	}
+
+	n, err := s.file.ReadAt(p, s.offset)
+	s.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Seek moves to an offset. Seeking from the end is only possible when the file is complete.
+func (s *GrowingStreamer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		written, complete := s.Available()
+		if !complete {
+			return s.offset, ErrUnknownSize
+		}
+		offset += written
+	default:
+		return s.offset, errors.New("Invalid whence")
+	}
+
+	if offset < 0 {
+		return s.offset, errors.New("Negative offset")
+	}
+
+	s.offset = offset
+	return offset, nil
+}
+
+// Close stops reading. The transcoding is stopped if no one else reads it.
+func (s *GrowingStreamer) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.f.close()
+	return s.file.Close()
+}
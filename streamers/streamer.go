@@ -1,10 +1,12 @@
 package streamers
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/cadenzr/cadenzr/models"
 	"github.com/cadenzr/cadenzr/transcoders"
@@ -47,41 +49,43 @@ func NewFileStreamer(path string) (streamer Streamer, err error) {
 	return
 }
 
-func NewTranscodeStreamer(song *models.Song, codec transcoders.CodecType) (streamer Streamer, err error) {
-	cachePath := "cache" + string(filepath.Separator) + "transcodings" + string(filepath.Separator) + strconv.Itoa(int(song.ID)) + codec.Extension()
-	err = os.MkdirAll(filepath.Dir(cachePath), 0755)
-	if err != nil {
-		return
-	}
+// Transcodings caches transcoded songs. Replace it to change its limits.
+var Transcodings = NewCache(filepath.Join("cache", "transcodings"), 1<<30, 30*24*time.Hour)
 
-	if _, err = os.Stat(cachePath); !os.IsNotExist(err) {
-		return NewFileStreamer(cachePath)
+// TranscodeKey returns the name of a transcoded song in the cache. It changes when the
+// file is modified, so a re-tagged song is transcoded again.
+func TranscodeKey(song *models.Song, modified time.Time, profile *transcoders.Profile, gain float64) string {
+	key := strconv.Itoa(int(song.ID)) + "_" + strconv.FormatInt(modified.Unix(), 36) + "_" + profile.Key()
+	if gain != 0 {
+		key += "_" + strconv.FormatFloat(gain, 'f', 2, 64) + "dB"
 	}
 
-	originalFile, err := os.Open(song.Path)
-	if err != nil {
-		return
-	}
-	defer originalFile.Close()
+	return key + profile.Extension()
+}
 
-	cacheFile, err := os.Create(cachePath)
+// NewTranscodeStreamer transcodes a song with a profile and its volume changed by gain dB.
+// Use 0 for the original volume. The result is cached in Transcodings. While the song is
+// being transcoded a *GrowingStreamer is returned, which reads what ffmpeg has written.
+func NewTranscodeStreamer(song *models.Song, profile *transcoders.Profile, gain float64) (streamer Streamer, err error) {
+	info, err := os.Stat(song.Path)
 	if err != nil {
 		return
 	}
-	defer cacheFile.Close()
 
-	transcoder, err := transcoders.NewTranscoder(originalFile, codec)
-	if err != nil {
-		return
-	}
+	return Transcodings.Open(TranscodeKey(song, info.ModTime(), profile, gain), func(ctx context.Context, w io.Writer) error {
+		transcoder, err := transcoders.TranscodeFile(ctx, song.Path, 0, profile, gain)
+		if err != nil {
+			return err
+		}
+		defer transcoder.Close()
 
-	_, err = io.Copy(cacheFile, transcoder)
-	if err != nil {
-		// So that it first gets closed?
-		defer os.Remove(cachePath)
-		return
-	}
+		_, err = io.Copy(w, transcoder)
+		return err
+	})
+}
 
-	streamer, err = NewFileStreamer(cachePath)
-	return
+// NewSeekStreamer transcodes a song from start seconds without caching it, for clients
+// that seek to a part that is not transcoded yet. ffmpeg stops when ctx is done.
+func NewSeekStreamer(ctx context.Context, song *models.Song, profile *transcoders.Profile, gain float64, start float64) (io.ReadCloser, error) {
+	return transcoders.TranscodeFile(ctx, song.Path, start, profile, gain)
 }
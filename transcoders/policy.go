@@ -0,0 +1,165 @@
+package transcoders
+
+import (
+	"strings"
+
+	"github.com/cadenzr/cadenzr/config"
+)
+
+// Rule sets the bitrates of the users and clients it matches.
+type Rule struct {
+	users   map[string]bool
+	clients []string
+
+	// Profile is the name of the profile used when the request doesn't name one.
+	Profile string
+	// DefaultBitrate is used when the request doesn't ask for a bitrate, in kbit/s.
+	DefaultBitrate int
+	// MaxBitrate is the highest bitrate that is streamed, in kbit/s. 0 means no limit.
+	MaxBitrate int
+}
+
+// NewRule creates a rule from its configuration.
+func NewRule(conf config.BitrateRule) *Rule {
+	r := &Rule{
+		users:          map[string]bool{},
+		Profile:        conf.Profile,
+		DefaultBitrate: conf.DefaultBitrate,
+		MaxBitrate:     conf.MaxBitrate,
+	}
+
+	for _, user := range conf.Users {
+		r.users[user] = true
+	}
+
+	for _, client := range conf.Clients {
+		r.clients = append(r.clients, strings.ToLower(client))
+	}
+
+	return r
+}
+
+// Matches returns whether the rule applies to a user and client. A rule without users
+// matches everyone and a rule without clients every client. Clients match if their
+// name contains one of the rule's, without case.
+func (r *Rule) Matches(username string, client string) bool {
+	if len(r.users) != 0 && !r.users[username] {
+		return false
+	}
+
+	if len(r.clients) == 0 {
+		return true
+	}
+
+	client = strings.ToLower(client)
+	for _, c := range r.clients {
+		if strings.Contains(client, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Request describes a stream that a client asks for.
+type Request struct {
+	Username string
+	// Client is the name the client gave, or its user agent.
+	Client string
+
+	// Profile is the name of the profile the client asked for, if any.
+	Profile string
+	// Bitrate is the bitrate the client asked for in kbit/s, 0 if it did not.
+	Bitrate int
+
+	// SourceBitrate is the bitrate of the file in kbit/s, 0 if it is unknown.
+	SourceBitrate int
+	// Transcode is set when the file can't be streamed as it is, e.g. to change its volume.
+	Transcode bool
+}
+
+// matching returns the rules that apply to a request, in the order of the configuration.
+func matching(username string, client string) []*Rule {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	r := []*Rule{}
+	for _, rule := range rules {
+		if rule.Matches(username, client) {
+			r = append(r, rule)
+		}
+	}
+
+	return r
+}
+
+// MaxBitrate returns the lowest limit of the rules for a user and client in kbit/s,
+// 0 if there is none.
+func MaxBitrate(username string, client string) int {
+	max := 0
+	for _, rule := range matching(username, client) {
+		if rule.MaxBitrate > 0 && (max == 0 || rule.MaxBitrate < max) {
+			max = rule.MaxBitrate
+		}
+	}
+
+	return max
+}
+
+// Choose returns the profile to transcode a stream with, or nil to stream the file as it is.
+// Files are streamed as they are unless the request asks for a profile or bitrate, sets
+// Transcode, or the file is above the maximum bitrate.
+//
+// The profile is the one in the request, else the one of the first matching rule that
+// names one, else the first profile. The bitrate is the one in the request, else the
+// default of the first matching rule that has one, else the profile's. It is lowered to
+// the maximum of the matching rules.
+func Choose(req *Request) (*Profile, error) {
+	matched := matching(req.Username, req.Client)
+
+	max := MaxBitrate(req.Username, req.Client)
+	overLimit := max > 0 && (req.SourceBitrate == 0 || req.SourceBitrate > max)
+	if len(req.Profile) == 0 && req.Bitrate == 0 && !req.Transcode && !overLimit {
+		return nil, nil
+	}
+
+	var profile *Profile
+	if len(req.Profile) != 0 {
+		p, ok := FindProfile(req.Profile)
+		if !ok {
+			return nil, ErrUnknownProfile
+		}
+		profile = p
+	}
+
+	for _, rule := range matched {
+		if profile == nil && len(rule.Profile) != 0 {
+			profile, _ = FindProfile(rule.Profile)
+		}
+	}
+
+	if profile == nil {
+		all := Profiles()
+		if len(all) == 0 {
+			return nil, ErrUnknownProfile
+		}
+		profile = all[0]
+	}
+
+	bitrate := req.Bitrate
+	for _, rule := range matched {
+		if bitrate == 0 && rule.DefaultBitrate > 0 {
+			bitrate = rule.DefaultBitrate
+		}
+	}
+	if bitrate == 0 {
+		bitrate = profile.Bitrate
+	}
+	if max > 0 && (bitrate == 0 || bitrate > max) {
+		bitrate = max
+	}
+
+	chosen := *profile
+	chosen.Bitrate = bitrate
+	return &chosen, nil
+}
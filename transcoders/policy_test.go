@@ -0,0 +1,93 @@
+package transcoders
+
+import (
+	"testing"
+
+	"github.com/cadenzr/cadenzr/config"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProfiles(t *testing.T) {
+	defer Setup(nil, nil)
+
+	Convey("Profiles are checked when they are set up.", t, func() {
+		So(Setup([]config.TranscodeProfile{{Name: "flac", Codec: "flac"}}, nil), ShouldNotBeNil)
+		So(Setup([]config.TranscodeProfile{{Name: "aac", Codec: "aac", Container: "ogg"}}, nil), ShouldNotBeNil)
+		So(Setup([]config.TranscodeProfile{{Name: "a", Codec: "mp3"}, {Name: "a", Codec: "opus"}}, nil), ShouldNotBeNil)
+		So(Setup(nil, []config.BitrateRule{{Profile: "missing"}}), ShouldNotBeNil)
+
+		So(Setup(nil, nil), ShouldBeNil)
+		So(len(Profiles()), ShouldEqual, len(defaultProfiles))
+	})
+
+	Convey("Profiles become ffmpeg options.", t, func() {
+		p, err := NewProfile(config.TranscodeProfile{Name: "m4a", Codec: "AAC", Bitrate: 96, SampleRate: 44100, Channels: 2, Container: "mp4"})
+		So(err, ShouldBeNil)
+		So(p.Args(), ShouldResemble, []string{"-codec:a", "aac", "-b:a", "96k", "-ar", "44100", "-ac", "2", "-movflags", "frag_keyframe+empty_moov", "-f", "mp4"})
+		So(p.Extension(), ShouldEqual, ".m4a")
+		So(p.ContentType(), ShouldEqual, "audio/mp4")
+		So(p.Key(), ShouldEqual, "m4a-aac-mp4-96k-44100-2ch")
+
+		adts, err := NewProfile(config.TranscodeProfile{Name: "m4a", Codec: "AAC", Bitrate: 96, SampleRate: 44100, Channels: 2, Container: "adts"})
+		So(err, ShouldBeNil)
+		So(adts.Key(), ShouldNotEqual, p.Key())
+
+		opus := DefaultProfile(OPUS)
+		So(opus.Args(), ShouldResemble, []string{"-codec:a", "libopus", "-b:a", "128k", "-f", "ogg"})
+	})
+
+	Convey("Unknown codecs don't panic.", t, func() {
+		c := CodecType(42)
+		So(c.String(), ShouldEqual, "unknown")
+		So(c.Extension(), ShouldEqual, "")
+	})
+}
+
+func TestChoose(t *testing.T) {
+	defer Setup(nil, nil)
+
+	Convey("Profiles and bitrates follow the request and the rules.", t, func() {
+		err := Setup(nil, []config.BitrateRule{
+			{Clients: []string{"Android"}, Profile: "opus", MaxBitrate: 96},
+			{Users: []string{"guest"}, DefaultBitrate: 64, MaxBitrate: 128},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Files are streamed as they are unless something asks for transcoding.", func() {
+			p, err := Choose(&Request{Username: "admin", Client: "Firefox", SourceBitrate: 320})
+			So(err, ShouldBeNil)
+			So(p, ShouldBeNil)
+
+			// A guest can get 128 kbit/s.
+			p, _ = Choose(&Request{Username: "guest", SourceBitrate: 128})
+			So(p, ShouldBeNil)
+		})
+
+		Convey("Files above the maximum are transcoded with the rule's profile.", func() {
+			p, err := Choose(&Request{Username: "admin", Client: "MyPlayer/1.0 (Linux; Android 9)", SourceBitrate: 320})
+			So(err, ShouldBeNil)
+			So(p.Name, ShouldEqual, "opus")
+			So(p.Bitrate, ShouldEqual, 96)
+		})
+
+		Convey("The lowest maximum and the first default apply.", func() {
+			p, _ := Choose(&Request{Username: "guest", Client: "android", Profile: "mp3", Bitrate: 320})
+			So(p.Name, ShouldEqual, "mp3")
+			So(p.Bitrate, ShouldEqual, 96)
+
+			p, _ = Choose(&Request{Username: "guest", Transcode: true})
+			So(p.Name, ShouldEqual, "mp3")
+			So(p.Bitrate, ShouldEqual, 64)
+
+			// Profiles are copied, not changed.
+			mp3, _ := FindProfile("mp3")
+			So(mp3.Bitrate, ShouldEqual, 192)
+		})
+
+		Convey("Unknown profiles are an error.", func() {
+			_, err := Choose(&Request{Profile: "wav"})
+			So(err, ShouldEqual, ErrUnknownProfile)
+		})
+	})
+}
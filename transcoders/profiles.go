@@ -0,0 +1,268 @@
+package transcoders
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cadenzr/cadenzr/config"
+)
+
+// Containers.
+const (
+	ContainerMP3  = "mp3"
+	ContainerOgg  = "ogg"
+	ContainerWebM = "webm"
+	ContainerADTS = "adts"
+	ContainerMP4  = "mp4"
+)
+
+// containers are the containers each codec can be streamed in, the first is the default.
+var containers = map[CodecType][]string{
+	MP3:    {ContainerMP3},
+	VORBIS: {ContainerOgg, ContainerWebM},
+	OPUS:   {ContainerOgg, ContainerWebM},
+	AAC:    {ContainerADTS, ContainerMP4},
+}
+
+// Errors returned for invalid profiles and requests.
+var (
+	ErrUnknownCodec     = errors.New("Unknown codec")
+	ErrUnknownContainer = errors.New("Container can't hold the codec")
+	ErrUnknownProfile   = errors.New("Unknown transcoding profile")
+)
+
+// Profile is a named set of encoder settings.
+type Profile struct {
+	Name  string
+	Codec CodecType
+	// Bitrate is in kbit/s.
+	Bitrate int
+	// SampleRate is in Hz. 0 keeps the sample rate of the source.
+	SampleRate int
+	// Channels is 0 to keep the channels of the source.
+	Channels  int
+	Container string
+}
+
+// Key identifies the output of the profile, e.g. to cache it. It changes with every
+// setting, so the output of a profile that was changed under the same name is not reused.
+func (p *Profile) Key() string {
+	key := p.Name + "-" + p.Codec.String() + "-" + p.Container + "-" + strconv.Itoa(p.Bitrate) + "k"
+	if p.SampleRate != 0 {
+		key += "-" + strconv.Itoa(p.SampleRate)
+	}
+	if p.Channels != 0 {
+		key += "-" + strconv.Itoa(p.Channels) + "ch"
+	}
+
+	return key
+}
+
+// Extension returns the extension of files made with the profile.
+func (p *Profile) Extension() string {
+	switch p.Container {
+	case ContainerWebM:
+		return ".webm"
+	case ContainerMP4:
+		return ".m4a"
+	default:
+		return p.Codec.Extension()
+	}
+}
+
+// ContentType returns the MIME type of files made with the profile.
+func (p *Profile) ContentType() string {
+	switch p.Container {
+	case ContainerMP3:
+		return "audio/mpeg"
+	case ContainerOgg:
+		return "audio/ogg"
+	case ContainerWebM:
+		return "audio/webm"
+	case ContainerADTS:
+		return "audio/aac"
+	case ContainerMP4:
+		return "audio/mp4"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Args returns the ffmpeg output options of the profile.
+func (p *Profile) Args() []string {
+	args := []string{"-codec:a", p.Codec.encoder()}
+	if p.Bitrate != 0 {
+		args = append(args, "-b:a", strconv.Itoa(p.Bitrate)+"k")
+	}
+	if p.SampleRate != 0 {
+		args = append(args, "-ar", strconv.Itoa(p.SampleRate))
+	}
+	if p.Channels != 0 {
+		args = append(args, "-ac", strconv.Itoa(p.Channels))
+	}
+
+	switch p.Container {
+	case ContainerMP4:
+		// A pipe can't seek back to write the index, so it is written in fragments.
+		args = append(args, "-movflags", "frag_keyframe+empty_moov", "-f", "mp4")
+	default:
+		args = append(args, "-f", p.Container)
+	}
+
+	return args
+}
+
+// NewProfile checks a profile from the configuration.
+func NewProfile(conf config.TranscodeProfile) (*Profile, error) {
+	codec, ok := ParseCodec(strings.ToLower(conf.Codec))
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+
+	p := &Profile{
+		Name:       conf.Name,
+		Codec:      codec,
+		Bitrate:    conf.Bitrate,
+		SampleRate: conf.SampleRate,
+		Channels:   conf.Channels,
+		Container:  strings.ToLower(conf.Container),
+	}
+
+	if len(p.Container) == 0 {
+		p.Container = containers[codec][0]
+	}
+
+	valid := false
+	for _, container := range containers[codec] {
+		valid = valid || container == p.Container
+	}
+	if !valid {
+		return nil, ErrUnknownContainer
+	}
+
+	if p.Bitrate < 0 || p.SampleRate < 0 || p.Channels < 0 {
+		return nil, errors.New("Profile '" + p.Name + "' has a negative setting")
+	}
+
+	return p, nil
+}
+
+// defaultProfiles are used when the configuration has none.
+var defaultProfiles = []config.TranscodeProfile{
+	{Name: "mp3", Codec: "mp3", Bitrate: 192},
+	{Name: "ogg", Codec: "vorbis", Bitrate: 160},
+	{Name: "opus", Codec: "opus", Bitrate: 128},
+	{Name: "aac", Codec: "aac", Bitrate: 192},
+}
+
+// DefaultProfile returns the first profile with a codec, or a profile with the
+// defaults of ffmpeg if there is none.
+func DefaultProfile(codec CodecType) *Profile {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, p := range profiles {
+		if p.Codec == codec {
+			return p
+		}
+	}
+
+	return &Profile{Name: codec.String(), Codec: codec, Container: containers[codec][0]}
+}
+
+// FindProfile returns the profile with a name.
+func FindProfile(name string) (*Profile, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return nil, false
+}
+
+// Profiles returns all profiles. The first is used when nothing else chooses one.
+func Profiles() []*Profile {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return append([]*Profile{}, profiles...)
+}
+
+var (
+	mu       sync.RWMutex
+	profiles = mustProfiles(defaultProfiles)
+	rules    = []*Rule{}
+)
+
+func mustProfiles(confs []config.TranscodeProfile) []*Profile {
+	p, err := newProfiles(confs)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+func newProfiles(confs []config.TranscodeProfile) ([]*Profile, error) {
+	r := []*Profile{}
+	names := map[string]bool{}
+	for _, conf := range confs {
+		if len(conf.Name) == 0 {
+			return nil, errors.New("Every transcoding profile needs a name")
+		}
+
+		if names[conf.Name] {
+			return nil, errors.New("Transcoding profile '" + conf.Name + "' is defined more than once")
+		}
+		names[conf.Name] = true
+
+		p, err := NewProfile(conf)
+		if err != nil {
+			return nil, errors.New("Transcoding profile '" + conf.Name + "': " + err.Error())
+		}
+		r = append(r, p)
+	}
+
+	return r, nil
+}
+
+// Setup replaces the profiles and bitrate rules with those of the configuration.
+// Without profiles the defaults are used: mp3, ogg, opus and aac.
+func Setup(profileConfs []config.TranscodeProfile, ruleConfs []config.BitrateRule) error {
+	if len(profileConfs) == 0 {
+		profileConfs = defaultProfiles
+	}
+
+	newProfiles, err := newProfiles(profileConfs)
+	if err != nil {
+		return err
+	}
+
+	newRules := []*Rule{}
+	for i, conf := range ruleConfs {
+		rule := NewRule(conf)
+		if len(rule.Profile) != 0 {
+			found := false
+			for _, p := range newProfiles {
+				found = found || p.Name == rule.Profile
+			}
+			if !found {
+				return errors.New("Bitrate rule " + strconv.Itoa(i+1) + " uses unknown profile '" + rule.Profile + "'")
+			}
+		}
+		newRules = append(newRules, rule)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	profiles = newProfiles
+	rules = newRules
+
+	return nil
+}
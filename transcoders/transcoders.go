@@ -1,8 +1,10 @@
 package transcoders
 
 import (
+	"context"
 	"io"
 	"os/exec"
+	"strconv"
 
 	"github.com/cadenzr/cadenzr/log"
 )
@@ -15,63 +17,147 @@ func (c *CodecType) String() string {
 		return "mp3"
 	case VORBIS:
 		return "vorbis"
+	case OPUS:
+		return "opus"
+	case AAC:
+		return "aac"
 	default:
-		panic("Unknown codec type")
+		return "unknown"
 	}
 }
 
+// Extension returns the extension of files with only this codec, in its default container.
 func (c *CodecType) Extension() string {
 	switch *c {
 	case MP3:
 		return ".mp3"
 	case VORBIS:
 		return ".ogg"
+	case OPUS:
+		return ".opus"
+	case AAC:
+		return ".aac"
 	default:
-		panic("Unknown codec type")
+		return ""
 	}
 }
 
+// encoder returns the name of the ffmpeg encoder of the codec.
+func (c *CodecType) encoder() string {
+	switch *c {
+	case MP3:
+		return "mp3"
+	case VORBIS:
+		return "libvorbis"
+	case OPUS:
+		return "libopus"
+	case AAC:
+		return "aac"
+	default:
+		return ""
+	}
+}
+
+// ParseCodec returns the codec with a name as returned by String.
+func ParseCodec(name string) (CodecType, bool) {
+	for _, c := range []CodecType{MP3, VORBIS, OPUS, AAC} {
+		if c.String() == name {
+			return c, true
+		}
+	}
+
+	return 0, false
+}
+
 const (
 	MP3 CodecType = iota
 	VORBIS
+	OPUS
+	AAC
 )
 
 type Transcoder struct {
 	cmd    *exec.Cmd
 	stdin  io.WriteCloser
 	stdout io.ReadCloser
+	// ctx is done when ffmpeg was stopped on purpose. It is nil for transcoders without one.
+	ctx context.Context
+
+	waited  bool
+	waitErr error
 }
 
+// Close stops ffmpeg if it is still running.
+func (t *Transcoder) Close() error {
+	if t.waited {
+		return nil
+	}
+	t.waited = true
+	t.waitErr = io.EOF
+
+	t.cmd.Process.Kill()
+	t.cmd.Wait()
+	return nil
+}
+
+// Read returns the transcoded output. At the end it returns the error of ffmpeg if it
+// failed, so a partial transcoding is not mistaken for a complete one.
 func (t *Transcoder) Read(p []byte) (n int, err error) {
+	if t.waited {
+		return 0, t.waitErr
+	}
+
 	n, err = t.stdout.Read(p)
+	if err == io.EOF {
+		t.waited = true
+		t.waitErr = io.EOF
+		if waitErr := t.cmd.Wait(); waitErr != nil {
+			if t.ctx == nil || t.ctx.Err() == nil {
+				log.WithFields(log.Fields{"reason": waitErr}).Error("Transcoding failed.")
+			}
+			t.waitErr = waitErr
+		}
+		err = t.waitErr
+	}
 	return
 }
 
+// NewTranscoder transcodes input with the default profile of a codec.
 func NewTranscoder(input io.Reader, codec CodecType) (io.Reader, error) {
+	return NewGainTranscoder(input, codec, 0)
+}
+
+// NewGainTranscoder transcodes input and changes its volume by gain dB, e.g. a ReplayGain value.
+func NewGainTranscoder(input io.Reader, codec CodecType, gain float64) (io.Reader, error) {
+	return NewProfileTranscoder(input, DefaultProfile(codec), gain)
+}
+
+// args returns the ffmpeg arguments to transcode input from start seconds with a profile
+// and a gain in dB.
+func args(input string, start float64, profile *Profile, gain float64) []string {
+	a := []string{"-hide_banner", "-loglevel", "error"}
+	if start > 0 {
+		// Before the input ffmpeg seeks in the file instead of decoding up to start.
+		a = append(a, "-ss", strconv.FormatFloat(start, 'f', 3, 64))
+	}
+	a = append(a, "-i", input, "-vn")
+	if gain != 0 {
+		a = append(a, "-filter:a", "volume="+strconv.FormatFloat(gain, 'f', 2, 64)+"dB")
+	}
+	a = append(a, profile.Args()...)
+
+	return append(a, "pipe:1")
+}
+
+// NewProfileTranscoder transcodes input with the settings of a profile and changes its
+// volume by gain dB.
+func NewProfileTranscoder(input io.Reader, profile *Profile, gain float64) (io.Reader, error) {
 	ffmpeg, err := exec.LookPath("ffmpeg")
 	if err != nil {
 		return nil, err
 	}
 
-	codecString := ""
-	formatString := ""
-	switch codec {
-	case MP3:
-		codecString = "mp3"
-		formatString = "mp3"
-	case VORBIS:
-		codecString = "libvorbis"
-		formatString = "ogg"
-	default:
-		panic("Unknown codec type")
-	}
-
-	cmd := exec.Command(ffmpeg,
-		"-i", "-",
-		"-codec:a", codecString,
-		"-f", formatString,
-		"pipe:1",
-	)
+	cmd := exec.Command(ffmpeg, args("-", 0, profile, gain)...)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -96,11 +182,43 @@ func NewTranscoder(input io.Reader, codec CodecType) (io.Reader, error) {
 	go func() {
 		io.Copy(transcoder.stdin, input)
 		transcoder.stdin.Close()
-
-		if err := cmd.Wait(); err != nil {
-			log.WithFields(log.Fields{"reason": err}).Error("Wait failed.")
-		}
 	}()
 
 	return transcoder, nil
 }
+
+// TranscodeFile transcodes a file from start seconds with a profile and changes its volume
+// by gain dB. ffmpeg is killed when ctx is done or the transcoder is closed.
+func TranscodeFile(ctx context.Context, path string, start float64, profile *Profile, gain float64) (*Transcoder, error) {
+	return transcodeFile(ctx, args(path, start, profile, gain))
+}
+
+// TranscodeLive transcodes a file like TranscodeFile, but only as fast as it plays, for
+// live streams.
+func TranscodeLive(ctx context.Context, path string, profile *Profile, gain float64) (*Transcoder, error) {
+	return transcodeFile(ctx, append([]string{"-re"}, args(path, 0, profile, gain)...))
+}
+
+func transcodeFile(ctx context.Context, args []string) (*Transcoder, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, ffmpeg, args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &Transcoder{
+		cmd:    cmd,
+		stdout: stdout,
+		ctx:    ctx,
+	}, nil
+}
@@ -0,0 +1,344 @@
+// Package tus implements the server side of the tus resumable upload protocol
+// (https://tus.io/protocols/resumable-upload.html) with the creation, termination
+// and expiration extensions.
+package tus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cadenzr/cadenzr/log"
+	"github.com/labstack/echo"
+)
+
+// Version is the supported version of the protocol.
+const Version = "1.0.0"
+
+// Extensions are the supported protocol extensions.
+const Extensions = "creation,termination,expiration"
+
+// Protocol headers.
+const (
+	HeaderResumable      = "Tus-Resumable"
+	HeaderVersion        = "Tus-Version"
+	HeaderExtension      = "Tus-Extension"
+	HeaderMaxSize        = "Tus-Max-Size"
+	HeaderUploadOffset   = "Upload-Offset"
+	HeaderUploadLength   = "Upload-Length"
+	HeaderUploadMetadata = "Upload-Metadata"
+	HeaderUploadExpires  = "Upload-Expires"
+)
+
+// OffsetContentType is the content type of PATCH requests.
+const OffsetContentType = "application/offset+octet-stream"
+
+// exposedHeaders are the response headers browsers have to make available to tus clients.
+var exposedHeaders = strings.Join([]string{
+	HeaderResumable, HeaderVersion, HeaderExtension, HeaderMaxSize,
+	HeaderUploadOffset, HeaderUploadLength, HeaderUploadExpires, echo.HeaderLocation,
+}, ", ")
+
+// Handler serves the tus protocol. Routes are expected for OPTIONS and POST on a base
+// path and for HEAD, PATCH, DELETE and GET on the base path followed by '/:id'.
+type Handler struct {
+	Store *Store
+
+	// MaxSize is the maximum length of an upload in bytes.
+	MaxSize int64
+
+	// UserID returns the ID of the user making a request. Uploads can only be
+	// accessed by the user that created them.
+	UserID func(ctx echo.Context) uint
+
+	// Authorize is called with the metadata of a new upload. The upload is refused with
+	// 403 Forbidden if it returns an error.
+	Authorize func(ctx echo.Context, metadata map[string]string) error
+
+	// Complete is called when all data of an upload has been received, before the last
+	// PATCH request is answered. The returned value is stored as the result of the upload.
+	Complete func(ctx echo.Context, upload *Upload) interface{}
+}
+
+// ParseMetadata parses an Upload-Metadata header: comma separated keys, each followed
+// by a space and its base64 encoded value.
+func ParseMetadata(header string) (map[string]string, bool) {
+	metadata := map[string]string{}
+	if len(strings.TrimSpace(header)) == 0 {
+		return metadata, true
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, false
+		}
+
+		value := []byte{}
+		if len(fields) == 2 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				return nil, false
+			}
+		}
+
+		metadata[fields[0]] = string(value)
+	}
+
+	return metadata, true
+}
+
+func (h *Handler) userID(ctx echo.Context) uint {
+	if h.UserID == nil {
+		return 0
+	}
+
+	return h.UserID(ctx)
+}
+
+// setHeaders sets the headers sent with every response.
+func setHeaders(ctx echo.Context) {
+	header := ctx.Response().Header()
+	header.Set(HeaderResumable, Version)
+	header.Set(echo.HeaderAccessControlExposeHeaders, exposedHeaders)
+}
+
+// checkVersion returns whether the client speaks a supported version of the protocol.
+func checkVersion(ctx echo.Context) bool {
+	if ctx.Request().Header.Get(HeaderResumable) == Version {
+		return true
+	}
+
+	ctx.Response().Header().Set(HeaderVersion, Version)
+	return false
+}
+
+// upload returns the upload in the request if it belongs to the current user.
+func (h *Handler) upload(ctx echo.Context) (*Upload, error) {
+	u, err := h.Store.Get(ctx.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.UserID != h.userID(ctx) {
+		return nil, ErrNotFound
+	}
+
+	return u, nil
+}
+
+// storeError responds to an error of the store.
+func storeError(ctx echo.Context, action string, err error) error {
+	switch err {
+	case ErrNotFound:
+		return ctx.NoContent(http.StatusNotFound)
+	case ErrLocked:
+		return ctx.NoContent(http.StatusLocked)
+	case ErrOffsetMismatch:
+		return ctx.NoContent(http.StatusConflict)
+	case ErrTooLarge:
+		return ctx.NoContent(http.StatusRequestEntityTooLarge)
+	}
+
+	log.Errorf("tus::%s %v", action, err)
+	return ctx.NoContent(http.StatusInternalServerError)
+}
+
+// Options describes what the server supports in the answers to OPTIONS requests for
+// path and the uploads below it. It is added with echo's Pre, because OPTIONS requests
+// are answered by the CORS middleware before they reach a route.
+func (h *Handler) Options(path string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			p := ctx.Request().URL.Path
+			if ctx.Request().Method == echo.OPTIONS && (p == path || strings.HasPrefix(p, path+"/")) {
+				setHeaders(ctx)
+
+				header := ctx.Response().Header()
+				header.Set(HeaderVersion, Version)
+				header.Set(HeaderExtension, Extensions)
+				header.Set(HeaderMaxSize, strconv.FormatInt(h.MaxSize, 10))
+			}
+
+			return next(ctx)
+		}
+	}
+}
+
+// Create starts a new upload. The length is required, deferring it is not supported.
+func (h *Handler) Create(ctx echo.Context) error {
+	setHeaders(ctx)
+	if !checkVersion(ctx) {
+		return ctx.NoContent(http.StatusPreconditionFailed)
+	}
+
+	length, err := strconv.ParseInt(ctx.Request().Header.Get(HeaderUploadLength), 10, 64)
+	if err != nil || length <= 0 {
+		log.Debugf("tus::Create Invalid upload length '%s'.", ctx.Request().Header.Get(HeaderUploadLength))
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if length > h.MaxSize {
+		return ctx.NoContent(http.StatusRequestEntityTooLarge)
+	}
+
+	metadata, ok := ParseMetadata(ctx.Request().Header.Get(HeaderUploadMetadata))
+	if !ok {
+		log.Debugf("tus::Create Invalid upload metadata '%s'.", ctx.Request().Header.Get(HeaderUploadMetadata))
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if h.Authorize != nil {
+		if err = h.Authorize(ctx, metadata); err != nil {
+			log.WithFields(log.Fields{"reason": err.Error()}).Info("Refused upload.")
+			return ctx.JSON(http.StatusForbidden, echo.Map{
+				"message": err.Error(),
+			})
+		}
+	}
+
+	u, err := h.Store.Create(h.userID(ctx), length, metadata)
+	if err != nil {
+		return storeError(ctx, "Create", err)
+	}
+
+	log.WithFields(log.Fields{"id": u.ID, "length": u.Length, "metadata": u.Metadata}).Debug("Created upload.")
+
+	header := ctx.Response().Header()
+	header.Set(echo.HeaderLocation, strings.TrimSuffix(ctx.Request().URL.Path, "/")+"/"+u.ID)
+	header.Set(HeaderUploadExpires, u.Expires.UTC().Format(http.TimeFormat))
+	return ctx.NoContent(http.StatusCreated)
+}
+
+// Head returns the offset of an upload.
+func (h *Handler) Head(ctx echo.Context) error {
+	setHeaders(ctx)
+	if !checkVersion(ctx) {
+		return ctx.NoContent(http.StatusPreconditionFailed)
+	}
+
+	u, err := h.upload(ctx)
+	if err != nil {
+		return storeError(ctx, "Head", err)
+	}
+
+	header := ctx.Response().Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set(HeaderUploadOffset, strconv.FormatInt(u.Offset, 10))
+	header.Set(HeaderUploadLength, strconv.FormatInt(u.Length, 10))
+	if !u.Complete() {
+		header.Set(HeaderUploadExpires, u.Expires.UTC().Format(http.TimeFormat))
+	}
+
+	return ctx.NoContent(http.StatusOK)
+}
+
+// Patch appends data to an upload. The upload is handed to Complete when all data has
+// been received.
+func (h *Handler) Patch(ctx echo.Context) error {
+	setHeaders(ctx)
+	if !checkVersion(ctx) {
+		return ctx.NoContent(http.StatusPreconditionFailed)
+	}
+
+	if ctx.Request().Header.Get(echo.HeaderContentType) != OffsetContentType {
+		return ctx.NoContent(http.StatusUnsupportedMediaType)
+	}
+
+	offset, err := strconv.ParseInt(ctx.Request().Header.Get(HeaderUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	u, err := h.upload(ctx)
+	if err != nil {
+		return storeError(ctx, "Patch", err)
+	}
+
+	if err = h.Store.Lock(u.ID); err != nil {
+		return storeError(ctx, "Patch", err)
+	}
+	defer h.Store.Unlock(u.ID)
+
+	// Read the state again, it may have changed before the lock was taken.
+	if u, err = h.Store.Get(u.ID); err != nil {
+		return storeError(ctx, "Patch", err)
+	}
+
+	if u.Complete() {
+		if offset != u.Offset {
+			return ctx.NoContent(http.StatusConflict)
+		}
+		ctx.Response().Header().Set(HeaderUploadOffset, strconv.FormatInt(u.Offset, 10))
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	// Every chunk gives the client more time to finish.
+	u.Expires = time.Now().Add(h.Store.expiry)
+
+	u, err = h.Store.Append(u, offset, ctx.Request().Body)
+	if err != nil {
+		log.WithFields(log.Fields{"id": u.ID, "offset": u.Offset, "reason": err.Error()}).Debug("Couldn't append to upload.")
+		return storeError(ctx, "Patch", err)
+	}
+
+	if u.Complete() && h.Complete != nil {
+		result, err := json.Marshal(h.Complete(ctx, u))
+		if err != nil {
+			return storeError(ctx, "Patch", err)
+		}
+
+		u.Result = result
+		if err = h.Store.Save(u); err != nil {
+			return storeError(ctx, "Patch", err)
+		}
+	}
+
+	header := ctx.Response().Header()
+	header.Set(HeaderUploadOffset, strconv.FormatInt(u.Offset, 10))
+	if !u.Complete() {
+		header.Set(HeaderUploadExpires, u.Expires.UTC().Format(http.TimeFormat))
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Delete terminates an upload and removes its data.
+func (h *Handler) Delete(ctx echo.Context) error {
+	setHeaders(ctx)
+	if !checkVersion(ctx) {
+		return ctx.NoContent(http.StatusPreconditionFailed)
+	}
+
+	u, err := h.upload(ctx)
+	if err != nil {
+		return storeError(ctx, "Delete", err)
+	}
+
+	if err = h.Store.Lock(u.ID); err != nil {
+		return storeError(ctx, "Delete", err)
+	}
+	defer h.Store.Unlock(u.ID)
+
+	if err = h.Store.Delete(u.ID); err != nil {
+		return storeError(ctx, "Delete", err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Show returns the state of an upload and, when it is complete, its result.
+// This is not part of the protocol.
+func (h *Handler) Show(ctx echo.Context) error {
+	setHeaders(ctx)
+
+	u, err := h.upload(ctx)
+	if err != nil {
+		return storeError(ctx, "Show", err)
+	}
+
+	return ctx.JSON(http.StatusOK, u)
+}
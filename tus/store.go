@@ -0,0 +1,273 @@
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cadenzr/cadenzr/log"
+)
+
+// Store errors.
+var (
+	ErrNotFound       = errors.New("Upload not found")
+	ErrLocked         = errors.New("Upload is in use")
+	ErrOffsetMismatch = errors.New("Upload offset does not match")
+	ErrTooLarge       = errors.New("Upload is larger than its length")
+)
+
+// Upload is the state of a resumable upload.
+type Upload struct {
+	ID       string            `json:"id"`
+	UserID   uint              `json:"user_id"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Created  time.Time         `json:"created"`
+	Expires  time.Time         `json:"expires"`
+
+	// Result is set when the completed upload has been handled.
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Complete returns whether all data has been received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Store keeps uploads in a directory. The data of an upload is in a file named after
+// its ID, its state in the same file with a .json extension.
+type Store struct {
+	dir    string
+	expiry time.Duration
+
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+// NewStore creates a store in dir. Uploads that are not finished within expiry are removed by Expire.
+func NewStore(dir string, expiry time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		dir:    dir,
+		expiry: expiry,
+		locked: map[string]bool{},
+	}, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// validID returns whether id can be an upload ID, so it is safe to use in a path.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// DataPath returns the file with the data of an upload.
+func (s *Store) DataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Create starts a new upload.
+func (s *Store) Create(userID uint, length int64, metadata map[string]string) (*Upload, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	u := &Upload{
+		ID:       id,
+		UserID:   userID,
+		Length:   length,
+		Metadata: metadata,
+		Created:  now,
+		Expires:  now.Add(s.expiry),
+	}
+
+	if err = ioutil.WriteFile(s.DataPath(id), nil, 0644); err != nil {
+		return nil, err
+	}
+
+	if err = s.Save(u); err != nil {
+		os.Remove(s.DataPath(id))
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Get returns an upload.
+func (s *Store) Get(id string) (*Upload, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	raw, err := ioutil.ReadFile(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	u := &Upload{}
+	if err = json.Unmarshal(raw, u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Save stores the state of an upload.
+func (s *Store) Save(u *Upload) error {
+	raw, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	// Write and rename so a crash never leaves half a state file.
+	tmp := s.infoPath(u.ID) + ".tmp"
+	if err = ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.infoPath(u.ID))
+}
+
+// Lock claims an upload so it is not written to concurrently.
+func (s *Store) Lock(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locked[id] {
+		return ErrLocked
+	}
+
+	s.locked[id] = true
+	return nil
+}
+
+// Unlock releases an upload claimed with Lock.
+func (s *Store) Unlock(id string) {
+	s.mu.Lock()
+	delete(s.locked, id)
+	s.mu.Unlock()
+}
+
+// Append writes data at offset to a locked upload and returns the updated upload.
+// Data that was written before an error is kept, so the client can resume from the new offset.
+func (s *Store) Append(u *Upload, offset int64, r io.Reader) (*Upload, error) {
+	if offset != u.Offset {
+		return u, ErrOffsetMismatch
+	}
+
+	fh, err := os.OpenFile(s.DataPath(u.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		return u, err
+	}
+
+	// The state can be behind the data if the server stopped during a write.
+	if _, err = fh.Seek(u.Offset, io.SeekStart); err == nil {
+		err = fh.Truncate(u.Offset)
+	}
+	if err != nil {
+		fh.Close()
+		return u, err
+	}
+
+	// Read one byte more than allowed to notice clients that send too much.
+	n, copyErr := io.Copy(fh, io.LimitReader(r, u.Length-u.Offset+1))
+	if closeErr := fh.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+
+	if u.Offset+n > u.Length {
+		fh, err := os.OpenFile(s.DataPath(u.ID), os.O_WRONLY, 0644)
+		if err == nil {
+			fh.Truncate(u.Offset)
+			fh.Close()
+		}
+		return u, ErrTooLarge
+	}
+
+	u.Offset += n
+	if err = s.Save(u); err != nil {
+		return u, err
+	}
+
+	return u, copyErr
+}
+
+// Delete removes an upload and its data.
+func (s *Store) Delete(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	if err := os.Remove(s.infoPath(id)); os.IsNotExist(err) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.DataPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Expire removes the uploads that expired before now.
+func (s *Store) Expire(now time.Time) {
+	infos, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		log.Errorf("Could not list uploads: %v", err)
+		return
+	}
+
+	for _, info := range infos {
+		id := filepath.Base(info)
+		id = id[:len(id)-len(".json")]
+
+		u, err := s.Get(id)
+		if err != nil || u.Expires.After(now) {
+			continue
+		}
+
+		if s.Lock(id) != nil {
+			continue
+		}
+
+		if err = s.Delete(id); err != nil {
+			log.WithFields(log.Fields{"id": id, "reason": err.Error()}).Error("Could not remove expired upload.")
+		} else {
+			log.WithFields(log.Fields{"id": id}).Info("Removed expired upload.")
+		}
+		s.Unlock(id)
+	}
+}
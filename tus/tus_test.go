@@ -0,0 +1,230 @@
+package tus
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestHandler(dir string) (*echo.Echo, *Handler, *[]*Upload) {
+	store, err := NewStore(dir, time.Hour)
+	if err != nil {
+		panic(err)
+	}
+
+	completed := []*Upload{}
+	h := &Handler{
+		Store:   store,
+		MaxSize: 100,
+		UserID: func(ctx echo.Context) uint {
+			id, _ := strconv.ParseUint(ctx.Request().Header.Get("X-User"), 10, 64)
+			return uint(id)
+		},
+		Complete: func(ctx echo.Context, u *Upload) interface{} {
+			completed = append(completed, u)
+			data, _ := ioutil.ReadFile(store.DataPath(u.ID))
+			return string(data)
+		},
+	}
+
+	// Like the API, OPTIONS requests are answered by the CORS middleware.
+	e := echo.New()
+	e.Pre(h.Options("/tus"))
+	e.Use(middleware.CORSWithConfig(middleware.DefaultCORSConfig))
+	e.POST("/tus", h.Create)
+	e.HEAD("/tus/:id", h.Head)
+	e.PATCH("/tus/:id", h.Patch)
+	e.DELETE("/tus/:id", h.Delete)
+	e.GET("/tus/:id", h.Show)
+
+	return e, h, &completed
+}
+
+func do(e *echo.Echo, method string, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set(HeaderResumable, Version)
+	req.Header.Set("X-User", "1")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func patch(e *echo.Echo, location string, offset int, data string) *httptest.ResponseRecorder {
+	return do(e, echo.PATCH, location, []byte(data), map[string]string{
+		echo.HeaderContentType: OffsetContentType,
+		HeaderUploadOffset:     strconv.Itoa(offset),
+	})
+}
+
+func TestParseMetadata(t *testing.T) {
+	Convey("Metadata values are base64 encoded.", t, func() {
+		name := base64.StdEncoding.EncodeToString([]byte("01 Song.flac"))
+		metadata, ok := ParseMetadata("filename " + name + ",library MQ==, flag")
+		So(ok, ShouldBeTrue)
+		So(metadata, ShouldResemble, map[string]string{"filename": "01 Song.flac", "library": "1", "flag": ""})
+
+		_, ok = ParseMetadata("filename not-base64!")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tus")
+	defer os.RemoveAll(dir)
+
+	e, _, completed := newTestHandler(dir)
+
+	Convey("OPTIONS describes the server.", t, func() {
+		rec := do(e, echo.OPTIONS, "/tus", nil, nil)
+		So(rec.Code, ShouldEqual, http.StatusNoContent)
+		So(rec.Header().Get(HeaderVersion), ShouldEqual, Version)
+		So(rec.Header().Get(HeaderExtension), ShouldEqual, Extensions)
+		So(rec.Header().Get(HeaderMaxSize), ShouldEqual, "100")
+
+		rec = do(e, echo.OPTIONS, "/tus/upload", nil, map[string]string{
+			echo.HeaderOrigin:                     "https://app.example.com",
+			echo.HeaderAccessControlRequestMethod: echo.PATCH,
+		})
+		So(rec.Code, ShouldEqual, http.StatusNoContent)
+		So(rec.Header().Get(HeaderVersion), ShouldEqual, Version)
+		So(rec.Header().Get(echo.HeaderAccessControlAllowOrigin), ShouldEqual, "*")
+
+		rec = do(e, echo.OPTIONS, "/tusother", nil, nil)
+		So(rec.Header().Get(HeaderVersion), ShouldEqual, "")
+	})
+
+	Convey("Requests without a supported version are refused.", t, func() {
+		rec := do(e, echo.POST, "/tus", nil, map[string]string{HeaderResumable: "0.2.2", HeaderUploadLength: "10"})
+		So(rec.Code, ShouldEqual, http.StatusPreconditionFailed)
+		So(rec.Header().Get(HeaderVersion), ShouldEqual, Version)
+	})
+
+	Convey("Uploads need a length within the maximum.", t, func() {
+		So(do(e, echo.POST, "/tus", nil, nil).Code, ShouldEqual, http.StatusBadRequest)
+		So(do(e, echo.POST, "/tus", nil, map[string]string{HeaderUploadLength: "101"}).Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+	})
+
+	Convey("An upload is sent in chunks and resumed from its offset.", t, func() {
+		rec := do(e, echo.POST, "/tus", nil, map[string]string{
+			HeaderUploadLength:   "10",
+			HeaderUploadMetadata: "filename " + base64.StdEncoding.EncodeToString([]byte("a.mp3")),
+		})
+		So(rec.Code, ShouldEqual, http.StatusCreated)
+		So(rec.Header().Get(HeaderUploadExpires), ShouldNotBeEmpty)
+		location := rec.Header().Get(echo.HeaderLocation)
+		So(location, ShouldStartWith, "/tus/")
+
+		rec = patch(e, location, 0, "01234")
+		So(rec.Code, ShouldEqual, http.StatusNoContent)
+		So(rec.Header().Get(HeaderUploadOffset), ShouldEqual, "5")
+
+		rec = do(e, echo.HEAD, location, nil, nil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Header().Get(HeaderUploadOffset), ShouldEqual, "5")
+		So(rec.Header().Get(HeaderUploadLength), ShouldEqual, "10")
+		So(rec.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+
+		// A chunk at the wrong offset is refused.
+		So(patch(e, location, 3, "34567").Code, ShouldEqual, http.StatusConflict)
+		So(len(*completed), ShouldEqual, 0)
+
+		// Other users can't see the upload.
+		So(do(e, echo.HEAD, location, nil, map[string]string{"X-User": "2"}).Code, ShouldEqual, http.StatusNotFound)
+
+		rec = patch(e, location, 5, "56789")
+		So(rec.Code, ShouldEqual, http.StatusNoContent)
+		So(rec.Header().Get(HeaderUploadOffset), ShouldEqual, "10")
+		So(len(*completed), ShouldEqual, 1)
+		So((*completed)[0].Metadata["filename"], ShouldEqual, "a.mp3")
+
+		// Repeating the last chunk doesn't complete the upload again.
+		So(patch(e, location, 10, "").Code, ShouldEqual, http.StatusNoContent)
+		So(len(*completed), ShouldEqual, 1)
+
+		rec = do(e, echo.GET, location, nil, nil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Body.String(), ShouldContainSubstring, `"result":"0123456789"`)
+	})
+
+	Convey("Chunks can't go past the length.", t, func() {
+		rec := do(e, echo.POST, "/tus", nil, map[string]string{HeaderUploadLength: "4"})
+		location := rec.Header().Get(echo.HeaderLocation)
+
+		So(patch(e, location, 0, "12345").Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		So(do(e, echo.HEAD, location, nil, nil).Header().Get(HeaderUploadOffset), ShouldEqual, "0")
+	})
+
+	Convey("PATCH needs the offset content type.", t, func() {
+		rec := do(e, echo.POST, "/tus", nil, map[string]string{HeaderUploadLength: "4"})
+		location := rec.Header().Get(echo.HeaderLocation)
+
+		rec = do(e, echo.PATCH, location, []byte("1234"), map[string]string{HeaderUploadOffset: "0"})
+		So(rec.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+	})
+
+	Convey("A terminated upload is removed.", t, func() {
+		rec := do(e, echo.POST, "/tus", nil, map[string]string{HeaderUploadLength: "4"})
+		location := rec.Header().Get(echo.HeaderLocation)
+		patch(e, location, 0, "12")
+
+		So(do(e, echo.DELETE, location, nil, nil).Code, ShouldEqual, http.StatusNoContent)
+		So(do(e, echo.HEAD, location, nil, nil).Code, ShouldEqual, http.StatusNotFound)
+
+		_, err := os.Stat(filepath.Join(dir, filepath.Base(location)))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
+
+func TestStoreExpire(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tus")
+	defer os.RemoveAll(dir)
+
+	Convey("Expired uploads are removed.", t, func() {
+		store, err := NewStore(dir, time.Hour)
+		So(err, ShouldBeNil)
+
+		old, _ := store.Create(1, 10, nil)
+		recent, _ := store.Create(1, 10, nil)
+		old.Expires = time.Now().Add(-time.Minute)
+		So(store.Save(old), ShouldBeNil)
+
+		// Uploads that are being written to are kept.
+		locked, _ := store.Create(1, 10, nil)
+		locked.Expires = old.Expires
+		So(store.Save(locked), ShouldBeNil)
+		So(store.Lock(locked.ID), ShouldBeNil)
+
+		store.Expire(time.Now())
+
+		_, err = store.Get(old.ID)
+		So(err, ShouldEqual, ErrNotFound)
+		_, err = os.Stat(store.DataPath(old.ID))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		_, err = store.Get(recent.ID)
+		So(err, ShouldBeNil)
+		_, err = store.Get(locked.ID)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("IDs can't escape the store.", t, func() {
+		store, _ := NewStore(dir, time.Hour)
+		_, err := store.Get("../../etc/passwd")
+		So(err, ShouldEqual, ErrNotFound)
+	})
+}
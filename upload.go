@@ -1,51 +1,268 @@
 package main
 
 import (
+	"archive/zip"
+	"errors"
 	"io"
-	"os"
-
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cadenzr/cadenzr/config"
+	"github.com/cadenzr/cadenzr/controllers"
+	"github.com/cadenzr/cadenzr/library"
 	"github.com/cadenzr/cadenzr/log"
 	"github.com/cadenzr/cadenzr/scan"
+	"github.com/cadenzr/cadenzr/tus"
 	"github.com/labstack/echo"
 )
 
-func upload(c echo.Context) error {
+// stagingDir is where uploads are kept until they are moved into a library.
+var stagingDir = filepath.Join("cache", "uploads")
+
+var errTooLarge = errors.New("File is too large")
+
+// stage copies at most limit bytes of r to a new file in the staging directory.
+func stage(r io.Reader, limit int64) (string, int64, error) {
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return "", 0, err
+	}
+
+	dst, err := ioutil.TempFile(stagingDir, "upload")
+	if err != nil {
+		return "", 0, err
+	}
+	defer dst.Close()
 
-	//-----------
-	// Read file
-	//-----------
+	// Copy one byte more than allowed to find out if the file is too large.
+	n, err := io.Copy(dst, io.LimitReader(r, limit+1))
+	if err == nil && n > limit {
+		err = errTooLarge
+	}
 
-	// Source
-	file, err := c.FormFile("file")
 	if err != nil {
-		log.WithFields(log.Fields{"reason": err.Error()}).Error("Couldn't find upload file.")
-		return c.NoContent(http.StatusInternalServerError)
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", 0, err
+	}
+
+	return dst.Name(), n, nil
+}
+
+// uploadLibrary returns the library to upload to: the one with the given ID or, if the
+// ID is 0, the first library that is not read only.
+func uploadLibrary(c echo.Context, id uint) (*library.Library, error) {
+	lib := library.Writable()
+	if id != 0 {
+		lib = library.Get(id)
+	}
+
+	if lib == nil {
+		return nil, errors.New("No library to upload to")
 	}
+
+	if lib.ReadOnly {
+		return nil, errors.New("Library '" + lib.Name + "' is read only")
+	}
+
+	username := ""
+	if claim := controllers.CurrentUser(c); claim != nil {
+		username = claim.Username
+	}
+	if !lib.Allows(username) {
+		return nil, errors.New("No access to library '" + lib.Name + "'")
+	}
+
+	return lib, nil
+}
+
+// uploadName returns the base name of an uploaded file.
+func uploadName(name string) string {
+	return path.Base(filepath.ToSlash(name))
+}
+
+// ingestUpload stages an uploaded file and adds it to lib. Zip archives are extracted.
+func ingestUpload(c echo.Context, lib *library.Library, file *multipart.FileHeader) []*scan.Ingested {
+	name := uploadName(file.Filename)
+
 	src, err := file.Open()
 	if err != nil {
-		log.WithFields(log.Fields{"reason": err.Error()}).Error("Couldn't open upload file.")
-		return c.NoContent(http.StatusInternalServerError)
+		log.WithFields(log.Fields{"reason": err.Error(), "name": name}).Error("Couldn't open upload file.")
+		return []*scan.Ingested{{Name: name, Status: scan.IngestRejected, Reason: err.Error()}}
 	}
 	defer src.Close()
 
-	// Destination
-	dst, err := os.Create("media/uploads/" + file.Filename)
+	staged, size, err := stage(src, config.Config.UploadMaxSize)
 	if err != nil {
-		log.WithFields(log.Fields{"reason": err.Error()}).Error("Couldn't upload file.")
-		return c.NoContent(http.StatusInternalServerError)
+		log.WithFields(log.Fields{"reason": err.Error(), "name": name}).Error("Couldn't stage upload file.")
+		return []*scan.Ingested{{Name: name, Status: scan.IngestRejected, Reason: err.Error()}}
 	}
-	defer dst.Close()
 
-	// Copy
-	if _, err = io.Copy(dst, src); err != nil {
-		log.WithFields(log.Fields{"reason": err.Error()}).Error("Couldn't copy uploaded file.")
-		return c.NoContent(http.StatusInternalServerError)
+	if strings.ToLower(filepath.Ext(name)) != ".zip" {
+		return []*scan.Ingested{scan.Ingest(c.Request().Context(), lib, staged, name)}
+	}
+
+	defer os.Remove(staged)
+	return ingestZip(c, lib, staged, size, name)
+}
+
+// ingestZip adds the files in a zip archive to lib. Everything extracted together may
+// not be larger than the upload limit.
+func ingestZip(c echo.Context, lib *library.Library, archive string, size int64, name string) []*scan.Ingested {
+	fh, err := os.Open(archive)
+	if err != nil {
+		return []*scan.Ingested{{Name: name, Status: scan.IngestRejected, Reason: err.Error()}}
+	}
+	defer fh.Close()
+
+	r, err := zip.NewReader(fh, size)
+	if err != nil {
+		log.WithFields(log.Fields{"reason": err.Error(), "name": name}).Debug("Couldn't read uploaded zip.")
+		return []*scan.Ingested{{Name: name, Status: scan.IngestRejected, Reason: "Invalid zip archive"}}
+	}
+
+	results := []*scan.Ingested{}
+	remaining := config.Config.UploadMaxSize
+	for _, entry := range r.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		// Only the base name is used, the destination comes from the tags.
+		entryName := path.Base(entry.Name)
+
+		src, err := entry.Open()
+		if err != nil {
+			results = append(results, &scan.Ingested{Name: entryName, Status: scan.IngestRejected, Reason: err.Error()})
+			continue
+		}
+
+		staged, n, err := stage(src, remaining)
+		src.Close()
+		if err == errTooLarge {
+			results = append(results, &scan.Ingested{Name: entryName, Status: scan.IngestRejected, Reason: "Archive is too large"})
+			break
+		} else if err != nil {
+			results = append(results, &scan.Ingested{Name: entryName, Status: scan.IngestRejected, Reason: err.Error()})
+			continue
+		}
+		remaining -= n
+
+		results = append(results, scan.Ingest(c.Request().Context(), lib, staged, entryName))
+	}
+
+	return results
+}
+
+// upload adds uploaded audio files, or zip archives with audio files, to a library.
+// Files are sent as one or more 'file' or 'files' form fields.
+func upload(c echo.Context) error {
+	// The form is stored while it is parsed, so the request is limited before.
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, config.Config.UploadMaxSize)
+	form, err := c.MultipartForm()
+	if err != nil {
+		log.WithFields(log.Fields{"reason": err.Error()}).Debug("Couldn't read upload form.")
+		return c.NoContent(http.StatusBadRequest)
+	}
+	defer form.RemoveAll()
+
+	lib, err := uploadLibrary(c, controllers.StrToUint(c.FormValue("library")))
+	if err != nil {
+		log.WithFields(log.Fields{"reason": err.Error()}).Info("Couldn't upload file.")
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"message": err.Error(),
+		})
 	}
 
-	// TODO: Should be done by the channel.
-	scan.ScanFilesystem("media/uploads/" + file.Filename)
+	files := []*multipart.FileHeader{}
+	for _, field := range []string{"file", "files", "files[]"} {
+		files = append(files, form.File[field]...)
+	}
+
+	if len(files) == 0 {
+		log.Debug("Couldn't find upload file.")
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	results := []*scan.Ingested{}
+	for _, file := range files {
+		results = append(results, ingestUpload(c, lib, file)...)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"data": results,
+	})
+}
+
+// tusDir is where resumable uploads are kept until they are complete.
+var tusDir = filepath.Join("cache", "tus")
+
+// newResumableUploads returns the handler for resumable uploads. The name of the file
+// is sent in the 'filename' metadata and the library in 'library'. Completed uploads
+// are added like uploaded files. Expired uploads are removed every hour.
+func newResumableUploads() (*tus.Handler, error) {
+	expiry := time.Duration(config.Config.UploadExpiry) * time.Hour
+	store, err := tus.NewStore(tusDir, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for now := range time.Tick(time.Hour) {
+			store.Expire(now)
+		}
+	}()
+
+	return &tus.Handler{
+		Store:   store,
+		MaxSize: config.Config.UploadMaxSize,
+		UserID: func(c echo.Context) uint {
+			if claim := controllers.CurrentUser(c); claim != nil {
+				return claim.ID
+			}
+			return 0
+		},
+		Authorize: func(c echo.Context, metadata map[string]string) error {
+			// Some clients only send 'name'.
+			if len(metadata["filename"]) == 0 {
+				metadata["filename"] = metadata["name"]
+			}
+			if len(strings.TrimSpace(metadata["filename"])) == 0 {
+				return errors.New("Missing file name")
+			}
+
+			lib, err := uploadLibrary(c, controllers.StrToUint(metadata["library"]))
+			if err != nil {
+				return err
+			}
+
+			metadata["library"] = strconv.FormatUint(uint64(lib.ID), 10)
+			return nil
+		},
+		Complete: func(c echo.Context, upload *tus.Upload) interface{} {
+			name := uploadName(upload.Metadata["filename"])
+			staged := store.DataPath(upload.ID)
+
+			// Access to the library may have changed since the upload was created.
+			lib, err := uploadLibrary(c, controllers.StrToUint(upload.Metadata["library"]))
+			if err != nil {
+				os.Remove(staged)
+				return []*scan.Ingested{{Name: name, Status: scan.IngestRejected, Reason: err.Error()}}
+			}
+
+			if strings.ToLower(filepath.Ext(name)) != ".zip" {
+				return []*scan.Ingested{scan.Ingest(c.Request().Context(), lib, staged, name)}
+			}
 
-	return c.NoContent(http.StatusOK)
+			defer os.Remove(staged)
+			return ingestZip(c, lib, staged, upload.Length, name)
+		},
+	}, nil
 }